@@ -11,7 +11,9 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/totegamma/concurrent/core"
@@ -22,6 +24,7 @@ import (
 
 const (
 	defaultTimeout = 3 * time.Second
+	chunkLengthTTL = 1 * time.Hour
 )
 
 var tracer = otel.Tracer("client")
@@ -36,15 +39,27 @@ type Client interface {
 	GetChunks(ctx context.Context, domain string, timelines []string, queryTime time.Time, opts *Options) (map[string]core.Chunk, error)
 	GetKey(ctx context.Context, domain, id string, opts *Options) ([]core.Key, error)
 	GetDomain(ctx context.Context, domain string, opts *Options) (core.Domain, error)
+	GetChunkLength(ctx context.Context, domain string, opts *Options) (int64, error)
 	GetChunkItrs(ctx context.Context, domain string, timelines []string, epoch string, opts *Options) (map[string]string, error)
 	GetChunkBodies(ctx context.Context, domain string, query map[string]string, opts *Options) (map[string]core.Chunk, error)
 	GetRetracted(ctx context.Context, domain string, timelines []string, opts *Options) (map[string][]string, error)
+	GetRepository(ctx context.Context, domain string, opts *Options) (string, error)
+	GetRepositoryHead(ctx context.Context, domain, owner string, opts *Options) (core.CommitHead, error)
+	GetRevocations(ctx context.Context, domain string, since time.Time, opts *Options) (core.RevocationFeed, error)
+	GetSyncStatus(ctx context.Context, domain string, opts *Options) (core.SyncStatus, error)
+	PerformSync(ctx context.Context, domain string, opts *Options) (core.SyncStatus, error)
 }
 
 type client struct {
-	client     http.Client
-	lastFailed map[string]time.Time
-	failCount  map[string]int
+	client       http.Client
+	lastFailed   map[string]time.Time
+	failCount    map[string]int
+	chunkLengths sync.Map // domain -> chunkLengthEntry
+}
+
+type chunkLengthEntry struct {
+	length    int64
+	fetchedAt time.Time
 }
 
 func NewClient() Client {
@@ -359,6 +374,32 @@ func (c *client) GetChunks(ctx context.Context, domain string, timelines []strin
 	return *response, nil
 }
 
+// GetChunkLength returns the chunk length advertised by the domain.
+// Epochs passed to GetChunkItrs and GetChunkBodies must be aligned to this length.
+func (c *client) GetChunkLength(ctx context.Context, domain string, opts *Options) (int64, error) {
+	ctx, span := tracer.Start(ctx, "Client.GetChunkLength")
+	defer span.End()
+
+	if cached, ok := c.chunkLengths.Load(domain); ok {
+		entry := cached.(chunkLengthEntry)
+		if time.Since(entry.fetchedAt) < chunkLengthTTL {
+			return entry.length, nil
+		}
+	}
+
+	info, err := c.GetDomain(ctx, domain, opts)
+	if err != nil {
+		span.RecordError(err)
+		return core.DefaultChunkLength, err
+	}
+
+	length := core.ChunkLengthFromMeta(info.Meta)
+	c.chunkLengths.Store(domain, chunkLengthEntry{length: length, fetchedAt: time.Now()})
+
+	return length, nil
+}
+
+// GetChunkItrs looks up chunk iterators on the domain. epoch is in the domain's chunk length.
 func (c *client) GetChunkItrs(ctx context.Context, domain string, timelines []string, epoch string, opts *Options) (map[string]string, error) {
 	ctx, span := tracer.Start(ctx, "Client.GetChunkItrs")
 	defer span.End()
@@ -386,6 +427,7 @@ func (c *client) GetChunkItrs(ctx context.Context, domain string, timelines []st
 	return *response, nil
 }
 
+// GetChunkBodies loads chunk bodies from the domain. epochs are in the domain's chunk length.
 func (c *client) GetChunkBodies(ctx context.Context, domain string, query map[string]string, opts *Options) (map[string]core.Chunk, error) {
 	ctx, span := tracer.Start(ctx, "Client.GetChunkBodies")
 	defer span.End()
@@ -493,3 +535,151 @@ func (c *client) GetRetracted(ctx context.Context, domain string, timelines []st
 
 	return *response, nil
 }
+
+func (c *client) GetRepository(ctx context.Context, domain string, opts *Options) (string, error) {
+	ctx, span := tracer.Start(ctx, "Client.GetRepository")
+	defer span.End()
+
+	if !c.IsOnline(domain) {
+		return "", fmt.Errorf("Domain is offline")
+	}
+
+	url := "https://" + domain + "/api/v1/repository"
+	span.SetAttributes(attribute.String("url", url))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	if opts != nil {
+		if opts.AuthToken != "" {
+			req.Header.Set("Authorization", "Bearer "+opts.AuthToken)
+		}
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	// repository can be large. use a dedicated client without the default timeout
+	client := new(http.Client)
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Request failed(%s)", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+func (c *client) GetRepositoryHead(ctx context.Context, domain, owner string, opts *Options) (core.CommitHead, error) {
+	ctx, span := tracer.Start(ctx, "Client.GetRepositoryHead")
+	defer span.End()
+
+	if !c.IsOnline(domain) {
+		return core.CommitHead{}, fmt.Errorf("Domain is offline")
+	}
+
+	url := "https://" + domain + "/api/v1/repository/head?owner=" + owner
+	span.SetAttributes(attribute.String("url", url))
+
+	response, err := httpRequest[core.CommitHead](ctx, &c.client, "GET", url, "", opts)
+	if err != nil {
+		span.RecordError(err)
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			c.lastFailed[domain] = time.Now()
+		}
+
+		return core.CommitHead{}, err
+	}
+
+	return *response, nil
+}
+
+// GetRevocations fetches the signed revocation feed of the domain
+func (c *client) GetRevocations(ctx context.Context, domain string, since time.Time, opts *Options) (core.RevocationFeed, error) {
+	ctx, span := tracer.Start(ctx, "Client.GetRevocations")
+	defer span.End()
+
+	if !c.IsOnline(domain) {
+		return core.RevocationFeed{}, fmt.Errorf("Domain is offline")
+	}
+
+	url := "https://" + domain + "/api/v1/revocations?since=" + strconv.FormatInt(since.Unix(), 10)
+	span.SetAttributes(attribute.String("url", url))
+
+	response, err := httpRequest[core.RevocationFeed](ctx, &c.client, "GET", url, "", opts)
+	if err != nil {
+		span.RecordError(err)
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			c.lastFailed[domain] = time.Now()
+		}
+
+		return core.RevocationFeed{}, err
+	}
+
+	return *response, nil
+}
+
+func (c *client) GetSyncStatus(ctx context.Context, domain string, opts *Options) (core.SyncStatus, error) {
+	ctx, span := tracer.Start(ctx, "Client.GetSyncStatus")
+	defer span.End()
+
+	if !c.IsOnline(domain) {
+		return core.SyncStatus{}, fmt.Errorf("Domain is offline")
+	}
+
+	url := "https://" + domain + "/api/v1/repositories/sync"
+	span.SetAttributes(attribute.String("url", url))
+
+	response, err := httpRequest[core.SyncStatus](ctx, &c.client, "GET", url, "", opts)
+	if err != nil {
+		span.RecordError(err)
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			c.lastFailed[domain] = time.Now()
+		}
+
+		return core.SyncStatus{}, err
+	}
+
+	return *response, nil
+}
+
+func (c *client) PerformSync(ctx context.Context, domain string, opts *Options) (core.SyncStatus, error) {
+	ctx, span := tracer.Start(ctx, "Client.PerformSync")
+	defer span.End()
+
+	if !c.IsOnline(domain) {
+		return core.SyncStatus{}, fmt.Errorf("Domain is offline")
+	}
+
+	url := "https://" + domain + "/api/v1/repositories/sync"
+	span.SetAttributes(attribute.String("url", url))
+
+	response, err := httpRequest[core.SyncStatus](ctx, &c.client, "POST", url, "", opts)
+	if err != nil {
+		span.RecordError(err)
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			c.lastFailed[domain] = time.Now()
+		}
+
+		return core.SyncStatus{}, err
+	}
+
+	return *response, nil
+}
@@ -103,6 +103,21 @@ func (mr *MockClientMockRecorder) GetChunkItrs(ctx, domain, timelines, epoch, op
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChunkItrs", reflect.TypeOf((*MockClient)(nil).GetChunkItrs), ctx, domain, timelines, epoch, opts)
 }
 
+// GetChunkLength mocks base method.
+func (m *MockClient) GetChunkLength(ctx context.Context, domain string, opts *client.Options) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChunkLength", ctx, domain, opts)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetChunkLength indicates an expected call of GetChunkLength.
+func (mr *MockClientMockRecorder) GetChunkLength(ctx, domain, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChunkLength", reflect.TypeOf((*MockClient)(nil).GetChunkLength), ctx, domain, opts)
+}
+
 // GetChunks mocks base method.
 func (m *MockClient) GetChunks(ctx context.Context, domain string, timelines []string, queryTime time.Time, opts *client.Options) (map[string]core.Chunk, error) {
 	m.ctrl.T.Helper()
@@ -193,6 +208,36 @@ func (mr *MockClientMockRecorder) GetProfile(ctx, domain, address, opts any) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProfile", reflect.TypeOf((*MockClient)(nil).GetProfile), ctx, domain, address, opts)
 }
 
+// GetRepository mocks base method.
+func (m *MockClient) GetRepository(ctx context.Context, domain string, opts *client.Options) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRepository", ctx, domain, opts)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRepository indicates an expected call of GetRepository.
+func (mr *MockClientMockRecorder) GetRepository(ctx, domain, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRepository", reflect.TypeOf((*MockClient)(nil).GetRepository), ctx, domain, opts)
+}
+
+// GetRepositoryHead mocks base method.
+func (m *MockClient) GetRepositoryHead(ctx context.Context, domain, owner string, opts *client.Options) (core.CommitHead, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRepositoryHead", ctx, domain, owner, opts)
+	ret0, _ := ret[0].(core.CommitHead)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRepositoryHead indicates an expected call of GetRepositoryHead.
+func (mr *MockClientMockRecorder) GetRepositoryHead(ctx, domain, owner, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRepositoryHead", reflect.TypeOf((*MockClient)(nil).GetRepositoryHead), ctx, domain, owner, opts)
+}
+
 // GetRetracted mocks base method.
 func (m *MockClient) GetRetracted(ctx context.Context, domain string, timelines []string, opts *client.Options) (map[string][]string, error) {
 	m.ctrl.T.Helper()
@@ -208,6 +253,36 @@ func (mr *MockClientMockRecorder) GetRetracted(ctx, domain, timelines, opts any)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRetracted", reflect.TypeOf((*MockClient)(nil).GetRetracted), ctx, domain, timelines, opts)
 }
 
+// GetRevocations mocks base method.
+func (m *MockClient) GetRevocations(ctx context.Context, domain string, since time.Time, opts *client.Options) (core.RevocationFeed, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRevocations", ctx, domain, since, opts)
+	ret0, _ := ret[0].(core.RevocationFeed)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRevocations indicates an expected call of GetRevocations.
+func (mr *MockClientMockRecorder) GetRevocations(ctx, domain, since, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRevocations", reflect.TypeOf((*MockClient)(nil).GetRevocations), ctx, domain, since, opts)
+}
+
+// GetSyncStatus mocks base method.
+func (m *MockClient) GetSyncStatus(ctx context.Context, domain string, opts *client.Options) (core.SyncStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSyncStatus", ctx, domain, opts)
+	ret0, _ := ret[0].(core.SyncStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSyncStatus indicates an expected call of GetSyncStatus.
+func (mr *MockClientMockRecorder) GetSyncStatus(ctx, domain, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSyncStatus", reflect.TypeOf((*MockClient)(nil).GetSyncStatus), ctx, domain, opts)
+}
+
 // GetTimeline mocks base method.
 func (m *MockClient) GetTimeline(ctx context.Context, domain, id string, opts *client.Options) (core.Timeline, error) {
 	m.ctrl.T.Helper()
@@ -222,3 +297,18 @@ func (mr *MockClientMockRecorder) GetTimeline(ctx, domain, id, opts any) *gomock
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTimeline", reflect.TypeOf((*MockClient)(nil).GetTimeline), ctx, domain, id, opts)
 }
+
+// PerformSync mocks base method.
+func (m *MockClient) PerformSync(ctx context.Context, domain string, opts *client.Options) (core.SyncStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PerformSync", ctx, domain, opts)
+	ret0, _ := ret[0].(core.SyncStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PerformSync indicates an expected call of PerformSync.
+func (mr *MockClientMockRecorder) PerformSync(ctx, domain, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PerformSync", reflect.TypeOf((*MockClient)(nil).PerformSync), ctx, domain, opts)
+}
@@ -8,9 +8,11 @@ import (
 )
 
 type Config struct {
-	Server  Server           `yaml:"server"`
-	Concrnt core.ConfigInput `yaml:"concrnt"`
-	Profile Profile          `yaml:"profile"`
+	Server   Server              `yaml:"server"`
+	Concrnt  core.ConfigInput    `yaml:"concrnt"`
+	Profile  Profile             `yaml:"profile"`
+	Realtime core.RealtimeConfig `yaml:"realtime"`
+	Reactor  core.ReactorConfig  `yaml:"reactor"`
 }
 
 type Server struct {
@@ -23,6 +25,8 @@ type Server struct {
 	RepositoryPath string `yaml:"repositoryPath"`
 	CaptchaSitekey string `yaml:"captchaSitekey"`
 	CaptchaSecret  string `yaml:"captchaSecret"`
+	ChunkCache     string `yaml:"chunkCache"`     // memcached (default), redis or lru
+	ChunkCacheSize int    `yaml:"chunkCacheSize"` // max entries of the lru chunk cache
 }
 
 type BuildInfo struct {
@@ -45,6 +49,7 @@ type Profile struct {
 	Version      string    `yaml:"version" json:"version"`
 	BuildInfo    BuildInfo `yaml:"buildInfo" json:"buildInfo"`
 	SiteKey      string    `yaml:"captchaSiteKey" json:"captchaSiteKey"`
+	ChunkLength  int64     `yaml:"chunkLength" json:"chunkLength"`
 }
 
 // Load loads config from given path
@@ -23,6 +23,7 @@ import (
 	"github.com/totegamma/concurrent/client"
 	"github.com/totegamma/concurrent/core"
 	"github.com/totegamma/concurrent/x/ack"
+	"github.com/totegamma/concurrent/x/activitypub"
 	"github.com/totegamma/concurrent/x/association"
 	"github.com/totegamma/concurrent/x/auth"
 	"github.com/totegamma/concurrent/x/domain"
@@ -30,7 +31,9 @@ import (
 	"github.com/totegamma/concurrent/x/job"
 	"github.com/totegamma/concurrent/x/key"
 	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/policy"
 	"github.com/totegamma/concurrent/x/profile"
+	"github.com/totegamma/concurrent/x/revocation"
 	"github.com/totegamma/concurrent/x/store"
 	"github.com/totegamma/concurrent/x/subscription"
 	"github.com/totegamma/concurrent/x/timeline"
@@ -177,6 +180,8 @@ func main() {
 		&core.Domain{},
 		&core.Entity{},
 		&core.EntityMeta{},
+		&core.RecoveryApproval{},
+		&core.Revocation{},
 		&core.Ack{},
 		&core.Key{},
 		&core.UserKV{},
@@ -186,16 +191,32 @@ func main() {
 		&core.Job{},
 		&core.CommitLog{},
 		&core.CommitOwner{},
+		&core.MessageIndex{},
+		&core.LocalPolicy{},
+		&core.ApKey{},
+		&core.ApFollower{},
+		&core.ApObjectReference{},
 	)
 
 	if err != nil {
 		panic("failed to migrate schema: " + err.Error())
 	}
 
+	// full-text search indexes
+	db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_message_indices_text_trgm ON message_indices USING gin (text gin_trgm_ops)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_message_indices_text_tsv ON message_indices USING gin (to_tsvector('simple', text))")
+
 	// migration from 1.3.2 to 1.3.3
 	db.Model(&core.Timeline{}).Where("owner IS NULL or domain_owned = true").Update("owner", gorm.Expr("CASE WHEN domain_owned THEN ? ELSE author END", conconf.CSID))
 	db.Model(&core.Subscription{}).Where("owner IS NULL or domain_owned = true").Update("owner", gorm.Expr("CASE WHEN domain_owned THEN ? ELSE author END", conconf.CSID))
 
+	// ハッシュチェーン導入前のコミットにチェーンを付ける
+	err = store.BackfillCommitChain(context.Background(), db)
+	if err != nil {
+		panic("failed to backfill commit chain: " + err.Error())
+	}
+
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     config.Server.RedisAddr,
 		Password: "", // no password set
@@ -218,11 +239,44 @@ func main() {
 	defer mc.Close()
 
 	client := client.NewClient()
-	timelineKeeper := timeline.NewKeeper(rdb, mc, client, conconf)
+
+	// 失効・未登録のオペレーターキーでは署名しない。使えるものがなければドメインの鍵で署名する
+	// サービスは設定をコピーして持つので、組み立てる前に絞り込む
+	if len(conconf.OperatorKeys) > 0 {
+		operatorKeyService := concurrent.SetupKeyService(db, rdb, mc, client, conconf)
+		usable := make([]core.OperatorKey, 0, len(conconf.OperatorKeys))
+		for _, operatorKey := range conconf.OperatorKeys {
+			keys, err := operatorKeyService.GetKeyResolution(context.Background(), operatorKey.ID)
+			if err == nil {
+				err = key.ValidateOperatorKey(keys, operatorKey.ID, conconf.CSID, time.Now())
+			}
+			if err != nil {
+				slog.Warn(
+					fmt.Sprintf("operator key %s is not usable: %v", operatorKey.ID, err),
+					slog.String("module", "main"),
+				)
+				continue
+			}
+			usable = append(usable, operatorKey)
+		}
+
+		if len(usable) == 0 {
+			slog.Warn(
+				fmt.Sprintf("no operator key is enacted under %s. falling back to the domain key", conconf.CSID),
+				slog.String("module", "main"),
+			)
+		}
+		conconf.OperatorKeys = usable
+	}
+	chunkCache, err := timeline.NewChunkCache(config.Server.ChunkCache, rdb, mc, config.Server.ChunkCacheSize)
+	if err != nil {
+		panic("failed to setup chunk cache: " + err.Error())
+	}
+	timelineKeeper := timeline.NewKeeper(rdb, chunkCache, client, conconf)
 
 	globalPolicy := concurrent.GetDefaultGlobalPolicy()
 
-	policy := concurrent.SetupPolicyService(rdb, globalPolicy, conconf)
+	policyService := concurrent.SetupPolicyService(db, rdb, globalPolicy, conconf)
 
 	domainService := concurrent.SetupDomainService(db, client, conconf)
 	domainHandler := domain.NewHandler(domainService)
@@ -230,39 +284,48 @@ func main() {
 	userKvService := concurrent.SetupUserkvService(db)
 	userkvHandler := userkv.NewHandler(userKvService)
 
-	messageService := concurrent.SetupMessageService(db, rdb, mc, timelineKeeper, client, policy, conconf)
+	messageService := concurrent.SetupMessageService(db, rdb, mc, chunkCache, timelineKeeper, client, policyService, conconf)
 	messageHandler := message.NewHandler(messageService)
 
-	associationService := concurrent.SetupAssociationService(db, rdb, mc, timelineKeeper, client, policy, conconf)
+	associationService := concurrent.SetupAssociationService(db, rdb, mc, chunkCache, timelineKeeper, client, policyService, conconf)
 	associationHandler := association.NewHandler(associationService)
 
-	profileService := concurrent.SetupProfileService(db, rdb, mc, client, policy, conconf)
+	profileService := concurrent.SetupProfileService(db, rdb, mc, client, policyService, conconf)
 	profileHandler := profile.NewHandler(profileService)
 
-	timelineService := concurrent.SetupTimelineService(db, rdb, mc, timelineKeeper, client, policy, conconf)
-	timelineHandler := timeline.NewHandler(timelineService)
+	timelineService := concurrent.SetupTimelineService(db, rdb, mc, chunkCache, timelineKeeper, client, policyService, conconf)
 
-	entityService := concurrent.SetupEntityService(db, rdb, mc, client, policy, conconf)
+	entityService := concurrent.SetupEntityService(db, rdb, mc, client, policyService, conconf)
 	entityHandler := entity.NewHandler(entityService)
+	policyHandler := policy.NewHandler(policyService, entityService)
 
-	authService := concurrent.SetupAuthService(db, rdb, mc, client, policy, conconf)
+	authService := concurrent.SetupAuthService(db, rdb, mc, client, policyService, conconf)
 	authHandler := auth.NewHandler(authService)
 
+	timelineHandler := timeline.NewHandler(timelineService, authService, conconf, config.Realtime)
+
 	keyService := concurrent.SetupKeyService(db, rdb, mc, client, conconf)
 	keyHandler := key.NewHandler(keyService)
 
-	ackService := concurrent.SetupAckService(db, rdb, mc, client, policy, conconf)
+	revocationService := concurrent.SetupRevocationService(db, rdb, client, conconf)
+	revocationHandler := revocation.NewHandler(revocationService)
+
+	ackService := concurrent.SetupAckService(db, rdb, mc, client, policyService, conconf)
 	ackHandler := ack.NewHandler(ackService)
 
-	storeService := concurrent.SetupStoreService(db, rdb, mc, timelineKeeper, client, policy, conconf, config.Server.RepositoryPath)
+	storeService := concurrent.SetupStoreService(db, rdb, mc, chunkCache, timelineKeeper, client, policyService, conconf, config.Server.RepositoryPath)
 	storeHandler := store.NewHandler(storeService)
 
-	subscriptionService := concurrent.SetupSubscriptionService(db, rdb, mc, client, policy, conconf)
+	subscriptionService := concurrent.SetupSubscriptionService(db, rdb, mc, client, policyService, conconf)
 	subscriptionHandler := subscription.NewHandler(subscriptionService)
 
-	jobService := concurrent.SetupJobService(db)
+	jobService := concurrent.SetupJobService(db, conconf)
 	jobHandler := job.NewHandler(jobService)
-	jobReactor := job.NewReactor(storeService, jobService)
+	activitypubService := concurrent.SetupActivitypubService(db, rdb, mc, chunkCache, timelineKeeper, client, policyService, conconf, config.Server.RepositoryPath)
+	activitypubHandler := activitypub.NewHandler(activitypubService)
+
+	jobReactor := job.NewReactor(storeService, jobService, entityService, client, conconf, config.Reactor)
+	jobReactor.RegisterHandler(core.JobTypeApDeliver, activitypubService.Deliver, job.HandlerOptions{MaxAttempts: 8, BaseBackoff: time.Minute, MaxBackoff: 6 * time.Hour})
 
 	// migration from 1.3.2 to 1.3.3
 	var remotes []core.Domain
@@ -295,6 +358,7 @@ func main() {
 			GoVersion:    goVersion,
 		}
 		meta.SiteKey = config.Server.CaptchaSitekey
+		meta.ChunkLength = conconf.ChunkLength
 
 		return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": core.Domain{
 			ID:        conconf.FQDN,
@@ -316,6 +380,7 @@ func main() {
 
 	// message
 	apiV1.GET("/message/:id", messageHandler.Get)
+	apiV1.GET("/messages/search", messageHandler.Search)
 	apiV1.GET("/message/:id/associations", associationHandler.GetFiltered)
 	apiV1.GET("/message/:id/associationcounts", associationHandler.GetCounts)
 	apiV1.GET("/message/:id/associations/mine", associationHandler.GetOwnByTarget, auth.Restrict(auth.ISKNOWN))
@@ -340,6 +405,7 @@ func main() {
 	apiV1.GET("/timelines/chunks", timelineHandler.GetChunks)
 	apiV1.GET("/timelines/retracted", timelineHandler.Retracted)
 	apiV1.GET("/timelines/realtime", timelineHandler.Realtime)
+	apiV1.GET("/timelines/realtime/sse", timelineHandler.RealtimeSSE)
 
 	// chunk
 	apiV1.GET("/chunks/itr", timelineHandler.GetChunkItr)
@@ -351,6 +417,10 @@ func main() {
 
 	// auth
 	apiV1.GET("/auth/passport", authHandler.GetPassport, auth.Restrict(auth.ISLOCAL))
+	apiV1.POST("/auth/passport/revoke", authHandler.RevokePassport, auth.Restrict(auth.ISLOCAL))
+
+	// revocation
+	apiV1.GET("/revocations", revocationHandler.GetFeed)
 
 	// key
 	apiV1.GET("/key/:id", keyHandler.GetKeyResolution)
@@ -364,14 +434,33 @@ func main() {
 	// storage
 	apiV1.GET("/repository", storeHandler.Get, auth.Restrict(auth.ISREGISTERED))
 	apiV1.POST("/repository", storeHandler.Post, auth.Restrict(auth.ISLOCAL))
+	apiV1.GET("/repository/head", storeHandler.GetHead)
 	apiV1.GET("/repositories/sync", storeHandler.GetSyncStatus, auth.Restrict(auth.ISREGISTERED))
 	apiV1.POST("/repositories/sync", storeHandler.PerformSync, auth.Restrict(auth.ISREGISTERED))
 
 	// job
 	apiV1.GET("/jobs", jobHandler.List, auth.Restrict(auth.ISREGISTERED))
+	apiV1.GET("/jobs/system", jobHandler.ListSystem, auth.Restrict(auth.ISADMIN))
 	apiV1.POST("/jobs", jobHandler.Create, auth.Restrict(auth.ISREGISTERED))
 	apiV1.DELETE("/job/:id", jobHandler.Cancel, auth.Restrict(auth.ISREGISTERED))
 
+	// policy
+	apiV1.GET("/policies", policyHandler.List)
+	apiV1.POST("/policies", policyHandler.Upload, auth.Restrict(auth.ISADMIN))
+	apiV1.GET("/policies/:name", policyHandler.Get)
+	apiV1.GET("/policies/:name/document", policyHandler.GetDocument)
+	apiV1.GET("/policies/:name/versions", policyHandler.Versions)
+	apiV1.POST("/policy/explain", policyHandler.Explain, auth.Restrict(auth.ISREGISTERED))
+
+	// activitypub
+	apiV1.GET("/.well-known/webfinger", activitypubHandler.WebFinger)
+	apiV1.GET("/ap/acct/:id", activitypubHandler.Actor)
+	apiV1.GET("/ap/acct/:id/outbox", activitypubHandler.Outbox)
+	apiV1.GET("/ap/acct/:id/followers", activitypubHandler.Followers)
+	apiV1.POST("/ap/acct/:id/inbox", activitypubHandler.Inbox)
+	apiV1.POST("/ap/inbox", activitypubHandler.Inbox)
+	apiV1.GET("/ap/note/:id", activitypubHandler.Note)
+
 	// misc
 	e.GET("/health", func(c echo.Context) (err error) {
 		ctx := c.Request().Context()
@@ -407,56 +496,62 @@ func main() {
 	)
 	prometheus.MustRegister(resourceCountMetrics)
 
-	go func() {
-		for {
-			time.Sleep(15 * time.Second)
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			subscriptions, err := timelineService.ListTimelineSubscriptions(ctx)
-			if err != nil {
-				slog.Error(fmt.Sprintf("failed to list timeline subscriptions: %v", err))
-				continue
-			}
-			for timeline, count := range subscriptions {
-				timelineSubscriptionMetrics.WithLabelValues(timeline).Set(float64(count))
-			}
+	// ゲージはプロセスごとに持つので、ジョブではなく各プロセスで更新する
+	updateMetrics := func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
 
-			count, err := messageService.Count(ctx)
-			if err != nil {
-				slog.Error(fmt.Sprintf("failed to count messages: %v", err))
-				continue
-			}
-			resourceCountMetrics.WithLabelValues("message").Set(float64(count))
+		subscriptions, err := timelineService.ListTimelineSubscriptions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list timeline subscriptions: %w", err)
+		}
+		for timeline, count := range subscriptions {
+			timelineSubscriptionMetrics.WithLabelValues(timeline).Set(float64(count))
+		}
 
-			count, err = entityService.Count(ctx)
-			if err != nil {
-				slog.Error(fmt.Sprintf("failed to count entities: %v", err))
-				continue
-			}
-			resourceCountMetrics.WithLabelValues("entity").Set(float64(count))
+		count, err := messageService.Count(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count messages: %w", err)
+		}
+		resourceCountMetrics.WithLabelValues("message").Set(float64(count))
 
-			count, err = profileService.Count(ctx)
-			if err != nil {
-				slog.Error(fmt.Sprintf("failed to count profiles: %v", err))
-				continue
-			}
-			resourceCountMetrics.WithLabelValues("profile").Set(float64(count))
+		count, err = entityService.Count(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count entities: %w", err)
+		}
+		resourceCountMetrics.WithLabelValues("entity").Set(float64(count))
 
-			count, err = associationService.Count(ctx)
-			if err != nil {
-				slog.Error(fmt.Sprintf("failed to count associations: %v", err))
-				continue
-			}
-			resourceCountMetrics.WithLabelValues("association").Set(float64(count))
+		count, err = profileService.Count(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count profiles: %w", err)
+		}
+		resourceCountMetrics.WithLabelValues("profile").Set(float64(count))
+
+		count, err = associationService.Count(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count associations: %w", err)
+		}
+		resourceCountMetrics.WithLabelValues("association").Set(float64(count))
 
-			count, err = timelineService.Count(ctx)
+		count, err = timelineService.Count(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count timelines: %w", err)
+		}
+		resourceCountMetrics.WithLabelValues("timeline").Set(float64(count))
+
+		timelineService.UpdateMetrics()
+
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := updateMetrics(context.Background())
 			if err != nil {
-				slog.Error(fmt.Sprintf("failed to count timelines: %v", err))
-				continue
+				slog.Error(fmt.Sprintf("failed to update metrics: %v", err))
 			}
-			resourceCountMetrics.WithLabelValues("timeline").Set(float64(count))
-
-			timelineService.UpdateMetrics()
 		}
 	}()
 
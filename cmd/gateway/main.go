@@ -212,7 +212,7 @@ func main() {
 
 	client := client.NewClient()
 	globalPolicy := concurrent.GetDefaultGlobalPolicy()
-	policy := concurrent.SetupPolicyService(rdb, globalPolicy, conconf)
+	policy := concurrent.SetupPolicyService(db, rdb, globalPolicy, conconf)
 	authService := concurrent.SetupAuthService(db, rdb, mc, client, policy, conconf)
 
 	e.Use(authService.IdentifyIdentity)
@@ -24,6 +24,9 @@ const (
 	CaptchaVerifiedHeader       = "cc-captcha-verified"
 )
 
+// SubscriptionChangedChannelPrefix is the redis channel prefix notified when the items of a subscription are changed
+const SubscriptionChangedChannelPrefix = "concrnt:subscription:changed:"
+
 type CommitMode int
 
 const (
@@ -44,6 +47,24 @@ const (
 	PolicyEvalResultError
 )
 
+func (r PolicyEvalResult) String() string {
+	switch r {
+	case PolicyEvalResultDefault:
+		return "default"
+	case PolicyEvalResultNever:
+		return "never"
+	case PolicyEvalResultDeny:
+		return "deny"
+	case PolicyEvalResultAllow:
+		return "allow"
+	case PolicyEvalResultAlways:
+		return "always"
+	case PolicyEvalResultError:
+		return "error"
+	}
+	return "unknown"
+}
+
 const (
 	Unknown = iota
 	LocalUser
@@ -1,13 +1,29 @@
 package core
 
 import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+
 	"github.com/cosmos/cosmos-sdk/codec/address"
 	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/pkg/errors"
 	"gitlab.com/yawning/secp256k1-voi/secec"
+	"golang.org/x/crypto/ripemd160"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -17,60 +33,462 @@ func GetHash(bytes []byte) []byte {
 	return hash.Sum(nil)
 }
 
+const (
+	// AlgorithmSecp256k1 is keccak256 + recoverable secp256k1 signature (r||s||v). used when no algorithm is given
+	AlgorithmSecp256k1 = "secp256k1"
+	// AlgorithmEd25519 signature is public key (32 bytes) || signature (64 bytes)
+	AlgorithmEd25519 = "ed25519"
+	// AlgorithmP256 signature is compressed public key (33 bytes) || r || s over sha256 of the message
+	AlgorithmP256 = "p256"
+	// AlgorithmWebAuthn signature is a JSON encoded WebAuthnAssertion whose challenge is sha256 of the message
+	AlgorithmWebAuthn = "webauthn"
+)
+
+// Signer signs messages with a private key of an algorithm
+type Signer interface {
+	Algorithm() string
+	Sign(message []byte) ([]byte, error)
+	// Address returns the address of the key with the human readable part (con, ccs, cck)
+	Address(hrp string) (string, error)
+}
+
+// Verifier verifies signatures of an algorithm against an address
+type Verifier interface {
+	Algorithm() string
+	Verify(message, signature []byte, address string) error
+}
+
+var (
+	signersMu sync.RWMutex
+	signers   = map[string]func(privateKey string) (Signer, error){}
+
+	verifiersMu sync.RWMutex
+	verifiers   = map[string]Verifier{}
+)
+
+func init() {
+	RegisterSigner(AlgorithmSecp256k1, NewSecp256k1Signer)
+	RegisterSigner(AlgorithmEd25519, NewEd25519Signer)
+	RegisterSigner(AlgorithmP256, NewP256Signer)
+
+	RegisterVerifier(secp256k1Verifier{})
+	RegisterVerifier(ed25519Verifier{})
+	RegisterVerifier(p256Verifier{})
+	RegisterVerifier(webAuthnVerifier{})
+}
+
+// RegisterSigner registers a signer constructor for the algorithm. privateKey is hex encoded.
+func RegisterSigner(algorithm string, factory func(privateKey string) (Signer, error)) {
+	signersMu.Lock()
+	defer signersMu.Unlock()
+	signers[algorithm] = factory
+}
+
+// RegisterVerifier registers the verifier for its algorithm
+func RegisterVerifier(verifier Verifier) {
+	verifiersMu.Lock()
+	defer verifiersMu.Unlock()
+	verifiers[verifier.Algorithm()] = verifier
+}
+
+// NewSigner creates a signer of the algorithm. An empty algorithm means secp256k1.
+func NewSigner(algorithm, privateKey string) (Signer, error) {
+	if algorithm == "" {
+		algorithm = AlgorithmSecp256k1
+	}
+
+	signersMu.RLock()
+	factory, ok := signers[algorithm]
+	signersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", algorithm)
+	}
+
+	return factory(privateKey)
+}
+
+// GetVerifier returns the verifier of the algorithm. An empty algorithm means secp256k1.
+func GetVerifier(algorithm string) (Verifier, error) {
+	if algorithm == "" {
+		algorithm = AlgorithmSecp256k1
+	}
+
+	verifiersMu.RLock()
+	verifier, ok := verifiers[algorithm]
+	verifiersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", algorithm)
+	}
+
+	return verifier, nil
+}
+
 func SignBytes(bytes []byte, privatekey string) ([]byte, error) {
+	signer, err := NewSecp256k1Signer(privatekey)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(bytes)
+}
 
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write(bytes)
-	hashed := hash.Sum(nil)
+// VerifySignature verifies the secp256k1 signature of the message
+func VerifySignature(message []byte, signature []byte, address string) error {
+	return VerifySignatureWithAlgorithm(message, signature, address, AlgorithmSecp256k1)
+}
+
+// VerifySignatureWithAlgorithm verifies the signature with the verifier of the algorithm
+func VerifySignatureWithAlgorithm(message []byte, signature []byte, address, algorithm string) error {
+	verifier, err := GetVerifier(algorithm)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(message, signature, address)
+}
+
+// VerifyDocumentSignature verifies the hex encoded signature of the document
+// with the algorithm declared in the document
+func VerifyDocumentSignature(document, signature, address string) error {
+	var base DocumentBase[any]
+	err := json.Unmarshal([]byte(document), &base)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal document")
+	}
+
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
 
-	key, err := crypto.HexToECDSA(privatekey)
+	return VerifySignatureWithAlgorithm([]byte(document), signatureBytes, address, base.Algorithm)
+}
+
+func hrpOf(address string) (string, error) {
+	if len(address) < 3 {
+		return "", fmt.Errorf("invalid address: %s", address)
+	}
+	return address[:3], nil
+}
+
+func matchAddress(pubkey []byte, address string) error {
+	hrp, err := hrpOf(address)
+	if err != nil {
+		return err
+	}
+
+	sigaddr, err := PubkeyBytesToAddr(pubkey, hrp)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert public key to address")
+	}
+
+	if sigaddr != address {
+		return errors.New("signature is not matched with address. expected: " + address + ", actual: " + sigaddr)
+	}
+
+	return nil
+}
+
+// secp256k1
+
+type secp256k1Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func NewSecp256k1Signer(privateKey string) (Signer, error) {
+	key, err := crypto.HexToECDSA(privateKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to convert private key")
 	}
+	return secp256k1Signer{key}, nil
+}
 
-	signature, err := crypto.Sign(hashed, key)
+func (s secp256k1Signer) Algorithm() string { return AlgorithmSecp256k1 }
+
+func (s secp256k1Signer) Sign(message []byte) ([]byte, error) {
+	signature, err := crypto.Sign(GetHash(message), s.key)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to sign message")
 	}
-
 	return signature, nil
 }
 
-func VerifySignature(message []byte, signature []byte, address string) error {
+func (s secp256k1Signer) Address(hrp string) (string, error) {
+	return PubkeyBytesToAddr(crypto.CompressPubkey(&s.key.PublicKey), hrp)
+}
 
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write(message)
-	hashed := hash.Sum(nil)
+type secp256k1Verifier struct{}
+
+func (secp256k1Verifier) Algorithm() string { return AlgorithmSecp256k1 }
 
-	recoveredPub, err := crypto.Ecrecover(hashed, signature)
+func (secp256k1Verifier) Verify(message, signature []byte, address string) error {
+	recoveredPub, err := crypto.Ecrecover(GetHash(message), signature)
 	if err != nil {
 		return errors.Wrap(err, "failed to recover public key")
 	}
 
 	seckey, err := secec.NewPublicKey(recoveredPub)
 	if err != nil {
-		panic(err)
+		return errors.Wrap(err, "invalid recovered public key")
 	}
-	compressed := seckey.CompressedBytes()
-	hrp := address[:3]
-	sigaddr, err := PubkeyBytesToAddr(compressed, hrp)
+
+	return matchAddress(seckey.CompressedBytes(), address)
+}
+
+// ed25519
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer creates a signer from the hex encoded 32 bytes seed or 64 bytes private key
+func NewEd25519Signer(privateKey string) (Signer, error) {
+	keyBytes, err := hex.DecodeString(privateKey)
 	if err != nil {
-		return errors.Wrap(err, "failed to convert public key to address")
+		return nil, errors.Wrap(err, "failed to decode private key")
+	}
+	switch len(keyBytes) {
+	case ed25519.SeedSize:
+		return ed25519Signer{ed25519.NewKeyFromSeed(keyBytes)}, nil
+	case ed25519.PrivateKeySize:
+		return ed25519Signer{ed25519.PrivateKey(keyBytes)}, nil
+	default:
+		return nil, fmt.Errorf("invalid ed25519 private key length: %d", len(keyBytes))
 	}
+}
 
-	if sigaddr != address {
-		return errors.New("signature is not matched with address. expected: " + address + ", actual: " + sigaddr)
+func (s ed25519Signer) Algorithm() string { return AlgorithmEd25519 }
+
+func (s ed25519Signer) Sign(message []byte) ([]byte, error) {
+	pubkey := s.key.Public().(ed25519.PublicKey)
+	return append(append([]byte{}, pubkey...), ed25519.Sign(s.key, message)...), nil
+}
+
+func (s ed25519Signer) Address(hrp string) (string, error) {
+	return PubkeyBytesToAddr(s.key.Public().(ed25519.PublicKey), hrp)
+}
+
+type ed25519Verifier struct{}
+
+func (ed25519Verifier) Algorithm() string { return AlgorithmEd25519 }
+
+func (ed25519Verifier) Verify(message, signature []byte, address string) error {
+	if len(signature) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return fmt.Errorf("invalid ed25519 signature length: %d", len(signature))
 	}
 
-	return nil
+	pubkey := ed25519.PublicKey(signature[:ed25519.PublicKeySize])
+	if !ed25519.Verify(pubkey, message, signature[ed25519.PublicKeySize:]) {
+		return errors.New("invalid ed25519 signature")
+	}
+
+	return matchAddress(pubkey, address)
 }
 
-func PubkeyBytesToAddr(pubkeyBytes []byte, hrp string) (string, error) {
-	pubkey := secp256k1.PubKey{
-		Key: pubkeyBytes,
+// p256
+
+type p256Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewP256Signer creates a signer from the hex encoded 32 bytes scalar
+func NewP256Signer(privateKey string) (Signer, error) {
+	keyBytes, err := hex.DecodeString(privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode private key")
+	}
+	key, err := ecdh.P256().NewPrivateKey(keyBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid p256 private key")
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), key.PublicKey().Bytes())
+	return p256Signer{&ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		D:         new(big.Int).SetBytes(keyBytes),
+	}}, nil
+}
+
+func (s p256Signer) Algorithm() string { return AlgorithmP256 }
+
+func (s p256Signer) Sign(message []byte) ([]byte, error) {
+	hashed := sha256.Sum256(message)
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, hashed[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign message")
+	}
+
+	signature := elliptic.MarshalCompressed(elliptic.P256(), s.key.X, s.key.Y)
+	signature = append(signature, r.FillBytes(make([]byte, 32))...)
+	signature = append(signature, sig.FillBytes(make([]byte, 32))...)
+	return signature, nil
+}
+
+func (s p256Signer) Address(hrp string) (string, error) {
+	return PubkeyBytesToAddr(elliptic.MarshalCompressed(elliptic.P256(), s.key.X, s.key.Y), hrp)
+}
+
+// parseP256PublicKey parses a SEC1 compressed or uncompressed P-256 public key
+func parseP256PublicKey(pubkey []byte) (*ecdsa.PublicKey, []byte, error) {
+	var x, y *big.Int
+	switch len(pubkey) {
+	case 33:
+		x, y = elliptic.UnmarshalCompressed(elliptic.P256(), pubkey)
+	case 65:
+		x, y = elliptic.Unmarshal(elliptic.P256(), pubkey)
+	}
+	if x == nil {
+		return nil, nil, errors.New("invalid p256 public key")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, elliptic.MarshalCompressed(elliptic.P256(), x, y), nil
+}
+
+type p256Verifier struct{}
+
+func (p256Verifier) Algorithm() string { return AlgorithmP256 }
+
+func (p256Verifier) Verify(message, signature []byte, address string) error {
+	if len(signature) != 33+64 {
+		return fmt.Errorf("invalid p256 signature length: %d", len(signature))
+	}
+
+	pubkey, compressed, err := parseP256PublicKey(signature[:33])
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256(message)
+	r := new(big.Int).SetBytes(signature[33:65])
+	s := new(big.Int).SetBytes(signature[65:])
+	if !ecdsa.Verify(pubkey, hashed[:], r, s) {
+		return errors.New("invalid p256 signature")
+	}
+
+	return matchAddress(compressed, address)
+}
+
+// webauthn
+
+// WebAuthnAssertion is the signature of AlgorithmWebAuthn.
+// The authenticator signs authenticatorData || sha256(clientDataJSON) and
+// the challenge in clientDataJSON must be base64url(sha256(message)).
+type WebAuthnAssertion struct {
+	PublicKey         string `json:"publicKey"`         // hex encoded SEC1 P-256 public key
+	AuthenticatorData string `json:"authenticatorData"` // base64url
+	ClientDataJSON    string `json:"clientDataJSON"`    // base64url
+	Signature         string `json:"signature"`         // base64url, ASN.1 DER
+}
+
+type webAuthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// checkWebAuthnOrigin checks that the origin is a secure context and that rpIdHash is the hash of
+// its host or one of its parent domains, i.e. the assertion was made for the relying party of the origin.
+func checkWebAuthnOrigin(origin string, rpIDHash []byte) error {
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid origin: %s", origin)
+	}
+	host := u.Hostname()
+	if u.Scheme != "https" && !(u.Scheme == "http" && host == "localhost") {
+		return fmt.Errorf("insecure origin: %s", origin)
 	}
 
-	account := sdk.AccAddress(pubkey.Address())
+	labels := strings.Split(host, ".")
+	for i := range labels {
+		// トップレベルドメインだけをrpIdにはできない
+		if i > 0 && i == len(labels)-1 {
+			break
+		}
+		hash := sha256.Sum256([]byte(strings.Join(labels[i:], ".")))
+		if bytes.Equal(hash[:], rpIDHash) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("rpIdHash is not matched with the origin: %s", origin)
+}
+
+type webAuthnVerifier struct{}
+
+func (webAuthnVerifier) Algorithm() string { return AlgorithmWebAuthn }
+
+func (webAuthnVerifier) Verify(message, signature []byte, address string) error {
+	var assertion WebAuthnAssertion
+	err := json.Unmarshal(signature, &assertion)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal webauthn assertion")
+	}
+
+	pubkeyBytes, err := hex.DecodeString(assertion.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode public key")
+	}
+	pubkey, compressed, err := parseP256PublicKey(pubkeyBytes)
+	if err != nil {
+		return err
+	}
+
+	authenticatorData, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion.AuthenticatorData, "="))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode authenticator data")
+	}
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion.ClientDataJSON, "="))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode client data")
+	}
+	der, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion.Signature, "="))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	// rpIdHash (32) || flags (1) || signCount (4)
+	if len(authenticatorData) < 37 {
+		return errors.New("invalid authenticator data")
+	}
+	if authenticatorData[32]&0x01 == 0 {
+		return errors.New("user presence is not asserted")
+	}
+
+	var clientData webAuthnClientData
+	err = json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal client data")
+	}
+	if clientData.Type != "webauthn.get" {
+		return fmt.Errorf("invalid client data type: %s", clientData.Type)
+	}
+	if clientData.CrossOrigin {
+		return errors.New("cross origin assertion is not allowed")
+	}
+	err = checkWebAuthnOrigin(clientData.Origin, authenticatorData[:32])
+	if err != nil {
+		return err
+	}
+
+	challenge := sha256.Sum256(message)
+	if strings.TrimRight(clientData.Challenge, "=") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		return errors.New("challenge is not matched with the message")
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+	if !ecdsa.VerifyASN1(pubkey, signed[:], der) {
+		return errors.New("invalid webauthn signature")
+	}
+
+	return matchAddress(compressed, address)
+}
+
+// PubkeyBytesToAddr returns the bech32 address of ripemd160(sha256(pubkey)).
+// The public key is the compressed form for ECDSA keys and the raw 32 bytes for ed25519.
+func PubkeyBytesToAddr(pubkeyBytes []byte, hrp string) (string, error) {
+	sha := sha256.Sum256(pubkeyBytes)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	account := sdk.AccAddress(hasher.Sum(nil))
+
 	cdc := address.NewBech32Codec(hrp)
 	addr, err := cdc.BytesToString(account)
 	if err != nil {
@@ -116,6 +534,20 @@ func SetupConfig(base ConfigInput) Config {
 		panic(err)
 	}
 
+	operatorKeys := make([]OperatorKey, 0, len(base.OperatorKeys))
+	for _, operatorKey := range base.OperatorKeys {
+		operatorKeyID, err := PrivKeyToAddr(operatorKey, "cck")
+		if err != nil {
+			panic(err)
+		}
+		operatorKeys = append(operatorKeys, OperatorKey{ID: operatorKeyID, PrivateKey: operatorKey})
+	}
+
+	chunkLength := base.ChunkLength
+	if chunkLength <= 0 {
+		chunkLength = DefaultChunkLength
+	}
+
 	return Config{
 		FQDN:         base.FQDN,
 		PrivateKey:   base.PrivateKey,
@@ -124,5 +556,18 @@ func SetupConfig(base ConfigInput) Config {
 		Dimension:    base.Dimension,
 		CCID:         ccid,
 		CSID:         csid,
+		OperatorKeys: operatorKeys,
+		ChunkLength:  chunkLength,
+		HomeFeed:     base.HomeFeed,
+	}
+}
+
+// DomainSigningKey returns the key id and the private key to sign documents of the CSID with.
+// When operator keys are configured, documents are signed by the first one as a subkey of the CSID
+// so that a compromised operator key can be revoked without regenerating the CSID.
+func (c Config) DomainSigningKey() (keyID, privateKey string) {
+	if len(c.OperatorKeys) > 0 {
+		return c.OperatorKeys[0].ID, c.OperatorKeys[0].PrivateKey
 	}
+	return "", c.PrivateKey
 }
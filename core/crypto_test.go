@@ -0,0 +1,161 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecp256k1Key = "1236a0ab4fd2e3e5bbf7ae6cc9c1f4b1e7e0b3e1c2d3e4f5a6b7c8d9e0f1a2b3"
+
+func TestSignerRoundTrip(t *testing.T) {
+	message := []byte(`{"signer":"con1...","type":"message"}`)
+
+	keys := map[string]string{
+		AlgorithmSecp256k1: testSecp256k1Key,
+		AlgorithmEd25519:   "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+		AlgorithmP256:      "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721",
+	}
+
+	for algorithm, key := range keys {
+		signer, err := NewSigner(algorithm, key)
+		if assert.NoError(t, err, algorithm) {
+			address, err := signer.Address("con")
+			assert.NoError(t, err)
+
+			signature, err := signer.Sign(message)
+			assert.NoError(t, err)
+
+			assert.NoError(t, VerifySignatureWithAlgorithm(message, signature, address, algorithm), algorithm)
+			assert.Error(t, VerifySignatureWithAlgorithm([]byte("tampered"), signature, address, algorithm), algorithm)
+		}
+	}
+
+	_, err := NewSigner("rsa", "")
+	assert.Error(t, err)
+	_, err = GetVerifier("rsa")
+	assert.Error(t, err)
+}
+
+func TestVerifySignatureDoesNotPanic(t *testing.T) {
+	signer, err := NewSigner(AlgorithmSecp256k1, testSecp256k1Key)
+	assert.NoError(t, err)
+	address, err := signer.Address("con")
+	assert.NoError(t, err)
+
+	assert.NotPanics(t, func() {
+		assert.Error(t, VerifySignature([]byte("message"), make([]byte, 65), address))
+		assert.Error(t, VerifySignature([]byte("message"), []byte{0x01}, address))
+	})
+}
+
+func TestVerifyDocumentSignature(t *testing.T) {
+	signer, err := NewSigner(AlgorithmEd25519, "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	assert.NoError(t, err)
+	address, err := signer.Address("con")
+	assert.NoError(t, err)
+
+	document := `{"signer":"` + address + `","type":"message","algorithm":"ed25519"}`
+	signature, err := signer.Sign([]byte(document))
+	assert.NoError(t, err)
+
+	assert.NoError(t, VerifyDocumentSignature(document, hex.EncodeToString(signature), address))
+
+	// アルゴリズムを省略するとsecp256k1として扱う
+	legacy := `{"signer":"` + address + `","type":"message"}`
+	signature, err = signer.Sign([]byte(legacy))
+	assert.NoError(t, err)
+	assert.Error(t, VerifyDocumentSignature(legacy, hex.EncodeToString(signature), address))
+}
+
+func TestWebAuthnVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	pubkey := elliptic.MarshalCompressed(elliptic.P256(), key.X, key.Y)
+	address, err := PubkeyBytesToAddr(pubkey, "con")
+	assert.NoError(t, err)
+
+	message := []byte(`{"signer":"` + address + `","type":"message","algorithm":"webauthn"}`)
+	challenge := sha256.Sum256(message)
+
+	build := func(flags byte, clientType, origin string) []byte {
+		rpIDHash := sha256.Sum256([]byte("example.com"))
+		authenticatorData := append(rpIDHash[:], flags, 0, 0, 0, 1)
+		clientDataJSON, _ := json.Marshal(webAuthnClientData{
+			Type:      clientType,
+			Challenge: base64.RawURLEncoding.EncodeToString(challenge[:]),
+			Origin:    origin,
+		})
+
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signed := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+		der, _ := ecdsa.SignASN1(rand.Reader, key, signed[:])
+
+		signature, _ := json.Marshal(WebAuthnAssertion{
+			PublicKey:         hex.EncodeToString(pubkey),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authenticatorData),
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			Signature:         base64.RawURLEncoding.EncodeToString(der),
+		})
+		return signature
+	}
+
+	assert.NoError(t, VerifySignatureWithAlgorithm(message, build(0x05, "webauthn.get", "https://example.com"), address, AlgorithmWebAuthn))
+	assert.NoError(t, VerifySignatureWithAlgorithm(message, build(0x05, "webauthn.get", "https://app.example.com:8443"), address, AlgorithmWebAuthn))
+	assert.Error(t, VerifySignatureWithAlgorithm([]byte("other"), build(0x05, "webauthn.get", "https://example.com"), address, AlgorithmWebAuthn))
+	assert.Error(t, VerifySignatureWithAlgorithm(message, build(0x04, "webauthn.get", "https://example.com"), address, AlgorithmWebAuthn)) // UPフラグなし
+	assert.Error(t, VerifySignatureWithAlgorithm(message, build(0x05, "webauthn.create", "https://example.com"), address, AlgorithmWebAuthn))
+
+	// rpIdHashとoriginが一致しないものは拒否
+	assert.Error(t, VerifySignatureWithAlgorithm(message, build(0x05, "webauthn.get", "https://evil.example.net"), address, AlgorithmWebAuthn))
+	assert.Error(t, VerifySignatureWithAlgorithm(message, build(0x05, "webauthn.get", "http://example.com"), address, AlgorithmWebAuthn))
+	assert.Error(t, VerifySignatureWithAlgorithm(message, build(0x05, "webauthn.get", ""), address, AlgorithmWebAuthn))
+}
+
+func TestCheckWebAuthnOrigin(t *testing.T) {
+	hash := func(rpID string) []byte {
+		h := sha256.Sum256([]byte(rpID))
+		return h[:]
+	}
+
+	assert.NoError(t, checkWebAuthnOrigin("https://example.com", hash("example.com")))
+	assert.NoError(t, checkWebAuthnOrigin("https://a.b.example.com", hash("b.example.com")))
+	assert.NoError(t, checkWebAuthnOrigin("http://localhost:3000", hash("localhost")))
+	assert.Error(t, checkWebAuthnOrigin("https://example.com", hash("com")))
+	assert.Error(t, checkWebAuthnOrigin("https://example.com", hash("a.example.com")))
+	assert.Error(t, checkWebAuthnOrigin("http://example.com", hash("example.com")))
+}
+
+func TestDomainSigningKey(t *testing.T) {
+	operatorKey := "3fcfac6c211b743975de2d7b3f622c12694b8125daf4013562c5a1aefa3253a5"
+	standbyKey := "1ca30329e8d35217b2328bacfc21c5e3d762713edab0252eead1f4c1ac0b4d81"
+
+	config := SetupConfig(ConfigInput{
+		PrivateKey:   testSecp256k1Key,
+		OperatorKeys: []string{operatorKey, standbyKey},
+	})
+	operatorKeyID, err := PrivKeyToAddr(operatorKey, "cck")
+	assert.NoError(t, err)
+	if assert.Len(t, config.OperatorKeys, 2) {
+		assert.Equal(t, operatorKeyID, config.OperatorKeys[0].ID)
+	}
+
+	// 先頭のオペレーターキーで署名する
+	keyID, privateKey := config.DomainSigningKey()
+	assert.Equal(t, config.OperatorKeys[0].ID, keyID)
+	assert.Equal(t, operatorKey, privateKey)
+
+	// 使えるキーがなければドメインの鍵で署名する
+	config.OperatorKeys = nil
+	keyID, privateKey = config.DomainSigningKey()
+	assert.Equal(t, "", keyID)
+	assert.Equal(t, testSecp256k1Key, privateKey)
+}
@@ -15,9 +15,9 @@ type Key struct {
 	Root            string    `json:"root" gorm:"type:char(42)"`
 	Parent          string    `json:"parent" gorm:"type:char(42)"`
 	EnactDocument   string    `json:"enactDocument" gorm:"type:json"`
-	EnactSignature  string    `json:"enactSignature" gorm:"type:char(130)"`
+	EnactSignature  string    `json:"enactSignature" gorm:"type:text"`
 	RevokeDocument  *string   `json:"revokeDocument" gorm:"type:json;default:null"`
-	RevokeSignature *string   `json:"revokeSignature" gorm:"type:char(130);default:null"`
+	RevokeSignature *string   `json:"revokeSignature" gorm:"type:text;default:null"`
 	ValidSince      time.Time `json:"validSince" gorm:"type:timestamp with time zone"`
 	ValidUntil      time.Time `json:"validUntil" gorm:"type:timestamp with time zone"`
 }
@@ -27,7 +27,7 @@ type SemanticID struct {
 	Owner     string    `json:"owner" gorm:"primaryKey;type:char(42)"`
 	Target    string    `json:"target" gorm:"type:char(27)"`
 	Document  string    `json:"document" gorm:"type:json"`
-	Signature string    `json:"signature" gorm:"type:char(130)"`
+	Signature string    `json:"signature" gorm:"type:text"`
 	CDate     time.Time `json:"cdate" gorm:"->;<-:create;autoCreateTime"`
 	MDate     time.Time `json:"mdate" gorm:"autoUpdateTime"`
 }
@@ -44,7 +44,7 @@ type Association struct {
 	Variant   string         `json:"variant" gorm:"type:text"`
 	Unique    string         `json:"unique" gorm:"type:char(32);uniqueIndex:uniq_association"`
 	Document  string         `json:"document" gorm:"type:json"`
-	Signature string         `json:"signature" gorm:"type:char(130)"`
+	Signature string         `json:"signature" gorm:"type:text"`
 	CDate     time.Time      `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	Timelines pq.StringArray `json:"timelines" gorm:"type:text[]"`
 }
@@ -57,7 +57,7 @@ type Profile struct {
 	SchemaID     uint          `json:"-"`
 	Schema       string        `json:"schema" gorm:"-"`
 	Document     string        `json:"document" gorm:"type:json"`
-	Signature    string        `json:"signature" gorm:"type:char(130)"`
+	Signature    string        `json:"signature" gorm:"type:text"`
 	Associations []Association `json:"associations,omitempty" gorm:"-"`
 	PolicyID     uint          `json:"-"`
 	Policy       string        `json:"policy,omitempty" gorm:"-"`
@@ -69,18 +69,57 @@ type Profile struct {
 // Entity is one of a concurrent base object
 // mutable
 type Entity struct {
-	ID                   string    `json:"ccid" gorm:"type:char(42)"`
-	Domain               string    `json:"domain" gorm:"type:text"`
-	Tag                  string    `json:"tag" gorm:"type:text;"`
-	Score                int       `json:"score" gorm:"type:integer;default:0"`
-	IsScoreFixed         bool      `json:"isScoreFixed" gorm:"type:boolean;default:false"`
-	AffiliationDocument  string    `json:"affiliationDocument" gorm:"type:json"`
-	AffiliationSignature string    `json:"affiliationSignature" gorm:"type:char(130)"`
-	TombstoneDocument    *string   `json:"tombstoneDocument" gorm:"type:json;default:null"`
-	TombstoneSignature   *string   `json:"tombstoneSignature" gorm:"type:char(130);default:null"`
-	Alias                *string   `json:"alias,omitempty" gorm:"type:text"`
-	CDate                time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
-	MDate                time.Time `json:"mdate" gorm:"autoUpdateTime"`
+	ID                   string     `json:"ccid" gorm:"type:char(42)"`
+	Domain               string     `json:"domain" gorm:"type:text"`
+	Tag                  string     `json:"tag" gorm:"type:text;"`
+	Score                int        `json:"score" gorm:"type:integer;default:0"`
+	IsScoreFixed         bool       `json:"isScoreFixed" gorm:"type:boolean;default:false"`
+	AffiliationDocument  string     `json:"affiliationDocument" gorm:"type:json"`
+	AffiliationSignature string     `json:"affiliationSignature" gorm:"type:text"`
+	TombstoneDocument    *string    `json:"tombstoneDocument" gorm:"type:json;default:null"`
+	TombstoneSignature   *string    `json:"tombstoneSignature" gorm:"type:text;default:null"`
+	Alias                *string    `json:"alias,omitempty" gorm:"type:text"`
+	GuardianDocument     *string    `json:"guardianDocument,omitempty" gorm:"type:json;default:null"`
+	GuardianSignature    *string    `json:"guardianSignature,omitempty" gorm:"type:text;default:null"`
+	MasterKey            *string    `json:"masterKey,omitempty" gorm:"type:char(42);default:null"` // set when the entity is recovered by guardians
+	RecoveredAt          *time.Time `json:"recoveredAt,omitempty" gorm:"type:timestamp with time zone;default:null"`
+	RecoveryProof        *string    `json:"recoveryProof,omitempty" gorm:"type:json;default:null"` // []RecoveryProof. lets remotes verify MasterKey
+	CDate                time.Time  `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+	MDate                time.Time  `json:"mdate" gorm:"autoUpdateTime"`
+}
+
+const (
+	RevocationTypePassport = "passport" // target: jti
+	RevocationTypeEntity   = "entity"   // target: ccid
+	RevocationTypeKey      = "key"      // target: key id
+)
+
+// Revocation invalidates passports issued by this domain
+// immutable
+type Revocation struct {
+	Type      string    `json:"type" gorm:"primaryKey;type:text"`
+	Target    string    `json:"target" gorm:"primaryKey;type:text"`
+	RevokedAt time.Time `json:"revokedAt" gorm:"type:timestamp with time zone;index"`
+	CDate     time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp();index"` // when it is published. feeds are paged by this
+}
+
+// RecoveryApproval is a pending recover document of a guardian
+// one approval per guardian. replaced when the guardian signs a new one
+type RecoveryApproval struct {
+	Target    string    `json:"target" gorm:"primaryKey;type:char(42)"`
+	Guardian  string    `json:"guardian" gorm:"primaryKey;type:char(42)"`
+	NewKey    string    `json:"newKey" gorm:"type:char(42)"`
+	Document  string    `json:"document" gorm:"type:json"`
+	Signature string    `json:"signature" gorm:"type:text"`
+	SignedAt  time.Time `json:"signedAt" gorm:"type:timestamp with time zone"`
+}
+
+// RecoveryProof is the guardian document and the approvals that rebound an entity to a new master key.
+// one proof per recovery, in order
+type RecoveryProof struct {
+	GuardianDocument  string             `json:"guardianDocument"`
+	GuardianSignature string             `json:"guardianSignature"`
+	Approvals         []RecoveryApproval `json:"approvals"`
 }
 
 type EntityMeta struct {
@@ -117,13 +156,62 @@ type Message struct {
 	PolicyParams    *string        `json:"policyParams,omitempty" gorm:"type:json"`
 	PolicyDefaults  *string        `json:"policyDefaults,omitempty" gorm:"type:json"`
 	Document        string         `json:"document" gorm:"type:json"`
-	Signature       string         `json:"signature" gorm:"type:char(130)"`
+	Signature       string         `json:"signature" gorm:"type:text"`
 	CDate           time.Time      `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	Associations    []Association  `json:"associations,omitempty" gorm:"-"`
 	OwnAssociations []Association  `json:"ownAssociations,omitempty" gorm:"-"`
 	Timelines       pq.StringArray `json:"timelines" gorm:"type:text[]"`
 }
 
+// MessageIndex is a full-text search entry of a message
+// immutable
+type MessageIndex struct {
+	MessageID string         `json:"messageID" gorm:"primaryKey;type:char(26)"`
+	Author    string         `json:"author" gorm:"type:char(42);index"`
+	Timelines pq.StringArray `json:"timelines" gorm:"type:text[]"`
+	Text      string         `json:"text" gorm:"type:text"`
+	CDate     time.Time      `json:"cdate" gorm:"type:timestamp with time zone;not null;default:clock_timestamp();index"`
+}
+
+// LocalPolicy is a policy document hosted by this domain and referenced as "local:<name>"
+// immutable. uploading the same name creates a new version
+type LocalPolicy struct {
+	Name     string    `json:"name" gorm:"primaryKey;type:text"`
+	Version  int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Document string    `json:"document" gorm:"type:json"`
+	Author   string    `json:"author" gorm:"type:char(42)"`
+	CDate    time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
+// ApKey is the RSA key pair that signs ActivityPub requests on behalf of a local entity
+// immutable
+type ApKey struct {
+	ID         string    `json:"id" gorm:"primaryKey;type:char(42)"`
+	PrivateKey string    `json:"-" gorm:"type:text"`
+	PublicKey  string    `json:"publicKey" gorm:"type:text"`
+	CDate      time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
+// ApFollower is a remote ActivityPub actor following a local entity
+// immutable
+type ApFollower struct {
+	ID     string    `json:"id" gorm:"primaryKey;type:text"` // id of the Follow activity
+	Actor  string    `json:"actor" gorm:"type:text;uniqueIndex:uniq_ap_follower"`
+	Inbox  string    `json:"inbox" gorm:"type:text"`
+	Target string    `json:"target" gorm:"type:char(42);uniqueIndex:uniq_ap_follower"`
+	CDate  time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
+// ApObjectReference maps an ActivityPub object to the concurrent resource created from it.
+// CcObjectID is empty while the object is being processed
+// mutable
+type ApObjectReference struct {
+	ApObjectID string    `json:"apObjectID" gorm:"primaryKey;type:text"`
+	CcObjectID string    `json:"ccObjectID" gorm:"type:char(27)"`
+	Actor      string    `json:"actor" gorm:"type:text"`
+	CDate      time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
 // Timeline is one of a base object of concurrent
 // mutable
 type Timeline struct {
@@ -137,7 +225,7 @@ type Timeline struct {
 	Policy       string    `json:"policy,omitempty" gorm:"-"`
 	PolicyParams *string   `json:"policyParams,omitempty" gorm:"type:json"`
 	Document     string    `json:"document" gorm:"type:json"`
-	Signature    string    `json:"signature" gorm:"type:char(130)"`
+	Signature    string    `json:"signature" gorm:"type:text"`
 	CDate        time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	MDate        time.Time `json:"mdate" gorm:"autoUpdateTime"`
 
@@ -160,7 +248,7 @@ type Ack struct {
 	From      string `json:"from" gorm:"primaryKey;type:char(42)"`
 	To        string `json:"to" gorm:"primaryKey;type:char(42)"`
 	Document  string `json:"document" gorm:"type:json"`
-	Signature string `json:"signature" gorm:"type:char(130)"`
+	Signature string `json:"signature" gorm:"type:text"`
 	Valid     bool   `json:"valid" gorm:"type:boolean;default:false"`
 }
 
@@ -176,7 +264,7 @@ type Subscription struct {
 	Policy       string             `json:"policy,omitempty" gorm:"-"`
 	PolicyParams *string            `json:"policyParams,omitempty" gorm:"type:json"`
 	Document     string             `json:"document" gorm:"type:json"`
-	Signature    string             `json:"signature" gorm:"type:char(130)"`
+	Signature    string             `json:"signature" gorm:"type:text"`
 	Items        []SubscriptionItem `json:"items" gorm:"foreignKey:Subscription"`
 	CDate        time.Time          `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	MDate        time.Time          `json:"mdate" gorm:"autoUpdateTime"`
@@ -211,17 +299,25 @@ type Job struct {
 	Type        string    `json:"type" gorm:"type:text"`
 	Payload     string    `json:"payload" gorm:"type:json"`
 	Scheduled   time.Time `json:"scheduled" gorm:"type:timestamp with time zone"`
-	Status      string    `json:"status" gorm:"type:text"` // pending, running, completed, failed
+	Cron        string    `json:"cron,omitempty" gorm:"type:text"`     // 5 field cron expression for recurring jobs
+	Interval    string    `json:"interval,omitempty" gorm:"type:text"` // duration string (e.g. "15s") for recurring jobs
+	Status      string    `json:"status" gorm:"type:text"`             // pending, running, completed, failed, dead, canceled
 	Result      string    `json:"result" gorm:"type:text"`
+	Attempts    int       `json:"attempts" gorm:"type:integer;default:0"`
+	LeaseUntil  time.Time `json:"leaseUntil" gorm:"type:timestamp with time zone"`
 	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime"`
 	CompletedAt time.Time `json:"completedAt" gorm:"autoUpdateTime"`
 	TraceID     string    `json:"traceID" gorm:"type:text"`
 }
 
+// CommitOwner links a commit to its owner.
+// Non-ephemeral commits form a hash chain per owner: Hash covers Previous and the commit itself.
 type CommitOwner struct {
 	ID          uint   `json:"id" gorm:"primaryKey;auto_increment"`
 	CommitLogID uint   `json:"commitLogID" gorm:"index;uniqueIndex:idx_commit_owner"`
 	Owner       string `json:"owner" gorm:"type:char(42);index;uniqueIndex:idx_commit_owner"`
+	Previous    string `json:"previous" gorm:"type:char(64)"`
+	Hash        string `json:"hash" gorm:"type:char(64)"`
 }
 
 type CommitLog struct {
@@ -231,7 +327,7 @@ type CommitLog struct {
 	IsEphemeral  bool          `json:"isEphemeral" gorm:"type:boolean;default:false"`
 	Type         string        `json:"type" gorm:"type:text"`
 	Document     string        `json:"document" gorm:"type:json"`
-	Signature    string        `json:"signature" gorm:"type:char(130)"`
+	Signature    string        `json:"signature" gorm:"type:text"`
 	SignedAt     time.Time     `json:"signedAt" gorm:"type:timestamp with time zone;not null;default:clock_timestamp()"`
 	CommitOwners []CommitOwner `json:"commitOwners" gorm:"foreignKey:CommitLogID"`
 	Owners       []string      `json:"owners" gorm:"-"`
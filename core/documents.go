@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"slices"
 	"time"
 )
 
@@ -15,6 +17,7 @@ type DocumentBase[T any] struct {
 	PolicyParams   string    `json:"policyParams,omitempty"`
 	PolicyDefaults string    `json:"policyDefaults,omitempty"`
 	KeyID          string    `json:"keyID,omitempty"`
+	Algorithm      string    `json:"algorithm,omitempty"` // signature algorithm. empty means secp256k1
 	Body           T         `json:"body,omitempty"`
 	Meta           any       `json:"meta,omitempty"`
 	SemanticID     string    `json:"semanticID,omitempty"`
@@ -32,6 +35,20 @@ type TombstoneDocument struct { // type: tombstone
 	DocumentBase[any]
 }
 
+// GuardianDocument registers the guardians who can recover the entity. signed by the master key
+type GuardianDocument struct { // type: guardian
+	DocumentBase[any]
+	Guardians []string `json:"guardians"`
+	Threshold int      `json:"threshold"`
+}
+
+// RecoverDocument is a guardian's approval to rebind the target entity to a new master key
+type RecoverDocument struct { // type: recover
+	DocumentBase[any]
+	Target string `json:"target"`
+	NewKey string `json:"newKey"` // address of the new master key (cck...)
+}
+
 // ack
 type AckDocument struct { // type: ack
 	DocumentBase[any]
@@ -72,9 +89,44 @@ type ProfileDocument[T any] struct { // type: profile
 // key
 type EnactDocument struct { // type: enact
 	DocumentBase[any]
-	Target string `json:"target"`
-	Root   string `json:"root"`
-	Parent string `json:"parent"`
+	Target     string     `json:"target"`
+	Root       string     `json:"root"`
+	Parent     string     `json:"parent"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"` // 省略時は無期限
+	Scope      *KeyScope  `json:"scope,omitempty"`      // 省略時は制限なし
+}
+
+// KeyScope restricts what a subkey may sign. Empty fields are not restricted.
+// Timelines are compared literally with the timelines written in the document.
+type KeyScope struct {
+	Types     []string `json:"types,omitempty"`
+	Schemas   []string `json:"schemas,omitempty"`
+	Timelines []string `json:"timelines,omitempty"`
+}
+
+// Allows checks whether a document of docType and schema posted to timelines is in the scope
+func (s *KeyScope) Allows(docType, schema string, timelines []string) error {
+	if s == nil {
+		return nil
+	}
+
+	if len(s.Types) > 0 && !slices.Contains(s.Types, docType) {
+		return fmt.Errorf("document type %s is out of the key scope", docType)
+	}
+
+	if len(s.Schemas) > 0 && !slices.Contains(s.Schemas, schema) {
+		return fmt.Errorf("schema %s is out of the key scope", schema)
+	}
+
+	if len(s.Timelines) > 0 {
+		for _, timeline := range timelines {
+			if !slices.Contains(s.Timelines, timeline) {
+				return fmt.Errorf("timeline %s is out of the key scope", timeline)
+			}
+		}
+	}
+
+	return nil
 }
 
 type RevokeDocument struct { // type: revoke
@@ -114,11 +166,31 @@ type UnsubscribeDocument struct { // type: unsubscribe
 	Target       string `json:"target"`
 }
 
+// PassportLifetime is how long a passport is accepted after it is signed.
+// revocations of passports are dropped from the feeds once it has passed
+const PassportLifetime = 24 * time.Hour
+
 type PassportDocument struct {
 	DocumentBase[any]
-	Domain string `json:"domain"`
-	Entity Entity `json:"entity"`
-	Keys   []Key  `json:"keys"`
+	JTI        string     `json:"jti,omitempty"`
+	Domain     string     `json:"domain"`
+	Entity     Entity     `json:"entity"`
+	Keys       []Key      `json:"keys"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"` // the earliest expiry of the keys. 省略時はPassportLifetimeのみ
+}
+
+// RevocationFeedDocument lists passports, entities and keys whose passports must no longer be accepted
+type RevocationFeedDocument struct { // type: revocations
+	DocumentBase[any]
+	Domain      string       `json:"domain"`
+	Revocations []Revocation `json:"revocations"`
+}
+
+type CommitHeadDocument struct { // type: commithead
+	DocumentBase[any]
+	Owner      string `json:"owner"`
+	Head       string `json:"head"`
+	DocumentID string `json:"documentID"`
 }
 
 type EventDocument struct { // type: event
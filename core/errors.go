@@ -23,16 +23,30 @@ func NewErrorAlreadyExists() ErrorAlreadyExists {
 }
 
 type ErrorPermissionDenied struct {
+	Statement string // name of the policy statement that denied the request. optional
 }
 
 func (e ErrorPermissionDenied) Error() string {
+	if e.Statement != "" {
+		return "Permission Denied: " + e.Statement
+	}
 	return "Permission Denied"
 }
 
+// Is makes errors.Is match regardless of the statement
+func (e ErrorPermissionDenied) Is(target error) bool {
+	_, ok := target.(ErrorPermissionDenied)
+	return ok
+}
+
 func NewErrorPermissionDenied() ErrorPermissionDenied {
 	return ErrorPermissionDenied{}
 }
 
+func NewErrorPermissionDeniedByStatement(statement string) ErrorPermissionDenied {
+	return ErrorPermissionDenied{Statement: statement}
+}
+
 type ErrorAlreadyDeleted struct {
 }
 
@@ -43,3 +57,15 @@ func (e ErrorAlreadyDeleted) Error() string {
 func NewErrorAlreadyDeleted() ErrorAlreadyDeleted {
 	return ErrorAlreadyDeleted{}
 }
+
+// ErrorLeaseLost is returned when a job is updated by a worker that no longer holds its lease
+type ErrorLeaseLost struct {
+}
+
+func (e ErrorLeaseLost) Error() string {
+	return "Lease Lost"
+}
+
+func NewErrorLeaseLost() ErrorLeaseLost {
+	return ErrorLeaseLost{}
+}
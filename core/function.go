@@ -4,31 +4,57 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	chunkLength = 600
+	// DefaultChunkLength is the chunk length in seconds used when none is configured
+	DefaultChunkLength int64 = 600
 )
 
-func Time2Chunk(t time.Time) string {
-	// chunk by 10 minutes
-	return fmt.Sprintf("%d", (t.Unix()/chunkLength)*chunkLength)
+// LocalChunkLength returns the chunk length of this domain in seconds.
+// Configs not built by SetupConfig fall back to DefaultChunkLength.
+func (c Config) LocalChunkLength() int64 {
+	if c.ChunkLength <= 0 {
+		return DefaultChunkLength
+	}
+	return c.ChunkLength
+}
+
+// Time2Chunk returns the local epoch that holds t
+func (c Config) Time2Chunk(t time.Time) string {
+	return Time2ChunkWithLength(t, c.LocalChunkLength())
 }
 
-func NextChunk(chunk string) string {
+// NextChunk returns the local epoch after chunk
+func (c Config) NextChunk(chunk string) string {
 	i, _ := strconv.ParseInt(chunk, 10, 64)
-	return fmt.Sprintf("%d", i+chunkLength)
+	return fmt.Sprintf("%d", i+c.LocalChunkLength())
+}
+
+// PrevChunk returns the local epoch before chunk
+func (c Config) PrevChunk(chunk string) string {
+	return PrevChunkWithLength(chunk, c.LocalChunkLength())
+}
+
+// Chunk2RecentTime returns the end of the local chunk
+func (c Config) Chunk2RecentTime(chunk string) time.Time {
+	return Chunk2RecentTimeWithLength(chunk, c.LocalChunkLength())
 }
 
-func PrevChunk(chunk string) string {
+func Time2ChunkWithLength(t time.Time, length int64) string {
+	return fmt.Sprintf("%d", (t.Unix()/length)*length)
+}
+
+func PrevChunkWithLength(chunk string, length int64) string {
 	i, _ := strconv.ParseInt(chunk, 10, 64)
-	return fmt.Sprintf("%d", i-chunkLength)
+	return fmt.Sprintf("%d", i-length)
 }
 
-func Chunk2RecentTime(chunk string) time.Time {
+func Chunk2RecentTimeWithLength(chunk string, length int64) time.Time {
 	i, _ := strconv.ParseInt(chunk, 10, 64)
-	return time.Unix(i+chunkLength, 0)
+	return time.Unix(i+length, 0)
 }
 
 func Chunk2ImmediateTime(chunk string) time.Time {
@@ -41,6 +67,58 @@ func EpochTime(epoch string) time.Time {
 	return time.Unix(i, 0)
 }
 
+// TranslateChunk returns the chunk of length `to` that holds the last second of the given chunk of length `from`
+func TranslateChunk(chunk string, from, to int64) string {
+	if from == to {
+		return chunk
+	}
+	return Time2ChunkWithLength(Chunk2RecentTimeWithLength(chunk, from).Add(-time.Second), to)
+}
+
+// ChunkLengthFromMeta reads the chunk length advertised in domain metadata.
+// Domains that do not advertise it use the default length.
+func ChunkLengthFromMeta(meta any) int64 {
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return DefaultChunkLength
+	}
+	switch v := m["chunkLength"].(type) {
+	case float64:
+		if v > 0 {
+			return int64(v)
+		}
+	case int64:
+		if v > 0 {
+			return v
+		}
+	case int:
+		if v > 0 {
+			return int64(v)
+		}
+	}
+	return DefaultChunkLength
+}
+
+// String encodes the cursor as "<unix micro>_<message id>"
+func (c SearchCursor) String() string {
+	return strconv.FormatInt(c.CDate.UnixMicro(), 10) + "_" + c.MessageID
+}
+
+// ParseSearchCursor decodes the cursor encoded by SearchCursor.String
+func ParseSearchCursor(cursor string) (SearchCursor, error) {
+	micro, id, found := strings.Cut(cursor, "_")
+	if !found || id == "" {
+		return SearchCursor{}, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	epoch, err := strconv.ParseInt(micro, 10, 64)
+	if err != nil {
+		return SearchCursor{}, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	return SearchCursor{CDate: time.UnixMicro(epoch), MessageID: id}, nil
+}
+
 func TypedIDToType(id string) string {
 	if len(id) != 27 {
 		return ""
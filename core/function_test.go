@@ -0,0 +1,51 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkLength(t *testing.T) {
+	config := Config{ChunkLength: 300}
+	assert.Equal(t, int64(300), config.LocalChunkLength())
+	assert.Equal(t, "1500", config.Time2Chunk(time.Unix(1799, 0)))
+	assert.Equal(t, "1800", config.NextChunk("1500"))
+	assert.Equal(t, "1200", config.PrevChunk("1500"))
+	assert.Equal(t, time.Unix(1800, 0), config.Chunk2RecentTime("1500"))
+
+	// 未設定ならデフォルト
+	assert.Equal(t, DefaultChunkLength, Config{}.LocalChunkLength())
+	assert.Equal(t, "1200", Config{}.Time2Chunk(time.Unix(1799, 0)))
+}
+
+func TestTranslateChunk(t *testing.T) {
+	assert.Equal(t, "1200", TranslateChunk("1200", 600, 600))
+	// 長いチャンクへはチャンク末尾を含むチャンクに
+	assert.Equal(t, "0", TranslateChunk("1200", 600, 3600))
+	assert.Equal(t, "3000", TranslateChunk("3000", 600, 1000))
+	// 短いチャンクへはチャンク末尾のチャンクに
+	assert.Equal(t, "1700", TranslateChunk("1200", 600, 100))
+}
+
+func TestChunkLengthFromMeta(t *testing.T) {
+	assert.Equal(t, int64(300), ChunkLengthFromMeta(map[string]any{"chunkLength": float64(300)}))
+	assert.Equal(t, DefaultChunkLength, ChunkLengthFromMeta(map[string]any{"nickname": "old domain"}))
+	assert.Equal(t, DefaultChunkLength, ChunkLengthFromMeta(map[string]any{"chunkLength": float64(-1)}))
+	assert.Equal(t, DefaultChunkLength, ChunkLengthFromMeta(nil))
+}
+
+func TestSearchCursor(t *testing.T) {
+	cursor := SearchCursor{CDate: time.UnixMicro(1700000000123456), MessageID: "m0123456789abcdefghijklmno"}
+
+	parsed, err := ParseSearchCursor(cursor.String())
+	assert.NoError(t, err)
+	assert.True(t, cursor.CDate.Equal(parsed.CDate))
+	assert.Equal(t, cursor.MessageID, parsed.MessageID)
+
+	_, err = ParseSearchCursor("1700000000")
+	assert.Error(t, err)
+	_, err = ParseSearchCursor("abc_m0123")
+	assert.Error(t, err)
+}
@@ -37,8 +37,11 @@ type AssociationService interface {
 
 type AuthService interface {
 	IssuePassport(ctx context.Context, requester string, key []Key) (string, error)
+	RevokePassport(ctx context.Context, requester, passport string) error
 	IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc
 	RateLimiter(configMap RateLimitConfigMap) echo.MiddlewareFunc
+	AcquireSlot(ctx context.Context, c echo.Context, name, connection string, limit int, ttl time.Duration) (bool, error)
+	ReleaseSlot(ctx context.Context, c echo.Context, name, connection string) error
 }
 
 type DomainService interface {
@@ -56,12 +59,15 @@ type DomainService interface {
 type EntityService interface {
 	Affiliation(ctx context.Context, mode CommitMode, document, signature, meta string) (Entity, error)
 	Tombstone(ctx context.Context, mode CommitMode, document, signature string) (Entity, error)
+	Guardian(ctx context.Context, mode CommitMode, document, signature string) (Entity, error)
+	Recover(ctx context.Context, mode CommitMode, document, signature string) (Entity, error)
 
 	Clean(ctx context.Context, ccid string) error
 	Get(ctx context.Context, ccid string) (Entity, error)
 	GetWithHint(ctx context.Context, ccid, hint string) (Entity, error)
 	GetMeta(ctx context.Context, ccid string) (EntityMeta, error)
 	GetByAlias(ctx context.Context, alias string) (Entity, error)
+	GetByMasterKey(ctx context.Context, masterKey string) (Entity, error)
 	List(ctx context.Context) ([]Entity, error)
 	UpdateScore(ctx context.Context, id string, score int) error
 	UpdateTag(ctx context.Context, id, tag string) error
@@ -75,7 +81,8 @@ type KeyService interface {
 	Enact(ctx context.Context, mode CommitMode, payload, signature string) (Key, error)
 	Revoke(ctx context.Context, mode CommitMode, payload, signature string) (Key, error)
 	Clean(ctx context.Context, ccid string) error
-	ResolveSubkey(ctx context.Context, keyID string) (string, error)
+	InvalidateAll(ctx context.Context, root string, at time.Time) error
+	ResolveSubkey(ctx context.Context, keyID string, at time.Time) (string, error)
 	GetKeyResolution(ctx context.Context, keyID string) ([]Key, error)
 	GetRemoteKeyResolution(ctx context.Context, remote string, keyID string) ([]Key, error)
 	GetAllKeys(ctx context.Context, owner string) ([]Key, error)
@@ -89,14 +96,22 @@ type MessageService interface {
 	Create(ctx context.Context, mode CommitMode, document string, signature string) (Message, []string, error)
 	Delete(ctx context.Context, mode CommitMode, document, signature string) (Message, []string, error)
 	Count(ctx context.Context) (int64, error)
+	Search(ctx context.Context, query, timeline, author string, cursor SearchCursor, limit int) ([]Message, *SearchCursor, error)
 }
 
 type PolicyService interface {
 	Test(ctx context.Context, policy Policy, context RequestContext, action string) (PolicyEvalResult, error)
 	TestWithPolicyURL(ctx context.Context, url string, context RequestContext, action string) (PolicyEvalResult, error)
 	TestWithGlobalPolicy(ctx context.Context, context RequestContext, action string) (PolicyEvalResult, error)
+	Validate(policy Policy) error
+	ValidateWithPolicyURL(ctx context.Context, url string) error
+	Explain(ctx context.Context, policy Policy, url string, context RequestContext, action string, override *map[string]bool) (PolicyExplanation, error)
 	Summerize(results []PolicyEvalResult, action string, overrides *map[string]bool) bool
 	AccumulateOr(results []PolicyEvalResult, action string, override *map[string]bool) PolicyEvalResult
+	UploadLocal(ctx context.Context, requester, name, document string) (LocalPolicy, error)
+	GetLocal(ctx context.Context, name string, version int) (LocalPolicy, error)
+	ListLocal(ctx context.Context) ([]LocalPolicy, error)
+	ListLocalVersions(ctx context.Context, name string) ([]LocalPolicy, error)
 }
 
 type ProfileService interface {
@@ -117,6 +132,13 @@ type SchemaService interface {
 	IDToUrl(ctx context.Context, id uint) (string, error)
 }
 
+type SearchService interface {
+	IndexMessage(ctx context.Context, message Message) error
+	RemoveMessage(ctx context.Context, id string) error
+	SearchMessages(ctx context.Context, query, timeline, author string, cursor SearchCursor, limit int) ([]MessageIndex, error)
+	Clean(ctx context.Context, ccid string) error
+}
+
 type SemanticIDService interface {
 	Name(ctx context.Context, id, owner, target, document, signature string) (SemanticID, error)
 	Lookup(ctx context.Context, id, owner string) (string, error)
@@ -137,6 +159,7 @@ type StoreService interface {
 	CleanUserAllData(ctx context.Context, target string) error
 	SyncCommitFile(ctx context.Context, owner string) (SyncStatus, error)
 	SyncStatus(ctx context.Context, owner string) (SyncStatus, error)
+	GetHead(ctx context.Context, owner string) (CommitHead, error)
 }
 
 type SubscriptionService interface {
@@ -150,6 +173,24 @@ type SubscriptionService interface {
 	GetOwnSubscriptions(ctx context.Context, owner string) ([]Subscription, error)
 }
 
+// ChunkCache stores chunk iterators and chunk bodies of timelines.
+// An iterator of (timeline, epoch) is the latest epoch at or before it that has items,
+// and a body is the items of the chunk in descending order.
+type ChunkCache interface {
+	// GetItrs returns the cached iterators. timelines not cached are omitted
+	GetItrs(ctx context.Context, timelines []string, epoch string) (map[string]string, error)
+	SetItr(ctx context.Context, timeline, epoch, itr string) error
+
+	// GetBodies returns the cached bodies of timeline -> epoch. timelines not cached are omitted
+	GetBodies(ctx context.Context, query map[string]string) (map[string][]TimelineItem, error)
+	SetBody(ctx context.Context, timeline, epoch string, items []TimelineItem) error
+	// PrependBody adds the item to the head of the body only when the body is cached
+	PrependBody(ctx context.Context, timeline, epoch string, item TimelineItem) error
+
+	// InvalidateItrs removes the iterators of the epochs. bodies are left as they are
+	InvalidateItrs(ctx context.Context, timeline string, epochs []string) error
+}
+
 type TimelineService interface {
 	UpsertTimeline(ctx context.Context, mode CommitMode, document, signature string) (Timeline, error)
 	DeleteTimeline(ctx context.Context, mode CommitMode, document string) (Timeline, error)
@@ -188,15 +229,28 @@ type TimelineService interface {
 
 	ListLocalRecentlyRemovedItems(ctx context.Context, timelines []string) (map[string][]string, error)
 
-	Realtime(ctx context.Context, request <-chan []string, response chan<- Event)
+	Realtime(ctx context.Context, request <-chan RealtimeRequest, response chan<- Event)
 
 	UpdateMetrics()
 }
 
 type JobService interface {
 	List(ctx context.Context, requester string) ([]Job, error)
+	ListSystem(ctx context.Context) ([]Job, error)
 	Create(ctx context.Context, requester, typ, payload string, scheduled time.Time) (Job, error)
-	Dequeue(ctx context.Context) (*Job, error)
-	Complete(ctx context.Context, id, status, result string) (Job, error)
-	Cancel(ctx context.Context, id string) (Job, error)
+	CreateRecurring(ctx context.Context, requester, typ, payload, cron, interval string) (Job, error)
+	EnsureRecurring(ctx context.Context, requester, typ, payload, cron, interval string) (Job, error)
+	Dequeue(ctx context.Context, types []string, lease time.Duration) (*Job, error)
+	Heartbeat(ctx context.Context, id string, attempt int, lease time.Duration) error
+	Complete(ctx context.Context, id string, attempt int, status, result string) (Job, error)
+	Retry(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (Job, error)
+	Reschedule(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (Job, error)
+	UpdateResult(ctx context.Context, id, result string) error
+	Cancel(ctx context.Context, requester, id string) (Job, error)
+}
+
+type RevocationService interface {
+	Publish(ctx context.Context, typ, target string, revokedAt time.Time) error
+	GetFeed(ctx context.Context, since time.Time) (RevocationFeed, error)
+	IsPassportRevoked(ctx context.Context, domain Domain, passport PassportDocument) (bool, error)
 }
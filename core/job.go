@@ -4,5 +4,26 @@ const (
 	JobStatusPending   = "pending"
 	JobStatusRunning   = "running"
 	JobStatusCompleted = "completed"
-	JobStatusFailed    = "failed"
+	JobStatusFailed    = "failed" // failed but will be retried
+	JobStatusDead      = "dead"   // failed and gave up
+	JobStatusCanceled  = "canceled"
 )
+
+// JobTypeScheduledCommit commits a message held by a commit with scheduledAt option
+const JobTypeScheduledCommit = "scheduledCommit"
+
+// ScheduledCommitPayload is the payload of the scheduled commit job
+type ScheduledCommitPayload struct {
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+	Option    string `json:"option,omitempty"`
+}
+
+// JobTypeApDeliver delivers a signed ActivityPub activity to a remote inbox
+const JobTypeApDeliver = "apDeliver"
+
+// ApDeliverPayload is the payload of the ActivityPub delivery job. the job author signs the activity
+type ApDeliverPayload struct {
+	Inbox    string `json:"inbox"`
+	Activity string `json:"activity"`
+}
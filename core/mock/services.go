@@ -351,6 +351,21 @@ func (m *MockAuthService) EXPECT() *MockAuthServiceMockRecorder {
 	return m.recorder
 }
 
+// AcquireSlot mocks base method.
+func (m *MockAuthService) AcquireSlot(ctx context.Context, c echo.Context, name, connection string, limit int, ttl time.Duration) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AcquireSlot", ctx, c, name, connection, limit, ttl)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AcquireSlot indicates an expected call of AcquireSlot.
+func (mr *MockAuthServiceMockRecorder) AcquireSlot(ctx, c, name, connection, limit, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcquireSlot", reflect.TypeOf((*MockAuthService)(nil).AcquireSlot), ctx, c, name, connection, limit, ttl)
+}
+
 // IdentifyIdentity mocks base method.
 func (m *MockAuthService) IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 	m.ctrl.T.Helper()
@@ -394,6 +409,34 @@ func (mr *MockAuthServiceMockRecorder) RateLimiter(configMap any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RateLimiter", reflect.TypeOf((*MockAuthService)(nil).RateLimiter), configMap)
 }
 
+// ReleaseSlot mocks base method.
+func (m *MockAuthService) ReleaseSlot(ctx context.Context, c echo.Context, name, connection string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseSlot", ctx, c, name, connection)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseSlot indicates an expected call of ReleaseSlot.
+func (mr *MockAuthServiceMockRecorder) ReleaseSlot(ctx, c, name, connection any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseSlot", reflect.TypeOf((*MockAuthService)(nil).ReleaseSlot), ctx, c, name, connection)
+}
+
+// RevokePassport mocks base method.
+func (m *MockAuthService) RevokePassport(ctx context.Context, requester, passport string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokePassport", ctx, requester, passport)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokePassport indicates an expected call of RevokePassport.
+func (mr *MockAuthServiceMockRecorder) RevokePassport(ctx, requester, passport any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokePassport", reflect.TypeOf((*MockAuthService)(nil).RevokePassport), ctx, requester, passport)
+}
+
 // MockDomainService is a mock of DomainService interface.
 type MockDomainService struct {
 	ctrl     *gomock.Controller
@@ -660,6 +703,21 @@ func (mr *MockEntityServiceMockRecorder) GetByAlias(ctx, alias any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByAlias", reflect.TypeOf((*MockEntityService)(nil).GetByAlias), ctx, alias)
 }
 
+// GetByMasterKey mocks base method.
+func (m *MockEntityService) GetByMasterKey(ctx context.Context, masterKey string) (core.Entity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByMasterKey", ctx, masterKey)
+	ret0, _ := ret[0].(core.Entity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByMasterKey indicates an expected call of GetByMasterKey.
+func (mr *MockEntityServiceMockRecorder) GetByMasterKey(ctx, masterKey any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByMasterKey", reflect.TypeOf((*MockEntityService)(nil).GetByMasterKey), ctx, masterKey)
+}
+
 // GetMeta mocks base method.
 func (m *MockEntityService) GetMeta(ctx context.Context, ccid string) (core.EntityMeta, error) {
 	m.ctrl.T.Helper()
@@ -690,6 +748,21 @@ func (mr *MockEntityServiceMockRecorder) GetWithHint(ctx, ccid, hint any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithHint", reflect.TypeOf((*MockEntityService)(nil).GetWithHint), ctx, ccid, hint)
 }
 
+// Guardian mocks base method.
+func (m *MockEntityService) Guardian(ctx context.Context, mode core.CommitMode, document, signature string) (core.Entity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Guardian", ctx, mode, document, signature)
+	ret0, _ := ret[0].(core.Entity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Guardian indicates an expected call of Guardian.
+func (mr *MockEntityServiceMockRecorder) Guardian(ctx, mode, document, signature any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Guardian", reflect.TypeOf((*MockEntityService)(nil).Guardian), ctx, mode, document, signature)
+}
+
 // IsUserExists mocks base method.
 func (m *MockEntityService) IsUserExists(ctx context.Context, user string) bool {
 	m.ctrl.T.Helper()
@@ -734,6 +807,21 @@ func (mr *MockEntityServiceMockRecorder) PullEntityFromRemote(ctx, id, domain an
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PullEntityFromRemote", reflect.TypeOf((*MockEntityService)(nil).PullEntityFromRemote), ctx, id, domain)
 }
 
+// Recover mocks base method.
+func (m *MockEntityService) Recover(ctx context.Context, mode core.CommitMode, document, signature string) (core.Entity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recover", ctx, mode, document, signature)
+	ret0, _ := ret[0].(core.Entity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recover indicates an expected call of Recover.
+func (mr *MockEntityServiceMockRecorder) Recover(ctx, mode, document, signature any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recover", reflect.TypeOf((*MockEntityService)(nil).Recover), ctx, mode, document, signature)
+}
+
 // Tombstone mocks base method.
 func (m *MockEntityService) Tombstone(ctx context.Context, mode core.CommitMode, document, signature string) (core.Entity, error) {
 	m.ctrl.T.Helper()
@@ -874,19 +962,33 @@ func (mr *MockKeyServiceMockRecorder) GetRemoteKeyResolution(ctx, remote, keyID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRemoteKeyResolution", reflect.TypeOf((*MockKeyService)(nil).GetRemoteKeyResolution), ctx, remote, keyID)
 }
 
+// InvalidateAll mocks base method.
+func (m *MockKeyService) InvalidateAll(ctx context.Context, root string, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InvalidateAll", ctx, root, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InvalidateAll indicates an expected call of InvalidateAll.
+func (mr *MockKeyServiceMockRecorder) InvalidateAll(ctx, root, at any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InvalidateAll", reflect.TypeOf((*MockKeyService)(nil).InvalidateAll), ctx, root, at)
+}
+
 // ResolveSubkey mocks base method.
-func (m *MockKeyService) ResolveSubkey(ctx context.Context, keyID string) (string, error) {
+func (m *MockKeyService) ResolveSubkey(ctx context.Context, keyID string, at time.Time) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ResolveSubkey", ctx, keyID)
+	ret := m.ctrl.Call(m, "ResolveSubkey", ctx, keyID, at)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ResolveSubkey indicates an expected call of ResolveSubkey.
-func (mr *MockKeyServiceMockRecorder) ResolveSubkey(ctx, keyID any) *gomock.Call {
+func (mr *MockKeyServiceMockRecorder) ResolveSubkey(ctx, keyID, at any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveSubkey", reflect.TypeOf((*MockKeyService)(nil).ResolveSubkey), ctx, keyID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveSubkey", reflect.TypeOf((*MockKeyService)(nil).ResolveSubkey), ctx, keyID, at)
 }
 
 // Revoke mocks base method.
@@ -1033,6 +1135,22 @@ func (mr *MockMessageServiceMockRecorder) GetWithOwnAssociations(ctx, id, reques
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithOwnAssociations", reflect.TypeOf((*MockMessageService)(nil).GetWithOwnAssociations), ctx, id, requester)
 }
 
+// Search mocks base method.
+func (m *MockMessageService) Search(ctx context.Context, query, timeline, author string, cursor core.SearchCursor, limit int) ([]core.Message, *core.SearchCursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", ctx, query, timeline, author, cursor, limit)
+	ret0, _ := ret[0].([]core.Message)
+	ret1, _ := ret[1].(*core.SearchCursor)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockMessageServiceMockRecorder) Search(ctx, query, timeline, author, cursor, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockMessageService)(nil).Search), ctx, query, timeline, author, cursor, limit)
+}
+
 // MockPolicyService is a mock of PolicyService interface.
 type MockPolicyService struct {
 	ctrl     *gomock.Controller
@@ -1070,6 +1188,66 @@ func (mr *MockPolicyServiceMockRecorder) AccumulateOr(results, action, override
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccumulateOr", reflect.TypeOf((*MockPolicyService)(nil).AccumulateOr), results, action, override)
 }
 
+// Explain mocks base method.
+func (m *MockPolicyService) Explain(ctx context.Context, policy core.Policy, url string, context core.RequestContext, action string, override *map[string]bool) (core.PolicyExplanation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Explain", ctx, policy, url, context, action, override)
+	ret0, _ := ret[0].(core.PolicyExplanation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Explain indicates an expected call of Explain.
+func (mr *MockPolicyServiceMockRecorder) Explain(ctx, policy, url, context, action, override any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Explain", reflect.TypeOf((*MockPolicyService)(nil).Explain), ctx, policy, url, context, action, override)
+}
+
+// GetLocal mocks base method.
+func (m *MockPolicyService) GetLocal(ctx context.Context, name string, version int) (core.LocalPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLocal", ctx, name, version)
+	ret0, _ := ret[0].(core.LocalPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLocal indicates an expected call of GetLocal.
+func (mr *MockPolicyServiceMockRecorder) GetLocal(ctx, name, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLocal", reflect.TypeOf((*MockPolicyService)(nil).GetLocal), ctx, name, version)
+}
+
+// ListLocal mocks base method.
+func (m *MockPolicyService) ListLocal(ctx context.Context) ([]core.LocalPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLocal", ctx)
+	ret0, _ := ret[0].([]core.LocalPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLocal indicates an expected call of ListLocal.
+func (mr *MockPolicyServiceMockRecorder) ListLocal(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLocal", reflect.TypeOf((*MockPolicyService)(nil).ListLocal), ctx)
+}
+
+// ListLocalVersions mocks base method.
+func (m *MockPolicyService) ListLocalVersions(ctx context.Context, name string) ([]core.LocalPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLocalVersions", ctx, name)
+	ret0, _ := ret[0].([]core.LocalPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLocalVersions indicates an expected call of ListLocalVersions.
+func (mr *MockPolicyServiceMockRecorder) ListLocalVersions(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLocalVersions", reflect.TypeOf((*MockPolicyService)(nil).ListLocalVersions), ctx, name)
+}
+
 // Summerize mocks base method.
 func (m *MockPolicyService) Summerize(results []core.PolicyEvalResult, action string, overrides *map[string]bool) bool {
 	m.ctrl.T.Helper()
@@ -1129,6 +1307,49 @@ func (mr *MockPolicyServiceMockRecorder) TestWithPolicyURL(ctx, url, context, ac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TestWithPolicyURL", reflect.TypeOf((*MockPolicyService)(nil).TestWithPolicyURL), ctx, url, context, action)
 }
 
+// UploadLocal mocks base method.
+func (m *MockPolicyService) UploadLocal(ctx context.Context, requester, name, document string) (core.LocalPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UploadLocal", ctx, requester, name, document)
+	ret0, _ := ret[0].(core.LocalPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UploadLocal indicates an expected call of UploadLocal.
+func (mr *MockPolicyServiceMockRecorder) UploadLocal(ctx, requester, name, document any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UploadLocal", reflect.TypeOf((*MockPolicyService)(nil).UploadLocal), ctx, requester, name, document)
+}
+
+// Validate mocks base method.
+func (m *MockPolicyService) Validate(policy core.Policy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Validate", policy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Validate indicates an expected call of Validate.
+func (mr *MockPolicyServiceMockRecorder) Validate(policy any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Validate", reflect.TypeOf((*MockPolicyService)(nil).Validate), policy)
+}
+
+// ValidateWithPolicyURL mocks base method.
+func (m *MockPolicyService) ValidateWithPolicyURL(ctx context.Context, url string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateWithPolicyURL", ctx, url)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ValidateWithPolicyURL indicates an expected call of ValidateWithPolicyURL.
+func (mr *MockPolicyServiceMockRecorder) ValidateWithPolicyURL(ctx, url any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateWithPolicyURL", reflect.TypeOf((*MockPolicyService)(nil).ValidateWithPolicyURL), ctx, url)
+}
+
 // MockProfileService is a mock of ProfileService interface.
 type MockProfileService struct {
 	ctrl     *gomock.Controller
@@ -1339,6 +1560,86 @@ func (mr *MockSchemaServiceMockRecorder) UrlToID(ctx, url any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UrlToID", reflect.TypeOf((*MockSchemaService)(nil).UrlToID), ctx, url)
 }
 
+// MockSearchService is a mock of SearchService interface.
+type MockSearchService struct {
+	ctrl     *gomock.Controller
+	recorder *MockSearchServiceMockRecorder
+}
+
+// MockSearchServiceMockRecorder is the mock recorder for MockSearchService.
+type MockSearchServiceMockRecorder struct {
+	mock *MockSearchService
+}
+
+// NewMockSearchService creates a new mock instance.
+func NewMockSearchService(ctrl *gomock.Controller) *MockSearchService {
+	mock := &MockSearchService{ctrl: ctrl}
+	mock.recorder = &MockSearchServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSearchService) EXPECT() *MockSearchServiceMockRecorder {
+	return m.recorder
+}
+
+// Clean mocks base method.
+func (m *MockSearchService) Clean(ctx context.Context, ccid string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Clean", ctx, ccid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Clean indicates an expected call of Clean.
+func (mr *MockSearchServiceMockRecorder) Clean(ctx, ccid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clean", reflect.TypeOf((*MockSearchService)(nil).Clean), ctx, ccid)
+}
+
+// IndexMessage mocks base method.
+func (m *MockSearchService) IndexMessage(ctx context.Context, message core.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IndexMessage", ctx, message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IndexMessage indicates an expected call of IndexMessage.
+func (mr *MockSearchServiceMockRecorder) IndexMessage(ctx, message any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IndexMessage", reflect.TypeOf((*MockSearchService)(nil).IndexMessage), ctx, message)
+}
+
+// RemoveMessage mocks base method.
+func (m *MockSearchService) RemoveMessage(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveMessage", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveMessage indicates an expected call of RemoveMessage.
+func (mr *MockSearchServiceMockRecorder) RemoveMessage(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveMessage", reflect.TypeOf((*MockSearchService)(nil).RemoveMessage), ctx, id)
+}
+
+// SearchMessages mocks base method.
+func (m *MockSearchService) SearchMessages(ctx context.Context, query, timeline, author string, cursor core.SearchCursor, limit int) ([]core.MessageIndex, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchMessages", ctx, query, timeline, author, cursor, limit)
+	ret0, _ := ret[0].([]core.MessageIndex)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchMessages indicates an expected call of SearchMessages.
+func (mr *MockSearchServiceMockRecorder) SearchMessages(ctx, query, timeline, author, cursor, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchMessages", reflect.TypeOf((*MockSearchService)(nil).SearchMessages), ctx, query, timeline, author, cursor, limit)
+}
+
 // MockSemanticIDService is a mock of SemanticIDService interface.
 type MockSemanticIDService struct {
 	ctrl     *gomock.Controller
@@ -1533,6 +1834,21 @@ func (mr *MockStoreServiceMockRecorder) Commit(ctx, mode, document, signature, o
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Commit", reflect.TypeOf((*MockStoreService)(nil).Commit), ctx, mode, document, signature, option, keys, IP)
 }
 
+// GetHead mocks base method.
+func (m *MockStoreService) GetHead(ctx context.Context, owner string) (core.CommitHead, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHead", ctx, owner)
+	ret0, _ := ret[0].(core.CommitHead)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHead indicates an expected call of GetHead.
+func (mr *MockStoreServiceMockRecorder) GetHead(ctx, owner any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHead", reflect.TypeOf((*MockStoreService)(nil).GetHead), ctx, owner)
+}
+
 // Restore mocks base method.
 func (m *MockStoreService) Restore(ctx context.Context, archive io.Reader, from, IP string) ([]core.BatchResult, error) {
 	m.ctrl.T.Helper()
@@ -1719,6 +2035,115 @@ func (mr *MockSubscriptionServiceMockRecorder) UpsertSubscription(ctx, mode, doc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertSubscription", reflect.TypeOf((*MockSubscriptionService)(nil).UpsertSubscription), ctx, mode, document, signature)
 }
 
+// MockChunkCache is a mock of ChunkCache interface.
+type MockChunkCache struct {
+	ctrl     *gomock.Controller
+	recorder *MockChunkCacheMockRecorder
+}
+
+// MockChunkCacheMockRecorder is the mock recorder for MockChunkCache.
+type MockChunkCacheMockRecorder struct {
+	mock *MockChunkCache
+}
+
+// NewMockChunkCache creates a new mock instance.
+func NewMockChunkCache(ctrl *gomock.Controller) *MockChunkCache {
+	mock := &MockChunkCache{ctrl: ctrl}
+	mock.recorder = &MockChunkCacheMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockChunkCache) EXPECT() *MockChunkCacheMockRecorder {
+	return m.recorder
+}
+
+// GetBodies mocks base method.
+func (m *MockChunkCache) GetBodies(ctx context.Context, query map[string]string) (map[string][]core.TimelineItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBodies", ctx, query)
+	ret0, _ := ret[0].(map[string][]core.TimelineItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBodies indicates an expected call of GetBodies.
+func (mr *MockChunkCacheMockRecorder) GetBodies(ctx, query any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBodies", reflect.TypeOf((*MockChunkCache)(nil).GetBodies), ctx, query)
+}
+
+// GetItrs mocks base method.
+func (m *MockChunkCache) GetItrs(ctx context.Context, timelines []string, epoch string) (map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetItrs", ctx, timelines, epoch)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetItrs indicates an expected call of GetItrs.
+func (mr *MockChunkCacheMockRecorder) GetItrs(ctx, timelines, epoch any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetItrs", reflect.TypeOf((*MockChunkCache)(nil).GetItrs), ctx, timelines, epoch)
+}
+
+// InvalidateItrs mocks base method.
+func (m *MockChunkCache) InvalidateItrs(ctx context.Context, timeline string, epochs []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InvalidateItrs", ctx, timeline, epochs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InvalidateItrs indicates an expected call of InvalidateItrs.
+func (mr *MockChunkCacheMockRecorder) InvalidateItrs(ctx, timeline, epochs any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InvalidateItrs", reflect.TypeOf((*MockChunkCache)(nil).InvalidateItrs), ctx, timeline, epochs)
+}
+
+// PrependBody mocks base method.
+func (m *MockChunkCache) PrependBody(ctx context.Context, timeline, epoch string, item core.TimelineItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrependBody", ctx, timeline, epoch, item)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PrependBody indicates an expected call of PrependBody.
+func (mr *MockChunkCacheMockRecorder) PrependBody(ctx, timeline, epoch, item any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrependBody", reflect.TypeOf((*MockChunkCache)(nil).PrependBody), ctx, timeline, epoch, item)
+}
+
+// SetBody mocks base method.
+func (m *MockChunkCache) SetBody(ctx context.Context, timeline, epoch string, items []core.TimelineItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetBody", ctx, timeline, epoch, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBody indicates an expected call of SetBody.
+func (mr *MockChunkCacheMockRecorder) SetBody(ctx, timeline, epoch, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBody", reflect.TypeOf((*MockChunkCache)(nil).SetBody), ctx, timeline, epoch, items)
+}
+
+// SetItr mocks base method.
+func (m *MockChunkCache) SetItr(ctx context.Context, timeline, epoch, itr string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetItr", ctx, timeline, epoch, itr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetItr indicates an expected call of SetItr.
+func (mr *MockChunkCacheMockRecorder) SetItr(ctx, timeline, epoch, itr any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetItr", reflect.TypeOf((*MockChunkCache)(nil).SetItr), ctx, timeline, epoch, itr)
+}
+
 // MockTimelineService is a mock of TimelineService interface.
 type MockTimelineService struct {
 	ctrl     *gomock.Controller
@@ -2086,7 +2511,7 @@ func (mr *MockTimelineServiceMockRecorder) Query(ctx, timelineID, schema, owner,
 }
 
 // Realtime mocks base method.
-func (m *MockTimelineService) Realtime(ctx context.Context, request <-chan []string, response chan<- core.Event) {
+func (m *MockTimelineService) Realtime(ctx context.Context, request <-chan core.RealtimeRequest, response chan<- core.Event) {
 	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "Realtime", ctx, request, response)
 }
@@ -2178,33 +2603,33 @@ func (m *MockJobService) EXPECT() *MockJobServiceMockRecorder {
 }
 
 // Cancel mocks base method.
-func (m *MockJobService) Cancel(ctx context.Context, id string) (core.Job, error) {
+func (m *MockJobService) Cancel(ctx context.Context, requester, id string) (core.Job, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Cancel", ctx, id)
+	ret := m.ctrl.Call(m, "Cancel", ctx, requester, id)
 	ret0, _ := ret[0].(core.Job)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Cancel indicates an expected call of Cancel.
-func (mr *MockJobServiceMockRecorder) Cancel(ctx, id any) *gomock.Call {
+func (mr *MockJobServiceMockRecorder) Cancel(ctx, requester, id any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cancel", reflect.TypeOf((*MockJobService)(nil).Cancel), ctx, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cancel", reflect.TypeOf((*MockJobService)(nil).Cancel), ctx, requester, id)
 }
 
 // Complete mocks base method.
-func (m *MockJobService) Complete(ctx context.Context, id, status, result string) (core.Job, error) {
+func (m *MockJobService) Complete(ctx context.Context, id string, attempt int, status, result string) (core.Job, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Complete", ctx, id, status, result)
+	ret := m.ctrl.Call(m, "Complete", ctx, id, attempt, status, result)
 	ret0, _ := ret[0].(core.Job)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Complete indicates an expected call of Complete.
-func (mr *MockJobServiceMockRecorder) Complete(ctx, id, status, result any) *gomock.Call {
+func (mr *MockJobServiceMockRecorder) Complete(ctx, id, attempt, status, result any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Complete", reflect.TypeOf((*MockJobService)(nil).Complete), ctx, id, status, result)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Complete", reflect.TypeOf((*MockJobService)(nil).Complete), ctx, id, attempt, status, result)
 }
 
 // Create mocks base method.
@@ -2222,19 +2647,63 @@ func (mr *MockJobServiceMockRecorder) Create(ctx, requester, typ, payload, sched
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockJobService)(nil).Create), ctx, requester, typ, payload, scheduled)
 }
 
+// CreateRecurring mocks base method.
+func (m *MockJobService) CreateRecurring(ctx context.Context, requester, typ, payload, cron, interval string) (core.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRecurring", ctx, requester, typ, payload, cron, interval)
+	ret0, _ := ret[0].(core.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateRecurring indicates an expected call of CreateRecurring.
+func (mr *MockJobServiceMockRecorder) CreateRecurring(ctx, requester, typ, payload, cron, interval any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRecurring", reflect.TypeOf((*MockJobService)(nil).CreateRecurring), ctx, requester, typ, payload, cron, interval)
+}
+
 // Dequeue mocks base method.
-func (m *MockJobService) Dequeue(ctx context.Context) (*core.Job, error) {
+func (m *MockJobService) Dequeue(ctx context.Context, types []string, lease time.Duration) (*core.Job, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Dequeue", ctx)
+	ret := m.ctrl.Call(m, "Dequeue", ctx, types, lease)
 	ret0, _ := ret[0].(*core.Job)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Dequeue indicates an expected call of Dequeue.
-func (mr *MockJobServiceMockRecorder) Dequeue(ctx any) *gomock.Call {
+func (mr *MockJobServiceMockRecorder) Dequeue(ctx, types, lease any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dequeue", reflect.TypeOf((*MockJobService)(nil).Dequeue), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dequeue", reflect.TypeOf((*MockJobService)(nil).Dequeue), ctx, types, lease)
+}
+
+// EnsureRecurring mocks base method.
+func (m *MockJobService) EnsureRecurring(ctx context.Context, requester, typ, payload, cron, interval string) (core.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnsureRecurring", ctx, requester, typ, payload, cron, interval)
+	ret0, _ := ret[0].(core.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnsureRecurring indicates an expected call of EnsureRecurring.
+func (mr *MockJobServiceMockRecorder) EnsureRecurring(ctx, requester, typ, payload, cron, interval any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureRecurring", reflect.TypeOf((*MockJobService)(nil).EnsureRecurring), ctx, requester, typ, payload, cron, interval)
+}
+
+// Heartbeat mocks base method.
+func (m *MockJobService) Heartbeat(ctx context.Context, id string, attempt int, lease time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Heartbeat", ctx, id, attempt, lease)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Heartbeat indicates an expected call of Heartbeat.
+func (mr *MockJobServiceMockRecorder) Heartbeat(ctx, id, attempt, lease any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Heartbeat", reflect.TypeOf((*MockJobService)(nil).Heartbeat), ctx, id, attempt, lease)
 }
 
 // List mocks base method.
@@ -2251,3 +2720,129 @@ func (mr *MockJobServiceMockRecorder) List(ctx, requester any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockJobService)(nil).List), ctx, requester)
 }
+
+// ListSystem mocks base method.
+func (m *MockJobService) ListSystem(ctx context.Context) ([]core.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSystem", ctx)
+	ret0, _ := ret[0].([]core.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSystem indicates an expected call of ListSystem.
+func (mr *MockJobServiceMockRecorder) ListSystem(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSystem", reflect.TypeOf((*MockJobService)(nil).ListSystem), ctx)
+}
+
+// Reschedule mocks base method.
+func (m *MockJobService) Reschedule(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reschedule", ctx, id, attempt, result, scheduled)
+	ret0, _ := ret[0].(core.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reschedule indicates an expected call of Reschedule.
+func (mr *MockJobServiceMockRecorder) Reschedule(ctx, id, attempt, result, scheduled any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reschedule", reflect.TypeOf((*MockJobService)(nil).Reschedule), ctx, id, attempt, result, scheduled)
+}
+
+// Retry mocks base method.
+func (m *MockJobService) Retry(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Retry", ctx, id, attempt, result, scheduled)
+	ret0, _ := ret[0].(core.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Retry indicates an expected call of Retry.
+func (mr *MockJobServiceMockRecorder) Retry(ctx, id, attempt, result, scheduled any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Retry", reflect.TypeOf((*MockJobService)(nil).Retry), ctx, id, attempt, result, scheduled)
+}
+
+// UpdateResult mocks base method.
+func (m *MockJobService) UpdateResult(ctx context.Context, id, result string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateResult", ctx, id, result)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateResult indicates an expected call of UpdateResult.
+func (mr *MockJobServiceMockRecorder) UpdateResult(ctx, id, result any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateResult", reflect.TypeOf((*MockJobService)(nil).UpdateResult), ctx, id, result)
+}
+
+// MockRevocationService is a mock of RevocationService interface.
+type MockRevocationService struct {
+	ctrl     *gomock.Controller
+	recorder *MockRevocationServiceMockRecorder
+}
+
+// MockRevocationServiceMockRecorder is the mock recorder for MockRevocationService.
+type MockRevocationServiceMockRecorder struct {
+	mock *MockRevocationService
+}
+
+// NewMockRevocationService creates a new mock instance.
+func NewMockRevocationService(ctrl *gomock.Controller) *MockRevocationService {
+	mock := &MockRevocationService{ctrl: ctrl}
+	mock.recorder = &MockRevocationServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRevocationService) EXPECT() *MockRevocationServiceMockRecorder {
+	return m.recorder
+}
+
+// GetFeed mocks base method.
+func (m *MockRevocationService) GetFeed(ctx context.Context, since time.Time) (core.RevocationFeed, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFeed", ctx, since)
+	ret0, _ := ret[0].(core.RevocationFeed)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFeed indicates an expected call of GetFeed.
+func (mr *MockRevocationServiceMockRecorder) GetFeed(ctx, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFeed", reflect.TypeOf((*MockRevocationService)(nil).GetFeed), ctx, since)
+}
+
+// IsPassportRevoked mocks base method.
+func (m *MockRevocationService) IsPassportRevoked(ctx context.Context, domain core.Domain, passport core.PassportDocument) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsPassportRevoked", ctx, domain, passport)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsPassportRevoked indicates an expected call of IsPassportRevoked.
+func (mr *MockRevocationServiceMockRecorder) IsPassportRevoked(ctx, domain, passport any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPassportRevoked", reflect.TypeOf((*MockRevocationService)(nil).IsPassportRevoked), ctx, domain, passport)
+}
+
+// Publish mocks base method.
+func (m *MockRevocationService) Publish(ctx context.Context, typ, target string, revokedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Publish", ctx, typ, target, revokedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Publish indicates an expected call of Publish.
+func (mr *MockRevocationServiceMockRecorder) Publish(ctx, typ, target, revokedAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Publish", reflect.TypeOf((*MockRevocationService)(nil).Publish), ctx, typ, target, revokedAt)
+}
@@ -6,13 +6,57 @@ import (
 
 // Event is websocket root packet model
 type Event struct {
-	Timeline  string        `json:"timeline"` // stream full id (ex: <streamID>@<domain>)
+	Seq       int64         `json:"seq,omitempty"`  // per-timeline sequence number assigned on publish
+	Type      string        `json:"type,omitempty"` // empty for resource events. see EventTypeGap
+	Timeline  string        `json:"timeline"`       // stream full id (ex: <streamID>@<domain>)
 	Item      *TimelineItem `json:"item,omitempty"`
 	Resource  any           `json:"resource,omitempty"`
 	Document  string        `json:"document"`
 	Signature string        `json:"signature"`
 }
 
+// EventTypeGap tells the client that events after the requested sequence are no longer retained.
+// Seq of the gap event is the first sequence that will be delivered.
+const EventTypeGap = "gap"
+
+// EventTypeTooManyTimelines tells the client that the timelines of the request exceed the limit of the connection.
+// The connection is closed after it.
+const EventTypeTooManyTimelines = "too-many-timelines"
+
+// RealtimeRequest is a subscription request of a realtime connection.
+// Since maps a timeline to the last sequence the client has seen
+// Subscription is resolved to its timelines on the server and followed when its items are changed
+// MaxChannels limits the number of timelines after the subscription is resolved. 0 means unlimited
+type RealtimeRequest struct {
+	Channels     []string         `json:"channels"`
+	Subscription string           `json:"subscription,omitempty"`
+	Since        map[string]int64 `json:"since,omitempty"`
+	Filter       *RealtimeFilter  `json:"filter,omitempty"`
+	MaxChannels  int              `json:"-"`
+}
+
+// kinds of realtime events
+const (
+	EventKindItem     = "item"     // a new timeline item
+	EventKindResource = "resource" // a change of a resource without a new item (ex: association to a message)
+	EventKindRetract  = "retract"  // a deletion
+)
+
+// RealtimeFilter narrows down the events sent to a realtime connection. empty fields match everything
+type RealtimeFilter struct {
+	Schemas        []string `json:"schemas,omitempty"`
+	ExcludeSchemas []string `json:"excludeSchemas,omitempty"`
+	Kinds          []string `json:"kinds,omitempty"`
+	Authors        []string `json:"authors,omitempty"`
+}
+
+// SearchCursor is the position of the last examined entry of a search.
+// Entries are ordered by (CDate, MessageID) so that entries created in the same instant are not skipped
+type SearchCursor struct {
+	CDate     time.Time
+	MessageID string
+}
+
 type Chunk struct {
 	Key   string         `json:"key"`
 	Epoch string         `json:"epoch"`
@@ -59,22 +103,61 @@ type EvalResult struct {
 	Error    string       `json:"error"`
 }
 
+// PolicyTrace is the evaluation of a statement in a policy
+type PolicyTrace struct {
+	Statement string      `json:"statement"`
+	Found     bool        `json:"found"`
+	Result    string      `json:"result"`
+	Eval      *EvalResult `json:"eval,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// PolicyExplanation describes how a policy decision is made
+type PolicyExplanation struct {
+	Action      string       `json:"action"`
+	Global      PolicyTrace  `json:"global"`
+	Local       *PolicyTrace `json:"local,omitempty"`
+	Result      string       `json:"result"`      // result of Test
+	Accumulated string       `json:"accumulated"` // result of AccumulateOr
+	Allowed     bool         `json:"allowed"`     // result of Summerize
+}
+
 type Config struct {
-	FQDN         string `yaml:"fqdn"`
-	PrivateKey   string `yaml:"privatekey"`
-	Registration string `yaml:"registration"` // open, invite, close
-	SiteKey      string `yaml:"sitekey"`
-	Dimension    string `yaml:"dimension"`
-	CCID         string `yaml:"ccid"`
-	CSID         string `yaml:"csid"`
+	FQDN         string         `yaml:"fqdn"`
+	PrivateKey   string         `yaml:"privatekey"`
+	Registration string         `yaml:"registration"` // open, invite, close
+	SiteKey      string         `yaml:"sitekey"`
+	Dimension    string         `yaml:"dimension"`
+	CCID         string         `yaml:"ccid"`
+	CSID         string         `yaml:"csid"`
+	OperatorKeys []OperatorKey  `yaml:"operatorKeys"` // subkeys delegated from CSID, in order of preference. empty means PrivateKey
+	ChunkLength  int64          `yaml:"chunkLength"`  // seconds
+	HomeFeed     HomeFeedConfig `yaml:"homeFeed"`
+}
+
+// OperatorKey is a subkey delegated from the CSID that signs documents of the domain
+type OperatorKey struct {
+	ID         string `yaml:"id"` // cck address
+	PrivateKey string `yaml:"privatekey"`
 }
 
 type ConfigInput struct {
-	FQDN         string `yaml:"fqdn"`
-	PrivateKey   string `yaml:"privatekey"`
-	Registration string `yaml:"registration"` // open, invite, close
-	SiteKey      string `yaml:"sitekey"`
-	Dimension    string `yaml:"dimension"`
+	FQDN         string         `yaml:"fqdn"`
+	PrivateKey   string         `yaml:"privatekey"`
+	Registration string         `yaml:"registration"` // open, invite, close
+	SiteKey      string         `yaml:"sitekey"`
+	Dimension    string         `yaml:"dimension"`
+	OperatorKeys []string       `yaml:"operatorKeys"` // optional. private keys of subkeys enacted under the CSID, in order of preference
+	ChunkLength  int64          `yaml:"chunkLength"`  // seconds, default 600
+	HomeFeed     HomeFeedConfig `yaml:"homeFeed"`
+}
+
+// RevocationCache is the last verified revocation feed of a remote domain.
+// it never expires by itself, and is refreshed in place with the revocations published since SignedAt
+type RevocationCache struct {
+	Revocations []Revocation `json:"revocations"`
+	SignedAt    time.Time    `json:"signedAt"`  // signedAt of the last feed, by the clock of the remote
+	FetchedAt   time.Time    `json:"fetchedAt"` // by the local clock
 }
 
 type SyncStatus struct {
@@ -83,6 +166,8 @@ type SyncStatus struct {
 	Status       string    `json:"status"`
 	LatestOnFile time.Time `json:"latestOnFile"`
 	LatestOnDB   time.Time `json:"latestOnDB"`
+	HeadOnFile   string    `json:"headOnFile,omitempty"`
+	HeadOnDB     string    `json:"headOnDB,omitempty"`
 	Progress     string    `json:"progress"`
 }
 
@@ -92,3 +177,27 @@ type RateLimitConfig struct {
 }
 
 type RateLimitConfigMap map[string]RateLimitConfig
+
+// HomeFeedConfig configures materialized home feeds of subscriptions. zero values mean the default
+type HomeFeedConfig struct {
+	Enabled      bool    `yaml:"enabled"`
+	MinTimelines int     `yaml:"minTimelines"` // subscriptions with at least this many timelines are materialized (default: 50)
+	Size         int64   `yaml:"size"`         // items kept per feed (default: 1000)
+	IdleTimeout  float64 `yaml:"idleTimeout"`  // seconds a feed is kept without being read (default: 3600)
+}
+
+// ReactorConfig configures the job reactor of each process. zero values mean the default
+type ReactorConfig struct {
+	Workers      int     `yaml:"workers"`      // jobs run concurrently (default: 4)
+	PollInterval float64 `yaml:"pollInterval"` // seconds between polls when the queue is empty (default: 10)
+	Lease        float64 `yaml:"lease"`        // seconds a running job is held before another worker takes it over (default: 60)
+}
+
+// RealtimeConfig limits realtime (websocket/SSE) connections. zero values mean the default
+type RealtimeConfig struct {
+	SendQueueSize  int     `yaml:"sendQueueSize"`  // events buffered per connection (default: 256)
+	OverflowPolicy string  `yaml:"overflowPolicy"` // "drop-oldest" (default) or "disconnect"
+	MaxTimelines   int     `yaml:"maxTimelines"`   // timelines per connection (default: unlimited)
+	MaxSockets     int     `yaml:"maxSockets"`     // connections per ccid or ip (default: unlimited)
+	WriteTimeout   float64 `yaml:"writeTimeout"`   // seconds (default: 10)
+}
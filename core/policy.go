@@ -0,0 +1,39 @@
+package core
+
+import (
+	"slices"
+	"sync"
+)
+
+var (
+	policyActionsMu sync.RWMutex
+	policyActions   = map[string]bool{}
+)
+
+// RegisterPolicyAction registers the actions a service asks policies about
+func RegisterPolicyAction(actions ...string) {
+	policyActionsMu.Lock()
+	defer policyActionsMu.Unlock()
+	for _, action := range actions {
+		policyActions[action] = true
+	}
+}
+
+// IsPolicyAction reports whether the action is registered
+func IsPolicyAction(action string) bool {
+	policyActionsMu.RLock()
+	defer policyActionsMu.RUnlock()
+	return policyActions[action]
+}
+
+// PolicyActions returns every registered action in sorted order
+func PolicyActions() []string {
+	policyActionsMu.RLock()
+	defer policyActionsMu.RUnlock()
+	actions := make([]string, 0, len(policyActions))
+	for action := range policyActions {
+		actions = append(actions, action)
+	}
+	slices.Sort(actions)
+	return actions
+}
@@ -17,6 +17,16 @@ type Passport struct {
 	Signature string `json:"signature"`
 }
 
+type CommitHead struct {
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+}
+
+type RevocationFeed struct {
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+}
+
 type BatchResult struct {
 	ID    string
 	Error string
@@ -162,6 +162,8 @@ func CreateDB() (*gorm.DB, func()) {
 		&core.Domain{},
 		&core.Entity{},
 		&core.EntityMeta{},
+		&core.RecoveryApproval{},
+		&core.Revocation{},
 		&core.Ack{},
 		&core.Key{},
 		&core.UserKV{},
@@ -12,6 +12,7 @@ import (
 	"github.com/totegamma/concurrent/core"
 
 	"github.com/totegamma/concurrent/x/ack"
+	"github.com/totegamma/concurrent/x/activitypub"
 	"github.com/totegamma/concurrent/x/association"
 	"github.com/totegamma/concurrent/x/auth"
 	"github.com/totegamma/concurrent/x/domain"
@@ -22,7 +23,9 @@ import (
 	"github.com/totegamma/concurrent/x/message"
 	"github.com/totegamma/concurrent/x/policy"
 	"github.com/totegamma/concurrent/x/profile"
+	"github.com/totegamma/concurrent/x/revocation"
 	"github.com/totegamma/concurrent/x/schema"
+	"github.com/totegamma/concurrent/x/search"
 	"github.com/totegamma/concurrent/x/semanticid"
 	"github.com/totegamma/concurrent/x/store"
 	"github.com/totegamma/concurrent/x/subscription"
@@ -37,11 +40,13 @@ var domainServiceProvider = wire.NewSet(domain.NewService, domain.NewRepository)
 var semanticidServiceProvider = wire.NewSet(semanticid.NewService, semanticid.NewRepository)
 var userKvServiceProvider = wire.NewSet(userkv.NewService, userkv.NewRepository)
 var policyServiceProvider = wire.NewSet(policy.NewService, policy.NewRepository)
-var keyServiceProvider = wire.NewSet(key.NewService, key.NewRepository)
+var keyServiceProvider = wire.NewSet(key.NewService, key.NewRepository, SetupRevocationService)
 var jobServiceProvider = wire.NewSet(job.NewService, job.NewRepository)
+var searchServiceProvider = wire.NewSet(search.NewService, search.NewRepository)
+var revocationServiceProvider = wire.NewSet(revocation.NewService, revocation.NewRepository)
 
 // Lv1
-var entityServiceProvider = wire.NewSet(entity.NewService, entity.NewRepository, SetupJwtService, SetupSchemaService, SetupKeyService)
+var entityServiceProvider = wire.NewSet(entity.NewService, entity.NewRepository, SetupJwtService, SetupSchemaService, SetupKeyService, SetupRevocationService)
 
 // Lv2
 var timelineServiceProvider = wire.NewSet(timeline.NewService, timeline.NewRepository, SetupEntityService, SetupDomainService, SetupSchemaService, SetupSemanticidService, SetupSubscriptionService)
@@ -49,11 +54,11 @@ var subscriptionServiceProvider = wire.NewSet(subscription.NewService, subscript
 
 // Lv3
 var profileServiceProvider = wire.NewSet(profile.NewService, profile.NewRepository, SetupEntityService, SetupKeyService, SetupSchemaService, SetupSemanticidService)
-var authServiceProvider = wire.NewSet(auth.NewService, SetupEntityService, SetupDomainService, SetupKeyService)
+var authServiceProvider = wire.NewSet(auth.NewService, SetupEntityService, SetupDomainService, SetupKeyService, SetupRevocationService)
 var ackServiceProvider = wire.NewSet(ack.NewService, ack.NewRepository, SetupEntityService, SetupKeyService)
 
 // Lv4
-var messageServiceProvider = wire.NewSet(message.NewService, message.NewRepository, SetupEntityService, SetupDomainService, SetupTimelineService, SetupKeyService, SetupSchemaService)
+var messageServiceProvider = wire.NewSet(message.NewService, message.NewRepository, SetupEntityService, SetupDomainService, SetupTimelineService, SetupKeyService, SetupSchemaService, SetupSearchService)
 
 // Lv5
 var associationServiceProvider = wire.NewSet(association.NewService, association.NewRepository, SetupEntityService, SetupDomainService, SetupTimelineService, SetupMessageService, SetupKeyService, SetupSchemaService, SetupProfileService, SetupSubscriptionService)
@@ -71,11 +76,25 @@ var storeServiceProvider = wire.NewSet(
 	SetupAckService,
 	SetupSubscriptionService,
 	SetupSemanticidService,
+	SetupJobService,
+	SetupDomainService,
+)
+
+// Lv7
+var activitypubServiceProvider = wire.NewSet(
+	activitypub.NewService,
+	activitypub.NewRepository,
+	SetupEntityService,
+	SetupProfileService,
+	SetupTimelineService,
+	SetupMessageService,
+	SetupStoreService,
+	SetupJobService,
 )
 
 // -----------
 
-func SetupPolicyService(rdb *redis.Client, globalPolicy core.Policy, config core.Config) core.PolicyService {
+func SetupPolicyService(db *gorm.DB, rdb *redis.Client, globalPolicy core.Policy, config core.Config) core.PolicyService {
 	wire.Build(policyServiceProvider)
 	return nil
 }
@@ -85,7 +104,7 @@ func SetupJwtService(rdb *redis.Client) jwt.Service {
 	return nil
 }
 
-func SetupJobService(db *gorm.DB) core.JobService {
+func SetupJobService(db *gorm.DB, config core.Config) core.JobService {
 	wire.Build(jobServiceProvider)
 	return nil
 }
@@ -100,7 +119,7 @@ func SetupKeyService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, client
 	return nil
 }
 
-func SetupMessageService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config) core.MessageService {
+func SetupMessageService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config) core.MessageService {
 	wire.Build(messageServiceProvider)
 	return nil
 }
@@ -110,12 +129,12 @@ func SetupProfileService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, cl
 	return nil
 }
 
-func SetupAssociationService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config) core.AssociationService {
+func SetupAssociationService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config) core.AssociationService {
 	wire.Build(associationServiceProvider)
 	return nil
 }
 
-func SetupTimelineService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config) core.TimelineService {
+func SetupTimelineService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config) core.TimelineService {
 	wire.Build(timelineServiceProvider)
 	return nil
 }
@@ -145,7 +164,7 @@ func SetupSchemaService(db *gorm.DB) core.SchemaService {
 	return nil
 }
 
-func SetupStoreService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config, repositoryPath string) core.StoreService {
+func SetupStoreService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config, repositoryPath string) core.StoreService {
 	wire.Build(storeServiceProvider)
 	return nil
 }
@@ -159,3 +178,18 @@ func SetupSemanticidService(db *gorm.DB) core.SemanticIDService {
 	wire.Build(semanticidServiceProvider)
 	return nil
 }
+
+func SetupSearchService(db *gorm.DB) core.SearchService {
+	wire.Build(searchServiceProvider)
+	return nil
+}
+
+func SetupRevocationService(db *gorm.DB, rdb *redis.Client, client client.Client, config core.Config) core.RevocationService {
+	wire.Build(revocationServiceProvider)
+	return nil
+}
+
+func SetupActivitypubService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client client.Client, policy core.PolicyService, config core.Config, repositoryPath string) activitypub.Service {
+	wire.Build(activitypubServiceProvider)
+	return nil
+}
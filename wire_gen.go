@@ -13,6 +13,7 @@ import (
 	"github.com/totegamma/concurrent/client"
 	"github.com/totegamma/concurrent/core"
 	"github.com/totegamma/concurrent/x/ack"
+	"github.com/totegamma/concurrent/x/activitypub"
 	"github.com/totegamma/concurrent/x/association"
 	"github.com/totegamma/concurrent/x/auth"
 	"github.com/totegamma/concurrent/x/domain"
@@ -23,7 +24,9 @@ import (
 	"github.com/totegamma/concurrent/x/message"
 	"github.com/totegamma/concurrent/x/policy"
 	"github.com/totegamma/concurrent/x/profile"
+	"github.com/totegamma/concurrent/x/revocation"
 	"github.com/totegamma/concurrent/x/schema"
+	"github.com/totegamma/concurrent/x/search"
 	"github.com/totegamma/concurrent/x/semanticid"
 	"github.com/totegamma/concurrent/x/store"
 	"github.com/totegamma/concurrent/x/subscription"
@@ -34,8 +37,8 @@ import (
 
 // Injectors from wire.go:
 
-func SetupPolicyService(rdb *redis.Client, globalPolicy core.Policy, config core.Config) core.PolicyService {
-	repository := policy.NewRepository(rdb)
+func SetupPolicyService(db *gorm.DB, rdb *redis.Client, globalPolicy core.Policy, config core.Config) core.PolicyService {
+	repository := policy.NewRepository(db, rdb)
 	policyService := policy.NewService(repository, globalPolicy, config)
 	return policyService
 }
@@ -46,9 +49,9 @@ func SetupJwtService(rdb *redis.Client) jwt.Service {
 	return service
 }
 
-func SetupJobService(db *gorm.DB) core.JobService {
+func SetupJobService(db *gorm.DB, config core.Config) core.JobService {
 	repository := job.NewRepository(db)
-	jobService := job.NewService(repository)
+	jobService := job.NewService(repository, config)
 	return jobService
 }
 
@@ -62,18 +65,20 @@ func SetupAckService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, client
 
 func SetupKeyService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, client2 client.Client, config core.Config) core.KeyService {
 	repository := key.NewRepository(db, mc, client2)
-	keyService := key.NewService(repository, config)
+	revocationService := SetupRevocationService(db, rdb, client2, config)
+	keyService := key.NewService(repository, revocationService, config)
 	return keyService
 }
 
-func SetupMessageService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config) core.MessageService {
+func SetupMessageService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config) core.MessageService {
 	schemaService := SetupSchemaService(db)
 	repository := message.NewRepository(db, mc, schemaService)
 	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
 	domainService := SetupDomainService(db, client2, config)
-	timelineService := SetupTimelineService(db, rdb, mc, keeper, client2, policy2, config)
+	timelineService := SetupTimelineService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
 	keyService := SetupKeyService(db, rdb, mc, client2, config)
-	messageService := message.NewService(repository, client2, entityService, domainService, timelineService, keyService, policy2, config)
+	searchService := SetupSearchService(db)
+	messageService := message.NewService(repository, client2, entityService, domainService, timelineService, keyService, searchService, policy2, config)
 	return messageService
 }
 
@@ -86,23 +91,23 @@ func SetupProfileService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, cl
 	return profileService
 }
 
-func SetupAssociationService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config) core.AssociationService {
+func SetupAssociationService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config) core.AssociationService {
 	schemaService := SetupSchemaService(db)
 	repository := association.NewRepository(db, mc, schemaService)
 	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
 	domainService := SetupDomainService(db, client2, config)
 	profileService := SetupProfileService(db, rdb, mc, client2, policy2, config)
-	timelineService := SetupTimelineService(db, rdb, mc, keeper, client2, policy2, config)
+	timelineService := SetupTimelineService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
 	subscriptionService := SetupSubscriptionService(db, rdb, mc, client2, policy2, config)
-	messageService := SetupMessageService(db, rdb, mc, keeper, client2, policy2, config)
+	messageService := SetupMessageService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
 	keyService := SetupKeyService(db, rdb, mc, client2, config)
 	associationService := association.NewService(repository, client2, entityService, domainService, profileService, timelineService, subscriptionService, messageService, keyService, policy2, config)
 	return associationService
 }
 
-func SetupTimelineService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config) core.TimelineService {
+func SetupTimelineService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config) core.TimelineService {
 	schemaService := SetupSchemaService(db)
-	repository := timeline.NewRepository(db, rdb, mc, keeper, client2, schemaService, config)
+	repository := timeline.NewRepository(db, rdb, mc, chunkCache, keeper, client2, schemaService, config)
 	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
 	domainService := SetupDomainService(db, client2, config)
 	semanticIDService := SetupSemanticidService(db)
@@ -121,8 +126,9 @@ func SetupEntityService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, cli
 	schemaService := SetupSchemaService(db)
 	repository := entity.NewRepository(db, mc, schemaService)
 	keyService := SetupKeyService(db, rdb, mc, client2, config)
+	revocationService := SetupRevocationService(db, rdb, client2, config)
 	service := SetupJwtService(rdb)
-	entityService := entity.NewService(repository, client2, config, keyService, policy2, service)
+	entityService := entity.NewService(repository, client2, config, keyService, policy2, revocationService, service)
 	return entityService
 }
 
@@ -130,7 +136,8 @@ func SetupAuthService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, clien
 	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
 	domainService := SetupDomainService(db, client2, config)
 	keyService := SetupKeyService(db, rdb, mc, client2, config)
-	authService := auth.NewService(rdb, config, entityService, domainService, keyService, policy2)
+	revocationService := SetupRevocationService(db, rdb, client2, config)
+	authService := auth.NewService(rdb, config, entityService, domainService, keyService, policy2, revocationService)
 	return authService
 }
 
@@ -146,24 +153,26 @@ func SetupSchemaService(db *gorm.DB) core.SchemaService {
 	return schemaService
 }
 
-func SetupStoreService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config, repositoryPath string) core.StoreService {
+func SetupStoreService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config, repositoryPath string) core.StoreService {
 	repository := store.NewRepository(db, rdb)
 	keyService := SetupKeyService(db, rdb, mc, client2, config)
 	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
-	messageService := SetupMessageService(db, rdb, mc, keeper, client2, policy2, config)
-	associationService := SetupAssociationService(db, rdb, mc, keeper, client2, policy2, config)
+	messageService := SetupMessageService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
+	associationService := SetupAssociationService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
 	profileService := SetupProfileService(db, rdb, mc, client2, policy2, config)
-	timelineService := SetupTimelineService(db, rdb, mc, keeper, client2, policy2, config)
+	timelineService := SetupTimelineService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
 	ackService := SetupAckService(db, rdb, mc, client2, policy2, config)
 	subscriptionService := SetupSubscriptionService(db, rdb, mc, client2, policy2, config)
 	semanticIDService := SetupSemanticidService(db)
-	storeService := store.NewService(repository, keyService, entityService, messageService, associationService, profileService, timelineService, ackService, subscriptionService, semanticIDService, config, repositoryPath)
+	jobService := SetupJobService(db, config)
+	domainService := SetupDomainService(db, client2, config)
+	storeService := store.NewService(repository, keyService, entityService, messageService, associationService, profileService, timelineService, ackService, subscriptionService, semanticIDService, jobService, domainService, client2, config, repositoryPath)
 	return storeService
 }
 
 func SetupSubscriptionService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, client2 client.Client, policy2 core.PolicyService, config core.Config) core.SubscriptionService {
 	schemaService := SetupSchemaService(db)
-	repository := subscription.NewRepository(db, schemaService)
+	repository := subscription.NewRepository(db, rdb, schemaService)
 	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
 	subscriptionService := subscription.NewService(repository, entityService, policy2)
 	return subscriptionService
@@ -175,6 +184,30 @@ func SetupSemanticidService(db *gorm.DB) core.SemanticIDService {
 	return semanticIDService
 }
 
+func SetupSearchService(db *gorm.DB) core.SearchService {
+	repository := search.NewRepository(db)
+	searchService := search.NewService(repository)
+	return searchService
+}
+
+func SetupRevocationService(db *gorm.DB, rdb *redis.Client, client2 client.Client, config core.Config) core.RevocationService {
+	repository := revocation.NewRepository(db, rdb)
+	revocationService := revocation.NewService(repository, client2, config)
+	return revocationService
+}
+
+func SetupActivitypubService(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, chunkCache core.ChunkCache, keeper timeline.Keeper, client2 client.Client, policy2 core.PolicyService, config core.Config, repositoryPath string) activitypub.Service {
+	repository := activitypub.NewRepository(db)
+	entityService := SetupEntityService(db, rdb, mc, client2, policy2, config)
+	profileService := SetupProfileService(db, rdb, mc, client2, policy2, config)
+	timelineService := SetupTimelineService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
+	messageService := SetupMessageService(db, rdb, mc, chunkCache, keeper, client2, policy2, config)
+	storeService := SetupStoreService(db, rdb, mc, chunkCache, keeper, client2, policy2, config, repositoryPath)
+	jobService := SetupJobService(db, config)
+	service := activitypub.NewService(repository, entityService, profileService, timelineService, messageService, storeService, jobService, config)
+	return service
+}
+
 // wire.go:
 
 // Lv0
@@ -190,12 +223,16 @@ var userKvServiceProvider = wire.NewSet(userkv.NewService, userkv.NewRepository)
 
 var policyServiceProvider = wire.NewSet(policy.NewService, policy.NewRepository)
 
-var keyServiceProvider = wire.NewSet(key.NewService, key.NewRepository)
+var keyServiceProvider = wire.NewSet(key.NewService, key.NewRepository, SetupRevocationService)
 
 var jobServiceProvider = wire.NewSet(job.NewService, job.NewRepository)
 
+var searchServiceProvider = wire.NewSet(search.NewService, search.NewRepository)
+
+var revocationServiceProvider = wire.NewSet(revocation.NewService, revocation.NewRepository)
+
 // Lv1
-var entityServiceProvider = wire.NewSet(entity.NewService, entity.NewRepository, SetupJwtService, SetupSchemaService, SetupKeyService)
+var entityServiceProvider = wire.NewSet(entity.NewService, entity.NewRepository, SetupJwtService, SetupSchemaService, SetupKeyService, SetupRevocationService)
 
 // Lv2
 var timelineServiceProvider = wire.NewSet(timeline.NewService, timeline.NewRepository, SetupEntityService, SetupDomainService, SetupSchemaService, SetupSemanticidService, SetupSubscriptionService)
@@ -205,12 +242,12 @@ var subscriptionServiceProvider = wire.NewSet(subscription.NewService, subscript
 // Lv3
 var profileServiceProvider = wire.NewSet(profile.NewService, profile.NewRepository, SetupEntityService, SetupKeyService, SetupSchemaService, SetupSemanticidService)
 
-var authServiceProvider = wire.NewSet(auth.NewService, SetupEntityService, SetupDomainService, SetupKeyService)
+var authServiceProvider = wire.NewSet(auth.NewService, SetupEntityService, SetupDomainService, SetupKeyService, SetupRevocationService)
 
 var ackServiceProvider = wire.NewSet(ack.NewService, ack.NewRepository, SetupEntityService, SetupKeyService)
 
 // Lv4
-var messageServiceProvider = wire.NewSet(message.NewService, message.NewRepository, SetupEntityService, SetupDomainService, SetupTimelineService, SetupKeyService, SetupSchemaService)
+var messageServiceProvider = wire.NewSet(message.NewService, message.NewRepository, SetupEntityService, SetupDomainService, SetupTimelineService, SetupKeyService, SetupSchemaService, SetupSearchService)
 
 // Lv5
 var associationServiceProvider = wire.NewSet(association.NewService, association.NewRepository, SetupEntityService, SetupDomainService, SetupTimelineService, SetupMessageService, SetupKeyService, SetupSchemaService, SetupProfileService, SetupSubscriptionService)
@@ -225,4 +262,15 @@ var storeServiceProvider = wire.NewSet(store.NewService, store.NewRepository, Se
 	SetupAckService,
 	SetupSubscriptionService,
 	SetupSemanticidService,
+	SetupJobService,
+	SetupDomainService,
+)
+
+// Lv7
+var activitypubServiceProvider = wire.NewSet(activitypub.NewService, activitypub.NewRepository, SetupEntityService,
+	SetupProfileService,
+	SetupTimelineService,
+	SetupMessageService,
+	SetupStoreService,
+	SetupJobService,
 )
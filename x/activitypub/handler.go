@@ -0,0 +1,185 @@
+// Package activitypub bridges local entities and messages to ActivityPub
+package activitypub
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+var tracer = otel.Tracer("activitypub")
+
+const maxInboxBodySize = 1 << 20
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+	WebFinger(c echo.Context) error
+	Actor(c echo.Context) error
+	Outbox(c echo.Context) error
+	Followers(c echo.Context) error
+	Note(c echo.Context) error
+	Inbox(c echo.Context) error
+}
+
+type handler struct {
+	service Service
+}
+
+// NewHandler creates a new handler
+func NewHandler(service Service) Handler {
+	return &handler{service: service}
+}
+
+func activityResponse(c echo.Context, content any) error {
+	c.Response().Header().Set(echo.HeaderContentType, activityJSON)
+	return c.JSON(http.StatusOK, content)
+}
+
+func errorResponse(c echo.Context, err error) error {
+	if errors.Is(err, core.ErrorNotFound{}) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+}
+
+// WebFinger resolves acct: resources to actors
+func (h handler) WebFinger(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Activitypub.Handler.WebFinger")
+	defer span.End()
+
+	resource := c.QueryParam("resource")
+	if resource == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "resource is required"})
+	}
+
+	jrd, err := h.service.WebFinger(ctx, resource)
+	if err != nil {
+		span.RecordError(err)
+		return errorResponse(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/jrd+json")
+	return c.JSON(http.StatusOK, jrd)
+}
+
+// Actor returns the Person of a local entity
+func (h handler) Actor(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Activitypub.Handler.Actor")
+	defer span.End()
+
+	person, err := h.service.GetActor(ctx, c.Param("id"))
+	if err != nil {
+		span.RecordError(err)
+		return errorResponse(c, err)
+	}
+
+	return activityResponse(c, person)
+}
+
+// Outbox returns the outbox collection, or a page of it when "page" is given
+func (h handler) Outbox(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Activitypub.Handler.Outbox")
+	defer span.End()
+
+	id := c.Param("id")
+
+	if c.QueryParam("page") == "" {
+		outbox, err := h.service.GetOutbox(ctx, id)
+		if err != nil {
+			span.RecordError(err)
+			return errorResponse(c, err)
+		}
+		return activityResponse(c, outbox)
+	}
+
+	until := time.Now()
+	untilStr := c.QueryParam("until")
+	if untilStr != "" {
+		unix, err := strconv.ParseInt(untilStr, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid until"})
+		}
+		until = time.Unix(unix, 0)
+	}
+
+	page, err := h.service.GetOutboxPage(ctx, id, until)
+	if err != nil {
+		span.RecordError(err)
+		return errorResponse(c, err)
+	}
+
+	return activityResponse(c, page)
+}
+
+// Followers returns the followers collection
+func (h handler) Followers(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Activitypub.Handler.Followers")
+	defer span.End()
+
+	followers, err := h.service.GetFollowers(ctx, c.Param("id"))
+	if err != nil {
+		span.RecordError(err)
+		return errorResponse(c, err)
+	}
+
+	return activityResponse(c, followers)
+}
+
+// Note returns a public message as a Note
+func (h handler) Note(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Activitypub.Handler.Note")
+	defer span.End()
+
+	note, err := h.service.GetNote(ctx, c.Param("id"))
+	if err != nil {
+		span.RecordError(err)
+		return errorResponse(c, err)
+	}
+
+	return activityResponse(c, note)
+}
+
+// Inbox accepts signed deliveries for a user inbox and the shared inbox
+func (h handler) Inbox(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Activitypub.Handler.Inbox")
+	defer span.End()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxInboxBodySize))
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	signer, err := h.service.Verify(ctx, c.Request(), body)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+
+	var activity Activity
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	if activity.Actor != signer {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "actor does not match the signer"})
+	}
+
+	err = h.service.Inbox(ctx, activity)
+	if err != nil {
+		span.RecordError(err)
+		return errorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
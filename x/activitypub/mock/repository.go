@@ -0,0 +1,186 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: repository.go
+//
+// Generated by this command:
+//
+//	mockgen -source=repository.go -destination=mock/repository.go
+//
+
+// Package mock_activitypub is a generated GoMock package.
+package mock_activitypub
+
+import (
+	context "context"
+	reflect "reflect"
+
+	core "github.com/totegamma/concurrent/core"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AddFollower mocks base method.
+func (m *MockRepository) AddFollower(ctx context.Context, follower core.ApFollower) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddFollower", ctx, follower)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddFollower indicates an expected call of AddFollower.
+func (mr *MockRepositoryMockRecorder) AddFollower(ctx, follower any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddFollower", reflect.TypeOf((*MockRepository)(nil).AddFollower), ctx, follower)
+}
+
+// ClaimReference mocks base method.
+func (m *MockRepository) ClaimReference(ctx context.Context, reference core.ApObjectReference) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimReference", ctx, reference)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimReference indicates an expected call of ClaimReference.
+func (mr *MockRepositoryMockRecorder) ClaimReference(ctx, reference any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimReference", reflect.TypeOf((*MockRepository)(nil).ClaimReference), ctx, reference)
+}
+
+// CountFollowers mocks base method.
+func (m *MockRepository) CountFollowers(ctx context.Context, target string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountFollowers", ctx, target)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountFollowers indicates an expected call of CountFollowers.
+func (mr *MockRepositoryMockRecorder) CountFollowers(ctx, target any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountFollowers", reflect.TypeOf((*MockRepository)(nil).CountFollowers), ctx, target)
+}
+
+// CreateKey mocks base method.
+func (m *MockRepository) CreateKey(ctx context.Context, key core.ApKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateKey indicates an expected call of CreateKey.
+func (mr *MockRepositoryMockRecorder) CreateKey(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateKey", reflect.TypeOf((*MockRepository)(nil).CreateKey), ctx, key)
+}
+
+// DeleteReference mocks base method.
+func (m *MockRepository) DeleteReference(ctx context.Context, apObjectID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteReference", ctx, apObjectID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteReference indicates an expected call of DeleteReference.
+func (mr *MockRepositoryMockRecorder) DeleteReference(ctx, apObjectID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteReference", reflect.TypeOf((*MockRepository)(nil).DeleteReference), ctx, apObjectID)
+}
+
+// GetKey mocks base method.
+func (m *MockRepository) GetKey(ctx context.Context, id string) (core.ApKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetKey", ctx, id)
+	ret0, _ := ret[0].(core.ApKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetKey indicates an expected call of GetKey.
+func (mr *MockRepositoryMockRecorder) GetKey(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKey", reflect.TypeOf((*MockRepository)(nil).GetKey), ctx, id)
+}
+
+// GetReference mocks base method.
+func (m *MockRepository) GetReference(ctx context.Context, apObjectID string) (core.ApObjectReference, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReference", ctx, apObjectID)
+	ret0, _ := ret[0].(core.ApObjectReference)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReference indicates an expected call of GetReference.
+func (mr *MockRepositoryMockRecorder) GetReference(ctx, apObjectID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReference", reflect.TypeOf((*MockRepository)(nil).GetReference), ctx, apObjectID)
+}
+
+// ListFollowers mocks base method.
+func (m *MockRepository) ListFollowers(ctx context.Context, target string) ([]core.ApFollower, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListFollowers", ctx, target)
+	ret0, _ := ret[0].([]core.ApFollower)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFollowers indicates an expected call of ListFollowers.
+func (mr *MockRepositoryMockRecorder) ListFollowers(ctx, target any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFollowers", reflect.TypeOf((*MockRepository)(nil).ListFollowers), ctx, target)
+}
+
+// RemoveFollower mocks base method.
+func (m *MockRepository) RemoveFollower(ctx context.Context, actor, target string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveFollower", ctx, actor, target)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveFollower indicates an expected call of RemoveFollower.
+func (mr *MockRepositoryMockRecorder) RemoveFollower(ctx, actor, target any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFollower", reflect.TypeOf((*MockRepository)(nil).RemoveFollower), ctx, actor, target)
+}
+
+// SetReferenceTarget mocks base method.
+func (m *MockRepository) SetReferenceTarget(ctx context.Context, apObjectID, ccObjectID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetReferenceTarget", ctx, apObjectID, ccObjectID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetReferenceTarget indicates an expected call of SetReferenceTarget.
+func (mr *MockRepositoryMockRecorder) SetReferenceTarget(ctx, apObjectID, ccObjectID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetReferenceTarget", reflect.TypeOf((*MockRepository)(nil).SetReferenceTarget), ctx, apObjectID, ccObjectID)
+}
@@ -0,0 +1,126 @@
+package activitypub
+
+import (
+	"encoding/json"
+)
+
+// WebFinger is a JSON Resource Descriptor returned from /.well-known/webfinger
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href,omitempty"`
+}
+
+// Person is an ActivityPub actor
+type Person struct {
+	Context           any       `json:"@context,omitempty"`
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername,omitempty"`
+	Name              string    `json:"name,omitempty"`
+	Summary           string    `json:"summary,omitempty"`
+	URL               string    `json:"url,omitempty"`
+	Inbox             string    `json:"inbox"`
+	Outbox            string    `json:"outbox,omitempty"`
+	Followers         string    `json:"followers,omitempty"`
+	Endpoints         *Endpoint `json:"endpoints,omitempty"`
+	Icon              *Image    `json:"icon,omitempty"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+type Endpoint struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Image struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Note is an ActivityPub object rendered from a message
+type Note struct {
+	Context      any      `json:"@context,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo"`
+	Content      string   `json:"content"`
+	Published    string   `json:"published"`
+	To           []string `json:"to,omitempty"`
+	Cc           []string `json:"cc,omitempty"`
+}
+
+// Activity is an ActivityPub activity. Object is kept raw because it is either an id or an embedded object
+type Activity struct {
+	Context   any             `json:"@context,omitempty"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Object    json.RawMessage `json:"object,omitempty"`
+	Published string          `json:"published,omitempty"`
+	To        []string        `json:"to,omitempty"`
+	Cc        []string        `json:"cc,omitempty"`
+}
+
+// InboundObject is the subset of an incoming object the bridge reads
+type InboundObject struct {
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	Actor        string   `json:"actor"`
+	Object       string   `json:"object"`
+	AttributedTo string   `json:"attributedTo"`
+	Content      string   `json:"content"`
+	InReplyTo    string   `json:"inReplyTo"`
+	URL          string   `json:"url"`
+	To           []string `json:"to"`
+	Cc           []string `json:"cc"`
+}
+
+// OrderedCollection is an outbox or followers collection
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   *int64 `json:"totalItems,omitempty"`
+	First        string `json:"first,omitempty"`
+	OrderedItems []any  `json:"orderedItems,omitempty"`
+}
+
+// OrderedCollectionPage is a page of an OrderedCollection
+type OrderedCollectionPage struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	PartOf       string `json:"partOf"`
+	Next         string `json:"next,omitempty"`
+	OrderedItems []any  `json:"orderedItems"`
+}
+
+// NoteBody is the body of the message created from an inbound Note
+type NoteBody struct {
+	Body        string `json:"body"`
+	ActivityPub struct {
+		Actor string `json:"actor"`
+		ID    string `json:"id"`
+		URL   string `json:"url,omitempty"`
+	} `json:"activitypub"`
+}
+
+// LikeBody is the body of the association created from an inbound Like
+type LikeBody struct {
+	ActivityPub struct {
+		Actor string `json:"actor"`
+		ID    string `json:"id"`
+	} `json:"activitypub"`
+}
@@ -0,0 +1,186 @@
+//go:generate go run go.uber.org/mock/mockgen -source=repository.go -destination=mock/repository.go
+package activitypub
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// Repository is the interface for activitypub repository
+type Repository interface {
+	GetKey(ctx context.Context, id string) (core.ApKey, error)
+	CreateKey(ctx context.Context, key core.ApKey) error
+
+	AddFollower(ctx context.Context, follower core.ApFollower) error
+	RemoveFollower(ctx context.Context, actor, target string) error
+	ListFollowers(ctx context.Context, target string) ([]core.ApFollower, error)
+	CountFollowers(ctx context.Context, target string) (int64, error)
+
+	GetReference(ctx context.Context, apObjectID string) (core.ApObjectReference, error)
+	ClaimReference(ctx context.Context, reference core.ApObjectReference) (bool, error)
+	SetReferenceTarget(ctx context.Context, apObjectID, ccObjectID string) error
+	DeleteReference(ctx context.Context, apObjectID string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a new activitypub repository
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db}
+}
+
+// GetKey returns the signing key of a local entity
+func (r *repository) GetKey(ctx context.Context, id string) (core.ApKey, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.GetKey")
+	defer span.End()
+
+	var key core.ApKey
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&key).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return key, core.NewErrorNotFound()
+		}
+		span.RecordError(err)
+		return key, err
+	}
+
+	return key, nil
+}
+
+// CreateKey stores a signing key. an existing key for the same entity is kept
+func (r *repository) CreateKey(ctx context.Context, key core.ApKey) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.CreateKey")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&key).Error
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// AddFollower stores a remote follower. following twice replaces the previous follow
+func (r *repository) AddFollower(ctx context.Context, follower core.ApFollower) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.AddFollower")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("actor = ? AND target = ?", follower.Actor, follower.Target).Delete(&core.ApFollower{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&follower).Error
+	})
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// RemoveFollower deletes a remote follower
+func (r *repository) RemoveFollower(ctx context.Context, actor, target string) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.RemoveFollower")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Where("actor = ? AND target = ?", actor, target).Delete(&core.ApFollower{}).Error
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// ListFollowers returns the remote followers of a local entity
+func (r *repository) ListFollowers(ctx context.Context, target string) ([]core.ApFollower, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.ListFollowers")
+	defer span.End()
+
+	var followers []core.ApFollower
+	err := r.db.WithContext(ctx).Where("target = ?", target).Order("c_date DESC").Find(&followers).Error
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return followers, err
+}
+
+// CountFollowers returns the number of remote followers of a local entity
+func (r *repository) CountFollowers(ctx context.Context, target string) (int64, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.CountFollowers")
+	defer span.End()
+
+	var count int64
+	err := r.db.WithContext(ctx).Model(&core.ApFollower{}).Where("target = ?", target).Count(&count).Error
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return count, err
+}
+
+// GetReference returns the concurrent resource created from an ActivityPub object
+func (r *repository) GetReference(ctx context.Context, apObjectID string) (core.ApObjectReference, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.GetReference")
+	defer span.End()
+
+	var reference core.ApObjectReference
+	err := r.db.WithContext(ctx).Where("ap_object_id = ?", apObjectID).First(&reference).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return reference, core.NewErrorNotFound()
+		}
+		span.RecordError(err)
+		return reference, err
+	}
+
+	return reference, nil
+}
+
+// ClaimReference inserts the reference unless the object is already known.
+// it returns false when another delivery has claimed the object
+func (r *repository) ClaimReference(ctx context.Context, reference core.ApObjectReference) (bool, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.ClaimReference")
+	defer span.End()
+
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&reference)
+	if result.Error != nil {
+		span.RecordError(result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// SetReferenceTarget records the concurrent resource created from a claimed ActivityPub object
+func (r *repository) SetReferenceTarget(ctx context.Context, apObjectID, ccObjectID string) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.SetReferenceTarget")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Model(&core.ApObjectReference{}).Where("ap_object_id = ?", apObjectID).Update("cc_object_id", ccObjectID).Error
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// DeleteReference deletes an object reference
+func (r *repository) DeleteReference(ctx context.Context, apObjectID string) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Repository.DeleteReference")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Where("ap_object_id = ?", apObjectID).Delete(&core.ApObjectReference{}).Error
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
@@ -0,0 +1,1080 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	securityContext        = "https://w3id.org/security/v1"
+	publicCollection       = "https://www.w3.org/ns/activitystreams#Public"
+	activityJSON           = "application/activity+json"
+
+	profileSemanticID = "world.concrnt.p"
+	homeTimeline      = "world.concrnt.t-home"
+	notifyTimeline    = "world.concrnt.t-notify"
+
+	noteSchema  = "https://schema.concrnt.world/m/markdown.json"
+	likeSchema  = "https://schema.concrnt.world/a/like.json"
+	replySchema = "https://schema.concrnt.world/a/reply.json"
+
+	outboxPageSize  = 20
+	maxActorSize    = 1 << 20
+	deliveryTimeout = 10 * time.Second
+	actorCacheTTL   = 1 * time.Hour
+)
+
+var (
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// Service is the interface for activitypub service
+type Service interface {
+	WebFinger(ctx context.Context, resource string) (WebFinger, error)
+	GetActor(ctx context.Context, id string) (Person, error)
+	GetOutbox(ctx context.Context, id string) (OrderedCollection, error)
+	GetOutboxPage(ctx context.Context, id string, until time.Time) (OrderedCollectionPage, error)
+	GetFollowers(ctx context.Context, id string) (OrderedCollection, error)
+	GetNote(ctx context.Context, id string) (Note, error)
+
+	Verify(ctx context.Context, r *http.Request, body []byte) (string, error)
+	Inbox(ctx context.Context, activity Activity) error
+	Deliver(ctx context.Context, job *core.Job) (string, error)
+}
+
+type service struct {
+	repository Repository
+	entity     core.EntityService
+	profile    core.ProfileService
+	timeline   core.TimelineService
+	message    core.MessageService
+	store      core.StoreService
+	job        core.JobService
+	config     core.Config
+	httpClient *http.Client
+	actors     sync.Map // actor url -> cachedActor
+}
+
+type cachedActor struct {
+	person    Person
+	fetchedAt time.Time
+}
+
+// NewService creates a new activitypub service
+func NewService(
+	repository Repository,
+	entity core.EntityService,
+	profile core.ProfileService,
+	timeline core.TimelineService,
+	message core.MessageService,
+	store core.StoreService,
+	job core.JobService,
+	config core.Config,
+) Service {
+	return &service{
+		repository: repository,
+		entity:     entity,
+		profile:    profile,
+		timeline:   timeline,
+		message:    message,
+		store:      store,
+		job:        job,
+		config:     config,
+		httpClient: newHTTPClient(),
+	}
+}
+
+type profileBody struct {
+	Username    string `json:"username"`
+	Description string `json:"description"`
+	Avatar      string `json:"avatar"`
+}
+
+func (s *service) baseURL() string {
+	return "https://" + s.config.FQDN + "/ap"
+}
+
+func (s *service) actorURL(ccid string) string {
+	return s.baseURL() + "/acct/" + ccid
+}
+
+func (s *service) noteURL(id string) string {
+	return s.baseURL() + "/note/" + id
+}
+
+// localID extracts the id from a local actor or note url
+func (s *service) localID(objectURL, kind string) (string, bool) {
+	prefix := s.baseURL() + "/" + kind + "/"
+	if !strings.HasPrefix(objectURL, prefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(objectURL, prefix)
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// resolveEntity returns the local entity by ccid or alias
+func (s *service) resolveEntity(ctx context.Context, id string) (core.Entity, error) {
+	var entity core.Entity
+	var err error
+	if core.IsCCID(id) {
+		entity, err = s.entity.Get(ctx, id)
+	} else {
+		entity, err = s.entity.GetByAlias(ctx, id)
+	}
+	if err != nil {
+		return core.Entity{}, err
+	}
+
+	if entity.Domain != s.config.FQDN || entity.ID == s.config.CCID {
+		return core.Entity{}, core.NewErrorNotFound()
+	}
+
+	return entity, nil
+}
+
+// WebFinger resolves acct:<ccid or alias>@<fqdn> to the actor url
+func (s *service) WebFinger(ctx context.Context, resource string) (WebFinger, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.WebFinger")
+	defer span.End()
+
+	var name string
+	if id, ok := s.localID(resource, "acct"); ok {
+		name = id
+	} else {
+		acct := strings.TrimPrefix(resource, "acct:")
+		split := strings.Split(acct, "@")
+		if len(split) != 2 || split[1] != s.config.FQDN {
+			return WebFinger{}, core.NewErrorNotFound()
+		}
+		name = split[0]
+	}
+
+	entity, err := s.resolveEntity(ctx, name)
+	if err != nil {
+		span.RecordError(err)
+		return WebFinger{}, err
+	}
+
+	return WebFinger{
+		Subject: "acct:" + name + "@" + s.config.FQDN,
+		Aliases: []string{s.actorURL(entity.ID)},
+		Links: []WebFingerLink{
+			{
+				Rel:  "self",
+				Type: activityJSON,
+				Href: s.actorURL(entity.ID),
+			},
+		},
+	}, nil
+}
+
+// GetActor renders a local entity and its profile as a Person
+func (s *service) GetActor(ctx context.Context, id string) (Person, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.GetActor")
+	defer span.End()
+
+	entity, err := s.resolveEntity(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return Person{}, err
+	}
+
+	key, _, err := s.getKey(ctx, entity.ID)
+	if err != nil {
+		span.RecordError(err)
+		return Person{}, err
+	}
+
+	actorURL := s.actorURL(entity.ID)
+	person := Person{
+		Context:           []string{activityStreamsContext, securityContext},
+		ID:                actorURL,
+		Type:              "Person",
+		PreferredUsername: entity.ID,
+		URL:               actorURL,
+		Inbox:             actorURL + "/inbox",
+		Outbox:            actorURL + "/outbox",
+		Followers:         actorURL + "/followers",
+		Endpoints: &Endpoint{
+			SharedInbox: s.baseURL() + "/inbox",
+		},
+		PublicKey: PublicKey{
+			ID:           actorURL + "#main-key",
+			Owner:        actorURL,
+			PublicKeyPem: key.PublicKey,
+		},
+	}
+	if entity.Alias != nil {
+		person.PreferredUsername = *entity.Alias
+	}
+
+	profile, err := s.profile.GetBySemanticID(ctx, profileSemanticID, entity.ID)
+	if err == nil {
+		var doc core.ProfileDocument[profileBody]
+		err = json.Unmarshal([]byte(profile.Document), &doc)
+		if err == nil {
+			person.Name = doc.Body.Username
+			person.Summary = renderContent(doc.Body.Description)
+			if doc.Body.Avatar != "" {
+				person.Icon = &Image{Type: "Image", URL: doc.Body.Avatar}
+			}
+		}
+	}
+
+	return person, nil
+}
+
+// GetOutbox returns the outbox collection. items are served from GetOutboxPage
+func (s *service) GetOutbox(ctx context.Context, id string) (OrderedCollection, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.GetOutbox")
+	defer span.End()
+
+	entity, err := s.resolveEntity(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return OrderedCollection{}, err
+	}
+
+	outbox := s.actorURL(entity.ID) + "/outbox"
+	return OrderedCollection{
+		Context: activityStreamsContext,
+		ID:      outbox,
+		Type:    "OrderedCollection",
+		First:   outbox + "?page=true",
+	}, nil
+}
+
+// GetOutboxPage renders the messages on the home timeline of the entity as Create activities
+func (s *service) GetOutboxPage(ctx context.Context, id string, until time.Time) (OrderedCollectionPage, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.GetOutboxPage")
+	defer span.End()
+
+	entity, err := s.resolveEntity(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return OrderedCollectionPage{}, err
+	}
+
+	outbox := s.actorURL(entity.ID) + "/outbox"
+	page := OrderedCollectionPage{
+		Context:      activityStreamsContext,
+		ID:           outbox + "?page=true&until=" + strconv.FormatInt(until.Unix(), 10),
+		Type:         "OrderedCollectionPage",
+		PartOf:       outbox,
+		OrderedItems: []any{},
+	}
+
+	timelineID, err := s.timeline.NormalizeTimelineID(ctx, homeTimeline+"@"+entity.ID)
+	if err != nil {
+		// ホームタイムラインがないユーザーは空のoutboxを返す
+		return page, nil
+	}
+
+	items, err := s.timeline.GetRecentItems(ctx, []string{timelineID}, until, outboxPageSize)
+	if err != nil {
+		span.RecordError(err)
+		return page, err
+	}
+
+	for _, item := range items {
+		if !strings.HasPrefix(item.ResourceID, "m") {
+			continue
+		}
+
+		message, err := s.message.GetAsGuest(ctx, item.ResourceID)
+		if err != nil || message.Author != entity.ID {
+			continue
+		}
+
+		note, err := s.renderNote(message)
+		if err != nil {
+			continue
+		}
+
+		page.OrderedItems = append(page.OrderedItems, Activity{
+			ID:        note.ID + "/activity",
+			Type:      "Create",
+			Actor:     note.AttributedTo,
+			Object:    mustMarshal(note),
+			Published: note.Published,
+			To:        note.To,
+			Cc:        note.Cc,
+		})
+	}
+
+	if len(items) == outboxPageSize {
+		last := items[len(items)-1].CDate
+		page.Next = outbox + "?page=true&until=" + strconv.FormatInt(last.Unix(), 10)
+	}
+
+	return page, nil
+}
+
+// GetFollowers returns the number of remote followers. individual followers are not exposed
+func (s *service) GetFollowers(ctx context.Context, id string) (OrderedCollection, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.GetFollowers")
+	defer span.End()
+
+	entity, err := s.resolveEntity(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return OrderedCollection{}, err
+	}
+
+	count, err := s.repository.CountFollowers(ctx, entity.ID)
+	if err != nil {
+		span.RecordError(err)
+		return OrderedCollection{}, err
+	}
+
+	return OrderedCollection{
+		Context:    activityStreamsContext,
+		ID:         s.actorURL(entity.ID) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: &count,
+	}, nil
+}
+
+// GetNote renders a public message of a local entity
+func (s *service) GetNote(ctx context.Context, id string) (Note, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.GetNote")
+	defer span.End()
+
+	message, err := s.message.GetAsGuest(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return Note{}, err
+	}
+
+	_, err = s.resolveEntity(ctx, message.Author)
+	if err != nil {
+		span.RecordError(err)
+		return Note{}, err
+	}
+
+	note, err := s.renderNote(message)
+	if err != nil {
+		span.RecordError(err)
+		return Note{}, core.NewErrorNotFound()
+	}
+	note.Context = activityStreamsContext
+
+	return note, nil
+}
+
+func (s *service) renderNote(message core.Message) (Note, error) {
+	var doc core.MessageDocument[map[string]any]
+	err := json.Unmarshal([]byte(message.Document), &doc)
+	if err != nil {
+		return Note{}, err
+	}
+
+	body, ok := doc.Body["body"].(string)
+	if !ok {
+		return Note{}, fmt.Errorf("message %s has no text body", message.ID)
+	}
+
+	return Note{
+		ID:           s.noteURL(message.ID),
+		Type:         "Note",
+		AttributedTo: s.actorURL(message.Author),
+		Content:      renderContent(body),
+		Published:    message.CDate.UTC().Format(time.RFC3339),
+		To:           []string{publicCollection},
+		Cc:           []string{s.actorURL(message.Author) + "/followers"},
+	}, nil
+}
+
+// Verify checks the HTTP signature of an inbox delivery and returns the actor that signed it
+func (s *service) Verify(ctx context.Context, r *http.Request, body []byte) (string, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.Verify")
+	defer span.End()
+
+	params, err := parseSignatureHeader(r.Header.Get("Signature"))
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	keyURL, err := url.Parse(params.KeyID)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	keyURL.Fragment = ""
+
+	// 署名を検証する前に鍵を取りに行くので、取得先はactivityのactorと同じホストに限る
+	var activity Activity
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	actorURL, err := url.Parse(activity.Actor)
+	if err != nil || actorURL.Host == "" || actorURL.Host != keyURL.Host {
+		return "", fmt.Errorf("key %s is not hosted by the actor %s", params.KeyID, activity.Actor)
+	}
+
+	actor, cached := s.cachedActor(keyURL.String())
+	if !cached {
+		actor, err = s.fetchActor(ctx, keyURL.String())
+		if err != nil {
+			span.RecordError(err)
+			return "", errors.Wrap(err, "failed to fetch signer")
+		}
+	}
+
+	err = verifyActorSignature(r, body, params, actor)
+	if err != nil && cached {
+		// キャッシュした鍵が更新されているかもしれないので一度だけ取り直す
+		actor, err = s.fetchActor(ctx, keyURL.String())
+		if err != nil {
+			span.RecordError(err)
+			return "", errors.Wrap(err, "failed to fetch signer")
+		}
+		err = verifyActorSignature(r, body, params, actor)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return actor.ID, nil
+}
+
+func verifyActorSignature(r *http.Request, body []byte, params signatureParams, actor Person) error {
+	if actor.PublicKey.ID != params.KeyID {
+		return fmt.Errorf("key %s is not owned by %s", params.KeyID, actor.ID)
+	}
+
+	key, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return err
+	}
+
+	return verifyRequest(r, body, params, key)
+}
+
+// Inbox converts a verified activity into concurrent resources owned by the domain key
+func (s *service) Inbox(ctx context.Context, activity Activity) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.Inbox")
+	defer span.End()
+
+	var err error
+	switch activity.Type {
+	case "Follow":
+		err = s.follow(ctx, activity)
+	case "Like":
+		err = s.like(ctx, activity)
+	case "Create":
+		err = s.create(ctx, activity)
+	case "Undo":
+		err = s.undo(ctx, activity)
+	case "Delete":
+		object := parseObject(activity.Object)
+		err = s.deleteReferenced(ctx, object.ID, activity.Actor)
+	default:
+		// 未対応のアクティビティは受理して捨てる
+		return nil
+	}
+
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+func (s *service) follow(ctx context.Context, activity Activity) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.follow")
+	defer span.End()
+
+	object := parseObject(activity.Object)
+	id, ok := s.localID(object.ID, "acct")
+	if !ok {
+		return core.NewErrorNotFound()
+	}
+
+	entity, err := s.resolveEntity(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	follower, err := s.lookupActor(ctx, activity.Actor)
+	if err != nil {
+		span.RecordError(err)
+		return errors.Wrap(err, "failed to fetch follower")
+	}
+
+	err = s.repository.AddFollower(ctx, core.ApFollower{
+		ID:     activity.ID,
+		Actor:  follower.ID,
+		Inbox:  follower.Inbox,
+		Target: entity.ID,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	actorURL := s.actorURL(entity.ID)
+	accept := Activity{
+		Context: activityStreamsContext,
+		ID:      actorURL + "#accepts/" + hex.EncodeToString(core.GetHash([]byte(activity.ID)))[:16],
+		Type:    "Accept",
+		Actor:   actorURL,
+		Object: mustMarshal(Activity{
+			ID:     activity.ID,
+			Type:   activity.Type,
+			Actor:  activity.Actor,
+			Object: mustMarshal(actorURL),
+		}),
+	}
+
+	// フォローは保存できているので、Acceptの配送はジョブに任せて失敗しても受理する
+	err = s.enqueueDelivery(ctx, entity.ID, follower.Inbox, accept)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "failed to enqueue accept", slog.String("follow", activity.ID), slog.String("error", err.Error()))
+	}
+
+	return nil
+}
+
+func (s *service) like(ctx context.Context, activity Activity) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.like")
+	defer span.End()
+
+	object := parseObject(activity.Object)
+	id, ok := s.localID(object.ID, "note")
+	if !ok {
+		return core.NewErrorNotFound()
+	}
+
+	target, err := s.message.GetAsGuest(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	claimed, err := s.claimReference(ctx, activity.ID, activity.Actor)
+	if err != nil || !claimed {
+		return err
+	}
+
+	body := LikeBody{}
+	body.ActivityPub.Actor = activity.Actor
+	body.ActivityPub.ID = activity.ID
+
+	result, err := s.commit(ctx, core.AssociationDocument[LikeBody]{
+		DocumentBase: core.DocumentBase[LikeBody]{
+			Signer:   s.config.CCID,
+			Owner:    target.Author,
+			Type:     "association",
+			Schema:   likeSchema,
+			Body:     body,
+			SignedAt: time.Now(),
+		},
+		Timelines: []string{notifyTimeline + "@" + target.Author},
+		Target:    target.ID,
+	})
+	if err != nil {
+		span.RecordError(err)
+		s.releaseReference(ctx, activity.ID)
+		return err
+	}
+
+	association, ok := result.(core.Association)
+	if !ok {
+		return fmt.Errorf("unexpected commit result %T", result)
+	}
+
+	return s.repository.SetReferenceTarget(ctx, activity.ID, association.ID)
+}
+
+func (s *service) create(ctx context.Context, activity Activity) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.create")
+	defer span.End()
+
+	object := parseObject(activity.Object)
+	if object.Type != "Note" {
+		return nil
+	}
+
+	if object.AttributedTo != "" && object.AttributedTo != activity.Actor {
+		return fmt.Errorf("note is not attributed to %s", activity.Actor)
+	}
+
+	// 宛先に含まれるローカルユーザーの通知タイムラインに流す
+	timelines := []string{}
+	for _, recipient := range append(object.To, object.Cc...) {
+		id, ok := s.localID(recipient, "acct")
+		if !ok {
+			continue
+		}
+		if _, err := s.resolveEntity(ctx, id); err != nil {
+			continue
+		}
+		timelines = append(timelines, notifyTimeline+"@"+id)
+	}
+
+	var replyTarget *core.Message
+	if id, ok := s.localID(object.InReplyTo, "note"); ok {
+		target, err := s.message.GetAsGuest(ctx, id)
+		if err == nil {
+			replyTarget = &target
+			timelines = append(timelines, notifyTimeline+"@"+target.Author)
+		}
+	}
+
+	if len(timelines) == 0 {
+		// ローカルユーザーと関係のない投稿は取り込まない
+		return nil
+	}
+
+	claimed, err := s.claimReference(ctx, object.ID, activity.Actor)
+	if err != nil || !claimed {
+		return err
+	}
+
+	body := NoteBody{
+		Body: htmlToText(object.Content),
+	}
+	body.ActivityPub.Actor = activity.Actor
+	body.ActivityPub.ID = object.ID
+	body.ActivityPub.URL = object.URL
+
+	result, err := s.commit(ctx, core.MessageDocument[NoteBody]{
+		DocumentBase: core.DocumentBase[NoteBody]{
+			Signer:   s.config.CCID,
+			Type:     "message",
+			Schema:   noteSchema,
+			Body:     body,
+			SignedAt: time.Now(),
+		},
+		Timelines: timelines,
+	})
+	if err != nil {
+		span.RecordError(err)
+		s.releaseReference(ctx, object.ID)
+		return err
+	}
+
+	message, ok := result.(core.Message)
+	if !ok {
+		return fmt.Errorf("unexpected commit result %T", result)
+	}
+
+	err = s.repository.SetReferenceTarget(ctx, object.ID, message.ID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if replyTarget != nil {
+		_, err = s.commit(ctx, core.AssociationDocument[map[string]string]{
+			DocumentBase: core.DocumentBase[map[string]string]{
+				Signer: s.config.CCID,
+				Owner:  replyTarget.Author,
+				Type:   "association",
+				Schema: replySchema,
+				Body: map[string]string{
+					"messageId":     message.ID,
+					"messageAuthor": s.config.CCID,
+				},
+				SignedAt: time.Now(),
+			},
+			Timelines: []string{},
+			Target:    replyTarget.ID,
+		})
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) undo(ctx context.Context, activity Activity) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.undo")
+	defer span.End()
+
+	object := parseObject(activity.Object)
+	if object.Actor != "" && object.Actor != activity.Actor {
+		return fmt.Errorf("cannot undo an activity of another actor")
+	}
+
+	if object.Type == "Follow" {
+		id, ok := s.localID(object.Object, "acct")
+		if !ok {
+			return core.NewErrorNotFound()
+		}
+		// フォローはエイリアスではなくCCIDで保存している
+		entity, err := s.resolveEntity(ctx, id)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		return s.repository.RemoveFollower(ctx, activity.Actor, entity.ID)
+	}
+
+	return s.deleteReferenced(ctx, object.ID, activity.Actor)
+}
+
+// deleteReferenced deletes the resource created from an ActivityPub object by the same actor
+func (s *service) deleteReferenced(ctx context.Context, apObjectID, actor string) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.deleteReferenced")
+	defer span.End()
+
+	reference, err := s.repository.GetReference(ctx, apObjectID)
+	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return nil
+		}
+		span.RecordError(err)
+		return err
+	}
+
+	if reference.Actor != actor {
+		return fmt.Errorf("cannot delete an object of another actor")
+	}
+
+	if reference.CcObjectID == "" {
+		// まだ取り込み中で削除するものがない
+		return nil
+	}
+
+	_, err = s.commit(ctx, core.DeleteDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   s.config.CCID,
+			Type:     "delete",
+			SignedAt: time.Now(),
+		},
+		Target: reference.CcObjectID,
+	})
+	if err != nil && !errors.Is(err, core.ErrorNotFound{}) {
+		span.RecordError(err)
+		return err
+	}
+
+	return s.repository.DeleteReference(ctx, apObjectID)
+}
+
+// claimReference reserves an ActivityPub object before it is committed.
+// false means the object was already processed or is being processed by another delivery
+func (s *service) claimReference(ctx context.Context, apObjectID, actor string) (bool, error) {
+	if apObjectID == "" {
+		return false, fmt.Errorf("object id is required")
+	}
+
+	return s.repository.ClaimReference(ctx, core.ApObjectReference{
+		ApObjectID: apObjectID,
+		Actor:      actor,
+	})
+}
+
+// releaseReference drops a claim whose commit failed so that a redelivery can process it again
+func (s *service) releaseReference(ctx context.Context, apObjectID string) {
+	err := s.repository.DeleteReference(ctx, apObjectID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release reference", slog.String("object", apObjectID), slog.String("error", err.Error()))
+	}
+}
+
+// commit signs the document with the domain key and commits it.
+// the domain entity is registered on first use
+func (s *service) commit(ctx context.Context, document any) (any, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.commit")
+	defer span.End()
+
+	_, err := s.entity.Get(ctx, s.config.CCID)
+	if errors.Is(err, core.ErrorNotFound{}) {
+		_, err = s.commitSigned(ctx, core.AffiliationDocument{
+			Domain: s.config.FQDN,
+			DocumentBase: core.DocumentBase[any]{
+				Signer:   s.config.CCID,
+				Type:     "affiliation",
+				SignedAt: time.Now(),
+			},
+		})
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, errors.Wrap(err, "failed to register domain entity")
+	}
+
+	return s.commitSigned(ctx, document)
+}
+
+func (s *service) commitSigned(ctx context.Context, document any) (any, error) {
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureBytes, err := core.SignBytes(documentBytes, s.config.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.store.Commit(ctx, core.CommitModeExecute, string(documentBytes), hex.EncodeToString(signatureBytes), "{}", nil, "")
+}
+
+// getKey returns the signing key of a local entity, generating it on first use
+func (s *service) getKey(ctx context.Context, ccid string) (core.ApKey, *rsa.PrivateKey, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.getKey")
+	defer span.End()
+
+	key, err := s.repository.GetKey(ctx, ccid)
+	if errors.Is(err, core.ErrorNotFound{}) {
+		var privatePem, publicPem string
+		privatePem, publicPem, err = generateKey()
+		if err != nil {
+			span.RecordError(err)
+			return core.ApKey{}, nil, err
+		}
+
+		err = s.repository.CreateKey(ctx, core.ApKey{
+			ID:         ccid,
+			PrivateKey: privatePem,
+			PublicKey:  publicPem,
+		})
+		if err != nil {
+			span.RecordError(err)
+			return core.ApKey{}, nil, err
+		}
+
+		// 同時に生成された場合は先に保存された方を使う
+		key, err = s.repository.GetKey(ctx, ccid)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return core.ApKey{}, nil, err
+	}
+
+	privateKey, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		span.RecordError(err)
+		return core.ApKey{}, nil, err
+	}
+
+	return key, privateKey, nil
+}
+
+func (s *service) cachedActor(actorURL string) (Person, bool) {
+	cached, ok := s.actors.Load(actorURL)
+	if !ok {
+		return Person{}, false
+	}
+
+	entry := cached.(cachedActor)
+	if time.Since(entry.fetchedAt) >= actorCacheTTL {
+		s.actors.Delete(actorURL)
+		return Person{}, false
+	}
+
+	return entry.person, true
+}
+
+// lookupActor returns the remote actor, fetching it when it is not cached
+func (s *service) lookupActor(ctx context.Context, actorURL string) (Person, error) {
+	if person, ok := s.cachedActor(actorURL); ok {
+		return person, nil
+	}
+	return s.fetchActor(ctx, actorURL)
+}
+
+func (s *service) fetchActor(ctx context.Context, actorURL string) (Person, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.fetchActor")
+	defer span.End()
+
+	parsed, err := url.Parse(actorURL)
+	if err != nil || parsed.Scheme != "https" {
+		return Person{}, fmt.Errorf("invalid actor url %s", actorURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, actorURL, nil)
+	if err != nil {
+		span.RecordError(err)
+		return Person{}, err
+	}
+	req.Header.Set("Accept", activityJSON)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return Person{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Person{}, fmt.Errorf("failed to fetch actor %s: %s", actorURL, resp.Status)
+	}
+
+	var person Person
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxActorSize)).Decode(&person)
+	if err != nil {
+		span.RecordError(err)
+		return Person{}, err
+	}
+
+	if person.ID != actorURL || person.Inbox == "" {
+		return Person{}, fmt.Errorf("invalid actor document %s", actorURL)
+	}
+
+	s.actors.Store(actorURL, cachedActor{person: person, fetchedAt: time.Now()})
+
+	return person, nil
+}
+
+// enqueueDelivery schedules a delivery that the job reactor retries until the remote accepts it
+func (s *service) enqueueDelivery(ctx context.Context, ccid, inbox string, activity Activity) error {
+	activityBytes, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(core.ApDeliverPayload{
+		Inbox:    inbox,
+		Activity: string(activityBytes),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.job.Create(ctx, ccid, core.JobTypeApDeliver, string(payload), time.Now())
+	return err
+}
+
+// Deliver runs a delivery job on behalf of the job author
+func (s *service) Deliver(ctx context.Context, job *core.Job) (string, error) {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.Deliver")
+	defer span.End()
+
+	var payload core.ApDeliverPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		span.RecordError(err)
+		return "invalid payload", err
+	}
+
+	var activity Activity
+	err = json.Unmarshal([]byte(payload.Activity), &activity)
+	if err != nil {
+		span.RecordError(err)
+		return "invalid activity", err
+	}
+
+	if activity.Actor != s.actorURL(job.Author) {
+		return "invalid activity", fmt.Errorf("activity is not sent by the job author")
+	}
+
+	err = s.deliver(ctx, job.Author, payload.Inbox, activity)
+	if err != nil {
+		span.RecordError(err)
+		return "failed to deliver", err
+	}
+
+	return "delivered", nil
+}
+
+// deliver posts a signed activity to a remote inbox on behalf of a local entity
+func (s *service) deliver(ctx context.Context, ccid, inbox string, activity Activity) error {
+	ctx, span := tracer.Start(ctx, "Activitypub.Service.deliver")
+	defer span.End()
+
+	_, key, err := s.getKey(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	body, err := json.Marshal(activity)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	req.Header.Set("Content-Type", activityJSON)
+
+	err = signRequest(req, body, s.actorURL(ccid)+"#main-key", key)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to deliver to %s: %s", inbox, resp.Status)
+	}
+
+	return nil
+}
+
+// parseObject reads the object of an activity, which is either an id or an embedded object
+func parseObject(raw json.RawMessage) InboundObject {
+	var object InboundObject
+
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		object.ID = id
+		return object
+	}
+
+	_ = json.Unmarshal(raw, &object)
+	return object
+}
+
+func mustMarshal(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func renderContent(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "<p>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</p>"
+}
+
+func htmlToText(content string) string {
+	text := htmlBreakPattern.ReplaceAllString(content, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
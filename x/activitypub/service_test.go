@@ -0,0 +1,374 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+	"github.com/totegamma/concurrent/x/activitypub/mock"
+)
+
+const (
+	LocalFQDN       = "local.example.com"
+	LocalDomainPriv = "863183823d2c2a19101140eef0f905c872de1dae6470c9129a1547f3482cb612"
+	User1ID         = "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+)
+
+// fakeRemote is a remote ActivityPub server with a single actor
+type fakeRemote struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	actorURL  string
+	delivered chan *http.Request
+	fetched   atomic.Int32
+}
+
+func newFakeRemote(t *testing.T) *fakeRemote {
+	privatePem, publicPem, err := generateKey()
+	assert.NoError(t, err)
+	key, err := parsePrivateKey(privatePem)
+	assert.NoError(t, err)
+
+	remote := &fakeRemote{
+		key:       key,
+		delivered: make(chan *http.Request, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/alice", func(w http.ResponseWriter, r *http.Request) {
+		remote.fetched.Add(1)
+		w.Header().Set("Content-Type", activityJSON)
+		json.NewEncoder(w).Encode(Person{
+			ID:    remote.actorURL,
+			Type:  "Person",
+			Inbox: remote.actorURL + "/inbox",
+			PublicKey: PublicKey{
+				ID:           remote.actorURL + "#main-key",
+				Owner:        remote.actorURL,
+				PublicKeyPem: publicPem,
+			},
+		})
+	})
+	mux.HandleFunc("POST /users/alice/inbox", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		remote.delivered <- r
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	remote.server = httptest.NewTLSServer(mux)
+	remote.actorURL = remote.server.URL + "/users/alice"
+	t.Cleanup(remote.server.Close)
+
+	return remote
+}
+
+// post builds a signed inbox delivery from the fake actor
+func (f *fakeRemote) post(t *testing.T, activity Activity) (*http.Request, []byte) {
+	body, err := json.Marshal(activity)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "https://"+LocalFQDN+"/ap/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", activityJSON)
+	err = signRequest(req, body, f.actorURL+"#main-key", f.key)
+	assert.NoError(t, err)
+
+	return req, body
+}
+
+func setupService(t *testing.T, ctrl *gomock.Controller, remote *fakeRemote) (*service, *mock_activitypub.MockRepository, *mock_core.MockEntityService, *mock_core.MockMessageService, *mock_core.MockStoreService, *mock_core.MockJobService) {
+	repo := mock_activitypub.NewMockRepository(ctrl)
+	entity := mock_core.NewMockEntityService(ctrl)
+	profile := mock_core.NewMockProfileService(ctrl)
+	timeline := mock_core.NewMockTimelineService(ctrl)
+	message := mock_core.NewMockMessageService(ctrl)
+	store := mock_core.NewMockStoreService(ctrl)
+	job := mock_core.NewMockJobService(ctrl)
+
+	config := core.SetupConfig(core.ConfigInput{
+		FQDN:       LocalFQDN,
+		PrivateKey: LocalDomainPriv,
+	})
+
+	s := NewService(repo, entity, profile, timeline, message, store, job, config).(*service)
+	s.httpClient = remote.server.Client()
+
+	return s, repo, entity, message, store, job
+}
+
+func TestInboxFollow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := newFakeRemote(t)
+	s, repo, entity, _, _, job := setupService(t, ctrl, remote)
+
+	entity.EXPECT().Get(gomock.Any(), User1ID).Return(core.Entity{ID: User1ID, Domain: LocalFQDN}, nil).AnyTimes()
+
+	// 鍵は初回に生成される
+	var stored core.ApKey
+	repo.EXPECT().GetKey(gomock.Any(), User1ID).DoAndReturn(func(_ context.Context, _ string) (core.ApKey, error) {
+		if stored.ID == "" {
+			return core.ApKey{}, core.NewErrorNotFound()
+		}
+		return stored, nil
+	}).Times(2)
+	repo.EXPECT().CreateKey(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key core.ApKey) error {
+		stored = key
+		return nil
+	})
+	repo.EXPECT().AddFollower(gomock.Any(), core.ApFollower{
+		ID:     "https://remote.example.com/follows/1",
+		Actor:  remote.actorURL,
+		Inbox:  remote.actorURL + "/inbox",
+		Target: User1ID,
+	}).Return(nil)
+
+	// Acceptはジョブとして配送される
+	var queued core.Job
+	job.EXPECT().Create(gomock.Any(), User1ID, core.JobTypeApDeliver, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, author, typ, payload string, _ time.Time) (core.Job, error) {
+			queued = core.Job{Author: author, Type: typ, Payload: payload}
+			return queued, nil
+		})
+
+	follow := Activity{
+		ID:     "https://remote.example.com/follows/1",
+		Type:   "Follow",
+		Actor:  remote.actorURL,
+		Object: mustMarshal(s.actorURL(User1ID)),
+	}
+
+	req, body := remote.post(t, follow)
+	signer, err := s.Verify(context.Background(), req, body)
+	assert.NoError(t, err)
+	assert.Equal(t, remote.actorURL, signer)
+
+	err = s.Inbox(context.Background(), follow)
+	assert.NoError(t, err)
+
+	result, err := s.Deliver(context.Background(), &queued)
+	assert.NoError(t, err)
+	assert.Equal(t, "delivered", result)
+
+	// Acceptがローカルユーザーの鍵で署名されて届く
+	var delivered *http.Request
+	select {
+	case delivered = <-remote.delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept was not delivered")
+	}
+	deliveredBody, _ := io.ReadAll(delivered.Body)
+
+	var accept Activity
+	err = json.Unmarshal(deliveredBody, &accept)
+	assert.NoError(t, err)
+	assert.Equal(t, "Accept", accept.Type)
+	assert.Equal(t, s.actorURL(User1ID), accept.Actor)
+
+	params, err := parseSignatureHeader(delivered.Header.Get("Signature"))
+	assert.NoError(t, err)
+	assert.Equal(t, s.actorURL(User1ID)+"#main-key", params.KeyID)
+
+	publicKey, err := parsePublicKey(stored.PublicKey)
+	assert.NoError(t, err)
+	assert.NoError(t, verifyRequest(delivered, deliveredBody, params, publicKey))
+}
+
+func TestVerifyRejectsTamperedBody(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := newFakeRemote(t)
+	s, _, _, _, _, _ := setupService(t, ctrl, remote)
+
+	req, _ := remote.post(t, Activity{
+		ID:     "https://remote.example.com/likes/1",
+		Type:   "Like",
+		Actor:  remote.actorURL,
+		Object: mustMarshal(s.noteURL("m00000000000000000000000000")),
+	})
+
+	_, err := s.Verify(context.Background(), req, []byte(`{"type":"Delete","actor":"`+remote.actorURL+`"}`))
+	assert.ErrorContains(t, err, "digest mismatch")
+
+	// 署名の期限切れ
+	req, body := remote.post(t, Activity{ID: "https://remote.example.com/likes/2", Type: "Like", Actor: remote.actorURL})
+	req.Header.Set("Date", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+	_, err = s.Verify(context.Background(), req, body)
+	assert.ErrorContains(t, err, "date is out of range")
+}
+
+func TestInboxLike(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := newFakeRemote(t)
+	s, repo, entity, message, store, _ := setupService(t, ctrl, remote)
+
+	messageID := "m00000000000000000000000000"
+	like := Activity{
+		ID:     "https://remote.example.com/likes/1",
+		Type:   "Like",
+		Actor:  remote.actorURL,
+		Object: mustMarshal(s.noteURL(messageID)),
+	}
+
+	message.EXPECT().GetAsGuest(gomock.Any(), messageID).Return(core.Message{ID: messageID, Author: User1ID}, nil)
+	entity.EXPECT().Get(gomock.Any(), s.config.CCID).Return(core.Entity{ID: s.config.CCID, Domain: LocalFQDN}, nil)
+	repo.EXPECT().ClaimReference(gomock.Any(), core.ApObjectReference{
+		ApObjectID: like.ID,
+		Actor:      remote.actorURL,
+	}).Return(true, nil)
+
+	store.EXPECT().
+		Commit(gomock.Any(), core.CommitModeExecute, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ core.CommitMode, document, signature, _ string, _ []core.Key, _ string) (any, error) {
+			// ドメイン鍵で署名されたassociationになる
+			signatureBytes, err := hex.DecodeString(signature)
+			assert.NoError(t, err)
+			assert.NoError(t, core.VerifySignature([]byte(document), signatureBytes, s.config.CCID))
+
+			var doc core.AssociationDocument[LikeBody]
+			assert.NoError(t, json.Unmarshal([]byte(document), &doc))
+			assert.Equal(t, s.config.CCID, doc.Signer)
+			assert.Equal(t, User1ID, doc.Owner)
+			assert.Equal(t, messageID, doc.Target)
+			assert.Equal(t, likeSchema, doc.Schema)
+			assert.Equal(t, remote.actorURL, doc.Body.ActivityPub.Actor)
+
+			return core.Association{ID: "a00000000000000000000000000"}, nil
+		})
+
+	repo.EXPECT().SetReferenceTarget(gomock.Any(), like.ID, "a00000000000000000000000000").Return(nil)
+
+	err := s.Inbox(context.Background(), like)
+	assert.NoError(t, err)
+
+	// 同じLikeが届いても、先に確保されていればコミットしない
+	message.EXPECT().GetAsGuest(gomock.Any(), messageID).Return(core.Message{ID: messageID, Author: User1ID}, nil)
+	repo.EXPECT().ClaimReference(gomock.Any(), gomock.Any()).Return(false, nil)
+
+	err = s.Inbox(context.Background(), like)
+	assert.NoError(t, err)
+}
+
+func TestInboxLikeReleasesClaimOnFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := newFakeRemote(t)
+	s, repo, entity, message, store, _ := setupService(t, ctrl, remote)
+
+	messageID := "m00000000000000000000000000"
+	like := Activity{
+		ID:     "https://remote.example.com/likes/1",
+		Type:   "Like",
+		Actor:  remote.actorURL,
+		Object: mustMarshal(s.noteURL(messageID)),
+	}
+
+	message.EXPECT().GetAsGuest(gomock.Any(), messageID).Return(core.Message{ID: messageID, Author: User1ID}, nil)
+	entity.EXPECT().Get(gomock.Any(), s.config.CCID).Return(core.Entity{ID: s.config.CCID, Domain: LocalFQDN}, nil)
+	repo.EXPECT().ClaimReference(gomock.Any(), gomock.Any()).Return(true, nil)
+	store.EXPECT().
+		Commit(gomock.Any(), core.CommitModeExecute, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, fmt.Errorf("commit failed"))
+
+	// 再送で処理できるように確保を取り消す
+	repo.EXPECT().DeleteReference(gomock.Any(), like.ID).Return(nil)
+
+	err := s.Inbox(context.Background(), like)
+	assert.ErrorContains(t, err, "commit failed")
+}
+
+func TestInboxUndoFollowByAlias(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := newFakeRemote(t)
+	s, repo, entity, _, _, _ := setupService(t, ctrl, remote)
+
+	entity.EXPECT().GetByAlias(gomock.Any(), "alice.example.com").Return(core.Entity{ID: User1ID, Domain: LocalFQDN}, nil)
+	repo.EXPECT().RemoveFollower(gomock.Any(), remote.actorURL, User1ID).Return(nil)
+
+	err := s.Inbox(context.Background(), Activity{
+		ID:    "https://remote.example.com/follows/1/undo",
+		Type:  "Undo",
+		Actor: remote.actorURL,
+		Object: mustMarshal(InboundObject{
+			ID:     "https://remote.example.com/follows/1",
+			Type:   "Follow",
+			Actor:  remote.actorURL,
+			Object: s.actorURL("alice.example.com"),
+		}),
+	})
+	assert.NoError(t, err)
+}
+
+func TestVerifyRequiresKeyOnActorHost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := newFakeRemote(t)
+	s, _, _, _, _, _ := setupService(t, ctrl, remote)
+
+	// 別ホストのactorを名乗る署名では鍵を取りに行かない
+	req, body := remote.post(t, Activity{
+		ID:    "https://other.example.com/likes/1",
+		Type:  "Like",
+		Actor: "https://other.example.com/users/bob",
+	})
+	_, err := s.Verify(context.Background(), req, body)
+	assert.ErrorContains(t, err, "is not hosted by the actor")
+	assert.Equal(t, int32(0), remote.fetched.Load())
+
+	// 鍵は取得後しばらくキャッシュされる
+	for i := 0; i < 2; i++ {
+		req, body = remote.post(t, Activity{ID: "https://remote.example.com/likes/1", Type: "Like", Actor: remote.actorURL})
+		signer, err := s.Verify(context.Background(), req, body)
+		assert.NoError(t, err)
+		assert.Equal(t, remote.actorURL, signer)
+	}
+	assert.Equal(t, int32(1), remote.fetched.Load())
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		assert.Equal(t, public, isPublicAddress(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestHtmlToText(t *testing.T) {
+	assert.Equal(t, "hello\n<world> & you", htmlToText(`<p>hello<br/>&lt;world&gt; &amp; <a href="https://example.com">you</a></p>`))
+	assert.Equal(t, "<p>a &lt;b&gt;<br>c</p>", renderContent("a <b>\nc"))
+}
@@ -0,0 +1,227 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// HTTP Signatures (draft-cavage-http-signatures-12) as used by Mastodon and most ActivityPub servers
+
+const maxClockSkew = 12 * time.Hour
+
+var signatureParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type signatureParams struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+func digestBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func parseSignatureHeader(header string) (signatureParams, error) {
+	params := signatureParams{
+		Headers: []string{"date"},
+	}
+
+	for _, match := range signatureParamPattern.FindAllStringSubmatch(header, -1) {
+		switch match[1] {
+		case "keyId":
+			params.KeyID = match[2]
+		case "algorithm":
+			params.Algorithm = match[2]
+		case "headers":
+			params.Headers = strings.Fields(strings.ToLower(match[2]))
+		case "signature":
+			signature, err := base64.StdEncoding.DecodeString(match[2])
+			if err != nil {
+				return params, fmt.Errorf("invalid signature encoding")
+			}
+			params.Signature = signature
+		}
+	}
+
+	if params.KeyID == "" || len(params.Signature) == 0 {
+		return params, fmt.Errorf("keyId and signature are required")
+	}
+
+	if params.Algorithm != "" && params.Algorithm != "rsa-sha256" && params.Algorithm != "hs2019" {
+		return params, fmt.Errorf("unsupported algorithm %s", params.Algorithm)
+	}
+
+	return params, nil
+}
+
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			value = r.Header.Get(name)
+		}
+		if value == "" {
+			return "", fmt.Errorf("signed header %s is missing", name)
+		}
+		lines = append(lines, name+": "+value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// signRequest signs an outgoing request. body is nil for GET requests
+func signRequest(r *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", digestBody(body))
+		headers = append(headers, "digest")
+	}
+
+	signing, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(signing))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID,
+		strings.Join(headers, " "),
+		base64.StdEncoding.EncodeToString(signature),
+	))
+
+	return nil
+}
+
+// verifyRequest checks an incoming POST against the signature parameters and the sender's public key
+func verifyRequest(r *http.Request, body []byte, params signatureParams, key *rsa.PublicKey) error {
+	for _, required := range []string{"(request-target)", "host", "date", "digest"} {
+		found := false
+		for _, name := range params.Headers {
+			if name == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be signed", required)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("invalid date header")
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("date is out of range")
+	}
+
+	if r.Header.Get("Digest") != digestBody(body) {
+		return fmt.Errorf("digest mismatch")
+	}
+
+	signing, err := signingString(r, params.Headers)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(signing))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], params.Signature)
+	if err != nil {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+func generateKey() (privatePem string, publicPem string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	publicBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	privatePem = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}))
+	publicPem = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}))
+	return privatePem, publicPem, nil
+}
+
+func parsePrivateKey(privatePem string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePem))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not rsa")
+	}
+	return rsaKey, nil
+}
+
+func parsePublicKey(publicPem string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicPem))
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	// some implementations still publish PKCS#1 keys
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not rsa")
+	}
+	return rsaKey, nil
+}
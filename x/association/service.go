@@ -18,6 +18,17 @@ import (
 	"github.com/totegamma/concurrent/x/policy"
 )
 
+func init() {
+	core.RegisterPolicyAction(
+		"association.delete",
+		"message.association.attach",
+		"profile.association.attach",
+		"subscription.association.attach",
+		"timeline.association.attach",
+		"timeline.message.association.attach",
+	)
+}
+
 type service struct {
 	repo         Repository
 	client       client.Client
@@ -195,7 +206,7 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 			timelinePolicyResult := policy.AccumulateOr(timelinePolicyResults)
 			timelinePolicyIsDominant, timlinePolicyAllowed := policy.IsDominant(timelinePolicyResult)
 			if timelinePolicyIsDominant && !timlinePolicyAllowed {
-				return association, []string{}, core.ErrorPermissionDenied{}
+				return association, []string{}, core.NewErrorPermissionDeniedByStatement("timeline.message.association.attach")
 			}
 
 			var params map[string]any = make(map[string]any)
@@ -221,7 +232,7 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 
 			result := s.policy.Summerize([]core.PolicyEvalResult{timelinePolicyResult, messagePolicyResult}, "message.association.attach", nil)
 			if !result {
-				return association, []string{}, core.ErrorPermissionDenied{}
+				return association, []string{}, core.NewErrorPermissionDeniedByStatement("message.association.attach")
 			}
 
 		case 'p': // profile
@@ -257,7 +268,7 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 
 			result := s.policy.Summerize([]core.PolicyEvalResult{policyEvalResult}, "profile.association.attach", nil)
 			if !result {
-				return association, []string{}, core.ErrorPermissionDenied{}
+				return association, []string{}, core.NewErrorPermissionDeniedByStatement("profile.association.attach")
 			}
 
 		case 't': // timeline
@@ -293,7 +304,7 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 
 			result := s.policy.Summerize([]core.PolicyEvalResult{policyEvalResult}, "timeline.association.attach", nil)
 			if !result {
-				return association, []string{}, core.ErrorPermissionDenied{}
+				return association, []string{}, core.NewErrorPermissionDeniedByStatement("timeline.association.attach")
 			}
 
 		case 's': // subscription
@@ -329,7 +340,7 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 
 			result := s.policy.Summerize([]core.PolicyEvalResult{policyEvalResult}, "subscription.association.attach", nil)
 			if !result {
-				return association, []string{}, core.ErrorPermissionDenied{}
+				return association, []string{}, core.NewErrorPermissionDeniedByStatement("subscription.association.attach")
 			}
 		}
 
@@ -566,7 +577,7 @@ func (s *service) Delete(ctx context.Context, mode core.CommitMode, document, si
 
 	finally := s.policy.Summerize([]core.PolicyEvalResult{result}, "association.delete", nil)
 	if !finally {
-		return core.Association{}, []string{}, core.ErrorPermissionDenied{}
+		return core.Association{}, []string{}, core.NewErrorPermissionDeniedByStatement("association.delete")
 	}
 
 	err = s.repo.Delete(ctx, doc.Target)
@@ -13,6 +13,7 @@ var tracer = otel.Tracer("auth")
 // Handler is the interface for handling HTTP requests
 type Handler interface {
 	GetPassport(c echo.Context) error
+	RevokePassport(c echo.Context) error
 }
 
 type handler struct {
@@ -45,3 +46,32 @@ func (h *handler) GetPassport(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, echo.Map{"content": response})
 }
+
+// RevokePassport revokes a passport issued to the requester
+// input: {"passport": "<passport>"}
+func (h *handler) RevokePassport(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Auth.Handler.RevokePassport")
+	defer span.End()
+
+	requester, ok := ctx.Value(core.RequesterIdCtxKey).(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"status": "error", "message": "requester not found"})
+	}
+
+	var request struct {
+		Passport string `json:"passport"`
+	}
+	err := c.Bind(&request)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	err = h.service.RevokePassport(ctx, requester, request.Passport)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
@@ -21,6 +21,10 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 )
 
+func init() {
+	core.RegisterPolicyAction("global")
+}
+
 type Principal int
 
 const (
@@ -73,6 +77,16 @@ func (s *service) IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 				goto skipCheckPassport
 			}
 
+			if time.Since(passportDoc.SignedAt) > core.PassportLifetime {
+				span.RecordError(fmt.Errorf("passport is expired"))
+				goto skipCheckPassport
+			}
+
+			if passportDoc.ValidUntil != nil && !time.Now().Before(*passportDoc.ValidUntil) {
+				span.RecordError(fmt.Errorf("passport is expired with its keys"))
+				goto skipCheckPassport
+			}
+
 			domain, err := s.domain.GetByFQDN(ctx, passportDoc.Domain)
 			if err != nil {
 				span.RecordError(errors.Wrap(err, "failed to get domain by fqdn"))
@@ -87,21 +101,62 @@ func (s *service) IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 
 			if core.IsCSID(passportDoc.Signer) && domain.CSID == "" {
 				span.AddEvent("force fetch domain")
-				_, err := s.domain.ForceFetch(ctx, domain.ID)
+				fetched, err := s.domain.ForceFetch(ctx, domain.ID)
 				if err != nil {
 					span.RecordError(errors.Wrap(err, "failed to force fetch domain"))
 					goto skipCheckPassport
 				}
+				domain = fetched
+			}
+
+			// オペレーターのサブキーで署名されたパスポートは、発行元ドメインからキーを解決して検証する
+			// 委任元は文書の自己申告ではなく、発行元ドメインのCSIDで確認する
+			passportSigner := passportDoc.Signer
+			if passportDoc.KeyID != "" {
+				if passportDoc.Signer != domain.CSID {
+					span.RecordError(fmt.Errorf("passport signer %s is not the domain %s", passportDoc.Signer, domain.CSID))
+					goto skipCheckPassport
+				}
+
+				domainKeys, err := s.key.GetRemoteKeyResolution(ctx, domain.ID, passportDoc.KeyID)
+				if err != nil {
+					span.RecordError(errors.Wrap(err, "failed to resolve operator key"))
+					goto skipCheckPassport
+				}
+
+				err = key.ValidateOperatorKey(domainKeys, passportDoc.KeyID, domain.CSID, passportDoc.SignedAt)
+				if err != nil {
+					span.RecordError(errors.Wrap(err, "invalid operator key"))
+					goto skipCheckPassport
+				}
+
+				passportSigner = passportDoc.KeyID
 			}
 
-			err = core.VerifySignature([]byte(passport.Document), signatureBytes, passportDoc.Signer)
+			err = core.VerifySignatureWithAlgorithm([]byte(passport.Document), signatureBytes, passportSigner, passportDoc.Algorithm)
 			if err != nil { // TODO: this is misbehaving. should be logged to audit
 				span.RecordError(errors.Wrap(err, "failed to verify signature of passport"))
 				goto skipCheckPassport
 			}
 
+			// 発行元ドメインの失効リストに載っているパスポートは受け付けない
+			revoked, err := s.revocation.IsPassportRevoked(ctx, domain, passportDoc)
+			if err != nil {
+				span.RecordError(errors.Wrap(err, "failed to check revocation of passport"))
+			} else if revoked {
+				return c.JSON(http.StatusForbidden, echo.Map{
+					"error": "your passport is revoked",
+				})
+			}
+
 			if len(passportDoc.Keys) > 0 {
-				resolved, err := key.ValidateKeyResolution(passportDoc.Keys)
+				rootSigner, err := s.rootSignerOf(ctx, passportDoc.Keys, passportDoc.Domain, passportDoc.SignedAt)
+				if err != nil {
+					span.RecordError(errors.Wrap(err, "failed to resolve root signer"))
+					goto skipCheckPassport
+				}
+
+				resolved, err := key.ValidateKeyResolution(passportDoc.Keys, passportDoc.SignedAt, rootSigner)
 				if err != nil {
 					span.RecordError(errors.Wrap(err, "failed to validate key resolution"))
 					goto skipCheckPassport
@@ -173,8 +228,17 @@ func (s *service) IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 			if core.IsCCID(claims.Issuer) {
 				ccid = claims.Issuer
 			} else if core.IsCKID(claims.Issuer) {
-				if providedKeyChain, ok := ctx.Value(core.RequesterKeychainKey).([]core.Key); ok {
-					ccid, err = key.ValidateKeyResolution(providedKeyChain)
+				if recovered, err := s.entity.GetByMasterKey(ctx, claims.Issuer); err == nil {
+					// 復旧後の新しいマスターキー
+					ccid = recovered.ID
+				} else if providedKeyChain, ok := ctx.Value(core.RequesterKeychainKey).([]core.Key); ok {
+					rootSigner, err := s.rootSignerOf(ctx, providedKeyChain, "", time.Now())
+					if err != nil {
+						span.RecordError(errors.Wrap(err, "failed to resolve root signer"))
+						goto skipCheckAuthorization
+					}
+
+					ccid, err = key.ValidateKeyResolution(providedKeyChain, time.Now(), rootSigner)
 					if err != nil {
 						span.RecordError(errors.Wrap(err, "failed to validate key resolution"))
 						goto skipCheckAuthorization
@@ -188,7 +252,7 @@ func (s *service) IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 					}
 					ctx = context.WithValue(ctx, core.RequesterKeychainKey, keys)
 
-					ccid, err = s.key.ResolveSubkey(ctx, claims.Issuer)
+					ccid, err = s.key.ResolveSubkey(ctx, claims.Issuer, time.Now())
 					if err != nil {
 						span.RecordError(errors.Wrap(err, "failed to resolve subkey"))
 						goto skipCheckAuthorization
@@ -206,6 +270,14 @@ func (s *service) IdentifyIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 				return c.JSON(http.StatusForbidden, echo.Map{})
 			}
 
+			// 復旧済みのエンティティの古いマスターキーは使えない
+			if entity.MasterKey != nil && core.IsCCID(claims.Issuer) {
+				span.RecordError(fmt.Errorf("master key of %s has been replaced", ccid))
+				return c.JSON(http.StatusForbidden, echo.Map{
+					"error": "your master key has been replaced by recovery",
+				})
+			}
+
 			tags := core.ParseTags(entity.Tag)
 			ctx = context.WithValue(ctx, core.RequesterTagCtxKey, tags)
 
@@ -534,12 +606,7 @@ func (s *service) RateLimiter(configMap core.RateLimitConfigMap) echo.Middleware
 				path = "DEFAULT"
 			}
 
-			requester, ok := ctx.Value(core.RequesterIdCtxKey).(string)
-			if !ok {
-				requester = c.RealIP()
-			}
-
-			key := "rate_limit:" + requester + ":" + path
+			key := rateLimitKey(limitedRequester(c), path)
 
 			// Get the current value of the bucket
 			val, err := s.rdb.Get(ctx, key).Result()
@@ -611,3 +678,87 @@ func (s *service) RateLimiter(configMap core.RateLimitConfigMap) echo.Middleware
 		}
 	}
 }
+
+// limitedRequester identifies the requester for the limiters. anonymous requests are identified by the IP address
+func limitedRequester(c echo.Context) string {
+	requester, ok := c.Request().Context().Value(core.RequesterIdCtxKey).(string)
+	if !ok || requester == "" {
+		requester = c.RealIP()
+	}
+	return requester
+}
+
+func rateLimitKey(requester, name string) string {
+	return "rate_limit:" + requester + ":" + name
+}
+
+// acquireSlotScript holds a slot in the per-requester sorted set (member: connection, score: expiry).
+// Calling it again for the same connection refreshes the slot.
+var acquireSlotScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if not redis.call("ZSCORE", KEYS[1], ARGV[3]) and redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+`)
+
+// AcquireSlot takes one of the limited concurrent slots of the requester, identified in the same way as RateLimiter.
+// The slot expires after ttl unless it is acquired again for the same connection.
+func (s *service) AcquireSlot(ctx context.Context, c echo.Context, name, connection string, limit int, ttl time.Duration) (bool, error) {
+	ctx, span := tracer.Start(ctx, "Auth.Service.AcquireSlot")
+	defer span.End()
+
+	now := time.Now()
+	ok, err := acquireSlotScript.Run(
+		ctx,
+		s.rdb,
+		[]string{rateLimitKey(limitedRequester(c), name)},
+		now.UnixMilli(),
+		now.Add(ttl).UnixMilli(),
+		connection,
+		limit,
+		ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	return ok == 1, nil
+}
+
+// ReleaseSlot frees the slot taken by AcquireSlot
+func (s *service) ReleaseSlot(ctx context.Context, c echo.Context, name, connection string) error {
+	ctx, span := tracer.Start(ctx, "Auth.Service.ReleaseSlot")
+	defer span.End()
+
+	err := s.rdb.ZRem(ctx, rateLimitKey(limitedRequester(c), name), connection).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// rootSignerOf returns the address that signs for the root of the keychain at the given time.
+// hint is the domain to pull the root entity from when it is not known yet.
+func (s *service) rootSignerOf(ctx context.Context, keys []core.Key, hint string, at time.Time) (string, error) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	root := keys[len(keys)-1].Root
+	if !core.IsCCID(root) {
+		return "", nil
+	}
+
+	entity, err := s.entity.GetWithHint(ctx, root, hint)
+	if err != nil {
+		return "", err
+	}
+
+	return key.RootSigner(entity, at), nil
+}
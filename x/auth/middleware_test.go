@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"log"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
@@ -64,11 +66,13 @@ func TestLocalRootSuccess(t *testing.T) {
 	mockPolicy := mock_core.NewMockPolicyService(ctrl)
 	mockPolicy.EXPECT().TestWithGlobalPolicy(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.PolicyEvalResultAllow, nil)
 
+	mockRevocation := mock_core.NewMockRevocationService(ctrl)
+
 	config := core.Config{
 		FQDN: "local.example.com",
 	}
 
-	service := NewService(nil, config, mockEntity, mockDomain, mockKey, mockPolicy)
+	service := NewService(nil, config, mockEntity, mockDomain, mockKey, mockPolicy, mockRevocation)
 
 	c, req, rec, traceID := testutil.CreateHttpRequest()
 
@@ -102,6 +106,71 @@ func TestLocalRootSuccess(t *testing.T) {
 	}
 }
 
+func TestLocalRecoveredMasterKey(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	masterKey := SubKey1ID
+	recoveredAt := time.Now().Add(-time.Hour)
+	recovered := core.Entity{
+		ID:          User1ID,
+		Domain:      "local.example.com",
+		MasterKey:   &masterKey,
+		RecoveredAt: &recoveredAt,
+	}
+
+	mockEntity := mock_core.NewMockEntityService(ctrl)
+	mockEntity.EXPECT().Get(gomock.Any(), User1ID).Return(recovered, nil).AnyTimes()
+	mockEntity.EXPECT().GetByMasterKey(gomock.Any(), SubKey1ID).Return(recovered, nil).AnyTimes()
+	mockEntity.EXPECT().GetMeta(gomock.Any(), gomock.Any()).Return(core.EntityMeta{}, nil).AnyTimes()
+	mockDomain := mock_core.NewMockDomainService(ctrl)
+	mockKey := mock_core.NewMockKeyService(ctrl)
+	mockPolicy := mock_core.NewMockPolicyService(ctrl)
+	mockPolicy.EXPECT().TestWithGlobalPolicy(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.PolicyEvalResultAllow, nil).AnyTimes()
+	mockRevocation := mock_core.NewMockRevocationService(ctrl)
+
+	config := core.Config{
+		FQDN: "local.example.com",
+	}
+
+	service := NewService(nil, config, mockEntity, mockDomain, mockKey, mockPolicy, mockRevocation)
+
+	h := service.IdentifyIdentity(func(c echo.Context) error {
+		return nil
+	})
+
+	// 新しいマスターキーで署名したJWTは復旧したエンティティとして認証される
+	c, req, _, traceID := testutil.CreateHttpRequest()
+	req.Header.Set("Authorization", "Bearer "+createJwt(t, SubKey1Priv, jwt.Claims{
+		Issuer:   SubKey1ID,
+		Subject:  "concrnt",
+		Audience: "local.example.com",
+	}))
+
+	err := h(c)
+	if assert.NoError(t, err) {
+		ctx := c.Request().Context()
+		assert.Equal(t, core.LocalUser, ctx.Value(core.RequesterTypeCtxKey))
+		assert.Equal(t, User1ID, ctx.Value(core.RequesterIdCtxKey))
+	} else {
+		testutil.PrintSpans(checker.GetSpans(), traceID)
+	}
+
+	// 古いマスターキーで署名したJWTは拒否される
+	c, req, rec, _ := testutil.CreateHttpRequest()
+	req.Header.Set("Authorization", "Bearer "+createJwt(t, User1Priv, jwt.Claims{
+		Issuer:   User1ID,
+		Subject:  "concrnt",
+		Audience: "local.example.com",
+	}))
+
+	err = h(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, nil, c.Request().Context().Value(core.RequesterIdCtxKey))
+}
+
 func TestRemoteRootSuccess(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
@@ -130,11 +199,14 @@ func TestRemoteRootSuccess(t *testing.T) {
 	mockPolicy := mock_core.NewMockPolicyService(ctrl)
 	mockPolicy.EXPECT().TestWithGlobalPolicy(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.PolicyEvalResultAllow, nil)
 
+	mockRevocation := mock_core.NewMockRevocationService(ctrl)
+	mockRevocation.EXPECT().IsPassportRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+
 	config := core.Config{
 		FQDN: "local.example.com",
 	}
 
-	service := NewService(nil, config, mockEntity, mockDomain, mockKey, mockPolicy)
+	service := NewService(nil, config, mockEntity, mockDomain, mockKey, mockPolicy, mockRevocation)
 	c, req, rec, traceID := testutil.CreateHttpRequest()
 
 	fmt.Print("traceID: ", traceID, "\n")
@@ -149,7 +221,8 @@ func TestRemoteRootSuccess(t *testing.T) {
 
 	passportDoc := core.PassportDocument{
 		DocumentBase: core.DocumentBase[any]{
-			Signer: "con1er7kuzrw6vtv6nrq98d4jg7n2r0ayz772zvwxz",
+			Signer:   "con1er7kuzrw6vtv6nrq98d4jg7n2r0ayz772zvwxz",
+			SignedAt: time.Now(),
 		},
 		Domain: RemoteDomainFQDN,
 		Entity: core.Entity{
@@ -204,3 +277,132 @@ func TestRemoteRootSuccess(t *testing.T) {
 	log.Println(traceID)
 
 }
+
+func operatorKeyPassport(t *testing.T, domainPriv string) (string, []core.Key) {
+	csid, err := core.PrivKeyToAddr(domainPriv, "ccs")
+	assert.NoError(t, err)
+
+	enactDocument, err := json.Marshal(core.EnactDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   csid,
+			Type:     "enact",
+			SignedAt: time.Now().Add(-time.Hour),
+		},
+		Target: SubKey1ID,
+		Root:   csid,
+		Parent: csid,
+	})
+	assert.NoError(t, err)
+	enactSignature, err := core.SignBytes(enactDocument, domainPriv)
+	assert.NoError(t, err)
+
+	keys := []core.Key{{
+		ID:             SubKey1ID,
+		Root:           csid,
+		Parent:         csid,
+		EnactDocument:  string(enactDocument),
+		EnactSignature: hex.EncodeToString(enactSignature),
+	}}
+
+	passportDoc, err := json.Marshal(core.PassportDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   csid,
+			KeyID:    SubKey1ID,
+			Type:     "passport",
+			SignedAt: time.Now(),
+		},
+		Domain: RemoteDomainFQDN,
+		Entity: core.Entity{
+			ID:     User1ID,
+			Domain: RemoteDomainFQDN,
+		},
+		Keys: []core.Key{},
+	})
+	assert.NoError(t, err)
+	signature, err := core.SignBytes(passportDoc, SubKey1Priv)
+	assert.NoError(t, err)
+
+	passportJson, err := json.Marshal(core.Passport{
+		Document:  string(passportDoc),
+		Signature: hex.EncodeToString(signature),
+	})
+	assert.NoError(t, err)
+
+	return base64.URLEncoding.EncodeToString(passportJson), keys
+}
+
+func TestRemoteOperatorKeyPassport(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	csid, err := core.PrivKeyToAddr(RemoteDomainPriv, "ccs")
+	assert.NoError(t, err)
+
+	mockEntity := mock_core.NewMockEntityService(ctrl)
+	mockEntity.EXPECT().Affiliation(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(core.Entity{
+		ID:     User1ID,
+		Domain: RemoteDomainFQDN,
+	}, nil).Times(1)
+	mockEntity.EXPECT().Get(gomock.Any(), gomock.Any()).Return(core.Entity{
+		ID:     User1ID,
+		Domain: RemoteDomainFQDN,
+	}, nil).AnyTimes()
+	mockEntity.EXPECT().GetMeta(gomock.Any(), gomock.Any()).Return(core.EntityMeta{}, nil).AnyTimes()
+
+	mockDomain := mock_core.NewMockDomainService(ctrl)
+	mockDomain.EXPECT().GetByFQDN(gomock.Any(), RemoteDomainFQDN).Return(core.Domain{
+		ID:   RemoteDomainFQDN,
+		CCID: RemoteDomainCCID,
+		CSID: csid,
+	}, nil).AnyTimes()
+
+	mockKey := mock_core.NewMockKeyService(ctrl)
+	mockPolicy := mock_core.NewMockPolicyService(ctrl)
+	mockPolicy.EXPECT().TestWithGlobalPolicy(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.PolicyEvalResultAllow, nil).AnyTimes()
+	mockRevocation := mock_core.NewMockRevocationService(ctrl)
+	mockRevocation.EXPECT().IsPassportRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+
+	config := core.Config{
+		FQDN: "local.example.com",
+	}
+
+	service := NewService(nil, config, mockEntity, mockDomain, mockKey, mockPolicy, mockRevocation)
+
+	h := service.IdentifyIdentity(func(c echo.Context) error {
+		return nil
+	})
+
+	token := createJwt(t, User1Priv, jwt.Claims{
+		Issuer:   User1ID,
+		Subject:  "concrnt",
+		Audience: "local.example.com",
+	})
+
+	// ドメインのCSIDから委任されたオペレーターキーのパスポートは受け付ける
+	passport, keys := operatorKeyPassport(t, RemoteDomainPriv)
+	mockKey.EXPECT().GetRemoteKeyResolution(gomock.Any(), RemoteDomainFQDN, SubKey1ID).Return(keys, nil)
+
+	c, req, _, traceID := testutil.CreateHttpRequest()
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("passport", passport)
+
+	err = h(c)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, c.Request().Context().Value(core.RequesterKeychainKey))
+	} else {
+		testutil.PrintSpans(checker.GetSpans(), traceID)
+	}
+
+	// 別のCSIDから委任されたキーは、パスポートの署名者を偽っても受け付けない
+	forged, forgedKeys := operatorKeyPassport(t, User1Priv)
+	mockKey.EXPECT().GetRemoteKeyResolution(gomock.Any(), RemoteDomainFQDN, SubKey1ID).Return(forgedKeys, nil).AnyTimes()
+
+	c, req, _, _ = testutil.CreateHttpRequest()
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("passport", forged)
+
+	err = h(c)
+	assert.NoError(t, err)
+	assert.Nil(t, c.Request().Context().Value(core.RequesterKeychainKey))
+}
@@ -6,20 +6,25 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/totegamma/concurrent/cdid"
 	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/x/key"
 )
 
 type service struct {
-	rdb    *redis.Client
-	config core.Config
-	entity core.EntityService
-	domain core.DomainService
-	key    core.KeyService
-	policy core.PolicyService
+	rdb        *redis.Client
+	config     core.Config
+	entity     core.EntityService
+	domain     core.DomainService
+	key        core.KeyService
+	policy     core.PolicyService
+	revocation core.RevocationService
 }
 
 // NewService creates a new auth service
@@ -30,8 +35,9 @@ func NewService(
 	domain core.DomainService,
 	key core.KeyService,
 	policy core.PolicyService,
+	revocation core.RevocationService,
 ) core.AuthService {
-	return &service{rdb, config, entity, domain, key, policy}
+	return &service{rdb, config, entity, domain, key, policy, revocation}
 }
 
 // GetPassport takes client signed JWT and returns server signed JWT
@@ -49,12 +55,35 @@ func (s *service) IssuePassport(ctx context.Context, requester string, keys []co
 		return "", fmt.Errorf("You are not a local entity")
 	}
 
+	// 期限切れ・失効済みのサブキーにはパスポートを発行しない
+	if len(keys) > 0 {
+		root, err := key.ValidateKeyResolution(keys, time.Now(), key.RootSigner(entity, time.Now()))
+		if err != nil {
+			span.RecordError(err)
+			return "", err
+		}
+		if root != requester {
+			return "", fmt.Errorf("keychain is not matched with the requester")
+		}
+	}
+
+	validUntil, err := passportValidUntil(keys)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	keyID, privateKey := s.config.DomainSigningKey()
+
 	documentObj := core.PassportDocument{
-		Domain: s.config.FQDN,
-		Entity: entity,
-		Keys:   keys,
+		JTI:        cdid.Make().String(),
+		Domain:     s.config.FQDN,
+		Entity:     entity,
+		Keys:       keys,
+		ValidUntil: validUntil,
 		DocumentBase: core.DocumentBase[any]{
 			Signer:   s.config.CSID,
+			KeyID:    keyID,
 			Type:     "passport",
 			SignedAt: time.Now(),
 		},
@@ -66,7 +95,7 @@ func (s *service) IssuePassport(ctx context.Context, requester string, keys []co
 		return "", err
 	}
 
-	signatureBytes, err := core.SignBytes(document, s.config.PrivateKey)
+	signatureBytes, err := core.SignBytes(document, privateKey)
 	if err != nil {
 		span.RecordError(err)
 		return "", err
@@ -89,3 +118,94 @@ func (s *service) IssuePassport(ctx context.Context, requester string, keys []co
 
 	return websafePassport, nil
 }
+
+// passportValidUntil checks that every key of the keychain may be exchanged for a passport
+// and returns the earliest expiry of the keychain.
+// A passport carries the whole authority of the requester, so a scoped key must list "passport" in its types.
+func passportValidUntil(keys []core.Key) (*time.Time, error) {
+	var validUntil *time.Time
+	for _, k := range keys {
+		var enact core.EnactDocument
+		err := json.Unmarshal([]byte(k.EnactDocument), &enact)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal enact document")
+		}
+
+		if enact.Scope != nil && !slices.Contains(enact.Scope.Types, "passport") {
+			return nil, fmt.Errorf("Key %s is not allowed to issue a passport", k.ID)
+		}
+
+		// 署名された期限と、復旧で打ち切られた期限の早い方
+		expiries := []time.Time{k.ValidUntil}
+		if enact.ValidUntil != nil {
+			expiries = append(expiries, *enact.ValidUntil)
+		}
+		for _, expiry := range expiries {
+			if expiry.IsZero() {
+				continue
+			}
+			if validUntil == nil || expiry.Before(*validUntil) {
+				e := expiry
+				validUntil = &e
+			}
+		}
+	}
+	return validUntil, nil
+}
+
+// RevokePassport publishes the revocation of a passport issued to the requester
+func (s *service) RevokePassport(ctx context.Context, requester, passport string) error {
+	ctx, span := tracer.Start(ctx, "Auth.Service.RevokePassport")
+	defer span.End()
+
+	passportJson, err := base64.URLEncoding.DecodeString(passport)
+	if err != nil {
+		span.RecordError(err)
+		return errors.Wrap(err, "failed to decode passport")
+	}
+
+	var p core.Passport
+	err = json.Unmarshal(passportJson, &p)
+	if err != nil {
+		span.RecordError(err)
+		return errors.Wrap(err, "failed to unmarshal passport")
+	}
+
+	var doc core.PassportDocument
+	err = json.Unmarshal([]byte(p.Document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return errors.Wrap(err, "failed to unmarshal passport document")
+	}
+
+	if doc.Domain != s.config.FQDN || doc.Signer != s.config.CSID {
+		return fmt.Errorf("passport is not issued by this domain")
+	}
+
+	if doc.Entity.ID != requester {
+		return fmt.Errorf("passport is not issued for you")
+	}
+
+	if doc.JTI == "" {
+		return fmt.Errorf("passport has no jti")
+	}
+
+	signer := doc.Signer
+	if doc.KeyID != "" {
+		signer = doc.KeyID
+	}
+
+	signature, err := hex.DecodeString(p.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	err = core.VerifySignatureWithAlgorithm([]byte(p.Document), signature, signer, doc.Algorithm)
+	if err != nil {
+		span.RecordError(err)
+		return errors.Wrap(err, "failed to verify passport")
+	}
+
+	return s.revocation.Publish(ctx, core.RevocationTypePassport, doc.JTI, time.Now())
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+)
+
+func enactSubkey(t *testing.T, validUntil *time.Time, scope *core.KeyScope) core.Key {
+	enactDocument, err := json.Marshal(core.EnactDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   User1ID,
+			Type:     "enact",
+			SignedAt: time.Now().Add(-time.Hour),
+		},
+		Target:     SubKey1ID,
+		Root:       User1ID,
+		Parent:     User1ID,
+		ValidUntil: validUntil,
+		Scope:      scope,
+	})
+	assert.NoError(t, err)
+	enactSignature, err := core.SignBytes(enactDocument, User1Priv)
+	assert.NoError(t, err)
+
+	return core.Key{
+		ID:             SubKey1ID,
+		Root:           User1ID,
+		Parent:         User1ID,
+		EnactDocument:  string(enactDocument),
+		EnactSignature: hex.EncodeToString(enactSignature),
+	}
+}
+
+func decodePassport(t *testing.T, passport string) core.PassportDocument {
+	passportJson, err := base64.URLEncoding.DecodeString(passport)
+	assert.NoError(t, err)
+	var p core.Passport
+	assert.NoError(t, json.Unmarshal(passportJson, &p))
+	var doc core.PassportDocument
+	assert.NoError(t, json.Unmarshal([]byte(p.Document), &doc))
+	return doc
+}
+
+func TestIssuePassportKeyScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockEntity := mock_core.NewMockEntityService(ctrl)
+	mockEntity.EXPECT().Get(gomock.Any(), User1ID).Return(core.Entity{ID: User1ID, Domain: "local.example.com"}, nil).AnyTimes()
+
+	config := core.SetupConfig(core.ConfigInput{FQDN: "local.example.com", PrivateKey: RemoteDomainPriv})
+	service := NewService(nil, config, mockEntity, nil, nil, nil, nil)
+
+	// 投稿用に範囲を絞ったキーはパスポートに交換できない
+	scoped := enactSubkey(t, nil, &core.KeyScope{Types: []string{"message"}})
+	_, err := service.IssuePassport(context.Background(), User1ID, []core.Key{scoped})
+	assert.ErrorContains(t, err, "not allowed to issue a passport")
+
+	scoped = enactSubkey(t, nil, &core.KeyScope{Timelines: []string{"home@" + User1ID}})
+	_, err = service.IssuePassport(context.Background(), User1ID, []core.Key{scoped})
+	assert.ErrorContains(t, err, "not allowed to issue a passport")
+
+	allowed := enactSubkey(t, nil, &core.KeyScope{Types: []string{"message", "passport"}})
+	passport, err := service.IssuePassport(context.Background(), User1ID, []core.Key{allowed})
+	assert.NoError(t, err)
+	assert.Nil(t, decodePassport(t, passport).ValidUntil)
+}
+
+func TestIssuePassportValidUntil(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockEntity := mock_core.NewMockEntityService(ctrl)
+	mockEntity.EXPECT().Get(gomock.Any(), User1ID).Return(core.Entity{ID: User1ID, Domain: "local.example.com"}, nil).AnyTimes()
+
+	config := core.SetupConfig(core.ConfigInput{FQDN: "local.example.com", PrivateKey: RemoteDomainPriv})
+	service := NewService(nil, config, mockEntity, nil, nil, nil, nil)
+
+	// パスポートはキーより長く使えない
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	passport, err := service.IssuePassport(context.Background(), User1ID, []core.Key{enactSubkey(t, &expiry, nil)})
+	assert.NoError(t, err)
+	doc := decodePassport(t, passport)
+	if assert.NotNil(t, doc.ValidUntil) {
+		assert.True(t, expiry.Equal(*doc.ValidUntil))
+	}
+
+	// 復旧で打ち切られた期限のほうが早ければそちらに合わせる
+	cutoff := time.Now().Add(30 * time.Minute).UTC().Truncate(time.Second)
+	key := enactSubkey(t, &expiry, nil)
+	key.ValidUntil = cutoff
+	passport, err = service.IssuePassport(context.Background(), User1ID, []core.Key{key})
+	assert.NoError(t, err)
+	doc = decodePassport(t, passport)
+	if assert.NotNil(t, doc.ValidUntil) {
+		assert.True(t, cutoff.Equal(*doc.ValidUntil))
+	}
+}
@@ -1,9 +1,11 @@
+//go:generate go run go.uber.org/mock/mockgen -source=repository.go -destination=mock/repository.go
 package entity
 
 import (
 	"context"
 	"log/slog"
 	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -23,6 +25,12 @@ type Repository interface {
 	UpdateScore(ctx context.Context, id string, score int) error
 	UpdateTag(ctx context.Context, id, tag string) error
 	SetTombstone(ctx context.Context, id, document, signature string) error
+	SetGuardian(ctx context.Context, id, document, signature string) error
+	GetByMasterKey(ctx context.Context, masterKey string) (core.Entity, error)
+	SetMasterKey(ctx context.Context, id, masterKey string, recoveredAt time.Time, proof string) error
+	UpsertRecoveryApproval(ctx context.Context, approval core.RecoveryApproval) error
+	GetRecoveryApprovals(ctx context.Context, target string) ([]core.RecoveryApproval, error)
+	DeleteRecoveryApprovals(ctx context.Context, target string) error
 	GetList(ctx context.Context) ([]core.Entity, error)
 	Delete(ctx context.Context, key string) error
 	DeleteMeta(ctx context.Context, ccid string) error
@@ -35,6 +43,10 @@ type repository struct {
 	schema core.SchemaService
 }
 
+// recoveryColumns are only changed by SetGuardian and SetMasterKey.
+// Save must not reset them when the affiliation is updated.
+var recoveryColumns = []string{"guardian_document", "guardian_signature", "master_key", "recovered_at", "recovery_proof"}
+
 // NewRepository creates a new host repository
 func NewRepository(db *gorm.DB, mc *memcache.Client, schema core.SchemaService) Repository {
 	return &repository{db, mc, schema}
@@ -83,13 +95,80 @@ func (r *repository) SetTombstone(ctx context.Context, id, document, signature s
 	defer span.End()
 
 	err := r.db.Model(&core.Entity{}).Where("id = ?", id).Updates(map[string]interface{}{
-		"tombstone_payload":   document,
+		"tombstone_document":  document,
 		"tombstone_signature": signature,
 	}).Error
 
 	return err
 }
 
+// SetGuardian sets the guardian document of a entity
+func (r *repository) SetGuardian(ctx context.Context, id, document, signature string) error {
+	ctx, span := tracer.Start(ctx, "Entity.Repository.SetGuardian")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Model(&core.Entity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"guardian_document":  document,
+		"guardian_signature": signature,
+	}).Error
+}
+
+// SetMasterKey rebinds a entity to a new master key
+func (r *repository) SetMasterKey(ctx context.Context, id, masterKey string, recoveredAt time.Time, proof string) error {
+	ctx, span := tracer.Start(ctx, "Entity.Repository.SetMasterKey")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Model(&core.Entity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"master_key":     masterKey,
+		"recovered_at":   recoveredAt,
+		"recovery_proof": proof,
+	}).Error
+}
+
+// GetByMasterKey returns the recovered entity bound to the master key
+func (r *repository) GetByMasterKey(ctx context.Context, masterKey string) (core.Entity, error) {
+	ctx, span := tracer.Start(ctx, "Entity.Repository.GetByMasterKey")
+	defer span.End()
+
+	var entity core.Entity
+	err := r.db.WithContext(ctx).First(&entity, "master_key = ?", masterKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return core.Entity{}, core.NewErrorNotFound()
+		}
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	return entity, nil
+}
+
+// UpsertRecoveryApproval saves the approval. replaces the previous one of the same guardian
+func (r *repository) UpsertRecoveryApproval(ctx context.Context, approval core.RecoveryApproval) error {
+	ctx, span := tracer.Start(ctx, "Entity.Repository.UpsertRecoveryApproval")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Save(&approval).Error
+}
+
+// GetRecoveryApprovals returns pending approvals for the target
+func (r *repository) GetRecoveryApprovals(ctx context.Context, target string) ([]core.RecoveryApproval, error) {
+	ctx, span := tracer.Start(ctx, "Entity.Repository.GetRecoveryApprovals")
+	defer span.End()
+
+	var approvals []core.RecoveryApproval
+	err := r.db.WithContext(ctx).Where("target = ?", target).Find(&approvals).Error
+	return approvals, err
+}
+
+// DeleteRecoveryApprovals deletes every approval for the target
+func (r *repository) DeleteRecoveryApprovals(ctx context.Context, target string) error {
+	ctx, span := tracer.Start(ctx, "Entity.Repository.DeleteRecoveryApprovals")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Delete(&core.RecoveryApproval{}, "target = ?", target).Error
+}
+
 // Get returns a entity by key
 func (r *repository) Get(ctx context.Context, key string) (core.Entity, error) {
 	ctx, span := tracer.Start(ctx, "Entity.Repository.Get")
@@ -155,7 +234,7 @@ func (r *repository) Upsert(ctx context.Context, entity core.Entity) (core.Entit
 		isNewRecord = true
 	}
 
-	if err := r.db.WithContext(ctx).Save(&entity).Error; err != nil {
+	if err := r.db.WithContext(ctx).Omit(recoveryColumns...).Save(&entity).Error; err != nil {
 		return core.Entity{}, err
 	}
 
@@ -184,7 +263,7 @@ func (r *repository) UpsertWithMeta(ctx context.Context, entity core.Entity, met
 	defer span.End()
 
 	err := r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&entity).Error; err != nil {
+		if err := tx.Omit(recoveryColumns...).Save(&entity).Error; err != nil {
 			return err
 		}
 		if err := tx.Save(&meta).Error; err != nil {
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"log/slog"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,14 +16,23 @@ import (
 	"github.com/totegamma/concurrent/client"
 	"github.com/totegamma/concurrent/core"
 	"github.com/totegamma/concurrent/x/jwt"
+	"github.com/totegamma/concurrent/x/key"
 )
 
+// movedEntityRefreshInterval is how long the record of an entity moved to another domain is served without asking its new home
+const movedEntityRefreshInterval = 10 * time.Minute
+
+func init() {
+	core.RegisterPolicyAction("invite")
+}
+
 type service struct {
 	repository Repository
 	client     client.Client
 	config     core.Config
 	key        core.KeyService
 	policy     core.PolicyService
+	revocation core.RevocationService
 	jwtService jwt.Service
 }
 
@@ -32,6 +43,7 @@ func NewService(
 	config core.Config,
 	key core.KeyService,
 	policy core.PolicyService,
+	revocation core.RevocationService,
 	jwtService jwt.Service,
 ) core.EntityService {
 	return &service{
@@ -40,6 +52,7 @@ func NewService(
 		config,
 		key,
 		policy,
+		revocation,
 		jwtService,
 	}
 }
@@ -62,13 +75,29 @@ func (s *service) PullEntityFromRemote(ctx context.Context, id, remote string) (
 		return core.Entity{}, err
 	}
 
-	signatureBytes, err := hex.DecodeString(entity.AffiliationSignature)
-	if err != nil {
-		span.RecordError(err)
-		return core.Entity{}, err
+	// 復旧済みのエンティティは、ガーディアンの承認を検証できた場合だけ新しいマスターキーを受け入れる
+	signer := id
+	if entity.MasterKey != nil {
+		masterKey, recoveredAt, err := s.verifyRecovery(ctx, entity)
+		if err != nil {
+			span.RecordError(err)
+			return core.Entity{}, errors.Wrap(err, "failed to verify recovery")
+		}
+		if masterKey != *entity.MasterKey {
+			return core.Entity{}, fmt.Errorf("masterKey is not matched with the recovery proof")
+		}
+		entity.RecoveredAt = &recoveredAt
+
+		var affiliation core.AffiliationDocument
+		err = json.Unmarshal([]byte(entity.AffiliationDocument), &affiliation)
+		if err != nil {
+			span.RecordError(err)
+			return core.Entity{}, errors.Wrap(err, "Failed to unmarshal affiliation document")
+		}
+		signer = key.RootSigner(entity, affiliation.SignedAt)
 	}
 
-	err = core.VerifySignature([]byte(entity.AffiliationDocument), signatureBytes, id)
+	err = core.VerifyDocumentSignature(entity.AffiliationDocument, entity.AffiliationSignature, signer)
 	if err != nil {
 		span.RecordError(err)
 		return core.Entity{}, err
@@ -80,9 +109,134 @@ func (s *service) PullEntityFromRemote(ctx context.Context, id, remote string) (
 		return core.Entity{}, err
 	}
 
+	if entity.MasterKey != nil {
+		err = s.repository.SetMasterKey(ctx, id, *entity.MasterKey, *entity.RecoveredAt, *entity.RecoveryProof)
+		if err != nil {
+			span.RecordError(err)
+			return core.Entity{}, err
+		}
+		created.MasterKey = entity.MasterKey
+		created.RecoveredAt = entity.RecoveredAt
+		created.RecoveryProof = entity.RecoveryProof
+	}
+
 	return created, nil
 }
 
+// verifyRecovery checks the recovery proofs of the entity from the CCID onwards
+// and returns the resulting master key and the time of the last recovery.
+func (s *service) verifyRecovery(ctx context.Context, entity core.Entity) (string, time.Time, error) {
+	if entity.RecoveryProof == nil {
+		return "", time.Time{}, fmt.Errorf("recovery proof is missing")
+	}
+
+	var proofs []core.RecoveryProof
+	err := json.Unmarshal([]byte(*entity.RecoveryProof), &proofs)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to unmarshal recovery proof")
+	}
+
+	if len(proofs) == 0 {
+		return "", time.Time{}, fmt.Errorf("recovery proof is empty")
+	}
+
+	current := core.Entity{ID: entity.ID}
+	for i, proof := range proofs {
+		var guardian core.GuardianDocument
+		err := json.Unmarshal([]byte(proof.GuardianDocument), &guardian)
+		if err != nil {
+			return "", time.Time{}, errors.Wrap(err, "Failed to unmarshal guardian document")
+		}
+
+		if guardian.Type != "guardian" || guardian.Signer != entity.ID || guardian.KeyID != "" {
+			return "", time.Time{}, fmt.Errorf("proof %d: invalid guardian document", i)
+		}
+
+		// ガーディアンの登録は、その時点のマスターキーで署名されている
+		err = core.VerifyDocumentSignature(proof.GuardianDocument, proof.GuardianSignature, key.RootSigner(current, guardian.SignedAt))
+		if err != nil {
+			return "", time.Time{}, errors.Wrapf(err, "proof %d: failed to verify guardian document", i)
+		}
+
+		since := guardian.SignedAt
+		if current.RecoveredAt != nil && current.RecoveredAt.After(since) {
+			since = *current.RecoveredAt
+		}
+
+		verified := make([]core.RecoveryApproval, 0, len(proof.Approvals))
+		for _, approval := range proof.Approvals {
+			var doc core.RecoverDocument
+			err := json.Unmarshal([]byte(approval.Document), &doc)
+			if err != nil {
+				return "", time.Time{}, errors.Wrap(err, "Failed to unmarshal recover document")
+			}
+
+			if doc.Type != "recover" || doc.KeyID != "" || doc.Signer != approval.Guardian || doc.Target != entity.ID ||
+				doc.NewKey != approval.NewKey || !doc.SignedAt.Equal(approval.SignedAt) {
+				return "", time.Time{}, fmt.Errorf("proof %d: invalid recover document of %s", i, approval.Guardian)
+			}
+
+			guardianSigner, err := s.signerOf(ctx, approval.Guardian, doc.SignedAt)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+
+			err = core.VerifyDocumentSignature(approval.Document, approval.Signature, guardianSigner)
+			if err != nil {
+				return "", time.Time{}, errors.Wrapf(err, "proof %d: failed to verify recover document of %s", i, approval.Guardian)
+			}
+
+			verified = append(verified, approval)
+		}
+
+		if len(verified) == 0 {
+			return "", time.Time{}, fmt.Errorf("proof %d: no approvals", i)
+		}
+
+		newKey := verified[0].NewKey
+		approved, recoveredAt := countApprovals(guardian, verified, newKey, since)
+		if len(approved) < guardian.Threshold {
+			return "", time.Time{}, fmt.Errorf("proof %d: not enough approvals", i)
+		}
+
+		current.MasterKey = &newKey
+		current.RecoveredAt = &recoveredAt
+	}
+
+	return *current.MasterKey, *current.RecoveredAt, nil
+}
+
+// countApprovals returns the approvals of distinct guardians for newKey signed after since,
+// and the signedAt of the latest one
+func countApprovals(guardian core.GuardianDocument, approvals []core.RecoveryApproval, newKey string, since time.Time) ([]core.RecoveryApproval, time.Time) {
+	approved := make([]core.RecoveryApproval, 0)
+	seen := make(map[string]bool)
+	var latest time.Time
+	for _, approval := range approvals {
+		if approval.NewKey != newKey || !approval.SignedAt.After(since) || !slices.Contains(guardian.Guardians, approval.Guardian) || seen[approval.Guardian] {
+			continue
+		}
+		seen[approval.Guardian] = true
+		approved = append(approved, approval)
+		if approval.SignedAt.After(latest) {
+			latest = approval.SignedAt
+		}
+	}
+	return approved, latest
+}
+
+// signerOf returns the address that signed for the ccid at the given time, as far as this domain knows
+func (s *service) signerOf(ctx context.Context, ccid string, at time.Time) (string, error) {
+	entity, err := s.repository.Get(ctx, ccid)
+	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return ccid, nil
+		}
+		return "", err
+	}
+	return key.RootSigner(entity, at), nil
+}
+
 // Total returns the count number of entities
 func (s *service) Count(ctx context.Context) (int64, error) {
 	ctx, span := tracer.Start(ctx, "Entity.Service.Count")
@@ -118,6 +272,29 @@ func (s *service) Affiliation(ctx context.Context, mode core.CommitMode, documen
 	}
 
 	if doc.Domain == s.config.FQDN {
+		// ドメイン鍵はcaptchaや招待なしで登録する (ActivityPubブリッジ等がリソースを所有するため)
+		if doc.Signer == s.config.CCID {
+			entity, _, err := s.repository.UpsertWithMeta(
+				ctx,
+				core.Entity{
+					ID:                   doc.Signer,
+					Domain:               doc.Domain,
+					AffiliationDocument:  document,
+					AffiliationSignature: signature,
+				},
+				core.EntityMeta{
+					ID:   doc.Signer,
+					Info: "null",
+				},
+			)
+			if err != nil {
+				span.RecordError(err)
+				return core.Entity{}, errors.Wrap(err, "Failed to create domain entity")
+			}
+
+			return entity, nil
+		}
+
 		if s.config.SiteKey != "" {
 			captchaVerified, ok := ctx.Value(core.CaptchaVerifiedKey).(bool)
 			if !ok || !captchaVerified {
@@ -183,7 +360,7 @@ func (s *service) Affiliation(ctx context.Context, mode core.CommitMode, documen
 
 			inviterccid := claims.Issuer
 			if core.IsCKID(inviterccid) {
-				inviterccid, err = s.key.ResolveSubkey(ctx, inviterccid)
+				inviterccid, err = s.key.ResolveSubkey(ctx, inviterccid, time.Now())
 				if err != nil {
 					span.RecordError(err)
 					return core.Entity{}, err
@@ -292,9 +469,209 @@ func (s *service) Tombstone(ctx context.Context, mode core.CommitMode, document,
 		return core.Entity{}, err
 	}
 
+	err = s.revocation.Publish(ctx, core.RevocationTypeEntity, doc.Signer, doc.SignedAt)
+	if err != nil {
+		span.RecordError(errors.Wrap(err, "failed to publish revocation"))
+	}
+
 	return core.Entity{}, nil
 }
 
+// Guardian registers the guardians who can recover the entity.
+// The document must be signed by the master key, not by a subkey.
+func (s *service) Guardian(ctx context.Context, mode core.CommitMode, document, signature string) (core.Entity, error) {
+	ctx, span := tracer.Start(ctx, "Entity.Service.Guardian")
+	defer span.End()
+
+	var doc core.GuardianDocument
+	err := json.Unmarshal([]byte(document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, errors.Wrap(err, "Failed to unmarshal document")
+	}
+
+	if doc.KeyID != "" {
+		return core.Entity{}, fmt.Errorf("guardian document must be signed by the master key")
+	}
+
+	entity, err := s.repository.Get(ctx, doc.Signer)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	if entity.Domain != s.config.FQDN {
+		return core.Entity{}, fmt.Errorf("You are not a local entity")
+	}
+
+	// guardiansが空ならリカバリーを無効化する
+	if len(doc.Guardians) > 0 || doc.Threshold != 0 {
+		if doc.Threshold < 1 || doc.Threshold > len(doc.Guardians) {
+			return core.Entity{}, fmt.Errorf("threshold must be between 1 and the number of guardians")
+		}
+		seen := make(map[string]bool)
+		for _, guardian := range doc.Guardians {
+			if !core.IsCCID(guardian) || guardian == doc.Signer || seen[guardian] {
+				return core.Entity{}, fmt.Errorf("invalid guardian: %s", guardian)
+			}
+			seen[guardian] = true
+		}
+	}
+
+	if entity.GuardianDocument != nil {
+		var current core.GuardianDocument
+		err = json.Unmarshal([]byte(*entity.GuardianDocument), &current)
+		if err == nil && !doc.SignedAt.After(current.SignedAt) {
+			return core.Entity{}, fmt.Errorf("guardian document is older than the current one")
+		}
+	}
+
+	err = s.repository.SetGuardian(ctx, doc.Signer, document, signature)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	entity.GuardianDocument = &document
+	entity.GuardianSignature = &signature
+
+	return entity, nil
+}
+
+// Recover records a guardian's approval. When the threshold of guardians approve
+// the same new key, the entity is rebound to it and the old subkeys are invalidated.
+func (s *service) Recover(ctx context.Context, mode core.CommitMode, document, signature string) (core.Entity, error) {
+	ctx, span := tracer.Start(ctx, "Entity.Service.Recover")
+	defer span.End()
+
+	var doc core.RecoverDocument
+	err := json.Unmarshal([]byte(document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, errors.Wrap(err, "Failed to unmarshal document")
+	}
+
+	if !core.IsCKID(doc.NewKey) {
+		return core.Entity{}, fmt.Errorf("newKey must be a key address")
+	}
+
+	// 承認はリモートでも検証できるようにマスターキーで署名させる
+	if doc.KeyID != "" {
+		return core.Entity{}, fmt.Errorf("recover document must be signed by the master key")
+	}
+
+	entity, err := s.repository.Get(ctx, doc.Target)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	if entity.Domain != s.config.FQDN {
+		return core.Entity{}, fmt.Errorf("target is not a local entity")
+	}
+
+	if entity.GuardianDocument == nil {
+		return core.Entity{}, fmt.Errorf("target has no guardians")
+	}
+
+	var guardian core.GuardianDocument
+	err = json.Unmarshal([]byte(*entity.GuardianDocument), &guardian)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, errors.Wrap(err, "Failed to unmarshal guardian document")
+	}
+
+	if !slices.Contains(guardian.Guardians, doc.Signer) {
+		return core.Entity{}, fmt.Errorf("signer is not a guardian of the target")
+	}
+
+	// ガーディアンの変更前や前回の復旧前に署名された承認は無効
+	since := guardian.SignedAt
+	if entity.RecoveredAt != nil && entity.RecoveredAt.After(since) {
+		since = *entity.RecoveredAt
+	}
+	if !doc.SignedAt.After(since) {
+		return core.Entity{}, fmt.Errorf("recover document is outdated")
+	}
+
+	err = s.repository.UpsertRecoveryApproval(ctx, core.RecoveryApproval{
+		Target:    doc.Target,
+		Guardian:  doc.Signer,
+		NewKey:    doc.NewKey,
+		Document:  document,
+		Signature: signature,
+		SignedAt:  doc.SignedAt,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	approvals, err := s.repository.GetRecoveryApprovals(ctx, doc.Target)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	approved, recoveredAt := countApprovals(guardian, approvals, doc.NewKey, since)
+	if len(approved) < guardian.Threshold {
+		return entity, nil
+	}
+
+	// 他のドメインが新しいマスターキーを検証できるように、承認をこれまでの復旧の記録に追加する
+	var proofs []core.RecoveryProof
+	if entity.RecoveryProof != nil {
+		err = json.Unmarshal([]byte(*entity.RecoveryProof), &proofs)
+		if err != nil {
+			span.RecordError(err)
+			return core.Entity{}, errors.Wrap(err, "Failed to unmarshal recovery proof")
+		}
+	}
+
+	proof := core.RecoveryProof{
+		GuardianDocument: *entity.GuardianDocument,
+		Approvals:        approved,
+	}
+	if entity.GuardianSignature != nil {
+		proof.GuardianSignature = *entity.GuardianSignature
+	}
+
+	proofBytes, err := json.Marshal(append(proofs, proof))
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+	proofString := string(proofBytes)
+
+	err = s.repository.SetMasterKey(ctx, doc.Target, doc.NewKey, recoveredAt, proofString)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	err = s.key.InvalidateAll(ctx, doc.Target, recoveredAt)
+	if err != nil {
+		span.RecordError(err)
+		return core.Entity{}, err
+	}
+
+	err = s.repository.DeleteRecoveryApprovals(ctx, doc.Target)
+	if err != nil {
+		span.RecordError(errors.Wrap(err, "failed to delete recovery approvals"))
+	}
+
+	slog.Info(
+		fmt.Sprintf("entity %s is recovered with %s", doc.Target, doc.NewKey),
+		slog.String("module", "entity"),
+	)
+
+	entity.MasterKey = &doc.NewKey
+	entity.RecoveredAt = &recoveredAt
+	entity.RecoveryProof = &proofString
+
+	return entity, nil
+}
+
 // Get returns entity by ccid
 func (s *service) Get(ctx context.Context, key string) (core.Entity, error) {
 	ctx, span := tracer.Start(ctx, "Entity.Service.Get")
@@ -306,6 +683,20 @@ func (s *service) Get(ctx context.Context, key string) (core.Entity, error) {
 		return core.Entity{}, err
 	}
 
+	// 自ドメインから移行済みのエンティティは移行先の情報を返す
+	// 移行先への問い合わせは記録が古くなったときだけ行う
+	if entity.Domain != s.config.FQDN && time.Since(entity.MDate) > movedEntityRefreshInterval {
+		_, err := s.repository.GetMeta(ctx, key)
+		if err == nil {
+			moved, err := s.PullEntityFromRemote(ctx, key, entity.Domain)
+			if err != nil {
+				span.RecordError(errors.Wrap(err, "failed to pull moved entity"))
+				return entity, nil
+			}
+			return moved, nil
+		}
+	}
+
 	return entity, nil
 }
 
@@ -332,6 +723,14 @@ func (s *service) GetWithHint(ctx context.Context, ccid, hint string) (core.Enti
 	return entity, nil
 }
 
+// GetByMasterKey returns the recovered entity bound to the master key
+func (s *service) GetByMasterKey(ctx context.Context, masterKey string) (core.Entity, error) {
+	ctx, span := tracer.Start(ctx, "Entity.Service.GetByMasterKey")
+	defer span.End()
+
+	return s.repository.GetByMasterKey(ctx, masterKey)
+}
+
 func (s *service) GetByAlias(ctx context.Context, alias string) (core.Entity, error) {
 	ctx, span := tracer.Start(ctx, "Entity.Service.GetByAlias")
 	defer span.End()
@@ -367,7 +766,8 @@ func (s *service) GetByAlias(ctx context.Context, alias string) (core.Entity, er
 		return core.Entity{}, err
 	}
 
-	err = core.VerifySignature([]byte(alias), signatureBytes, ccid)
+	// algが無ければsecp256k1
+	err = core.VerifySignatureWithAlgorithm([]byte(alias), signatureBytes, ccid, kv["alg"])
 	if err != nil {
 		return core.Entity{}, err
 	}
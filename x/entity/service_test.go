@@ -0,0 +1,237 @@
+package entity
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/client/mock"
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+	"github.com/totegamma/concurrent/x/entity/mock"
+)
+
+const (
+	User1ID    = "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	Guardian1  = "con1fk8zlkrfmens3sgj7dzcu3gsw8v9kkysrf8dt5"
+	Guardian2  = "con1er7kuzrw6vtv6nrq98d4jg7n2r0ayz772zvwxz"
+	Guardian3  = "con1t0tey8uxhkqkd4wcp4hd4jedt7f0vfhk29xdd2"
+	NewKeyAddr = "cck1ydda2qj3nr32hulm65vj2g746f06hy36wzh9ke"
+
+	User1Priv     = "3fcfac6c211b743975de2d7b3f622c12694b8125daf4013562c5a1aefa3253a5"
+	Guardian1Priv = "863183823d2c2a19101140eef0f905c872de1dae6470c9129a1547f3482cb612"
+	Guardian2Priv = "1ca30329e8d35217b2328bacfc21c5e3d762713edab0252eead1f4c1ac0b4d81"
+	MasterKeyPriv = "a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0"
+
+	RemoteDomainFQDN = "remote.example.com"
+)
+
+func recoverDocument(t *testing.T, guardian string, signedAt time.Time) string {
+	document, err := json.Marshal(core.RecoverDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   guardian,
+			Type:     "recover",
+			SignedAt: signedAt,
+		},
+		Target: User1ID,
+		NewKey: NewKeyAddr,
+	})
+	assert.NoError(t, err)
+	return string(document)
+}
+
+func TestRecover(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	guardianSignedAt := time.Now().Add(-time.Hour)
+	guardianDocument, err := json.Marshal(core.GuardianDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   User1ID,
+			Type:     "guardian",
+			SignedAt: guardianSignedAt,
+		},
+		Guardians: []string{Guardian1, Guardian2, Guardian3},
+		Threshold: 2,
+	})
+	assert.NoError(t, err)
+	guardian := string(guardianDocument)
+
+	mockRepo := mock_entity.NewMockRepository(ctrl)
+	mockRepo.EXPECT().Get(gomock.Any(), User1ID).Return(core.Entity{
+		ID:               User1ID,
+		Domain:           "local.example.com",
+		GuardianDocument: &guardian,
+	}, nil).AnyTimes()
+
+	mockKey := mock_core.NewMockKeyService(ctrl)
+
+	service := NewService(mockRepo, nil, core.Config{FQDN: "local.example.com"}, mockKey, nil, nil, nil)
+
+	// ガーディアンでない署名者は拒否
+	_, err = service.Recover(context.Background(), core.CommitModeExecute, recoverDocument(t, User1ID, time.Now()), "")
+	assert.Error(t, err)
+
+	// ガーディアン登録前の承認は拒否
+	_, err = service.Recover(context.Background(), core.CommitModeExecute, recoverDocument(t, Guardian1, guardianSignedAt.Add(-time.Minute)), "")
+	assert.Error(t, err)
+
+	// 1人目: しきい値未満なので復旧しない
+	first := core.RecoveryApproval{Target: User1ID, Guardian: Guardian1, NewKey: NewKeyAddr, SignedAt: time.Now()}
+	mockRepo.EXPECT().UpsertRecoveryApproval(gomock.Any(), gomock.Any()).Return(nil)
+	mockRepo.EXPECT().GetRecoveryApprovals(gomock.Any(), User1ID).Return([]core.RecoveryApproval{first}, nil)
+
+	entity, err := service.Recover(context.Background(), core.CommitModeExecute, recoverDocument(t, Guardian1, first.SignedAt), "")
+	assert.NoError(t, err)
+	assert.Nil(t, entity.MasterKey)
+
+	// 2人目: マスターキーを差し替え、古いサブキーを無効化する
+	second := core.RecoveryApproval{Target: User1ID, Guardian: Guardian2, NewKey: NewKeyAddr, SignedAt: time.Now()}
+	mockRepo.EXPECT().UpsertRecoveryApproval(gomock.Any(), gomock.Any()).Return(nil)
+	mockRepo.EXPECT().GetRecoveryApprovals(gomock.Any(), User1ID).Return([]core.RecoveryApproval{first, second}, nil)
+	mockRepo.EXPECT().SetMasterKey(gomock.Any(), User1ID, NewKeyAddr, second.SignedAt, gomock.Any()).Return(nil)
+	mockKey.EXPECT().InvalidateAll(gomock.Any(), User1ID, gomock.Any()).Return(nil)
+	mockRepo.EXPECT().DeleteRecoveryApprovals(gomock.Any(), User1ID).Return(nil)
+
+	entity, err = service.Recover(context.Background(), core.CommitModeExecute, recoverDocument(t, Guardian2, second.SignedAt), "")
+	assert.NoError(t, err)
+	if assert.NotNil(t, entity.MasterKey) {
+		assert.Equal(t, NewKeyAddr, *entity.MasterKey)
+	}
+	// 復旧時刻は最後の承認の署名時刻
+	if assert.NotNil(t, entity.RecoveredAt) {
+		assert.Equal(t, second.SignedAt, *entity.RecoveredAt)
+	}
+	if assert.NotNil(t, entity.RecoveryProof) {
+		var proofs []core.RecoveryProof
+		assert.NoError(t, json.Unmarshal([]byte(*entity.RecoveryProof), &proofs))
+		if assert.Len(t, proofs, 1) {
+			assert.Equal(t, guardian, proofs[0].GuardianDocument)
+			assert.Len(t, proofs[0].Approvals, 2)
+		}
+	}
+}
+
+func signDocument(t *testing.T, document any, priv string) (string, string) {
+	documentBytes, err := json.Marshal(document)
+	assert.NoError(t, err)
+
+	signature, err := core.SignBytes(documentBytes, priv)
+	assert.NoError(t, err)
+
+	return string(documentBytes), hex.EncodeToString(signature)
+}
+
+func TestPullRecoveredEntity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	guardian1, err := core.PrivKeyToAddr(Guardian1Priv, "con")
+	assert.NoError(t, err)
+	guardian2, err := core.PrivKeyToAddr(Guardian2Priv, "con")
+	assert.NoError(t, err)
+	masterKey, err := core.PrivKeyToAddr(MasterKeyPriv, "cck")
+	assert.NoError(t, err)
+
+	guardianDocument, guardianSignature := signDocument(t, core.GuardianDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   User1ID,
+			Type:     "guardian",
+			SignedAt: time.Now().Add(-time.Hour),
+		},
+		Guardians: []string{guardian1, guardian2},
+		Threshold: 2,
+	}, User1Priv)
+
+	approval := func(guardian, priv string, signedAt time.Time) core.RecoveryApproval {
+		document, signature := signDocument(t, core.RecoverDocument{
+			DocumentBase: core.DocumentBase[any]{
+				Signer:   guardian,
+				Type:     "recover",
+				SignedAt: signedAt,
+			},
+			Target: User1ID,
+			NewKey: masterKey,
+		}, priv)
+		return core.RecoveryApproval{
+			Target:    User1ID,
+			Guardian:  guardian,
+			NewKey:    masterKey,
+			Document:  document,
+			Signature: signature,
+			SignedAt:  signedAt,
+		}
+	}
+
+	recoveredAt := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	approvals := []core.RecoveryApproval{
+		approval(guardian1, Guardian1Priv, recoveredAt.Add(-time.Minute)),
+		approval(guardian2, Guardian2Priv, recoveredAt),
+	}
+
+	// 復旧後の所属表明は新しいマスターキーで署名されている
+	affiliationDocument, affiliationSignature := signDocument(t, core.AffiliationDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   User1ID,
+			Type:     "affiliation",
+			SignedAt: time.Now(),
+		},
+		Domain: RemoteDomainFQDN,
+	}, MasterKeyPriv)
+
+	remoteEntity := func(approvals []core.RecoveryApproval) core.Entity {
+		proof, err := json.Marshal([]core.RecoveryProof{{
+			GuardianDocument:  guardianDocument,
+			GuardianSignature: guardianSignature,
+			Approvals:         approvals,
+		}})
+		assert.NoError(t, err)
+		proofString := string(proof)
+		claimedAt := time.Now()
+
+		return core.Entity{
+			ID:                   User1ID,
+			Domain:               RemoteDomainFQDN,
+			AffiliationDocument:  affiliationDocument,
+			AffiliationSignature: affiliationSignature,
+			MasterKey:            &masterKey,
+			RecoveredAt:          &claimedAt,
+			RecoveryProof:        &proofString,
+		}
+	}
+
+	mockRepo := mock_entity.NewMockRepository(ctrl)
+	mockRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(core.Entity{}, core.NewErrorNotFound()).AnyTimes()
+	mockRepo.EXPECT().Upsert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, entity core.Entity) (core.Entity, error) {
+		return entity, nil
+	}).AnyTimes()
+
+	mockClient := mock_client.NewMockClient(ctrl)
+	service := NewService(mockRepo, mockClient, core.Config{FQDN: "local.example.com"}, nil, nil, nil, nil)
+
+	// しきい値に届かない承認ではマスターキーを受け入れない
+	mockClient.EXPECT().GetEntity(gomock.Any(), RemoteDomainFQDN, User1ID, gomock.Any()).Return(remoteEntity(approvals[:1]), nil)
+	_, err = service.PullEntityFromRemote(context.Background(), User1ID, RemoteDomainFQDN)
+	assert.Error(t, err)
+
+	// 証明のないマスターキーも受け入れない
+	unproven := remoteEntity(approvals)
+	unproven.RecoveryProof = nil
+	mockClient.EXPECT().GetEntity(gomock.Any(), RemoteDomainFQDN, User1ID, gomock.Any()).Return(unproven, nil)
+	_, err = service.PullEntityFromRemote(context.Background(), User1ID, RemoteDomainFQDN)
+	assert.Error(t, err)
+
+	// 復旧時刻はリモートの申告ではなく承認から求める
+	mockClient.EXPECT().GetEntity(gomock.Any(), RemoteDomainFQDN, User1ID, gomock.Any()).Return(remoteEntity(approvals), nil)
+	mockRepo.EXPECT().SetMasterKey(gomock.Any(), User1ID, masterKey, recoveredAt, gomock.Any()).Return(nil)
+	entity, err := service.PullEntityFromRemote(context.Background(), User1ID, RemoteDomainFQDN)
+	if assert.NoError(t, err) && assert.NotNil(t, entity.MasterKey) {
+		assert.Equal(t, masterKey, *entity.MasterKey)
+		assert.Equal(t, recoveredAt, *entity.RecoveredAt)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel"
 
 	"github.com/totegamma/concurrent/core"
@@ -13,6 +14,7 @@ var tracer = otel.Tracer("job")
 
 type Handler interface {
 	List(c echo.Context) error
+	ListSystem(c echo.Context) error
 	Create(c echo.Context) error
 	Cancel(c echo.Context) error
 }
@@ -45,6 +47,19 @@ func (h *handler) List(c echo.Context) error {
 	return c.JSON(http.StatusOK, echo.Map{"content": jobs})
 }
 
+func (h *handler) ListSystem(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Job.Handler.ListSystem")
+	defer span.End()
+
+	jobs, err := h.service.ListSystem(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"content": jobs})
+}
+
 func (h *handler) Create(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "Job.Handler.Create")
 	defer span.End()
@@ -61,7 +76,17 @@ func (h *handler) Create(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	}
 
-	job, err := h.service.Create(ctx, requester, request.Type, request.Payload, request.Scheduled)
+	err = validateUserJob(request.Type, request.Cron, request.Interval)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	var job core.Job
+	if request.Cron != "" || request.Interval != "" {
+		job, err = h.service.CreateRecurring(ctx, requester, request.Type, request.Payload, request.Cron, request.Interval)
+	} else {
+		job, err = h.service.Create(ctx, requester, request.Type, request.Payload, request.Scheduled)
+	}
 	if err != nil {
 		span.RecordError(err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
@@ -74,9 +99,17 @@ func (h *handler) Cancel(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "Job.Handler.Cancel")
 	defer span.End()
 
+	requester, ok := ctx.Value(core.RequesterIdCtxKey).(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+
 	id := c.Param("id")
-	job, err := h.service.Cancel(ctx, id)
+	job, err := h.service.Cancel(ctx, requester, id)
 	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "job not found or already started"})
+		}
 		span.RecordError(err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
@@ -0,0 +1,256 @@
+package job
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/totegamma/concurrent/client"
+	"github.com/totegamma/concurrent/core"
+)
+
+// MigratePayload is the payload of the migrate job
+// Token is sealed into SealedToken when the job is created so that the user's JWT is not stored in plain text
+type MigratePayload struct {
+	From        string `json:"from"`                  // FQDN of the old domain
+	Token       string `json:"token,omitempty"`       // JWT issued by the user for the old domain
+	SealedToken string `json:"sealedToken,omitempty"` // Token encrypted with the key of this domain
+}
+
+const (
+	migrateSyncTimeout  = 10 * time.Minute
+	migrateSyncInterval = 5 * time.Second
+)
+
+func migrateTokenCipher(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("concrnt-migrate-token:" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealMigratePayload replaces the token of the payload with its encrypted form
+func sealMigratePayload(payload, secret string) (string, error) {
+	var obj MigratePayload
+	err := json.Unmarshal([]byte(payload), &obj)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid migrate payload")
+	}
+
+	if obj.Token == "" {
+		return "", fmt.Errorf("token is required")
+	}
+
+	aead, err := migrateTokenCipher(secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	obj.SealedToken = hex.EncodeToString(aead.Seal(nonce, nonce, []byte(obj.Token), []byte(obj.From)))
+	obj.Token = ""
+
+	sealed, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+
+	return string(sealed), nil
+}
+
+// openMigratePayload decodes the payload and decrypts its token
+func openMigratePayload(payload, secret string) (MigratePayload, error) {
+	var obj MigratePayload
+	err := json.Unmarshal([]byte(payload), &obj)
+	if err != nil {
+		return MigratePayload{}, errors.Wrap(err, "invalid migrate payload")
+	}
+
+	if obj.SealedToken == "" {
+		return MigratePayload{}, fmt.Errorf("token is not sealed")
+	}
+
+	sealed, err := hex.DecodeString(obj.SealedToken)
+	if err != nil {
+		return MigratePayload{}, errors.Wrap(err, "invalid sealed token")
+	}
+
+	aead, err := migrateTokenCipher(secret)
+	if err != nil {
+		return MigratePayload{}, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return MigratePayload{}, fmt.Errorf("invalid sealed token")
+	}
+
+	token, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(obj.From))
+	if err != nil {
+		return MigratePayload{}, errors.Wrap(err, "failed to open sealed token")
+	}
+
+	obj.Token = string(token)
+	obj.SealedToken = ""
+	return obj, nil
+}
+
+func (a *reactor) reportProgress(ctx context.Context, job *core.Job, format string, args ...any) {
+	err := a.job.UpdateResult(ctx, job.ID, fmt.Sprintf(format, args...))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to report job progress", slog.String("error", err.Error()))
+	}
+}
+
+// jobMigrate moves the author's repository from the old domain to this domain.
+// The author must already be affiliated with this domain.
+//  1. wait until the commit file on the old domain is in sync with its database
+//  2. pull the commit file
+//  3. replay it through Restore, which checks it against the head signed by the old domain
+//  4. send the new affiliation to the old domain so that it points to the new home
+func (a *reactor) jobMigrate(ctx context.Context, job *core.Job) (string, error) {
+	ctx, span := tracer.Start(ctx, "reactor.JobMigrate")
+	defer span.End()
+
+	payload, err := openMigratePayload(job.Payload, a.config.PrivateKey)
+	if err != nil {
+		span.RecordError(err)
+		return "invalid payload", err
+	}
+
+	if payload.From == "" || payload.From == a.config.FQDN {
+		return "invalid payload", fmt.Errorf("invalid source domain: %s", payload.From)
+	}
+
+	entity, err := a.entity.Get(ctx, job.Author)
+	if err != nil {
+		span.RecordError(err)
+		return "entity not found", err
+	}
+
+	if entity.Domain != a.config.FQDN {
+		return "not affiliated", fmt.Errorf("entity must be affiliated with %s before migration", a.config.FQDN)
+	}
+
+	opts := &client.Options{AuthToken: payload.Token}
+
+	// 1. sync
+	a.reportProgress(ctx, job, "checking sync status of %s", payload.From)
+	_, err = a.waitForSync(ctx, job, payload.From, opts)
+	if err != nil {
+		span.RecordError(err)
+		return "failed to sync commit file on old domain", err
+	}
+
+	// 2. pull
+	a.reportProgress(ctx, job, "pulling repository from %s", payload.From)
+	archive, err := a.client.GetRepository(ctx, payload.From, opts)
+	if err != nil {
+		span.RecordError(err)
+		return "failed to pull repository", err
+	}
+
+	// 3. replay (Restore checks the log against the head signed by the old domain)
+	a.reportProgress(ctx, job, "restoring repository")
+	results, err := a.store.Restore(ctx, strings.NewReader(archive), payload.From, "migrate")
+	if err != nil {
+		span.RecordError(err)
+		return "failed to restore repository", err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error == "<nil>" || result.Error == "" {
+			continue
+		}
+		if strings.Contains(result.Error, core.NewErrorAlreadyExists().Error()) {
+			continue
+		}
+		failed++
+	}
+
+	// 一部でも取り込めていなければ旧ドメインの向き先は変えない (再試行で続きから取り込む)
+	if failed > 0 {
+		return fmt.Sprintf("%d of %d commits failed to restore", failed, len(results)), fmt.Errorf("failed to restore %d commits", failed)
+	}
+
+	// 4. redirect
+	a.reportProgress(ctx, job, "announcing new affiliation to %s", payload.From)
+	packet, err := json.Marshal(core.Commit{
+		Document:  entity.AffiliationDocument,
+		Signature: entity.AffiliationSignature,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return "failed to announce new affiliation", err
+	}
+
+	resp, err := a.client.Commit(ctx, payload.From, string(packet), nil, opts)
+	if err != nil {
+		span.RecordError(err)
+		return "failed to announce new affiliation", err
+	}
+	if resp.StatusCode >= 400 {
+		return "failed to announce new affiliation", fmt.Errorf("old domain rejected affiliation: %s", resp.Status)
+	}
+
+	return fmt.Sprintf("migrated %d commits from %s", len(results), payload.From), nil
+}
+
+// waitForSync triggers sync on the old domain and waits until the commit file is in sync
+func (a *reactor) waitForSync(ctx context.Context, job *core.Job, domain string, opts *client.Options) (core.SyncStatus, error) {
+	ctx, span := tracer.Start(ctx, "reactor.WaitForSync")
+	defer span.End()
+
+	status, err := a.client.GetSyncStatus(ctx, domain, opts)
+	if err != nil {
+		span.RecordError(err)
+		return core.SyncStatus{}, err
+	}
+
+	if status.Status == "outofsync" {
+		status, err = a.client.PerformSync(ctx, domain, opts)
+		if err != nil {
+			span.RecordError(err)
+			return core.SyncStatus{}, err
+		}
+	}
+
+	deadline := time.Now().Add(migrateSyncTimeout)
+	for status.Status != "insync" {
+		if time.Now().After(deadline) {
+			return status, fmt.Errorf("timed out waiting for sync")
+		}
+
+		a.reportProgress(ctx, job, "waiting for %s to sync commit file (%s)", domain, status.Progress)
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(migrateSyncInterval):
+		}
+
+		status, err = a.client.GetSyncStatus(ctx, domain, opts)
+		if err != nil {
+			span.RecordError(err)
+			return core.SyncStatus{}, err
+		}
+	}
+
+	return status, nil
+}
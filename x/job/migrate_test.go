@@ -0,0 +1,110 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/client"
+	"github.com/totegamma/concurrent/client/mock"
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+)
+
+const (
+	migrateAuthor = "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	migrateSecret = "3fcfac6c211b743975de2d7b3f622c12694b8125daf4013562c5a1aefa3253a5"
+)
+
+func TestSealMigratePayload(t *testing.T) {
+	sealed, err := sealMigratePayload(`{"from":"old.example.com","token":"secret-jwt"}`, migrateSecret)
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "secret-jwt")
+
+	payload, err := openMigratePayload(sealed, migrateSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "old.example.com", payload.From)
+	assert.Equal(t, "secret-jwt", payload.Token)
+
+	// 別のドメイン鍵では開けない
+	_, err = openMigratePayload(sealed, "other")
+	assert.Error(t, err)
+
+	// 移行元を書き換えると開けない
+	var obj MigratePayload
+	assert.NoError(t, json.Unmarshal([]byte(sealed), &obj))
+	obj.From = "evil.example.com"
+	tampered, _ := json.Marshal(obj)
+	_, err = openMigratePayload(string(tampered), migrateSecret)
+	assert.Error(t, err)
+
+	// 平文のままのペイロードは受け付けない
+	_, err = openMigratePayload(`{"from":"old.example.com","token":"secret-jwt"}`, migrateSecret)
+	assert.Error(t, err)
+}
+
+func TestJobMigrateAbortsOnRestoreFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sealed, err := sealMigratePayload(`{"from":"old.example.com","token":"secret-jwt"}`, migrateSecret)
+	assert.NoError(t, err)
+
+	mockEntity := mock_core.NewMockEntityService(ctrl)
+	mockEntity.EXPECT().Get(gomock.Any(), migrateAuthor).Return(core.Entity{ID: migrateAuthor, Domain: "new.example.com"}, nil).Times(2)
+
+	mockJob := mock_core.NewMockJobService(ctrl)
+	mockJob.EXPECT().UpdateResult(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetSyncStatus(gomock.Any(), "old.example.com", &client.Options{AuthToken: "secret-jwt"}).Return(core.SyncStatus{Status: "insync"}, nil).Times(2)
+	mockClient.EXPECT().GetRepository(gomock.Any(), "old.example.com", gomock.Any()).Return("archive", nil).Times(2)
+
+	mockStore := mock_core.NewMockStoreService(ctrl)
+	r := &reactor{store: mockStore, job: mockJob, entity: mockEntity, client: mockClient, config: core.Config{FQDN: "new.example.com", PrivateKey: migrateSecret}}
+	job := &core.Job{ID: "job", Author: migrateAuthor, Payload: sealed}
+
+	// 取り込みに失敗したコミットがあれば新しい所属を通知しない
+	mockStore.EXPECT().Restore(gomock.Any(), gomock.Any(), "old.example.com", "migrate").Return([]core.BatchResult{
+		{ID: "a", Error: "<nil>"},
+		{ID: "b", Error: "failed to resolve signer"},
+	}, nil)
+
+	_, err = r.jobMigrate(context.Background(), job)
+	assert.Error(t, err)
+
+	// すべて取り込めたら通知する
+	mockStore.EXPECT().Restore(gomock.Any(), gomock.Any(), "old.example.com", "migrate").Return([]core.BatchResult{
+		{ID: "a", Error: "<nil>"},
+		{ID: "b", Error: core.NewErrorAlreadyExists().Error()},
+	}, nil)
+	mockClient.EXPECT().Commit(gomock.Any(), "old.example.com", gomock.Any(), nil, gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK}, nil)
+
+	result, err := r.jobMigrate(context.Background(), job)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(result, "migrated 2 commits"))
+}
+
+func TestWaitForSyncCanceled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockJob := mock_core.NewMockJobService(ctrl)
+	mockJob.EXPECT().UpdateResult(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetSyncStatus(gomock.Any(), "old.example.com", gomock.Any()).Return(core.SyncStatus{Status: "syncing"}, nil)
+
+	r := &reactor{job: mockJob, client: mockClient}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.waitForSync(ctx, &core.Job{ID: "job"}, "old.example.com", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
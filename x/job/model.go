@@ -8,4 +8,6 @@ type Job struct {
 	Type      string    `json:"type"`
 	Payload   string    `json:"payload"`
 	Scheduled time.Time `json:"scheduled"`
+	Cron      string    `json:"cron"`
+	Interval  string    `json:"interval"`
 }
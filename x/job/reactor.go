@@ -2,94 +2,321 @@ package job
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/totegamma/concurrent/client"
 	"github.com/totegamma/concurrent/core"
 )
 
+const (
+	defaultWorkers      = 4
+	defaultPollInterval = 10 * time.Second
+	defaultLease        = 1 * time.Minute
+)
+
+// HandlerFunc processes a job and returns a human readable result
+type HandlerFunc func(ctx context.Context, job *core.Job) (string, error)
+
+// HandlerOptions controls how failed jobs of a type are retried
+type HandlerOptions struct {
+	MaxAttempts int           // total attempts including the first one. 0 means 1
+	BaseBackoff time.Duration // wait before the 2nd attempt. doubled on each retry
+	MaxBackoff  time.Duration // upper limit of the backoff
+}
+
+type jobHandler struct {
+	fn   HandlerFunc
+	opts HandlerOptions
+}
+
 type reactor struct {
-	store core.StoreService
-	job   core.JobService
+	store  core.StoreService
+	job    core.JobService
+	entity core.EntityService
+	client client.Client
+	config core.Config
+
+	workers      int
+	pollInterval time.Duration
+	lease        time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]jobHandler
 }
 
 type Reactor interface {
 	Start(ctx context.Context)
+	RegisterHandler(typ string, fn HandlerFunc, opts HandlerOptions)
+	Schedule(ctx context.Context, typ, cron, interval string) error
 }
 
 // Newreactor creates a new reactor
 func NewReactor(
 	store core.StoreService,
 	job core.JobService,
+	entity core.EntityService,
+	client client.Client,
+	config core.Config,
+	reactorConfig core.ReactorConfig,
 ) Reactor {
-	return &reactor{
-		store,
-		job,
+	r := &reactor{
+		store:        store,
+		job:          job,
+		entity:       entity,
+		client:       client,
+		config:       config,
+		workers:      defaultWorkers,
+		pollInterval: defaultPollInterval,
+		lease:        defaultLease,
+		handlers:     make(map[string]jobHandler),
+	}
+
+	if reactorConfig.Workers > 0 {
+		r.workers = reactorConfig.Workers
+	}
+	if reactorConfig.PollInterval > 0 {
+		r.pollInterval = time.Duration(reactorConfig.PollInterval * float64(time.Second))
 	}
+	if reactorConfig.Lease > 0 {
+		r.lease = time.Duration(reactorConfig.Lease * float64(time.Second))
+	}
+
+	r.RegisterHandler("clean", r.jobClean, HandlerOptions{MaxAttempts: 3, BaseBackoff: time.Minute, MaxBackoff: time.Hour})
+	r.RegisterHandler("hello", r.JobHello, HandlerOptions{})
+	r.RegisterHandler("migrate", r.jobMigrate, HandlerOptions{MaxAttempts: 5, BaseBackoff: time.Minute, MaxBackoff: time.Hour})
+	r.RegisterHandler(core.JobTypeScheduledCommit, r.jobScheduledCommit, HandlerOptions{MaxAttempts: 3, BaseBackoff: time.Minute, MaxBackoff: 10 * time.Minute})
+
+	return r
+}
+
+// RegisterHandler registers a handler for the job type
+func (a *reactor) RegisterHandler(typ string, fn HandlerFunc, opts HandlerOptions) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+
+	a.handlers[typ] = jobHandler{fn, opts}
+}
+
+// Schedule makes sure a recurring system job of the type exists.
+// It is safe to call from every replica on startup.
+func (a *reactor) Schedule(ctx context.Context, typ, cron, interval string) error {
+	ctx, span := tracer.Start(ctx, "reactor.Schedule")
+	defer span.End()
+
+	_, err := a.job.EnsureRecurring(ctx, a.config.CSID, typ, "{}", cron, interval)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (a *reactor) types() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	types := make([]string, 0, len(a.handlers))
+	for typ := range a.handlers {
+		types = append(types, typ)
+	}
+	return types
+}
+
+func (a *reactor) handler(typ string) (jobHandler, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	handler, ok := a.handlers[typ]
+	return handler, ok
 }
 
 // Boot starts reactor
 func (r *reactor) Start(ctx context.Context) {
 	slog.Info("reactor start!")
 
-	ticker60 := time.NewTicker(60 * time.Second)
-	go func() {
-		for {
-			select {
-			case <-ticker60.C:
-				ctx, span := tracer.Start(ctx, "reactor.Boot.DispatchJobs")
-				r.dispatchJobs(ctx)
-				span.End()
-				break
-			}
+	for i := 0; i < r.workers; i++ {
+		go r.worker(ctx)
+	}
+}
+
+// worker takes jobs one by one until there are no runnable jobs, then waits for the next poll
+func (a *reactor) worker(ctx context.Context) {
+	ticker := time.NewTicker(a.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for a.dispatchJobs(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-	}()
+	}
 }
 
-func (a *reactor) dispatchJobs(ctx context.Context) {
+// dispatchJobs runs a single job and reports whether a job was found
+func (a *reactor) dispatchJobs(ctx context.Context) bool {
 	ctx, span := tracer.Start(ctx, "reactor.DispatchJobs")
 	defer span.End()
 
-	job, err := a.job.Dequeue(ctx)
+	job, err := a.job.Dequeue(ctx, a.types(), a.lease)
 	if err != nil {
-		return
+		return false
 	}
 
-	switch job.Type {
-	case "clean":
-		go a.dispatchJob(ctx, job, a.jobClean)
-	case "hello":
-		go a.dispatchJob(ctx, job, a.JobHello)
-	default:
+	handler, ok := a.handler(job.Type)
+	if !ok {
 		slog.ErrorContext(ctx, "unknown job type",
 			slog.String("type", job.Type),
 		)
-		a.job.Complete(ctx, job.ID, "failed", "unknown job type")
+		a.job.Complete(ctx, job.ID, job.Attempts, core.JobStatusDead, "unknown job type")
+		return true
 	}
+
+	a.dispatchJob(ctx, job, handler)
+	return true
 }
 
-func (a *reactor) dispatchJob(ctx context.Context, job *core.Job, fn func(context.Context, *core.Job) (string, error)) {
+func (a *reactor) dispatchJob(ctx context.Context, job *core.Job, handler jobHandler) {
 	ctx, span := tracer.Start(ctx, "reactor.DispatchJob")
 	defer span.End()
 
-	result, err := fn(ctx, job)
+	if job.Attempts > handler.opts.MaxAttempts {
+		// the lease of the last attempt has expired
+		a.finish(ctx, job, core.JobStatusDead, "lease expired on the last attempt")
+		return
+	}
+
+	// リースを失ったら処理を打ち切る
+	jobCtx, cancel := context.WithCancel(ctx)
+	go a.heartbeat(jobCtx, job, cancel)
+
+	result, err := handler.fn(jobCtx, job)
+	cancel()
+
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to process job", slog.String("error", err.Error()))
+		slog.ErrorContext(
+			ctx, "failed to process job",
+			slog.String("error", err.Error()),
+			slog.String("type", job.Type),
+			slog.Int("attempts", job.Attempts),
+		)
+
+		if result != "" {
+			result = result + ": " + err.Error()
+		} else {
+			result = err.Error()
+		}
 
-		_, err = a.job.Complete(ctx, job.ID, "failed: "+result, err.Error())
+		if job.Attempts >= handler.opts.MaxAttempts {
+			a.finish(ctx, job, core.JobStatusDead, result)
+			return
+		}
+
+		_, err = a.job.Retry(ctx, job.ID, job.Attempts, result, time.Now().Add(backoff(handler.opts, job.Attempts)))
 		if err != nil {
 			span.RecordError(err)
-			slog.ErrorContext(ctx, "failed to complete job", slog.String("error", err.Error()))
+			logLeaseError(ctx, "failed to retry job", job, err)
+		}
+		return
+	}
+
+	a.finish(ctx, job, core.JobStatusCompleted, result)
+}
+
+// finish completes the job. recurring jobs are put back to the queue for the next occurrence instead.
+func (a *reactor) finish(ctx context.Context, job *core.Job, status, result string) {
+	ctx, span := tracer.Start(ctx, "reactor.Finish")
+	defer span.End()
+
+	if job.Cron != "" || job.Interval != "" {
+		next, err := NextOccurrence(*job, time.Now())
+		if err == nil {
+			if status != core.JobStatusCompleted {
+				result = status + ": " + result
+			}
+			_, err = a.job.Reschedule(ctx, job.ID, job.Attempts, result, next)
+			if err != nil {
+				span.RecordError(err)
+				logLeaseError(ctx, "failed to reschedule job", job, err)
+			}
+			return
 		}
+
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "failed to calculate next occurrence", slog.String("error", err.Error()))
+		status = core.JobStatusDead
+		result = err.Error()
 	}
 
-	_, err = a.job.Complete(ctx, job.ID, "completed", result)
+	_, err := a.job.Complete(ctx, job.ID, job.Attempts, status, result)
 	if err != nil {
 		span.RecordError(err)
-		slog.ErrorContext(ctx, "failed to complete job", slog.String("error", err.Error()))
+		logLeaseError(ctx, "failed to complete job", job, err)
 	}
 }
 
+// logLeaseError logs a failed update of the job.
+// a lost lease is expected when the job was canceled or taken over by another worker, so its result is dropped
+func logLeaseError(ctx context.Context, msg string, job *core.Job, err error) {
+	if errors.Is(err, core.ErrorLeaseLost{}) {
+		slog.WarnContext(ctx, "job lease lost, dropping the result",
+			slog.String("id", job.ID),
+			slog.Int("attempts", job.Attempts),
+		)
+		return
+	}
+	slog.ErrorContext(ctx, msg, slog.String("error", err.Error()))
+}
+
+// heartbeat keeps the lease of the job while it is running. cancel is called once the lease is lost
+func (a *reactor) heartbeat(ctx context.Context, job *core.Job, cancel context.CancelFunc) {
+	ticker := time.NewTicker(a.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.job.Heartbeat(ctx, job.ID, job.Attempts, a.lease)
+			if errors.Is(err, core.ErrorLeaseLost{}) {
+				logLeaseError(ctx, "failed to extend job lease", job, err)
+				cancel()
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to extend job lease", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// backoff returns the wait before the next attempt
+func backoff(opts HandlerOptions, attempts int) time.Duration {
+	wait := opts.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if opts.MaxBackoff > 0 && wait >= opts.MaxBackoff {
+			return opts.MaxBackoff
+		}
+	}
+	return wait
+}
+
 func (a *reactor) jobClean(ctx context.Context, job *core.Job) (string, error) {
 	ctx, span := tracer.Start(ctx, "reactor.JobClean")
 	defer span.End()
@@ -97,6 +324,39 @@ func (a *reactor) jobClean(ctx context.Context, job *core.Job) (string, error) {
 	return "", a.store.CleanUserAllData(ctx, job.Author)
 }
 
+// jobScheduledCommit commits the message held by a scheduled commit
+func (a *reactor) jobScheduledCommit(ctx context.Context, job *core.Job) (string, error) {
+	ctx, span := tracer.Start(ctx, "reactor.JobScheduledCommit")
+	defer span.End()
+
+	var payload core.ScheduledCommitPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		span.RecordError(err)
+		return "invalid payload", err
+	}
+
+	var doc core.DocumentBase[any]
+	err = json.Unmarshal([]byte(payload.Document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return "invalid document", err
+	}
+
+	if doc.Signer != job.Author {
+		return "invalid document", fmt.Errorf("document is not signed by the job author")
+	}
+
+	// 鍵はコミット時に解決しなおす (予約後に失効したサブキーでは投稿しない)
+	_, err = a.store.Commit(ctx, core.CommitModeExecute, payload.Document, payload.Signature, payload.Option, nil, "scheduled")
+	if err != nil && !errors.Is(err, core.ErrorAlreadyExists{}) {
+		span.RecordError(err)
+		return "failed to commit", err
+	}
+
+	return "committed", nil
+}
+
 func (a *reactor) JobHello(ctx context.Context, job *core.Job) (string, error) {
 	ctx, span := tracer.Start(ctx, "reactor.JobHello")
 	defer span.End()
@@ -0,0 +1,118 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+)
+
+func TestJobScheduledCommit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	author := "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	document := `{"signer":"` + author + `","type":"message","body":{"body":"hello"}}`
+	payload, _ := json.Marshal(core.ScheduledCommitPayload{
+		Document:  document,
+		Signature: "deadbeef",
+		Option:    `{}`,
+	})
+
+	mockStore := mock_core.NewMockStoreService(ctrl)
+	mockStore.EXPECT().
+		Commit(gomock.Any(), core.CommitModeExecute, document, "deadbeef", `{}`, nil, "scheduled").
+		Return(core.Message{ID: "m00000000000000000000000000"}, nil)
+
+	r := &reactor{store: mockStore}
+
+	result, err := r.jobScheduledCommit(context.Background(), &core.Job{Author: author, Payload: string(payload)})
+	assert.NoError(t, err)
+	assert.Equal(t, "committed", result)
+
+	// 他人の署名したドキュメントはコミットしない
+	_, err = r.jobScheduledCommit(context.Background(), &core.Job{Author: "con1other", Payload: string(payload)})
+	assert.Error(t, err)
+}
+
+func TestBackoff(t *testing.T) {
+	opts := HandlerOptions{MaxAttempts: 10, BaseBackoff: time.Minute, MaxBackoff: time.Hour}
+	assert.Equal(t, time.Minute, backoff(opts, 1))
+	assert.Equal(t, 2*time.Minute, backoff(opts, 2))
+	assert.Equal(t, 4*time.Minute, backoff(opts, 3))
+	assert.Equal(t, time.Hour, backoff(opts, 10))
+}
+
+func TestDispatchJobRetryAndDeadLetter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockJob := mock_core.NewMockJobService(ctrl)
+	r := &reactor{job: mockJob, lease: time.Minute}
+
+	failing := jobHandler{
+		fn: func(ctx context.Context, job *core.Job) (string, error) {
+			return "boom", fmt.Errorf("failed")
+		},
+		opts: HandlerOptions{MaxAttempts: 3, BaseBackoff: time.Minute, MaxBackoff: time.Hour},
+	}
+
+	// 上限未満ならバックオフして再試行
+	mockJob.EXPECT().Retry(gomock.Any(), "job", 2, "boom: failed", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ int, _ string, scheduled time.Time) (core.Job, error) {
+			assert.WithinDuration(t, time.Now().Add(2*time.Minute), scheduled, 5*time.Second)
+			return core.Job{}, nil
+		},
+	)
+	r.dispatchJob(context.Background(), &core.Job{ID: "job", Attempts: 2}, failing)
+
+	// 最後の試行で失敗したら dead
+	mockJob.EXPECT().Complete(gomock.Any(), "job", 3, core.JobStatusDead, "boom: failed").Return(core.Job{}, nil)
+	r.dispatchJob(context.Background(), &core.Job{ID: "job", Attempts: 3}, failing)
+
+	// 最後の試行中にリースが切れたジョブは実行せずに dead
+	mockJob.EXPECT().Complete(gomock.Any(), "job", 4, core.JobStatusDead, gomock.Any()).Return(core.Job{}, nil)
+	r.dispatchJob(context.Background(), &core.Job{ID: "job", Attempts: 4}, jobHandler{
+		fn: func(ctx context.Context, job *core.Job) (string, error) {
+			t.Fatal("handler must not run")
+			return "", nil
+		},
+		opts: failing.opts,
+	})
+
+	// 繰り返しジョブは dead にせず次回に回す
+	mockJob.EXPECT().Reschedule(gomock.Any(), "job", 3, "dead: boom: failed", gomock.Any()).Return(core.Job{}, nil)
+	r.dispatchJob(context.Background(), &core.Job{ID: "job", Attempts: 3, Interval: "1h"}, failing)
+}
+
+func TestDispatchJobLeaseLost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockJob := mock_core.NewMockJobService(ctrl)
+	r := &reactor{job: mockJob, lease: 30 * time.Millisecond}
+
+	// 他のワーカーに取られたらハートビートが失敗し、処理は打ち切られる
+	mockJob.EXPECT().Heartbeat(gomock.Any(), "job", 1, r.lease).Return(core.NewErrorLeaseLost())
+	mockJob.EXPECT().Retry(gomock.Any(), "job", 1, gomock.Any(), gomock.Any()).Return(core.Job{}, core.NewErrorLeaseLost())
+
+	r.dispatchJob(context.Background(), &core.Job{ID: "job", Attempts: 1}, jobHandler{
+		fn: func(ctx context.Context, job *core.Job) (string, error) {
+			select {
+			case <-ctx.Done():
+				return "interrupted", ctx.Err()
+			case <-time.After(5 * time.Second):
+				t.Fatal("job was not canceled after losing the lease")
+				return "", nil
+			}
+		},
+		opts: HandlerOptions{MaxAttempts: 3, BaseBackoff: time.Minute},
+	})
+}
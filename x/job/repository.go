@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/totegamma/concurrent/core"
 )
@@ -12,9 +13,15 @@ import (
 type Repository interface {
 	List(ctx context.Context, authorID string) ([]core.Job, error)
 	Enqueue(ctx context.Context, author, typ, payload string, scheduled time.Time) (core.Job, error)
-	Dequeue(ctx context.Context) (*core.Job, error)
-	Complete(ctx context.Context, id, status, result string) (core.Job, error)
-	Cancel(ctx context.Context, id string) (core.Job, error)
+	EnqueueRecurring(ctx context.Context, author, typ, payload, cron, interval string, scheduled time.Time) (core.Job, error)
+	EnsureRecurring(ctx context.Context, author, typ, payload, cron, interval string, scheduled time.Time) (core.Job, error)
+	Dequeue(ctx context.Context, types []string, lease time.Duration) (*core.Job, error)
+	Heartbeat(ctx context.Context, id string, attempt int, lease time.Duration) error
+	Complete(ctx context.Context, id string, attempt int, status, result string) (core.Job, error)
+	Retry(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error)
+	Reschedule(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error)
+	UpdateResult(ctx context.Context, id, result string) error
+	Cancel(ctx context.Context, id, author string) (core.Job, error)
 	Clean(ctx context.Context, olderThan time.Time) ([]core.Job, error)
 }
 
@@ -48,7 +55,7 @@ func (r *repository) Enqueue(ctx context.Context, author, typ, payload string, s
 		Type:      typ,
 		Payload:   payload,
 		Scheduled: scheduled,
-		Status:    "pending",
+		Status:    core.JobStatusPending,
 	}
 
 	if err := r.db.WithContext(ctx).Create(&job).Error; err != nil {
@@ -58,7 +65,88 @@ func (r *repository) Enqueue(ctx context.Context, author, typ, payload string, s
 	return job, nil
 }
 
-func (r *repository) Dequeue(ctx context.Context) (*core.Job, error) {
+func (r *repository) EnqueueRecurring(ctx context.Context, author, typ, payload, cron, interval string, scheduled time.Time) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Repository.EnqueueRecurring")
+	defer span.End()
+
+	job := core.Job{
+		Author:    author,
+		Type:      typ,
+		Payload:   payload,
+		Scheduled: scheduled,
+		Cron:      cron,
+		Interval:  interval,
+		Status:    core.JobStatusPending,
+	}
+
+	if err := r.db.WithContext(ctx).Create(&job).Error; err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
+// EnsureRecurring creates the recurring job unless the author already has an active one of the same type.
+// If the existing job has a different schedule, the schedule is updated.
+func (r *repository) EnsureRecurring(ctx context.Context, author, typ, payload, cron, interval string, scheduled time.Time) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Repository.EnsureRecurring")
+	defer span.End()
+
+	var job core.Job
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// serialize replicas that start at the same time
+		err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", author+":"+typ).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.
+			Where("author = ? AND type = ? AND status IN ?", author, typ,
+				[]string{core.JobStatusPending, core.JobStatusRunning, core.JobStatusFailed},
+			).
+			Where("cron <> '' OR \"interval\" <> ''").
+			First(&job).Error
+
+		if err == gorm.ErrRecordNotFound {
+			job = core.Job{
+				Author:    author,
+				Type:      typ,
+				Payload:   payload,
+				Scheduled: scheduled,
+				Cron:      cron,
+				Interval:  interval,
+				Status:    core.JobStatusPending,
+			}
+			return tx.Create(&job).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		if job.Cron == cron && job.Interval == interval && job.Payload == payload {
+			return nil
+		}
+
+		job.Cron = cron
+		job.Interval = interval
+		job.Payload = payload
+		if job.Status != core.JobStatusRunning {
+			job.Scheduled = scheduled
+		}
+		return tx.Save(&job).Error
+	})
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
+// Dequeue takes a runnable job of the given types and leases it.
+// Jobs that are running but whose lease has expired (e.g. the instance crashed) are reclaimed.
+func (r *repository) Dequeue(ctx context.Context, types []string, lease time.Duration) (*core.Job, error) {
 	ctx, span := tracer.Start(ctx, "Job.Repository.Dequeue")
 	defer span.End()
 
@@ -68,10 +156,17 @@ func (r *repository) Dequeue(ctx context.Context) (*core.Job, error) {
 		return nil, tx.Error
 	}
 
+	now := time.Now()
+
 	var job core.Job
 	err := tx.WithContext(ctx).
 		Model(&core.Job{}).
-		Where("status = 'pending' AND scheduled <= ?", time.Now()).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("type IN ?", types).
+		Where("(status IN ? AND scheduled <= ?) OR (status = ? AND lease_until < ?)",
+			[]string{core.JobStatusPending, core.JobStatusFailed}, now,
+			core.JobStatusRunning, now,
+		).
 		Order("scheduled ASC").
 		First(&job).Error
 
@@ -81,55 +176,150 @@ func (r *repository) Dequeue(ctx context.Context) (*core.Job, error) {
 		return nil, err
 	}
 
-	job.Status = "running"
+	job.Status = core.JobStatusRunning
+	job.Attempts++
+	job.LeaseUntil = now.Add(lease)
 	job.TraceID = span.SpanContext().TraceID().String()
-	if tx.WithContext(ctx).Save(&job).Error != nil {
+	err = tx.WithContext(ctx).Save(&job).Error
+	if err != nil {
 		span.RecordError(err)
 		tx.Rollback()
 		return nil, err
 	}
 
-	tx.WithContext(ctx).Commit()
+	err = tx.WithContext(ctx).Commit().Error
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 
 	return &job, nil
 }
 
-func (r *repository) Complete(ctx context.Context, id, status, result string) (core.Job, error) {
-	ctx, span := tracer.Start(ctx, "Job.Repository.Complete")
+// Heartbeat extends the lease of a running job
+func (r *repository) Heartbeat(ctx context.Context, id string, attempt int, lease time.Duration) error {
+	ctx, span := tracer.Start(ctx, "Job.Repository.Heartbeat")
 	defer span.End()
 
-	var job core.Job
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&job).Error
+	_, err := r.updateLeased(ctx, id, attempt, map[string]any{
+		"lease_until": time.Now().Add(lease),
+	})
 	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Retry marks the job as failed and schedules the next attempt
+func (r *repository) Retry(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Repository.Retry")
+	defer span.End()
+
+	job, err := r.updateLeased(ctx, id, attempt, map[string]any{
+		"status":    core.JobStatusFailed,
+		"result":    result,
+		"scheduled": scheduled,
+	})
+	if err != nil {
+		span.RecordError(err)
 		return core.Job{}, err
 	}
 
-	job.Status = status
-	job.Result = result
+	return job, nil
+}
+
+// Reschedule puts a finished occurrence of a recurring job back to pending.
+// Jobs canceled while running are left as they are.
+func (r *repository) Reschedule(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Repository.Reschedule")
+	defer span.End()
 
-	if err := r.db.WithContext(ctx).Save(&job).Error; err != nil {
+	job, err := r.updateLeased(ctx, id, attempt, map[string]any{
+		"status":    core.JobStatusPending,
+		"result":    result,
+		"scheduled": scheduled,
+		"attempts":  0,
+	})
+	if err != nil {
+		span.RecordError(err)
 		return core.Job{}, err
 	}
 
 	return job, nil
 }
 
-func (r *repository) Cancel(ctx context.Context, id string) (core.Job, error) {
-	ctx, span := tracer.Start(ctx, "Job.Repository.Cancel")
+func (r *repository) Complete(ctx context.Context, id string, attempt int, status, result string) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Repository.Complete")
 	defer span.End()
 
-	var job core.Job
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&job).Error
+	job, err := r.updateLeased(ctx, id, attempt, map[string]any{
+		"status": status,
+		"result": result,
+	})
 	if err != nil {
+		span.RecordError(err)
 		return core.Job{}, err
 	}
 
-	job.Status = "canceled"
+	return job, nil
+}
+
+// updateLeased updates a running job only while the caller still holds its lease.
+// Dequeue increments the attempts every time it hands the job out, so the attempt identifies the lease.
+// Once the lease has expired and another worker has taken the job, or the job was canceled, it returns ErrorLeaseLost.
+func (r *repository) updateLeased(ctx context.Context, id string, attempt int, values map[string]any) (core.Job, error) {
+	var job core.Job
+	result := r.db.WithContext(ctx).
+		Model(&job).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND status = ? AND attempts = ?", id, core.JobStatusRunning, attempt).
+		Updates(values)
+	if result.Error != nil {
+		return core.Job{}, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return core.Job{}, core.NewErrorLeaseLost()
+	}
+
+	return job, nil
+}
+
+func (r *repository) UpdateResult(ctx context.Context, id, result string) error {
+	ctx, span := tracer.Start(ctx, "Job.Repository.UpdateResult")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Model(&core.Job{}).Where("id = ?", id).Update("result", result).Error
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-	if err := r.db.WithContext(ctx).Save(&job).Error; err != nil {
+	return nil
+}
+
+// Cancel cancels a job of the author that has not started yet
+func (r *repository) Cancel(ctx context.Context, id, author string) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Repository.Cancel")
+	defer span.End()
+
+	var job core.Job
+	err := r.db.WithContext(ctx).
+		Model(&job).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND author = ? AND status IN ?", id, author, []string{core.JobStatusPending, core.JobStatusFailed}).
+		Update("status", core.JobStatusCanceled).Error
+	if err != nil {
+		span.RecordError(err)
 		return core.Job{}, err
 	}
 
+	if job.ID == "" {
+		return core.Job{}, core.NewErrorNotFound()
+	}
+
 	return job, nil
 }
 
@@ -138,7 +328,7 @@ func (r *repository) Clean(ctx context.Context, olderThan time.Time) ([]core.Job
 	defer span.End()
 
 	var jobs []core.Job
-	err := r.db.WithContext(ctx).Where("scheduled < ? AND (status = 'completed' OR status = 'failed' OR status = 'canceled')", olderThan).Find(&jobs).Error
+	err := r.db.WithContext(ctx).Where("scheduled < ? AND status IN ?", olderThan, []string{core.JobStatusCompleted, core.JobStatusDead, core.JobStatusCanceled}).Find(&jobs).Error
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,278 @@
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// cronSchedule is a parsed 5 field cron expression (minute hour day-of-month month day-of-week)
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domAny bool
+	dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expr string) (cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron expression must have 5 fields. got %d", len(fields))
+	}
+
+	var schedule cronSchedule
+	var err error
+
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid month field: %w", err)
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid day of week field: %w", err)
+	}
+
+	// 7 is also sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	schedule.domAny = fields[2] == "*"
+	schedule.dowAny = fields[4] == "*"
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of "*", "n", "a-b" with optional "/step"
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step: %s", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range: %s", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range: %s", part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value: %s", part)
+			}
+			start = value
+			if step == 1 {
+				end = value
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("out of range: %s", part)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSchedule) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// same as vixie cron: if both are restricted, either one may match
+	if !c.domAny && !c.dowAny {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// next returns the first time after t that matches the schedule
+func (c cronSchedule) next(t time.Time) (time.Time, error) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("no matching time within 5 years")
+}
+
+// validateRecurrence checks that exactly one of cron and interval is set and valid
+func validateRecurrence(cron, interval string) error {
+	if cron != "" && interval != "" {
+		return fmt.Errorf("cron and interval are exclusive")
+	}
+
+	if cron != "" {
+		_, err := parseCron(cron)
+		return err
+	}
+
+	if interval != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil {
+			return err
+		}
+		if duration < time.Second {
+			return fmt.Errorf("interval must be at least 1s")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("either cron or interval is required")
+}
+
+// NextOccurrence returns the next scheduled time of a recurring job after the given time
+func NextOccurrence(job core.Job, after time.Time) (time.Time, error) {
+	if job.Cron != "" {
+		schedule, err := parseCron(job.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return schedule.next(after)
+	}
+
+	if job.Interval != "" {
+		duration, err := time.ParseDuration(job.Interval)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return after.Add(duration), nil
+	}
+
+	return time.Time{}, fmt.Errorf("job is not recurring")
+}
+
+// shortestPeriod returns the shortest gap between two occurrences of the recurring job within a day from the given time
+func shortestPeriod(job core.Job, from time.Time) (time.Duration, error) {
+	if job.Cron == "" {
+		return time.ParseDuration(job.Interval)
+	}
+
+	previous, err := NextOccurrence(job, from)
+	if err != nil {
+		return 0, err
+	}
+
+	shortest := time.Duration(-1)
+	end := previous.Add(24 * time.Hour)
+	for previous.Before(end) {
+		next, err := NextOccurrence(job, previous)
+		if err != nil {
+			return 0, err
+		}
+		if gap := next.Sub(previous); shortest < 0 || gap < shortest {
+			shortest = gap
+		}
+		previous = next
+	}
+
+	return shortest, nil
+}
+
+// userJobPolicy restricts how a job type may be created through the API
+type userJobPolicy struct {
+	Recurring   bool          // may be created as a recurring job
+	MinInterval time.Duration // shortest period of a recurring job
+}
+
+// userJobPolicies lists the job types users may create. other types (ex: metrics) are run by the domain only
+var userJobPolicies = map[string]userJobPolicy{
+	"clean":   {},
+	"migrate": {},
+	"hello":   {Recurring: true, MinInterval: time.Hour},
+}
+
+// validateUserJob checks that users may create the job of the type with the recurrence
+func validateUserJob(typ, cron, interval string) error {
+	policy, ok := userJobPolicies[typ]
+	if !ok {
+		return fmt.Errorf("job type %s can not be created", typ)
+	}
+
+	if cron == "" && interval == "" {
+		return nil
+	}
+
+	if !policy.Recurring {
+		return fmt.Errorf("job type %s can not be recurring", typ)
+	}
+
+	err := validateRecurrence(cron, interval)
+	if err != nil {
+		return err
+	}
+
+	period, err := shortestPeriod(core.Job{Cron: cron, Interval: interval}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if period < policy.MinInterval {
+		return fmt.Errorf("job type %s must not run more often than every %s", typ, policy.MinInterval)
+	}
+
+	return nil
+}
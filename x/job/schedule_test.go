@@ -0,0 +1,82 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	base := time.Date(2024, 1, 31, 10, 30, 15, 0, time.UTC) // wednesday
+
+	tests := []struct {
+		cron     string
+		interval string
+		expected time.Time
+	}{
+		{"", "15s", base.Add(15 * time.Second)},
+		{"* * * * *", "", time.Date(2024, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", "", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 9 * * *", "", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", "", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 * *", "", time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 1-5", "", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", "", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", "", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", "", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		next, err := NextOccurrence(core.Job{Cron: test.cron, Interval: test.interval}, base)
+		if assert.NoError(t, err, test.cron) {
+			assert.Equal(t, test.expected, next, test.cron)
+		}
+	}
+}
+
+func TestValidateRecurrence(t *testing.T) {
+	assert.NoError(t, validateRecurrence("0 0 * * *", ""))
+	assert.NoError(t, validateRecurrence("", "1m"))
+	assert.Error(t, validateRecurrence("", ""))
+	assert.Error(t, validateRecurrence("0 0 * * *", "1m"))
+	assert.Error(t, validateRecurrence("0 0 * *", ""))
+	assert.Error(t, validateRecurrence("60 * * * *", ""))
+	assert.Error(t, validateRecurrence("*/0 * * * *", ""))
+	assert.Error(t, validateRecurrence("", "100ms"))
+}
+
+func TestShortestPeriod(t *testing.T) {
+	base := time.Date(2024, 1, 31, 10, 30, 15, 0, time.UTC)
+
+	period, err := shortestPeriod(core.Job{Interval: "90m"}, base)
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, period)
+
+	period, err = shortestPeriod(core.Job{Cron: "0,1 * * * *"}, base)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, period)
+
+	period, err = shortestPeriod(core.Job{Cron: "@daily"}, base)
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, period)
+}
+
+func TestValidateUserJob(t *testing.T) {
+	assert.NoError(t, validateUserJob("clean", "", ""))
+	assert.NoError(t, validateUserJob("hello", "", "2h"))
+	assert.NoError(t, validateUserJob("hello", "@hourly", ""))
+
+	// ドメインが実行するジョブは作れない
+	assert.Error(t, validateUserJob("metrics", "", "15s"))
+	assert.Error(t, validateUserJob(core.JobTypeScheduledCommit, "", ""))
+
+	// 繰り返せない種類
+	assert.Error(t, validateUserJob("migrate", "", "1h"))
+
+	// 短すぎる間隔
+	assert.Error(t, validateUserJob("hello", "", "1s"))
+	assert.Error(t, validateUserJob("hello", "*/5 * * * *", ""))
+}
@@ -10,12 +10,14 @@ import (
 )
 
 type service struct {
-	repo Repository
+	repo   Repository
+	config core.Config
 }
 
-func NewService(repo Repository) core.JobService {
+func NewService(repo Repository, config core.Config) core.JobService {
 	return &service{
 		repo,
+		config,
 	}
 }
 
@@ -31,6 +33,20 @@ func (s *service) List(ctx context.Context, requester string) ([]core.Job, error
 	return jobs, nil
 }
 
+// ListSystem returns jobs owned by the domain itself
+func (s *service) ListSystem(ctx context.Context) ([]core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Service.ListSystem")
+	defer span.End()
+
+	jobs, err := s.repo.List(ctx, s.config.CSID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
 func (s *service) Create(ctx context.Context, requester, typ, payload string, scheduled time.Time) (core.Job, error) {
 	ctx, span := tracer.Start(ctx, "Job.Service.Create")
 	defer span.End()
@@ -43,6 +59,16 @@ func (s *service) Create(ctx context.Context, requester, typ, payload string, sc
 	   }
 	*/
 
+	// 移行ジョブの JWT は平文で保存しない
+	if typ == "migrate" {
+		sealed, err := sealMigratePayload(payload, s.config.PrivateKey)
+		if err != nil {
+			span.RecordError(err)
+			return core.Job{}, err
+		}
+		payload = sealed
+	}
+
 	job, err := s.repo.Enqueue(ctx, requester, typ, payload, scheduled)
 	if err != nil {
 		return core.Job{}, err
@@ -51,11 +77,63 @@ func (s *service) Create(ctx context.Context, requester, typ, payload string, sc
 	return job, nil
 }
 
-func (s *service) Dequeue(ctx context.Context) (*core.Job, error) {
+// CreateRecurring creates a job that runs on the cron schedule or every interval
+func (s *service) CreateRecurring(ctx context.Context, requester, typ, payload, cron, interval string) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Service.CreateRecurring")
+	defer span.End()
+
+	err := validateRecurrence(cron, interval)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	scheduled, err := NextOccurrence(core.Job{Cron: cron, Interval: interval}, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	job, err := s.repo.EnqueueRecurring(ctx, requester, typ, payload, cron, interval, scheduled)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
+// EnsureRecurring is same as CreateRecurring but does nothing if the requester already has an active recurring job of the type
+func (s *service) EnsureRecurring(ctx context.Context, requester, typ, payload, cron, interval string) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Service.EnsureRecurring")
+	defer span.End()
+
+	err := validateRecurrence(cron, interval)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	scheduled, err := NextOccurrence(core.Job{Cron: cron, Interval: interval}, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	job, err := s.repo.EnsureRecurring(ctx, requester, typ, payload, cron, interval, scheduled)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
+func (s *service) Dequeue(ctx context.Context, types []string, lease time.Duration) (*core.Job, error) {
 	ctx, span := tracer.Start(ctx, "Job.Service.Dequeue")
 	defer span.End()
 
-	job, err := s.repo.Dequeue(ctx)
+	job, err := s.repo.Dequeue(ctx, types, lease)
 	if err != nil {
 		return nil, err
 	}
@@ -63,11 +141,19 @@ func (s *service) Dequeue(ctx context.Context) (*core.Job, error) {
 	return job, nil
 }
 
-func (s *service) Complete(ctx context.Context, id, status, result string) (core.Job, error) {
+// Heartbeat extends the lease of a running job
+func (s *service) Heartbeat(ctx context.Context, id string, attempt int, lease time.Duration) error {
+	ctx, span := tracer.Start(ctx, "Job.Service.Heartbeat")
+	defer span.End()
+
+	return s.repo.Heartbeat(ctx, id, attempt, lease)
+}
+
+func (s *service) Complete(ctx context.Context, id string, attempt int, status, result string) (core.Job, error) {
 	ctx, span := tracer.Start(ctx, "Job.Service.Complete")
 	defer span.End()
 
-	job, err := s.repo.Complete(ctx, id, status, result)
+	job, err := s.repo.Complete(ctx, id, attempt, status, result)
 	if err != nil {
 		return core.Job{}, err
 	}
@@ -75,12 +161,48 @@ func (s *service) Complete(ctx context.Context, id, status, result string) (core
 	return job, nil
 }
 
-func (s *service) Cancel(ctx context.Context, id string) (core.Job, error) {
+// Retry marks the job as failed and schedules the next attempt
+func (s *service) Retry(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Service.Retry")
+	defer span.End()
+
+	job, err := s.repo.Retry(ctx, id, attempt, result, scheduled)
+	if err != nil {
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
+// Reschedule puts a finished occurrence of a recurring job back to the queue
+func (s *service) Reschedule(ctx context.Context, id string, attempt int, result string, scheduled time.Time) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Job.Service.Reschedule")
+	defer span.End()
+
+	job, err := s.repo.Reschedule(ctx, id, attempt, result, scheduled)
+	if err != nil {
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
+// UpdateResult updates the result of a running job to report its progress
+func (s *service) UpdateResult(ctx context.Context, id, result string) error {
+	ctx, span := tracer.Start(ctx, "Job.Service.UpdateResult")
+	defer span.End()
+
+	return s.repo.UpdateResult(ctx, id, result)
+}
+
+// Cancel cancels the pending job of the requester
+func (s *service) Cancel(ctx context.Context, requester, id string) (core.Job, error) {
 	ctx, span := tracer.Start(ctx, "Job.Service.Cancel")
 	defer span.End()
 
-	job, err := s.repo.Cancel(ctx, id)
+	job, err := s.repo.Cancel(ctx, id, requester)
 	if err != nil {
+		span.RecordError(err)
 		return core.Job{}, err
 	}
 
@@ -59,9 +59,13 @@ func Validate(jwt string) (Claims, error) {
 	}
 
 	// check jwt type
-	if header.Type != "JWT" || header.Algorithm != "CONCRNT" {
+	if header.Type != "JWT" {
 		return claims, fmt.Errorf("Unsupported JWT type")
 	}
+	algorithm, err := signatureAlgorithm(header.Algorithm)
+	if err != nil {
+		return claims, err
+	}
 
 	payloadBytes, err := base64.RawURLEncoding.DecodeString(split[1])
 	if err != nil {
@@ -90,7 +94,7 @@ func Validate(jwt string) (Claims, error) {
 		return claims, err
 	}
 
-	err = core.VerifySignature([]byte(split[0]+"."+split[1]), signatureBytes, claims.Issuer)
+	err = core.VerifySignatureWithAlgorithm([]byte(split[0]+"."+split[1]), signatureBytes, claims.Issuer, algorithm)
 	if err != nil {
 		return claims, err
 	}
@@ -98,3 +102,16 @@ func Validate(jwt string) (Claims, error) {
 	// all checks passed
 	return claims, nil
 }
+
+// signatureAlgorithm maps the alg header to a signature algorithm of core.
+// "CONCRNT" is secp256k1, and "CONCRNT-<algorithm>" (e.g. "CONCRNT-ED25519") selects the other ones.
+func signatureAlgorithm(alg string) (string, error) {
+	if alg == "CONCRNT" {
+		return core.AlgorithmSecp256k1, nil
+	}
+	algorithm, ok := strings.CutPrefix(alg, "CONCRNT-")
+	if !ok {
+		return "", fmt.Errorf("Unsupported JWT type")
+	}
+	return strings.ToLower(algorithm), nil
+}
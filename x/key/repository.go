@@ -20,6 +20,7 @@ type Repository interface {
 	GetAll(ctx context.Context, owner string) ([]core.Key, error)
 	GetRemoteKeyResolution(ctx context.Context, remote string, keyID string) ([]core.Key, error)
 	Clean(ctx context.Context, ccid string) error
+	Expire(ctx context.Context, root string, at time.Time) ([]string, error)
 }
 
 type repository struct {
@@ -58,10 +59,13 @@ func (r *repository) GetRemoteKeyResolution(ctx context.Context, remote string,
 		return nil, err
 	}
 
-	_, err = ValidateKeyResolution(keys) // TODO: should have a negative cache
+	// 有効期限は使う側が文書の署名時刻で確認する
+	// 復旧済みエンティティのキーは新しいマスターキーで署名されているため、ここで検証できないものはキャッシュせずに返す
+	// 使う側がRootSignerで改めて検証する
+	_, err = verifyKeyChain(keys, "") // TODO: should have a negative cache
 	if err != nil {
 		span.RecordError(err)
-		return nil, err
+		return keys, nil
 	}
 
 	// cache
@@ -159,3 +163,32 @@ func (r *repository) Clean(ctx context.Context, ccid string) error {
 
 	return nil
 }
+
+// Expire ends the validity of every key of the root enacted before at and returns their ids
+func (r *repository) Expire(ctx context.Context, root string, at time.Time) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "Key.Repository.Expire")
+	defer span.End()
+
+	var expired []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&core.Key{}).
+			Where("root = ? AND valid_since < ?", root, at).
+			Where("valid_until = ? OR valid_until > ?", time.Time{}, at).
+			Pluck("id", &expired).Error
+		if err != nil {
+			return err
+		}
+
+		if len(expired) == 0 {
+			return nil
+		}
+
+		return tx.Model(&core.Key{}).Where("id IN ?", expired).Update("valid_until", at).Error
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return expired, nil
+}
@@ -62,4 +62,21 @@ func TestRepository(t *testing.T) {
 	if assert.NoError(t, err) {
 		assert.Equal(t, modified.ID, found.ID)
 	}
+
+	// 復旧時点より前に有効になったキーは全て期限切れになる
+	recoveredAt := time.Now()
+	expired, err := repo.Expire(ctx, newkey.Root, recoveredAt)
+	if assert.NoError(t, err) {
+		assert.Contains(t, expired, created.ID)
+	}
+
+	found, err = repo.Get(ctx, created.ID)
+	if assert.NoError(t, err) {
+		assert.WithinDuration(t, recoveredAt, found.ValidUntil, time.Millisecond)
+	}
+
+	expired, err = repo.Expire(ctx, newkey.Root, recoveredAt)
+	if assert.NoError(t, err) {
+		assert.Empty(t, expired)
+	}
 }
@@ -5,18 +5,22 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/totegamma/concurrent/core"
 )
 
 type service struct {
 	repository Repository
+	revocation core.RevocationService
 	config     core.Config
 }
 
 // NewService creates a new auth service
-func NewService(repository Repository, config core.Config) core.KeyService {
-	return &service{repository, config}
+func NewService(repository Repository, revocation core.RevocationService, config core.Config) core.KeyService {
+	return &service{repository, revocation, config}
 }
 
 // Enact validates new subkey and save it if valid
@@ -44,6 +48,12 @@ func (s *service) Enact(ctx context.Context, mode core.CommitMode, payload, sign
 		return core.Key{}, fmt.Errorf("Parent is not matched with the signer")
 	}
 
+	_, err = core.GetVerifier(object.Algorithm)
+	if err != nil {
+		span.RecordError(err)
+		return core.Key{}, err
+	}
+
 	key := core.Key{
 		ID:             object.Target,
 		Root:           object.Root,
@@ -53,6 +63,13 @@ func (s *service) Enact(ctx context.Context, mode core.CommitMode, payload, sign
 		ValidSince:     object.SignedAt,
 	}
 
+	if object.ValidUntil != nil {
+		if !object.ValidUntil.After(object.SignedAt) {
+			return core.Key{}, fmt.Errorf("validUntil must be after signedAt")
+		}
+		key.ValidUntil = *object.ValidUntil
+	}
+
 	created, err := s.repository.Enact(ctx, key)
 	if err != nil {
 		span.RecordError(err)
@@ -105,10 +122,57 @@ func (s *service) Revoke(ctx context.Context, mode core.CommitMode, payload, sig
 		return core.Key{}, err
 	}
 
+	// このキーで発行されたパスポートをリモートでも無効にする
+	err = s.revocation.Publish(ctx, core.RevocationTypeKey, object.Target, object.SignedAt)
+	if err != nil {
+		span.RecordError(errors.Wrap(err, "failed to publish revocation"))
+	}
+
 	return revoked, nil
 }
 
-func ValidateKeyResolution(keys []core.Key) (string, error) {
+// ValidateKeyResolution verifies the keychain and checks that every key was valid at the given time.
+// at is the signedAt of the document being validated, so that old documents stay valid after their keys expire.
+// rootSigner is the address that signs for the root of the keychain (see RootSigner). empty means the root itself.
+func ValidateKeyResolution(keys []core.Key, at time.Time, rootSigner string) (string, error) {
+	root, err := verifyKeyChain(keys, rootSigner)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		var enact core.EnactDocument
+		err := json.Unmarshal([]byte(key.EnactDocument), &enact)
+		if err != nil {
+			return "", err
+		}
+
+		if enact.ValidUntil != nil && !at.Before(*enact.ValidUntil) {
+			return "", fmt.Errorf("Key %s is expired", key.ID)
+		}
+
+		// 復旧によって無効化されたキーは署名されていない期限で切れる
+		if !key.ValidUntil.IsZero() && !at.Before(key.ValidUntil) {
+			return "", fmt.Errorf("Key %s is expired", key.ID)
+		}
+	}
+
+	return root, nil
+}
+
+// RootSigner returns the address that signs for the entity at the given time.
+// Once the entity is recovered by its guardians, the new master key signs in place of the CCID.
+func RootSigner(entity core.Entity, at time.Time) string {
+	if entity.MasterKey != nil && entity.RecoveredAt != nil && !at.Before(*entity.RecoveredAt) {
+		return *entity.MasterKey
+	}
+	return entity.ID
+}
+
+// verifyKeyChain verifies the signatures and the links of the keychain and returns its root.
+// Keys enacted by the root are verified against rootSigner, or against the root itself when it is empty.
+// Expiry is not checked since it depends on when the keychain is used.
+func verifyKeyChain(keys []core.Key, rootSigner string) (string, error) {
 
 	var rootKey string
 	var nextKey string
@@ -117,22 +181,27 @@ func ValidateKeyResolution(keys []core.Key) (string, error) {
 			return "", fmt.Errorf("Key %s is not a child of %s", key.ID, nextKey)
 		}
 
-		signature, err := hex.DecodeString(key.EnactSignature)
+		var enact core.EnactDocument
+		err := json.Unmarshal([]byte(key.EnactDocument), &enact)
 		if err != nil {
 			return "", err
 		}
-		err = core.VerifySignature([]byte(key.EnactDocument), signature, key.Parent)
+
+		signer := key.Parent
+		if rootSigner != "" && (core.IsCCID(key.Parent) || core.IsCSID(key.Parent)) {
+			signer = rootSigner
+		}
+
+		signature, err := hex.DecodeString(key.EnactSignature)
 		if err != nil {
 			return "", err
 		}
-
-		var enact core.EnactDocument
-		err = json.Unmarshal([]byte(key.EnactDocument), &enact)
+		err = core.VerifySignatureWithAlgorithm([]byte(key.EnactDocument), signature, signer, enact.Algorithm)
 		if err != nil {
 			return "", err
 		}
 
-		if core.IsCCID(key.Parent) {
+		if core.IsCCID(key.Parent) || core.IsCSID(key.Parent) {
 			if enact.Signer != key.Parent {
 				return "", fmt.Errorf("enact signer is not matched with the parent")
 			}
@@ -172,7 +241,8 @@ func ValidateKeyResolution(keys []core.Key) (string, error) {
 	return rootKey, nil
 }
 
-func (s *service) ResolveSubkey(ctx context.Context, keyID string) (string, error) {
+// ResolveSubkey returns the root of the keyID when every key of the chain was valid at the given time
+func (s *service) ResolveSubkey(ctx context.Context, keyID string, at time.Time) (string, error) {
 	ctx, span := tracer.Start(ctx, "Key.Service.ResolveSubkey")
 	defer span.End()
 
@@ -188,8 +258,8 @@ func (s *service) ResolveSubkey(ctx context.Context, keyID string) (string, erro
 	for _, key := range keychain {
 		rootKey = key.Root
 		validationTrace += " -> " + key.ID
-		if !IsKeyValid(ctx, key) {
-			return "", fmt.Errorf("Key %s is revoked. trace: %s", keyID, validationTrace)
+		if !IsKeyValid(ctx, key, at) {
+			return "", fmt.Errorf("Key %s is revoked or expired. trace: %s", keyID, validationTrace)
 		}
 	}
 
@@ -210,7 +280,7 @@ func (s *service) GetKeyResolution(ctx context.Context, keyID string) ([]core.Ke
 	var keys []core.Key
 	var currentDepth = 0
 	for {
-		if core.IsCCID(keyID) {
+		if core.IsCCID(keyID) || core.IsCSID(keyID) {
 			return keys, nil
 		}
 
@@ -236,6 +306,28 @@ func (s *service) GetAllKeys(ctx context.Context, owner string) ([]core.Key, err
 	return s.repository.GetAll(ctx, owner)
 }
 
+// InvalidateAll invalidates every subkey of the root enacted before at
+func (s *service) InvalidateAll(ctx context.Context, root string, at time.Time) error {
+	ctx, span := tracer.Start(ctx, "Key.Service.InvalidateAll")
+	defer span.End()
+
+	expired, err := s.repository.Expire(ctx, root, at)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// 無効化したサブキーのパスポートをリモートでも無効にする
+	for _, keyID := range expired {
+		err = s.revocation.Publish(ctx, core.RevocationTypeKey, keyID, at)
+		if err != nil {
+			span.RecordError(errors.Wrap(err, "failed to publish revocation"))
+		}
+	}
+
+	return nil
+}
+
 func (s *service) Clean(ctx context.Context, ccid string) error {
 	ctx, span := tracer.Start(ctx, "Key.Service.Clean")
 	defer span.End()
@@ -243,6 +335,61 @@ func (s *service) Clean(ctx context.Context, ccid string) error {
 	return s.repository.Clean(ctx, ccid)
 }
 
-func IsKeyValid(ctx context.Context, key core.Key) bool {
-	return key.RevokeDocument == nil
+// IsKeyValid reports whether the key was neither revoked nor expired at the given time
+func IsKeyValid(ctx context.Context, key core.Key, at time.Time) bool {
+	if key.RevokeDocument != nil {
+		return false
+	}
+	return key.ValidUntil.IsZero() || at.Before(key.ValidUntil)
+}
+
+// ValidateOperatorKey checks that keys is a valid keychain of keyID delegated from the domain csid at the given time
+func ValidateOperatorKey(keys []core.Key, keyID, csid string, at time.Time) error {
+	if !core.IsCSID(csid) {
+		return fmt.Errorf("%s is not a domain", csid)
+	}
+
+	if len(keys) == 0 || keys[0].ID != keyID {
+		return fmt.Errorf("keychain does not start with %s", keyID)
+	}
+
+	root, err := ValidateKeyResolution(keys, at, "")
+	if err != nil {
+		return err
+	}
+
+	if root != csid {
+		return fmt.Errorf("Key %s is not delegated from %s", keyID, csid)
+	}
+
+	return nil
+}
+
+// CheckKeyScope checks whether the document is allowed by every key of the keychain.
+// A subkey can never sign more than its ancestors allow.
+func CheckKeyScope(keys []core.Key, document string) error {
+	var doc struct {
+		Type      string   `json:"type"`
+		Schema    string   `json:"schema"`
+		Timelines []string `json:"timelines"`
+	}
+	err := json.Unmarshal([]byte(document), &doc)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var enact core.EnactDocument
+		err := json.Unmarshal([]byte(key.EnactDocument), &enact)
+		if err != nil {
+			return err
+		}
+
+		err = enact.Scope.Allows(doc.Type, doc.Schema, doc.Timelines)
+		if err != nil {
+			return fmt.Errorf("Key %s: %w", key.ID, err)
+		}
+	}
+
+	return nil
 }
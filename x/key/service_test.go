@@ -0,0 +1,178 @@
+package key
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	User1ID   = "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	User1Priv = "3fcfac6c211b743975de2d7b3f622c12694b8125daf4013562c5a1aefa3253a5"
+
+	SubKey1ID = "cck1ydda2qj3nr32hulm65vj2g746f06hy36wzh9ke"
+
+	MasterKeyPriv = "1ca30329e8d35217b2328bacfc21c5e3d762713edab0252eead1f4c1ac0b4d81"
+)
+
+func enactKey(t *testing.T, validUntil *time.Time, scope *core.KeyScope) core.Key {
+	document, err := json.Marshal(core.EnactDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   User1ID,
+			Type:     "enact",
+			SignedAt: time.Now(),
+		},
+		Target:     SubKey1ID,
+		Root:       User1ID,
+		Parent:     User1ID,
+		ValidUntil: validUntil,
+		Scope:      scope,
+	})
+	assert.NoError(t, err)
+
+	signature, err := core.SignBytes(document, User1Priv)
+	assert.NoError(t, err)
+
+	return core.Key{
+		ID:             SubKey1ID,
+		Root:           User1ID,
+		Parent:         User1ID,
+		EnactDocument:  string(document),
+		EnactSignature: hex.EncodeToString(signature),
+	}
+}
+
+func TestValidateKeyResolutionExpiry(t *testing.T) {
+	future := time.Now().Add(30 * 24 * time.Hour)
+	root, err := ValidateKeyResolution([]core.Key{enactKey(t, &future, nil)}, time.Now(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, User1ID, root)
+
+	past := time.Now().Add(-time.Minute)
+	_, err = ValidateKeyResolution([]core.Key{enactKey(t, &past, nil)}, time.Now(), "")
+	assert.Error(t, err)
+
+	// 期限切れ前に署名された文書はリストアなどで後から検証しても有効
+	root, err = ValidateKeyResolution([]core.Key{enactKey(t, &past, nil)}, past.Add(-time.Hour), "")
+	assert.NoError(t, err)
+	assert.Equal(t, User1ID, root)
+
+	// 復旧で無効化されたキー (DBのvalidUntil) も期限切れとして扱う
+	expired := enactKey(t, nil, nil)
+	expired.ValidUntil = past
+	_, err = ValidateKeyResolution([]core.Key{expired}, time.Now(), "")
+	assert.Error(t, err)
+
+	root, err = ValidateKeyResolution([]core.Key{expired}, past.Add(-time.Hour), "")
+	assert.NoError(t, err)
+	assert.Equal(t, User1ID, root)
+}
+
+func TestValidateKeyResolutionRecovered(t *testing.T) {
+	masterKey, err := core.PrivKeyToAddr(MasterKeyPriv, "cck")
+	assert.NoError(t, err)
+
+	document, err := json.Marshal(core.EnactDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   User1ID,
+			Type:     "enact",
+			SignedAt: time.Now(),
+		},
+		Target: SubKey1ID,
+		Root:   User1ID,
+		Parent: User1ID,
+	})
+	assert.NoError(t, err)
+
+	// 復旧後の新しいマスターキーで署名したサブキー
+	signature, err := core.SignBytes(document, MasterKeyPriv)
+	assert.NoError(t, err)
+
+	keys := []core.Key{{
+		ID:             SubKey1ID,
+		Root:           User1ID,
+		Parent:         User1ID,
+		EnactDocument:  string(document),
+		EnactSignature: hex.EncodeToString(signature),
+	}}
+
+	_, err = ValidateKeyResolution(keys, time.Now(), "")
+	assert.Error(t, err)
+
+	recoveredAt := time.Now().Add(-time.Hour)
+	entity := core.Entity{ID: User1ID, MasterKey: &masterKey, RecoveredAt: &recoveredAt}
+	assert.Equal(t, User1ID, RootSigner(entity, recoveredAt.Add(-time.Minute)))
+	assert.Equal(t, masterKey, RootSigner(entity, time.Now()))
+
+	root, err := ValidateKeyResolution(keys, time.Now(), RootSigner(entity, time.Now()))
+	assert.NoError(t, err)
+	assert.Equal(t, User1ID, root)
+}
+
+func TestIsKeyValid(t *testing.T) {
+	validUntil := time.Now().Add(-time.Minute)
+	key := core.Key{ID: SubKey1ID, ValidUntil: validUntil}
+
+	assert.True(t, IsKeyValid(context.Background(), key, validUntil.Add(-time.Second)))
+	assert.False(t, IsKeyValid(context.Background(), key, validUntil))
+	assert.False(t, IsKeyValid(context.Background(), key, time.Now()))
+	assert.True(t, IsKeyValid(context.Background(), core.Key{ID: SubKey1ID}, time.Now()))
+
+	revoke := "{}"
+	assert.False(t, IsKeyValid(context.Background(), core.Key{ID: SubKey1ID, RevokeDocument: &revoke}, validUntil.Add(-time.Second)))
+}
+
+func TestCheckKeyScope(t *testing.T) {
+	keys := []core.Key{enactKey(t, nil, &core.KeyScope{
+		Types:     []string{"message"},
+		Timelines: []string{"tbot00000000000000000000000@local.example.com"},
+	})}
+
+	assert.NoError(t, CheckKeyScope(keys, `{"type":"message","timelines":["tbot00000000000000000000000@local.example.com"]}`))
+	assert.Error(t, CheckKeyScope(keys, `{"type":"message","timelines":["tother000000000000000000000@local.example.com"]}`))
+	assert.Error(t, CheckKeyScope(keys, `{"type":"association","timelines":["tbot00000000000000000000000@local.example.com"]}`))
+
+	unscoped := []core.Key{enactKey(t, nil, nil)}
+	assert.NoError(t, CheckKeyScope(unscoped, `{"type":"delete"}`))
+}
+
+func TestValidateOperatorKey(t *testing.T) {
+	csid, err := core.PrivKeyToAddr(User1Priv, "ccs")
+	assert.NoError(t, err)
+
+	document, err := json.Marshal(core.EnactDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   csid,
+			Type:     "enact",
+			SignedAt: time.Now(),
+		},
+		Target: SubKey1ID,
+		Root:   csid,
+		Parent: csid,
+	})
+	assert.NoError(t, err)
+
+	signature, err := core.SignBytes(document, User1Priv)
+	assert.NoError(t, err)
+
+	keys := []core.Key{{
+		ID:             SubKey1ID,
+		Root:           csid,
+		Parent:         csid,
+		EnactDocument:  string(document),
+		EnactSignature: hex.EncodeToString(signature),
+	}}
+
+	assert.NoError(t, ValidateOperatorKey(keys, SubKey1ID, csid, time.Now()))
+	assert.Error(t, ValidateOperatorKey(keys, SubKey1ID, User1ID, time.Now()))
+
+	revoke := "{}"
+	keys[0].RevokeDocument = &revoke
+	assert.Error(t, ValidateOperatorKey(keys, SubKey1ID, csid, time.Now()))
+}
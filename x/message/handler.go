@@ -4,6 +4,8 @@ package message
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/totegamma/concurrent/core"
@@ -15,6 +17,7 @@ var tracer = otel.Tracer("message")
 // Handler is the interface for handling HTTP requests
 type Handler interface {
 	Get(c echo.Context) error
+	Search(c echo.Context) error
 }
 
 type handler struct {
@@ -58,3 +61,67 @@ func (h handler) Get(c echo.Context) error {
 		"content": message,
 	})
 }
+
+// Search returns public messages matching the query
+func (h handler) Search(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Message.Handler.Search")
+	defer span.End()
+
+	query := c.QueryParam("q")
+	timeline := c.QueryParam("timeline")
+	author := c.QueryParam("author")
+	untilStr := c.QueryParam("until")
+	cursorStr := c.QueryParam("cursor")
+	limitStr := c.QueryParam("limit")
+
+	if query == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "q is required"})
+	}
+
+	cursor := core.SearchCursor{CDate: time.Now()}
+
+	var err error
+	if cursorStr != "" {
+		cursor, err = core.ParseSearchCursor(cursorStr)
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+		}
+	} else if untilStr != "" {
+		epoch, err := strconv.ParseInt(untilStr, 10, 64)
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+		}
+		cursor.CDate = time.Unix(epoch, 0)
+	}
+
+	limit := 16
+	if limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+		}
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	messages, next, err := h.service.Search(ctx, query, timeline, author, cursor, limit)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	response := echo.Map{"status": "ok", "content": messages}
+	if next != nil {
+		response["next"] = next.String()
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
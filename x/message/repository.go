@@ -1,3 +1,4 @@
+//go:generate go run go.uber.org/mock/mockgen -source=repository.go -destination=mock/repository.go
 package message
 
 import (
@@ -17,6 +17,14 @@ import (
 	"github.com/totegamma/concurrent/x/policy"
 )
 
+func init() {
+	core.RegisterPolicyAction(
+		"message.delete",
+		"message.read",
+		"timeline.message.read",
+	)
+}
+
 type service struct {
 	repo     Repository
 	client   client.Client
@@ -24,6 +32,7 @@ type service struct {
 	domain   core.DomainService
 	timeline core.TimelineService
 	key      core.KeyService
+	search   core.SearchService
 	policy   core.PolicyService
 	config   core.Config
 }
@@ -36,6 +45,7 @@ func NewService(
 	domain core.DomainService,
 	timeline core.TimelineService,
 	key core.KeyService,
+	search core.SearchService,
 	policy core.PolicyService,
 	config core.Config,
 ) core.MessageService {
@@ -46,6 +56,7 @@ func NewService(
 		domain,
 		timeline,
 		key,
+		search,
 		policy,
 		config,
 	}
@@ -369,6 +380,16 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 		return core.Message{}, []string{}, err
 	}
 
+	// リストアやリレーでは取得を伴う検証をしない
+	// メッセージのリソースは署名者のドメインにのみ作成される
+	if doc.Policy != "" && mode != core.CommitModeLocalOnlyExec && signer.Domain == s.config.FQDN {
+		err = s.policy.ValidateWithPolicyURL(ctx, doc.Policy)
+		if err != nil {
+			span.RecordError(err)
+			return core.Message{}, []string{}, err
+		}
+	}
+
 	var policyparams *string = nil
 	if doc.PolicyParams != "" {
 		policyparams = &doc.PolicyParams
@@ -402,12 +423,14 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 	}
 
 	destinations := make(map[string][]string)
+	normalizedTimelines := make([]string, 0, len(doc.Timelines))
 	for _, timelineID := range doc.Timelines {
 		normalized, err := s.timeline.NormalizeTimelineID(ctx, timelineID)
 		if err != nil {
 			span.RecordError(errors.Wrap(err, "failed to normalize timeline id"))
 			continue
 		}
+		normalizedTimelines = append(normalizedTimelines, normalized)
 		split := strings.Split(normalized, "@")
 		if len(split) <= 1 {
 			span.RecordError(fmt.Errorf("invalid timeline id: %s", normalized))
@@ -434,6 +457,17 @@ func (s *service) Create(ctx context.Context, mode core.CommitMode, document str
 		sendDocument = document
 		sendSignature = signature
 		sendResource = &created
+
+		// このドメインに所属するユーザーが作成時点で公開しているメッセージを索引する
+		// 公開範囲はその後変わりうるので、検索時にも再評価する
+		if signer.Domain == s.config.FQDN {
+			indexTarget := created
+			indexTarget.Timelines = normalizedTimelines
+			err = s.search.IndexMessage(ctx, indexTarget)
+			if err != nil {
+				span.RecordError(errors.Wrap(err, "failed to index message"))
+			}
+		}
 	}
 
 	for domain, timelines := range destinations {
@@ -562,7 +596,7 @@ func (s *service) Delete(ctx context.Context, mode core.CommitMode, document, si
 
 	finally := s.policy.Summerize([]core.PolicyEvalResult{result}, "message.delete", nil)
 	if !finally {
-		return core.Message{}, []string{}, core.ErrorPermissionDenied{}
+		return core.Message{}, []string{}, core.NewErrorPermissionDeniedByStatement("message.delete")
 	}
 
 	err = s.repo.Delete(ctx, doc.Target)
@@ -576,6 +610,11 @@ func (s *service) Delete(ctx context.Context, mode core.CommitMode, document, si
 		span.RecordError(err)
 	}
 
+	err = s.search.RemoveMessage(ctx, doc.Target)
+	if err != nil {
+		span.RecordError(err)
+	}
+
 	ispublic, err := s.isMessagePublic(ctx, deleteTarget)
 	if err != nil {
 		span.RecordError(err)
@@ -615,5 +654,79 @@ func (s *service) Clean(ctx context.Context, ccid string) error {
 	ctx, span := tracer.Start(ctx, "Message.Service.Clean")
 	defer span.End()
 
+	err := s.search.Clean(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	return s.repo.Clean(ctx, ccid)
 }
+
+// searchMaxRounds bounds how many batches of the index are examined to fill a page of public messages
+const searchMaxRounds = 5
+
+// Search returns public messages older than the cursor matching the query
+// It also returns the cursor for the next page. The cursor is nil if there are no more results.
+func (s *service) Search(ctx context.Context, query, timeline, author string, cursor core.SearchCursor, limit int) ([]core.Message, *core.SearchCursor, error) {
+	ctx, span := tracer.Start(ctx, "Message.Service.Search")
+	defer span.End()
+
+	if timeline != "" {
+		normalized, err := s.timeline.NormalizeTimelineID(ctx, timeline)
+		if err != nil {
+			span.RecordError(err)
+			return nil, nil, err
+		}
+		timeline = normalized
+	}
+
+	// 非公開のメッセージを除いた分だけページが欠けないよう、埋まるまで続きを読む
+	messages := make([]core.Message, 0, limit)
+	exhausted := false
+	for round := 0; round < searchMaxRounds && len(messages) < limit; round++ {
+		indices, err := s.search.SearchMessages(ctx, query, timeline, author, cursor, limit)
+		if err != nil {
+			span.RecordError(err)
+			return nil, nil, err
+		}
+
+		examined := 0
+		for _, index := range indices {
+			if len(messages) >= limit {
+				break
+			}
+			examined++
+			cursor = core.SearchCursor{CDate: index.CDate, MessageID: index.MessageID}
+
+			message, err := s.repo.Get(ctx, index.MessageID)
+			if err != nil {
+				span.RecordError(err)
+				continue
+			}
+
+			ispublic, err := s.isMessagePublic(ctx, message)
+			if err != nil {
+				span.RecordError(err)
+				continue
+			}
+
+			if !ispublic {
+				continue
+			}
+
+			messages = append(messages, message)
+		}
+
+		if examined == len(indices) && len(indices) < limit {
+			exhausted = true
+			break
+		}
+	}
+
+	if exhausted {
+		return messages, nil, nil
+	}
+
+	return messages, &cursor, nil
+}
@@ -0,0 +1,97 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+	"github.com/totegamma/concurrent/x/message/mock"
+)
+
+func TestSearchFillsPage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	base := time.Now().Truncate(time.Second)
+	indices := []core.MessageIndex{
+		{MessageID: "m00000000000000000000000004", CDate: base},
+		{MessageID: "m00000000000000000000000003", CDate: base},
+		{MessageID: "m00000000000000000000000002", CDate: base},
+		{MessageID: "m00000000000000000000000001", CDate: base.Add(-time.Second)},
+	}
+	private := map[string]bool{
+		"m00000000000000000000000004": true,
+	}
+
+	mockSearch := mock_core.NewMockSearchService(ctrl)
+	mockSearch.EXPECT().SearchMessages(gomock.Any(), "hello", "", "", gomock.Any(), 2).DoAndReturn(
+		func(_ context.Context, _, _, _ string, cursor core.SearchCursor, limit int) ([]core.MessageIndex, error) {
+			result := []core.MessageIndex{}
+			for _, index := range indices {
+				if cursor.MessageID != "" && !(index.CDate.Before(cursor.CDate) || (index.CDate.Equal(cursor.CDate) && index.MessageID < cursor.MessageID)) {
+					continue
+				}
+				if len(result) < limit {
+					result = append(result, index)
+				}
+			}
+			return result, nil
+		},
+	).AnyTimes()
+
+	mockRepo := mock_message.NewMockRepository(ctrl)
+	mockRepo.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id string) (core.Message, error) {
+		policy := ""
+		if private[id] {
+			policy = "private"
+		}
+		return core.Message{ID: id, Policy: policy}, nil
+	}).AnyTimes()
+
+	mockPolicy := mock_core.NewMockPolicyService(ctrl)
+	mockPolicy.EXPECT().AccumulateOr(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.PolicyEvalResultDefault).AnyTimes()
+	mockPolicy.EXPECT().TestWithPolicyURL(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, url string, _ core.RequestContext, _ string) (core.PolicyEvalResult, error) {
+			if url == "private" {
+				return core.PolicyEvalResultNever, nil
+			}
+			return core.PolicyEvalResultDefault, nil
+		},
+	).AnyTimes()
+	mockPolicy.EXPECT().Summerize(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(results []core.PolicyEvalResult, _ string, _ *map[string]bool) bool {
+			for _, result := range results {
+				if result == core.PolicyEvalResultNever {
+					return false
+				}
+			}
+			return true
+		},
+	).AnyTimes()
+
+	service := NewService(mockRepo, nil, nil, nil, nil, nil, mockSearch, mockPolicy, core.Config{})
+
+	// 非公開のメッセージを除いてもページが埋まる
+	messages, next, err := service.Search(context.Background(), "hello", "", "", core.SearchCursor{CDate: time.Now()}, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "m00000000000000000000000003", messages[0].ID)
+		assert.Equal(t, "m00000000000000000000000002", messages[1].ID)
+	}
+	if assert.NotNil(t, next) {
+		assert.Equal(t, "m00000000000000000000000002", next.MessageID)
+	}
+
+	// 同じ時刻の後続も読み飛ばさない
+	messages, next, err = service.Search(context.Background(), "hello", "", "", *next, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "m00000000000000000000000001", messages[0].ID)
+	}
+	assert.Nil(t, next)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/totegamma/concurrent/core"
@@ -59,6 +60,70 @@ func AccumulateOr(results []core.PolicyEvalResult) core.PolicyEvalResult {
 	return core.PolicyEvalResultDefault
 }
 
+var localPolicyNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// ParsePolicyDocument picks the supported version from the policy document.
+// Documents without versions are treated as a policy itself.
+func ParsePolicyDocument(jsonStr []byte) (core.Policy, error) {
+	var policyDoc core.PolicyDocument
+	err := json.Unmarshal(jsonStr, &policyDoc)
+	if err != nil {
+		return core.Policy{}, err
+	}
+
+	policy20240701, ok := policyDoc.Versions["2024-07-01"]
+	if ok {
+		return policy20240701, nil
+	}
+
+	var policy core.Policy
+	err = json.Unmarshal(jsonStr, &policy)
+	if err != nil {
+		return core.Policy{}, err
+	}
+
+	return policy, nil
+}
+
+// ParseLocalPolicyURL splits "local:<name>[@<version>]". version 0 means the latest
+func ParseLocalPolicyURL(url string) (string, int, error) {
+	name, ok := strings.CutPrefix(url, localPolicyPrefix)
+	if !ok {
+		return "", 0, fmt.Errorf("not a local policy: %s", url)
+	}
+
+	version := 0
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		var err error
+		version, err = strconv.Atoi(name[i+1:])
+		if err != nil || version <= 0 {
+			return "", 0, fmt.Errorf("invalid policy version: %s", url)
+		}
+		name = name[:i]
+	}
+
+	if !localPolicyNamePattern.MatchString(name) {
+		return "", 0, fmt.Errorf("invalid policy name: %s", name)
+	}
+
+	return name, version, nil
+}
+
+// QualifyLocalPolicyURL rewrites a "local:" url of a resource hosted on the domain to the url of its document on that domain.
+// "local:" is relative to the domain that hosts the resource, so it must not be resolved against the registry of another domain.
+func QualifyLocalPolicyURL(url, domain string) string {
+	name, version, err := ParseLocalPolicyURL(url)
+	if err != nil {
+		return url
+	}
+
+	qualified := "https://" + domain + "/api/v1/policies/" + name + "/document"
+	if version > 0 {
+		qualified += "?version=" + strconv.Itoa(version)
+	}
+	return qualified
+}
+
 func debugPrint(comment string, v interface{}) {
 	b, _ := json.MarshalIndent(v, "", "  ")
 	fmt.Println(comment, string(b))
@@ -0,0 +1,207 @@
+// Package policy evaluates access policies and hosts the local policy registry
+package policy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+var tracer = otel.Tracer("policy")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+	List(c echo.Context) error
+	Get(c echo.Context) error
+	GetDocument(c echo.Context) error
+	Versions(c echo.Context) error
+	Upload(c echo.Context) error
+	Explain(c echo.Context) error
+}
+
+type handler struct {
+	service core.PolicyService
+	entity  core.EntityService
+}
+
+// NewHandler creates a new handler
+func NewHandler(service core.PolicyService, entity core.EntityService) Handler {
+	return &handler{service: service, entity: entity}
+}
+
+// List returns the latest version of every local policy
+func (h handler) List(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Policy.Handler.List")
+	defer span.End()
+
+	policies, err := h.service.ListLocal(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": policies})
+}
+
+// Get returns a local policy. the latest version is returned unless "version" is given
+func (h handler) Get(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Policy.Handler.Get")
+	defer span.End()
+
+	name := c.Param("name")
+
+	version := 0
+	versionStr := c.QueryParam("version")
+	if versionStr != "" {
+		var err error
+		version, err = strconv.Atoi(versionStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid version"})
+		}
+	}
+
+	policy, err := h.service.GetLocal(ctx, name, version)
+	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Policy not found"})
+		}
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": policy})
+}
+
+// GetDocument returns the raw document of a local policy so that other domains can fetch "local:" policies of this domain
+func (h handler) GetDocument(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Policy.Handler.GetDocument")
+	defer span.End()
+
+	name := c.Param("name")
+
+	version := 0
+	versionStr := c.QueryParam("version")
+	if versionStr != "" {
+		var err error
+		version, err = strconv.Atoi(versionStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid version"})
+		}
+	}
+
+	policy, err := h.service.GetLocal(ctx, name, version)
+	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Policy not found"})
+		}
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSONBlob(http.StatusOK, []byte(policy.Document))
+}
+
+// Versions returns every version of a local policy
+func (h handler) Versions(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Policy.Handler.Versions")
+	defer span.End()
+
+	policies, err := h.service.ListLocalVersions(ctx, c.Param("name"))
+	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Policy not found"})
+		}
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": policies})
+}
+
+// Upload registers a new version of a local policy
+func (h handler) Upload(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Policy.Handler.Upload")
+	defer span.End()
+
+	requester, ok := ctx.Value(core.RequesterIdCtxKey).(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+
+	var request UploadRequest
+	err := c.Bind(&request)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	policy, err := h.service.UploadLocal(ctx, requester, request.Name, string(request.Document))
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{"status": "ok", "content": policy})
+}
+
+// Explain evaluates a policy against a sample request and returns the evaluation trace
+func (h handler) Explain(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Policy.Handler.Explain")
+	defer span.End()
+
+	var request ExplainRequest
+	err := c.Bind(&request)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	if request.Action == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "action is required"})
+	}
+
+	requestCtx := core.RequestContext{
+		Params:   request.Params,
+		Document: request.Document,
+		Self:     request.Self,
+		Resource: request.Resource,
+	}
+
+	if request.Requester != nil {
+		requestCtx.Requester = *request.Requester
+	} else {
+		// 実際のリクエストと同じく、要求者はエンティティサービスから読み込む
+		requester, _ := ctx.Value(core.RequesterIdCtxKey).(string)
+		if requester != "" {
+			entity, err := h.entity.Get(ctx, requester)
+			if err != nil {
+				span.RecordError(err)
+				if errors.Is(err, core.ErrorNotFound{}) {
+					return c.JSON(http.StatusNotFound, echo.Map{"error": "Requester not found"})
+				}
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to load requester"})
+			}
+			requestCtx.Requester = entity
+		}
+	}
+
+	if request.RequesterDomain != nil {
+		requestCtx.RequesterDomain = *request.RequesterDomain
+	}
+
+	explanation, err := h.service.Explain(ctx, request.Policy, request.URL, requestCtx, request.Action, request.Defaults)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Policy not found"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Failed to load policy"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": explanation})
+}
@@ -0,0 +1,29 @@
+package policy
+
+import (
+	"encoding/json"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// UploadRequest is the request body to register a local policy
+type UploadRequest struct {
+	Name     string          `json:"name"`
+	Document json.RawMessage `json:"document"`
+}
+
+// ExplainRequest is the request body to dry-run a policy.
+// Either Policy or URL is used. URL must be a "local:" url or a policy already fetched by this domain.
+// Requester defaults to the caller.
+type ExplainRequest struct {
+	Policy          core.Policy      `json:"policy"`
+	URL             string           `json:"url"`
+	Action          string           `json:"action"`
+	Params          map[string]any   `json:"params"`
+	Defaults        *map[string]bool `json:"defaults"`
+	Document        any              `json:"document"`
+	Self            any              `json:"self"`
+	Resource        any              `json:"resource"`
+	Requester       *core.Entity     `json:"requester"`
+	RequesterDomain *core.Domain     `json:"requesterDomain"`
+}
@@ -0,0 +1,353 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+var errKeyNotFound = errors.New("key not found")
+
+const maxRegexpLength = 256
+
+// evalArgs evaluates the arguments of the operator.
+// on error, the returned EvalResult is the failed result of the operator itself.
+func (s service) evalArgs(expr core.Expr, requestCtx core.RequestContext, length int) ([]core.EvalResult, core.EvalResult, error) {
+	if len(expr.Args) != length {
+		err := fmt.Errorf("bad argument length for %s. Expected %d but got %d\n", expr.Operator, length, len(expr.Args))
+		return nil, core.EvalResult{
+			Operator: expr.Operator,
+			Error:    err.Error(),
+		}, err
+	}
+
+	args := make([]core.EvalResult, 0, length)
+	for _, arg := range expr.Args {
+		eval, err := s.eval(arg, requestCtx)
+		args = append(args, eval)
+		if err != nil {
+			return args, core.EvalResult{
+				Operator: expr.Operator,
+				Args:     args,
+				Error:    err.Error(),
+			}, err
+		}
+	}
+
+	return args, core.EvalResult{}, nil
+}
+
+func badArgumentType(expr core.Expr, args []core.EvalResult, expected string, actual any) (core.EvalResult, error) {
+	err := fmt.Errorf("bad argument type for %s. Expected %s but got %s\n", expr.Operator, expected, reflect.TypeOf(actual))
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Error:    err.Error(),
+	}, err
+}
+
+// toNumber converts json numbers and go integers to float64
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// toTime converts time.Time and RFC3339 strings to time.Time
+func toTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return parsed, true
+	}
+	return time.Time{}, false
+}
+
+// equals compares values treating every number type as the same
+func equals(a, b any) bool {
+	an, aok := toNumber(a)
+	bn, bok := toNumber(b)
+	if aok && bok {
+		return an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// evalCompare handles Lt, Gt, Le and Ge over numbers or times
+func (s service) evalCompare(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	args, failed, err := s.evalArgs(expr, requestCtx, 2)
+	if err != nil {
+		return failed, err
+	}
+
+	var cmp int
+	if lhs, ok := toNumber(args[0].Result); ok {
+		rhs, ok := toNumber(args[1].Result)
+		if !ok {
+			return badArgumentType(expr, args, "number", args[1].Result)
+		}
+		switch {
+		case lhs < rhs:
+			cmp = -1
+		case lhs > rhs:
+			cmp = 1
+		}
+	} else if lhs, ok := toTime(args[0].Result); ok {
+		rhs, ok := toTime(args[1].Result)
+		if !ok {
+			return badArgumentType(expr, args, "time", args[1].Result)
+		}
+		cmp = lhs.Compare(rhs)
+	} else {
+		return badArgumentType(expr, args, "number or time", args[0].Result)
+	}
+
+	var result bool
+	switch expr.Operator {
+	case "Lt":
+		result = cmp < 0
+	case "Gt":
+		result = cmp > 0
+	case "Le":
+		result = cmp <= 0
+	case "Ge":
+		result = cmp >= 0
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Result:   result,
+	}, nil
+}
+
+// evalArithmetic handles Add, Sub, Mul, Div and Mod over numbers
+func (s service) evalArithmetic(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	args, failed, err := s.evalArgs(expr, requestCtx, 2)
+	if err != nil {
+		return failed, err
+	}
+
+	lhs, ok := toNumber(args[0].Result)
+	if !ok {
+		return badArgumentType(expr, args, "number", args[0].Result)
+	}
+
+	rhs, ok := toNumber(args[1].Result)
+	if !ok {
+		return badArgumentType(expr, args, "number", args[1].Result)
+	}
+
+	var result float64
+	switch expr.Operator {
+	case "Add":
+		result = lhs + rhs
+	case "Sub":
+		result = lhs - rhs
+	case "Mul":
+		result = lhs * rhs
+	case "Div", "Mod":
+		if rhs == 0 {
+			err := fmt.Errorf("division by zero in %s\n", expr.Operator)
+			return core.EvalResult{
+				Operator: expr.Operator,
+				Args:     args,
+				Error:    err.Error(),
+			}, err
+		}
+		if expr.Operator == "Div" {
+			result = lhs / rhs
+		} else {
+			result = math.Mod(lhs, rhs)
+		}
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Result:   result,
+	}, nil
+}
+
+// evalLen returns the length of a string (in characters), an array or an object
+func (s service) evalLen(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	args, failed, err := s.evalArgs(expr, requestCtx, 1)
+	if err != nil {
+		return failed, err
+	}
+
+	var length int
+	switch v := args[0].Result.(type) {
+	case string:
+		length = utf8.RuneCountInString(v)
+	case []any:
+		length = len(v)
+	case []string:
+		length = len(v)
+	case map[string]any:
+		length = len(v)
+	default:
+		return badArgumentType(expr, args, "string, array or object", args[0].Result)
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Result:   float64(length),
+	}, nil
+}
+
+// evalTimeDiff returns arg0 - arg1 in seconds
+func (s service) evalTimeDiff(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	args, failed, err := s.evalArgs(expr, requestCtx, 2)
+	if err != nil {
+		return failed, err
+	}
+
+	lhs, ok := toTime(args[0].Result)
+	if !ok {
+		return badArgumentType(expr, args, "time", args[0].Result)
+	}
+
+	rhs, ok := toTime(args[1].Result)
+	if !ok {
+		return badArgumentType(expr, args, "time", args[1].Result)
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Result:   lhs.Sub(rhs).Seconds(),
+	}, nil
+}
+
+// evalIn reports whether arg0 is an element of the array arg1
+func (s service) evalIn(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	args, failed, err := s.evalArgs(expr, requestCtx, 2)
+	if err != nil {
+		return failed, err
+	}
+
+	var result bool
+	switch list := args[1].Result.(type) {
+	case []any:
+		result = slices.ContainsFunc(list, func(item any) bool {
+			return equals(item, args[0].Result)
+		})
+	case []string:
+		value, ok := args[0].Result.(string)
+		result = ok && slices.Contains(list, value)
+	default:
+		return badArgumentType(expr, args, "array", args[1].Result)
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Result:   result,
+	}, nil
+}
+
+// evalRegexp reports whether the string arg0 matches the pattern arg1
+func (s service) evalRegexp(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	args, failed, err := s.evalArgs(expr, requestCtx, 2)
+	if err != nil {
+		return failed, err
+	}
+
+	target, ok := args[0].Result.(string)
+	if !ok {
+		return badArgumentType(expr, args, "string", args[0].Result)
+	}
+
+	pattern, ok := args[1].Result.(string)
+	if !ok {
+		return badArgumentType(expr, args, "string", args[1].Result)
+	}
+
+	if len(pattern) > maxRegexpLength {
+		err := fmt.Errorf("pattern for %s is too long. Max %d but got %d\n", expr.Operator, maxRegexpLength, len(pattern))
+		return core.EvalResult{
+			Operator: expr.Operator,
+			Args:     args,
+			Error:    err.Error(),
+		}, err
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return core.EvalResult{
+			Operator: expr.Operator,
+			Args:     args,
+			Error:    err.Error(),
+		}, err
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     args,
+		Result:   re.MatchString(target),
+	}, nil
+}
+
+// evalExists reports whether the loader in arg0 finds its key
+func (s service) evalExists(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
+	if len(expr.Args) != 1 {
+		err := fmt.Errorf("bad argument length for %s. Expected 1 but got %d\n", expr.Operator, len(expr.Args))
+		return core.EvalResult{
+			Operator: expr.Operator,
+			Error:    err.Error(),
+		}, err
+	}
+
+	arg0, err := s.eval(expr.Args[0], requestCtx)
+	if err != nil {
+		if errors.Is(err, errKeyNotFound) {
+			return core.EvalResult{
+				Operator: expr.Operator,
+				Args:     []core.EvalResult{arg0},
+				Result:   false,
+			}, nil
+		}
+		return core.EvalResult{
+			Operator: expr.Operator,
+			Args:     []core.EvalResult{arg0},
+			Error:    err.Error(),
+		}, err
+	}
+
+	return core.EvalResult{
+		Operator: expr.Operator,
+		Args:     []core.EvalResult{arg0},
+		Result:   arg0.Result != nil,
+	}, nil
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
 
 	"github.com/totegamma/concurrent/core"
 )
@@ -18,18 +22,34 @@ var (
 	client = new(http.Client)
 )
 
+const (
+	localPolicyPrefix = "local:"
+	policyCacheTTL    = 10 * time.Minute
+	policyStaleTTL    = 7 * 24 * time.Hour
+	revalidateTimeout = 10 * time.Second
+)
+
 type Repository interface {
 	Get(ctx context.Context, url string) (core.Policy, error)
+	GetRegistered(ctx context.Context, url string) (core.Policy, error)
+	CreateLocal(ctx context.Context, name, document, author string) (core.LocalPolicy, error)
+	GetLocal(ctx context.Context, name string, version int) (core.LocalPolicy, error)
+	ListLocal(ctx context.Context) ([]core.LocalPolicy, error)
+	ListLocalVersions(ctx context.Context, name string) ([]core.LocalPolicy, error)
 }
 
 type repository struct {
+	db  *gorm.DB
 	rdb *redis.Client
 }
 
-func NewRepository(rdb *redis.Client) Repository {
-	return &repository{rdb}
+func NewRepository(db *gorm.DB, rdb *redis.Client) Repository {
+	return &repository{db, rdb}
 }
 
+// Get resolves the policy url.
+// "local:<name>" or "local:<name>@<version>" is resolved from the local registry.
+// Remote policies are cached for 10 minutes, and the stale copy is served while revalidating or when the fetch fails.
 func (r *repository) Get(ctx context.Context, url string) (core.Policy, error) {
 	ctx, span := tracer.Start(ctx, "Policy.Repository.Get")
 	defer span.End()
@@ -47,7 +67,86 @@ func (r *repository) Get(ctx context.Context, url string) (core.Policy, error) {
 		return policy, nil
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	if strings.HasPrefix(url, localPolicyPrefix) {
+		return r.getLocalPolicy(ctx, url)
+	}
+
+	staleKey := fmt.Sprintf("policy:stale:%s", url)
+	stale, err := r.rdb.Get(ctx, staleKey).Result()
+	if err == nil {
+		var policy core.Policy
+		err = json.Unmarshal([]byte(stale), &policy)
+		if err == nil {
+			span.AddEvent("serve stale policy")
+			r.revalidate(url)
+			return policy, nil
+		}
+	}
+
+	return r.fetch(ctx, url)
+}
+
+// GetRegistered resolves the policy url without fetching anything new.
+// "local:" urls are resolved from the local registry, and remote urls only from the policies this domain has already fetched.
+func (r *repository) GetRegistered(ctx context.Context, url string) (core.Policy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.GetRegistered")
+	defer span.End()
+
+	if strings.HasPrefix(url, localPolicyPrefix) {
+		return r.Get(ctx, url)
+	}
+
+	for _, key := range []string{fmt.Sprintf("policy:%s", url), fmt.Sprintf("policy:stale:%s", url)} {
+		val, err := r.rdb.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+
+		var policy core.Policy
+		err = json.Unmarshal([]byte(val), &policy)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return core.Policy{}, err
+		}
+		return policy, nil
+	}
+
+	return core.Policy{}, core.NewErrorNotFound()
+}
+
+// revalidate refreshes the cached remote policy in background.
+// only one replica revalidates the same url at a time.
+func (r *repository) revalidate(url string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+		defer cancel()
+
+		ctx, span := tracer.Start(ctx, "Policy.Repository.Revalidate")
+		defer span.End()
+
+		lockKey := fmt.Sprintf("policy:revalidate:%s", url)
+		locked, err := r.rdb.SetNX(ctx, lockKey, "1", revalidateTimeout).Result()
+		if err != nil || !locked {
+			return
+		}
+
+		_, err = r.fetch(ctx, url)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			slog.WarnContext(ctx, "failed to revalidate policy",
+				slog.String("url", url),
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
+}
+
+// fetch gets the remote policy and stores it to the cache
+func (r *repository) fetch(ctx context.Context, url string) (core.Policy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.Fetch")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
 		return core.Policy{}, err
@@ -61,8 +160,9 @@ func (r *repository) Get(ctx context.Context, url string) (core.Policy, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		span.SetStatus(codes.Error, err.Error())
-		return core.Policy{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return core.Policy{}, err
 	}
 
 	jsonStr, err := io.ReadAll(resp.Body)
@@ -71,35 +171,64 @@ func (r *repository) Get(ctx context.Context, url string) (core.Policy, error) {
 		return core.Policy{}, err
 	}
 
+	policy, err := ParsePolicyDocument(jsonStr)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.Policy{}, err
+	}
+
 	// cache policy
-	var policyDoc core.PolicyDocument
-	err = json.Unmarshal(jsonStr, &policyDoc)
+	jsonStr, err = json.Marshal(policy)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
 		return core.Policy{}, err
 	}
 
-	var policy core.Policy
-	policy20240701, ok := policyDoc.Versions["2024-07-01"]
-	if ok {
-		span.AddEvent("use version 2024-07-01")
-		policy = policy20240701
-	} else {
-		span.AddEvent("fallback to latest version")
-		err = json.Unmarshal(jsonStr, &policy)
-		if err != nil {
-			span.SetStatus(codes.Error, err.Error())
-			return core.Policy{}, err
-		}
+	err = r.rdb.Set(ctx, fmt.Sprintf("policy:%s", url), jsonStr, policyCacheTTL).Err()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.Policy{}, err
 	}
 
-	jsonStr, err = json.Marshal(policy)
+	err = r.rdb.Set(ctx, fmt.Sprintf("policy:stale:%s", url), jsonStr, policyStaleTTL).Err()
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
 		return core.Policy{}, err
 	}
 
-	err = r.rdb.Set(ctx, key, jsonStr, 10*time.Minute).Err() // 10 minutes
+	return policy, nil
+}
+
+// getLocalPolicy resolves "local:<name>[@<version>]" from the database
+func (r *repository) getLocalPolicy(ctx context.Context, url string) (core.Policy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.GetLocalPolicy")
+	defer span.End()
+
+	name, version, err := ParseLocalPolicyURL(url)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.Policy{}, err
+	}
+
+	local, err := r.GetLocal(ctx, name, version)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.Policy{}, err
+	}
+
+	policy, err := ParsePolicyDocument([]byte(local.Document))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.Policy{}, err
+	}
+
+	jsonStr, err := json.Marshal(policy)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.Policy{}, err
+	}
+
+	err = r.rdb.Set(ctx, fmt.Sprintf("policy:%s", url), jsonStr, policyCacheTTL).Err()
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
 		return core.Policy{}, err
@@ -107,3 +236,102 @@ func (r *repository) Get(ctx context.Context, url string) (core.Policy, error) {
 
 	return policy, nil
 }
+
+// CreateLocal stores the document as the next version of the local policy
+func (r *repository) CreateLocal(ctx context.Context, name, document, author string) (core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.CreateLocal")
+	defer span.End()
+
+	var local core.LocalPolicy
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", localPolicyPrefix+name).Error
+		if err != nil {
+			return err
+		}
+
+		var latest int
+		err = tx.Model(&core.LocalPolicy{}).
+			Where("name = ?", name).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error
+		if err != nil {
+			return err
+		}
+
+		local = core.LocalPolicy{
+			Name:     name,
+			Version:  latest + 1,
+			Document: document,
+			Author:   author,
+		}
+
+		return tx.Create(&local).Error
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	// unpinned url now points to the new version
+	err = r.rdb.Del(ctx, fmt.Sprintf("policy:%s%s", localPolicyPrefix, name)).Err()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return local, nil
+}
+
+// GetLocal returns the version of the local policy. version 0 means the latest
+func (r *repository) GetLocal(ctx context.Context, name string, version int) (core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.GetLocal")
+	defer span.End()
+
+	query := r.db.WithContext(ctx).Where("name = ?", name)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+
+	var local core.LocalPolicy
+	err := query.Order("version DESC").First(&local).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return core.LocalPolicy{}, core.NewErrorNotFound()
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	return local, nil
+}
+
+// ListLocal returns the latest version of every local policy
+func (r *repository) ListLocal(ctx context.Context) ([]core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.ListLocal")
+	defer span.End()
+
+	var locals []core.LocalPolicy
+	err := r.db.WithContext(ctx).
+		Raw("SELECT DISTINCT ON (name) * FROM local_policies ORDER BY name, version DESC").
+		Scan(&locals).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return locals, nil
+}
+
+// ListLocalVersions returns every version of the local policy, newest first
+func (r *repository) ListLocalVersions(ctx context.Context, name string) ([]core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Repository.ListLocalVersions")
+	defer span.End()
+
+	var locals []core.LocalPolicy
+	err := r.db.WithContext(ctx).Where("name = ?", name).Order("version DESC").Find(&locals).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return locals, nil
+}
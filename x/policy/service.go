@@ -3,11 +3,12 @@ package policy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"slices"
+	"time"
 
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 
@@ -15,7 +16,9 @@ import (
 	"github.com/totegamma/concurrent/internal/testutil"
 )
 
-var tracer = otel.Tracer("policy")
+// errRequesterUnknown is returned by requester operators that have no meaningful value for guests.
+// a statement that depends on it is evaluated as false instead of falling back to the defaults.
+var errRequesterUnknown = errors.New("requester is unknown")
 
 type service struct {
 	repository Repository
@@ -138,6 +141,103 @@ func (s service) TestWithGlobalPolicy(ctx context.Context, context core.RequestC
 	return s.test(ctx, s.global, context, action)
 }
 
+// UploadLocal registers the policy document as the next version of "local:<name>"
+func (s service) UploadLocal(ctx context.Context, requester, name, document string) (core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Service.UploadLocal")
+	defer span.End()
+
+	if !localPolicyNamePattern.MatchString(name) {
+		err := fmt.Errorf("invalid policy name: %s", name)
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	policy, err := ParsePolicyDocument([]byte(document))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	if len(policy.Statements) == 0 {
+		err := fmt.Errorf("policy has no statements")
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	err = s.Validate(policy)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	local, err := s.repository.CreateLocal(ctx, name, document, requester)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return core.LocalPolicy{}, err
+	}
+
+	return local, nil
+}
+
+// GetLocal returns the version of the local policy. version 0 means the latest
+func (s service) GetLocal(ctx context.Context, name string, version int) (core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Service.GetLocal")
+	defer span.End()
+
+	return s.repository.GetLocal(ctx, name, version)
+}
+
+// ListLocal returns the latest version of every local policy
+func (s service) ListLocal(ctx context.Context) ([]core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Service.ListLocal")
+	defer span.End()
+
+	return s.repository.ListLocal(ctx)
+}
+
+// ListLocalVersions returns every version of the local policy
+func (s service) ListLocalVersions(ctx context.Context, name string) ([]core.LocalPolicy, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Service.ListLocalVersions")
+	defer span.End()
+
+	locals, err := s.repository.ListLocalVersions(ctx, name)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if len(locals) == 0 {
+		return nil, core.NewErrorNotFound()
+	}
+
+	return locals, nil
+}
+
+// Validate statically checks operators, arity, constant types and action names of the policy
+func (s service) Validate(policy core.Policy) error {
+	return validatePolicy(policy)
+}
+
+// ValidateWithPolicyURL resolves the policy url and validates it
+func (s service) ValidateWithPolicyURL(ctx context.Context, url string) error {
+	ctx, span := tracer.Start(ctx, "Policy.Service.ValidateWithPolicyURL")
+	defer span.End()
+
+	policy, err := s.repository.Get(ctx, url)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to load policy %s: %w", url, err)
+	}
+
+	err = s.Validate(policy)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("%s: %w", url, err)
+	}
+
+	return nil
+}
+
 func (s service) TestWithPolicyURL(ctx context.Context, url string, context core.RequestContext, action string) (core.PolicyEvalResult, error) {
 	ctx, span := tracer.Start(ctx, "Policy.Service.TestWithPolicyURL")
 	defer span.End()
@@ -187,6 +287,12 @@ func (s service) Test(ctx context.Context, policy core.Policy, context core.Requ
 }
 
 func (s service) test(ctx context.Context, policy core.Policy, context core.RequestContext, action string) (core.PolicyEvalResult, error) {
+	result, _, err := s.trace(ctx, policy, context, action)
+	return result, err
+}
+
+// trace evaluates the statement for the action and returns the evaluation tree as well
+func (s service) trace(ctx context.Context, policy core.Policy, context core.RequestContext, action string) (core.PolicyEvalResult, *core.EvalResult, error) {
 	ctx, span := tracer.Start(ctx, "Policy.Service.test")
 	defer span.End()
 
@@ -195,37 +301,106 @@ func (s service) test(ctx context.Context, policy core.Policy, context core.Requ
 	statement, ok := policy.Statements[action]
 	if !ok {
 		span.SetAttributes(attribute.String("debug", "no rule"))
-		return core.PolicyEvalResultDefault, nil
+		return core.PolicyEvalResultDefault, nil, nil
 	}
 
 	result, err := s.eval(statement.Condition, context)
 	resultJson, _ := json.MarshalIndent(result, "", "  ")
 	span.SetAttributes(attribute.String("result", string(resultJson)))
-	if err != nil {
-		span.SetStatus(codes.Error, err.Error())
-		return core.PolicyEvalResultDefault, err
-	}
 
-	result_bool, ok := result.Result.(bool)
-	if !ok {
-		err := fmt.Errorf("bad argument type for Policy. Expected bool but got %s\n", reflect.TypeOf(result).String())
+	var result_bool bool
+	if errors.Is(err, errRequesterUnknown) {
+		// 判定できない条件は満たされなかったものとして扱う
+		span.AddEvent("requester unknown")
+		result_bool = false
+	} else if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return core.PolicyEvalResultDefault, err
+		return core.PolicyEvalResultDefault, &result, err
+	} else {
+		var ok bool
+		result_bool, ok = result.Result.(bool)
+		if !ok {
+			err := fmt.Errorf("bad argument type for Policy. Expected bool but got %s\n", reflect.TypeOf(result).String())
+			span.SetStatus(codes.Error, err.Error())
+			return core.PolicyEvalResultDefault, &result, err
+		}
 	}
 
 	if statement.DefaultOnTrue && result_bool {
-		return core.PolicyEvalResultDefault, nil
+		return core.PolicyEvalResultDefault, &result, nil
 	} else if statement.DefaultOnFalse && !result_bool {
-		return core.PolicyEvalResultDefault, nil
+		return core.PolicyEvalResultDefault, &result, nil
 	} else if statement.Dominant && result_bool {
-		return core.PolicyEvalResultAlways, nil
+		return core.PolicyEvalResultAlways, &result, nil
 	} else if statement.Dominant && !result_bool {
-		return core.PolicyEvalResultNever, nil
+		return core.PolicyEvalResultNever, &result, nil
 	} else if result_bool {
-		return core.PolicyEvalResultAllow, nil
+		return core.PolicyEvalResultAllow, &result, nil
 	} else {
-		return core.PolicyEvalResultDeny, nil
+		return core.PolicyEvalResultDeny, &result, nil
+	}
+}
+
+func newPolicyTrace(policy core.Policy, action string, result core.PolicyEvalResult, eval *core.EvalResult, err error) core.PolicyTrace {
+	_, found := policy.Statements[action]
+	trace := core.PolicyTrace{
+		Statement: action,
+		Found:     found,
+		Result:    result.String(),
+		Eval:      eval,
+	}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	return trace
+}
+
+// Explain evaluates the policy in the same way as Test, and reports every step of the decision.
+// Evaluation errors are reported in the explanation instead of being returned.
+func (s service) Explain(ctx context.Context, policy core.Policy, url string, context core.RequestContext, action string, override *map[string]bool) (core.PolicyExplanation, error) {
+	ctx, span := tracer.Start(ctx, "Policy.Service.Explain")
+	defer span.End()
+
+	if url != "" {
+		var err error
+		// 任意のURLを取得させないため、登録済みのポリシーのみ説明する
+		policy, err = s.repository.GetRegistered(ctx, url)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return core.PolicyExplanation{}, err
+		}
+	}
+
+	explanation := core.PolicyExplanation{
+		Action: action,
 	}
+
+	result, eval, err := s.trace(ctx, s.global, context, action)
+	explanation.Global = newPolicyTrace(s.global, action, result, eval, err)
+
+	dominant, _ := IsDominant(result)
+	if err == nil && !dominant && len(policy.Statements) > 0 {
+		localResult, localEval, localErr := s.trace(ctx, policy, context, action)
+		local := newPolicyTrace(policy, action, localResult, localEval, localErr)
+		explanation.Local = &local
+
+		if localErr != nil {
+			err = localErr
+		} else if localResult != core.PolicyEvalResultDefault {
+			result = localResult
+		}
+	}
+
+	if err != nil {
+		result = core.PolicyEvalResultDefault
+	}
+
+	results := []core.PolicyEvalResult{result}
+	explanation.Result = result.String()
+	explanation.Accumulated = s.AccumulateOr(results, action, override).String()
+	explanation.Allowed = s.Summerize(results, action, override)
+
+	return explanation, nil
 }
 
 func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.EvalResult, error) {
@@ -385,6 +560,33 @@ func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.Eval
 			Result:   expr.Constant,
 		}, nil
 
+	case "Lt", "Gt", "Le", "Ge":
+		return s.evalCompare(expr, requestCtx)
+
+	case "Add", "Sub", "Mul", "Div", "Mod":
+		return s.evalArithmetic(expr, requestCtx)
+
+	case "Len":
+		return s.evalLen(expr, requestCtx)
+
+	case "Now":
+		return core.EvalResult{
+			Operator: "Now",
+			Result:   time.Now(),
+		}, nil
+
+	case "TimeDiff":
+		return s.evalTimeDiff(expr, requestCtx)
+
+	case "In":
+		return s.evalIn(expr, requestCtx)
+
+	case "Regexp":
+		return s.evalRegexp(expr, requestCtx)
+
+	case "Exists":
+		return s.evalExists(expr, requestCtx)
+
 	case "Contains":
 		if len(expr.Args) != 2 {
 			err := fmt.Errorf("bad argument length for CONTAINS. Expected 2 but got %d\n", len(expr.Args))
@@ -450,7 +652,7 @@ func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.Eval
 
 		value, ok := resolveDotNotation(requestCtx.Params, key)
 		if !ok {
-			err := fmt.Errorf("key not found: %s\n", key)
+			err := fmt.Errorf("%w: %s\n", errKeyNotFound, key)
 			testutil.PrintJson(requestCtx)
 			return core.EvalResult{
 				Operator: "LoadParam",
@@ -476,7 +678,7 @@ func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.Eval
 		mappedDocument := structToMap(requestCtx.Document)
 		value, ok := resolveDotNotation(mappedDocument, key)
 		if !ok {
-			err := fmt.Errorf("key not found: %s\n", key)
+			err := fmt.Errorf("%w: %s\n", errKeyNotFound, key)
 			return core.EvalResult{
 				Operator: "LoadDocument",
 				Error:    err.Error(),
@@ -501,7 +703,7 @@ func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.Eval
 		mappedSelf := structToMap(requestCtx.Self)
 		value, ok := resolveDotNotation(mappedSelf, key)
 		if !ok {
-			err := fmt.Errorf("key not found: %s\n", key)
+			err := fmt.Errorf("%w: %s\n", errKeyNotFound, key)
 			return core.EvalResult{
 				Operator: "LoadSelf",
 				Error:    err.Error(),
@@ -526,7 +728,7 @@ func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.Eval
 		mappedResource := structToMap(requestCtx.Resource)
 		value, ok := resolveDotNotation(mappedResource, key)
 		if !ok {
-			err := fmt.Errorf("key not found: %s\n", key)
+			err := fmt.Errorf("%w: %s\n", errKeyNotFound, key)
 			return core.EvalResult{
 				Operator: "LoadResource",
 				Error:    err.Error(),
@@ -694,6 +896,24 @@ func (s service) eval(expr core.Expr, requestCtx core.RequestContext) (core.Eval
 			Result:   requestCtx.Requester.ID,
 		}, nil
 
+	case "RequesterCDate":
+		if requestCtx.Requester.ID == "" || requestCtx.Requester.CDate.IsZero() {
+			return core.EvalResult{
+				Operator: "RequesterCDate",
+				Error:    errRequesterUnknown.Error(),
+			}, errRequesterUnknown
+		}
+		return core.EvalResult{
+			Operator: "RequesterCDate",
+			Result:   requestCtx.Requester.CDate,
+		}, nil
+
+	case "RequesterScore":
+		return core.EvalResult{
+			Operator: "RequesterScore",
+			Result:   float64(requestCtx.Requester.Score),
+		}, nil
+
 	case "RequesterDomainHasTag":
 		target, ok := expr.Constant.(string)
 		if !ok {
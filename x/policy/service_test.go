@@ -1,8 +1,10 @@
 package policy
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.opentelemetry.io/otel/sdk/trace/tracetest"
@@ -82,7 +84,16 @@ func TestMain(m *testing.M) {
 		panic(err)
 	}
 
-	repository := NewRepository(nil)
+	// 各サービスが登録するアクションの代わり
+	for action := range globalPolicy.Statements {
+		core.RegisterPolicyAction(action)
+	}
+	for action := range globalPolicy.Defaults {
+		core.RegisterPolicyAction(action)
+	}
+	core.RegisterPolicyAction("timeline.distribute", "timeline.create")
+
+	repository := NewRepository(nil, nil)
 
 	s = NewService(
 		repository,
@@ -472,3 +483,269 @@ func TestPolicyMessageRead(t *testing.T) {
 		testutil.PrintSpans(checker.GetSpans(), id)
 	}
 }
+
+func TestParseLocalPolicyURL(t *testing.T) {
+	name, version, err := ParseLocalPolicyURL("local:moderated-timeline")
+	assert.NoError(t, err)
+	assert.Equal(t, "moderated-timeline", name)
+	assert.Equal(t, 0, version)
+
+	name, version, err = ParseLocalPolicyURL("local:moderated-timeline@3")
+	assert.NoError(t, err)
+	assert.Equal(t, "moderated-timeline", name)
+	assert.Equal(t, 3, version)
+
+	_, _, err = ParseLocalPolicyURL("local:moderated-timeline@0")
+	assert.Error(t, err)
+
+	_, _, err = ParseLocalPolicyURL("local:Invalid Name")
+	assert.Error(t, err)
+
+	_, _, err = ParseLocalPolicyURL("https://policy.example.com/policy.json")
+	assert.Error(t, err)
+}
+
+// 説明: ローカルポリシーで拒否された場合の評価ツリー
+func TestExplain(t *testing.T) {
+
+	const policyJson = `
+    {
+        "statements": {
+            "timeline": {
+                "condition": {
+                    "op": "RequesterHasTag",
+                    "const": "timeline_creator"
+                }
+            }
+        }
+    }`
+
+	var policy core.Policy
+	json.Unmarshal([]byte(policyJson), &policy)
+
+	rctx := core.RequestContext{
+		Requester: core.Entity{
+			Domain: "local.example.com",
+		},
+	}
+
+	ctx, _ := testutil.SetupTraceCtx()
+	explanation, err := s.Explain(ctx, policy, "", rctx, "timeline", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "timeline", explanation.Action)
+	assert.False(t, explanation.Global.Found)
+	if assert.NotNil(t, explanation.Local) {
+		assert.True(t, explanation.Local.Found)
+		assert.Equal(t, "deny", explanation.Local.Result)
+		if assert.NotNil(t, explanation.Local.Eval) {
+			assert.Equal(t, "RequesterHasTag", explanation.Local.Eval.Operator)
+			assert.Equal(t, false, explanation.Local.Eval.Result)
+		}
+	}
+	assert.Equal(t, "deny", explanation.Result)
+	assert.Equal(t, "deny", explanation.Accumulated)
+	assert.False(t, explanation.Allowed)
+}
+
+// 演算子のテーブルテスト
+func TestOperators(t *testing.T) {
+
+	svc := s.(*service)
+
+	now := time.Now()
+	rctx := core.RequestContext{
+		Requester: core.Entity{
+			ID:     "con1t0tey8uxhkqkd4wcp4hd4jedt7f0vfhk29xdd2",
+			Domain: "local.example.com",
+			Score:  10,
+			CDate:  now.Add(-10 * 24 * time.Hour),
+		},
+		Document: core.MessageDocument[any]{
+			DocumentBase: core.DocumentBase[any]{
+				Signer:   "con1t0tey8uxhkqkd4wcp4hd4jedt7f0vfhk29xdd2",
+				Body:     map[string]any{"body": "こんにちは world"},
+				SignedAt: now.Add(-1 * time.Minute),
+			},
+		},
+		Params: map[string]any{
+			"allowlist": []any{"con1t0tey8uxhkqkd4wcp4hd4jedt7f0vfhk29xdd2", "con1fk8zlkrfmens3sgj7dzcu3gsw8v9kkysrf8dt5"},
+			"limit":     float64(500),
+			"pattern":   "^こんにちは",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected any
+		hasError bool
+	}{
+		{"Lt number", `{"op":"Lt","args":[{"op":"Const","const":1},{"op":"Const","const":2}]}`, true, false},
+		{"Gt number", `{"op":"Gt","args":[{"op":"Const","const":1},{"op":"Const","const":2}]}`, false, false},
+		{"Le equal", `{"op":"Le","args":[{"op":"Const","const":2},{"op":"Const","const":2}]}`, true, false},
+		{"Ge int and float", `{"op":"Ge","args":[{"op":"RequesterScore"},{"op":"Const","const":10}]}`, true, false},
+		{"Lt time string", `{"op":"Lt","args":[{"op":"Const","const":"2024-01-01T00:00:00Z"},{"op":"Const","const":"2024-01-02T00:00:00Z"}]}`, true, false},
+		{"Lt bad type", `{"op":"Lt","args":[{"op":"Const","const":true},{"op":"Const","const":2}]}`, nil, true},
+		{"Lt mixed type", `{"op":"Lt","args":[{"op":"Const","const":1},{"op":"Const","const":"a"}]}`, nil, true},
+		{"Lt bad length", `{"op":"Lt","args":[{"op":"Const","const":1}]}`, nil, true},
+		{"Add", `{"op":"Add","args":[{"op":"Const","const":1},{"op":"Const","const":2}]}`, float64(3), false},
+		{"Sub", `{"op":"Sub","args":[{"op":"Const","const":1},{"op":"Const","const":2}]}`, float64(-1), false},
+		{"Mul", `{"op":"Mul","args":[{"op":"Const","const":3},{"op":"Const","const":2}]}`, float64(6), false},
+		{"Div", `{"op":"Div","args":[{"op":"Const","const":3},{"op":"Const","const":2}]}`, float64(1.5), false},
+		{"Mod", `{"op":"Mod","args":[{"op":"Const","const":7},{"op":"Const","const":3}]}`, float64(1), false},
+		{"Div by zero", `{"op":"Div","args":[{"op":"Const","const":1},{"op":"Const","const":0}]}`, nil, true},
+		{"Add bad type", `{"op":"Add","args":[{"op":"Const","const":"1"},{"op":"Const","const":2}]}`, nil, true},
+		{"Len string", `{"op":"Len","args":[{"op":"LoadDocument","const":"body.body"}]}`, float64(11), false},
+		{"Len array", `{"op":"Len","args":[{"op":"LoadParam","const":"allowlist"}]}`, float64(2), false},
+		{"Len bad type", `{"op":"Len","args":[{"op":"Const","const":1}]}`, nil, true},
+		{"max body length", `{"op":"Le","args":[{"op":"Len","args":[{"op":"LoadDocument","const":"body.body"}]},{"op":"LoadParam","const":"limit"}]}`, true, false},
+		{"account older than 7 days", `{"op":"Gt","args":[{"op":"TimeDiff","args":[{"op":"Now"},{"op":"RequesterCDate"}]},{"op":"Const","const":604800}]}`, true, false},
+		{"signedAt within 5 minutes", `{"op":"Lt","args":[{"op":"TimeDiff","args":[{"op":"Now"},{"op":"LoadDocument","const":"signedAt"}]},{"op":"Const","const":300}]}`, true, false},
+		{"TimeDiff bad type", `{"op":"TimeDiff","args":[{"op":"Now"},{"op":"Const","const":1}]}`, nil, true},
+		{"In allowlist", `{"op":"In","args":[{"op":"RequesterID"},{"op":"LoadParam","const":"allowlist"}]}`, true, false},
+		{"In not listed", `{"op":"In","args":[{"op":"Const","const":"con1xxxx"},{"op":"LoadParam","const":"allowlist"}]}`, false, false},
+		{"In number", `{"op":"In","args":[{"op":"RequesterScore"},{"op":"Const","const":[1,10]}]}`, true, false},
+		{"In bad type", `{"op":"In","args":[{"op":"RequesterID"},{"op":"Const","const":"abc"}]}`, nil, true},
+		{"Regexp match", `{"op":"Regexp","args":[{"op":"LoadDocument","const":"body.body"},{"op":"LoadParam","const":"pattern"}]}`, true, false},
+		{"Regexp unmatch", `{"op":"Regexp","args":[{"op":"LoadDocument","const":"body.body"},{"op":"Const","const":"^world"}]}`, false, false},
+		{"Regexp invalid", `{"op":"Regexp","args":[{"op":"Const","const":"a"},{"op":"Const","const":"("}]}`, nil, true},
+		{"Regexp bad type", `{"op":"Regexp","args":[{"op":"Const","const":1},{"op":"Const","const":"a"}]}`, nil, true},
+		{"Exists", `{"op":"Exists","args":[{"op":"LoadParam","const":"limit"}]}`, true, false},
+		{"Exists missing", `{"op":"Exists","args":[{"op":"LoadParam","const":"missing"}]}`, false, false},
+		{"Exists other error", `{"op":"Exists","args":[{"op":"LoadParam","const":1}]}`, nil, true},
+		{"RequesterScore", `{"op":"RequesterScore"}`, float64(10), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var expr core.Expr
+			err := json.Unmarshal([]byte(test.expr), &expr)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			result, err := svc.eval(expr, rctx)
+			if test.hasError {
+				assert.Error(t, err)
+				assert.NotEmpty(t, result.Error)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, result.Result)
+			}
+		})
+	}
+}
+
+// 静的検証のテーブルテスト
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		policy   string
+		hasError bool
+	}{
+		{"global policy", globalPolicyJson, false},
+		{"valid", `{"statements":{"timeline.distribute":{"condition":{"op":"And","args":[{"op":"IsRequesterLocalUser"},{"op":"Le","args":[{"op":"Len","args":[{"op":"LoadDocument","const":"body.body"}]},{"op":"Const","const":500}]}]}}}}`, false},
+		{"unknown operator", `{"statements":{"timeline.distribute":{"condition":{"op":"IsRequesterLocalUsr"}}}}`, true},
+		{"unknown action", `{"statements":{"timeline.distribut":{"condition":{"op":"IsRequesterLocalUser"}}}}`, true},
+		{"unknown default", `{"statements":{},"defaults":{"message.reed":true}}`, true},
+		{"bad arity", `{"statements":{"timeline.distribute":{"condition":{"op":"Not","args":[]}}}}`, true},
+		{"missing constant", `{"statements":{"timeline.distribute":{"condition":{"op":"RequesterHasTag"}}}}`, true},
+		{"bad argument type", `{"statements":{"timeline.distribute":{"condition":{"op":"Lt","args":[{"op":"Const","const":true},{"op":"Const","const":1}]}}}}`, true},
+		{"non bool condition", `{"statements":{"timeline.distribute":{"condition":{"op":"RequesterID"}}}}`, true},
+		{"invalid regexp", `{"statements":{"timeline.distribute":{"condition":{"op":"Regexp","args":[{"op":"RequesterID"},{"op":"Const","const":"("}]}}}}`, true},
+		{"exists without loader", `{"statements":{"timeline.distribute":{"condition":{"op":"Exists","args":[{"op":"RequesterID"}]}}}}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var policy core.Policy
+			err := json.Unmarshal([]byte(test.policy), &policy)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			err = s.Validate(policy)
+			if test.hasError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestUploadLocalRejectsInvalidPolicy(t *testing.T) {
+	_, err := s.UploadLocal(context.Background(), "con1admin", "moderated-timeline", `{"statements":{"timeline.distribute":{"condition":{"op":"IsRequesterLocalUsr"}}}}`)
+	assert.Error(t, err)
+}
+
+func TestQualifyLocalPolicyURL(t *testing.T) {
+	assert.Equal(t, "https://remote.example.com/api/v1/policies/moderated-timeline/document", QualifyLocalPolicyURL("local:moderated-timeline", "remote.example.com"))
+	assert.Equal(t, "https://remote.example.com/api/v1/policies/moderated-timeline/document?version=3", QualifyLocalPolicyURL("local:moderated-timeline@3", "remote.example.com"))
+	assert.Equal(t, "https://policy.example.com/policy.json", QualifyLocalPolicyURL("https://policy.example.com/policy.json", "remote.example.com"))
+}
+
+// 説明APIは未登録のURLを取得しない
+type unregisteredRepository struct {
+	Repository
+}
+
+func (r unregisteredRepository) Get(ctx context.Context, url string) (core.Policy, error) {
+	panic("explain must not fetch arbitrary urls")
+}
+
+func (r unregisteredRepository) GetRegistered(ctx context.Context, url string) (core.Policy, error) {
+	return core.Policy{}, core.NewErrorNotFound()
+}
+
+func TestExplainUnregisteredURL(t *testing.T) {
+	svc := NewService(unregisteredRepository{}, core.Policy{}, core.Config{FQDN: "local.example.com"})
+
+	_, err := svc.Explain(context.Background(), core.Policy{}, "http://169.254.169.254/latest/meta-data", core.RequestContext{}, "timeline.distribute", nil)
+	assert.ErrorIs(t, err, core.ErrorNotFound{})
+}
+
+// 説明: ゲストはアカウント作成日時を持たないので、アカウント年齢の条件は満たさない
+func TestRequesterCDateGuest(t *testing.T) {
+
+	const policyJson = `
+    {
+        "statements": {
+            "timeline.distribute": {
+                "condition": {
+                    "op": "Gt",
+                    "args": [
+                        {"op": "TimeDiff", "args": [{"op": "Now"}, {"op": "RequesterCDate"}]},
+                        {"op": "Const", "const": 604800}
+                    ]
+                }
+            }
+        }
+    }`
+
+	var policy core.Policy
+	err := json.Unmarshal([]byte(policyJson), &policy)
+	assert.NoError(t, err)
+
+	result, err := s.Test(context.Background(), policy, core.RequestContext{}, "timeline.distribute")
+	assert.NoError(t, err)
+	assert.Equal(t, core.PolicyEvalResultDeny, result)
+
+	result, err = s.Test(context.Background(), policy, core.RequestContext{
+		Requester: core.Entity{ID: "con1t0tey8uxhkqkd4wcp4hd4jedt7f0vfhk29xdd2"},
+	}, "timeline.distribute")
+	assert.NoError(t, err)
+	assert.Equal(t, core.PolicyEvalResultDeny, result)
+
+	result, err = s.Test(context.Background(), policy, core.RequestContext{
+		Requester: core.Entity{
+			ID:    "con1t0tey8uxhkqkd4wcp4hd4jedt7f0vfhk29xdd2",
+			CDate: time.Now().Add(-10 * 24 * time.Hour),
+		},
+	}, "timeline.distribute")
+	assert.NoError(t, err)
+	assert.Equal(t, core.PolicyEvalResultAllow, result)
+}
@@ -0,0 +1,251 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// valueType is the static type of an expression used by Validate
+type valueType int
+
+const (
+	typeAny valueType = iota
+	typeBool
+	typeNumber
+	typeString
+	typeTime
+	typeArray
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	case typeTime:
+		return "time"
+	case typeArray:
+		return "array"
+	}
+	return "any"
+}
+
+// compatible reports whether a value of type t can be used where one of the expected types is required
+func (t valueType) compatible(expected ...valueType) bool {
+	if t == typeAny {
+		return true
+	}
+	for _, e := range expected {
+		if e == typeAny || e == t {
+			return true
+		}
+		// times can be written as RFC3339 strings
+		if e == typeTime && t == typeString {
+			return true
+		}
+	}
+	return false
+}
+
+// constantKind is the requirement for Expr.Constant
+type constantKind int
+
+const (
+	constantNone constantKind = iota
+	constantString
+	constantAny
+)
+
+type operatorSpec struct {
+	args     int         // number of arguments. -1 means variadic
+	argTypes []valueType // accepted types for every argument. empty means any
+	constant constantKind
+	result   valueType
+}
+
+var operatorSpecs = map[string]operatorSpec{
+	"And":      {args: -1, argTypes: []valueType{typeBool}, result: typeBool},
+	"Or":       {args: -1, argTypes: []valueType{typeBool}, result: typeBool},
+	"Not":      {args: 1, argTypes: []valueType{typeBool}, result: typeBool},
+	"Eq":       {args: 2, result: typeBool},
+	"Const":    {args: 0, constant: constantAny, result: typeAny},
+	"Contains": {args: 2, result: typeBool},
+
+	"Lt": {args: 2, argTypes: []valueType{typeNumber, typeTime}, result: typeBool},
+	"Gt": {args: 2, argTypes: []valueType{typeNumber, typeTime}, result: typeBool},
+	"Le": {args: 2, argTypes: []valueType{typeNumber, typeTime}, result: typeBool},
+	"Ge": {args: 2, argTypes: []valueType{typeNumber, typeTime}, result: typeBool},
+
+	"Add": {args: 2, argTypes: []valueType{typeNumber}, result: typeNumber},
+	"Sub": {args: 2, argTypes: []valueType{typeNumber}, result: typeNumber},
+	"Mul": {args: 2, argTypes: []valueType{typeNumber}, result: typeNumber},
+	"Div": {args: 2, argTypes: []valueType{typeNumber}, result: typeNumber},
+	"Mod": {args: 2, argTypes: []valueType{typeNumber}, result: typeNumber},
+
+	"Len":      {args: 1, argTypes: []valueType{typeString, typeArray}, result: typeNumber},
+	"Now":      {args: 0, result: typeTime},
+	"TimeDiff": {args: 2, argTypes: []valueType{typeTime}, result: typeNumber},
+	"In":       {args: 2, result: typeBool},
+	"Regexp":   {args: 2, argTypes: []valueType{typeString}, result: typeBool},
+	"Exists":   {args: 1, result: typeBool},
+
+	"LoadParam":    {args: 0, constant: constantString, result: typeAny},
+	"LoadDocument": {args: 0, constant: constantString, result: typeAny},
+	"LoadSelf":     {args: 0, constant: constantString, result: typeAny},
+	"LoadResource": {args: 0, constant: constantString, result: typeAny},
+
+	"DomainFQDN": {args: 0, result: typeString},
+	"DomainCSID": {args: 0, result: typeString},
+	"IsCCID":     {args: 1, argTypes: []valueType{typeString}, result: typeBool},
+	"IsCSID":     {args: 1, argTypes: []valueType{typeString}, result: typeBool},
+	"IsCKID":     {args: 1, argTypes: []valueType{typeString}, result: typeBool},
+
+	"IsRequesterLocalUser":  {args: 0, result: typeBool},
+	"IsRequesterRemoteUser": {args: 0, result: typeBool},
+	"IsRequesterGuestUser":  {args: 0, result: typeBool},
+	"RequesterHasTag":       {args: 0, constant: constantString, result: typeBool},
+	"RequesterID":           {args: 0, result: typeString},
+	"RequesterCDate":        {args: 0, result: typeTime},
+	"RequesterScore":        {args: 0, result: typeNumber},
+	"RequesterDomainHasTag": {args: 0, constant: constantString, result: typeBool},
+}
+
+// validatePolicy statically checks the policy and returns every problem found
+func validatePolicy(policy core.Policy) error {
+	var errs []error
+
+	actions := make([]string, 0, len(policy.Statements))
+	for action := range policy.Statements {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	for _, action := range actions {
+		path := fmt.Sprintf("statements[%q]", action)
+		if !core.IsPolicyAction(action) {
+			errs = append(errs, fmt.Errorf("%s: unknown action", path))
+		}
+
+		result, exprErrs := validateExpr(policy.Statements[action].Condition, path+".condition")
+		errs = append(errs, exprErrs...)
+		if len(exprErrs) == 0 && !result.compatible(typeBool) {
+			errs = append(errs, fmt.Errorf("%s.condition: must be bool but is %s", path, result))
+		}
+	}
+
+	for action := range policy.Defaults {
+		if !core.IsPolicyAction(action) {
+			errs = append(errs, fmt.Errorf("defaults[%q]: unknown action", action))
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// validateExpr checks operator arity, constant and argument types, and returns the static type of the expression
+func validateExpr(expr core.Expr, path string) (valueType, []error) {
+	spec, ok := operatorSpecs[expr.Operator]
+	if !ok {
+		return typeAny, []error{fmt.Errorf("%s: unknown operator %q", path, expr.Operator)}
+	}
+
+	var errs []error
+
+	if spec.args >= 0 && len(expr.Args) != spec.args {
+		errs = append(errs, fmt.Errorf("%s: %s expects %d arguments but got %d", path, expr.Operator, spec.args, len(expr.Args)))
+	}
+
+	switch spec.constant {
+	case constantNone:
+		if expr.Constant != nil {
+			errs = append(errs, fmt.Errorf("%s: %s does not take a constant", path, expr.Operator))
+		}
+	case constantString:
+		if _, ok := expr.Constant.(string); !ok {
+			errs = append(errs, fmt.Errorf("%s: %s expects a string constant", path, expr.Operator))
+		}
+	}
+
+	if expr.Operator == "Const" {
+		return constantType(expr.Constant), errs
+	}
+
+	argTypes := make([]valueType, len(expr.Args))
+	for i, arg := range expr.Args {
+		argPath := fmt.Sprintf("%s.args[%d]", path, i)
+		argType, argErrs := validateExpr(arg, argPath)
+		argTypes[i] = argType
+		if len(argErrs) > 0 {
+			errs = append(errs, argErrs...)
+			continue
+		}
+
+		if len(spec.argTypes) > 0 && !argType.compatible(spec.argTypes...) {
+			expected := make([]string, len(spec.argTypes))
+			for j, t := range spec.argTypes {
+				expected[j] = t.String()
+			}
+			errs = append(errs, fmt.Errorf("%s: %s expects %s but got %s", argPath, expr.Operator, strings.Join(expected, " or "), argType))
+		}
+	}
+
+	if len(errs) > 0 {
+		return spec.result, errs
+	}
+
+	switch expr.Operator {
+	case "Contains":
+		if !argTypes[0].compatible(typeArray) {
+			errs = append(errs, fmt.Errorf("%s.args[0]: Contains expects array but got %s", path, argTypes[0]))
+		}
+	case "In":
+		if !argTypes[1].compatible(typeArray) {
+			errs = append(errs, fmt.Errorf("%s.args[1]: In expects array but got %s", path, argTypes[1]))
+		}
+	case "Regexp":
+		if pattern, ok := expr.Args[1].Constant.(string); ok && expr.Args[1].Operator == "Const" {
+			if _, err := regexp.Compile(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("%s.args[1]: invalid pattern: %s", path, err.Error()))
+			}
+		}
+	case "Exists":
+		if !strings.HasPrefix(expr.Args[0].Operator, "Load") {
+			errs = append(errs, fmt.Errorf("%s.args[0]: Exists expects a Load operator but got %s", path, expr.Args[0].Operator))
+		}
+	}
+
+	return spec.result, errs
+}
+
+func constantType(constant any) valueType {
+	switch constant.(type) {
+	case bool:
+		return typeBool
+	case float64:
+		return typeNumber
+	case string:
+		return typeString
+	case []any:
+		return typeArray
+	}
+	return typeAny
+}
+
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Errorf("invalid policy: %s", strings.Join(messages, "; "))
+}
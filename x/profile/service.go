@@ -10,6 +10,14 @@ import (
 	"go.opentelemetry.io/otel/codes"
 )
 
+func init() {
+	core.RegisterPolicyAction(
+		"profile.create",
+		"profile.delete",
+		"profile.update",
+	)
+}
+
 type service struct {
 	repo       Repository
 	entity     core.EntityService
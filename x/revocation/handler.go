@@ -0,0 +1,52 @@
+package revocation
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+var tracer = otel.Tracer("revocation")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+	GetFeed(c echo.Context) error
+}
+
+type handler struct {
+	service core.RevocationService
+}
+
+// NewHandler creates a new handler
+func NewHandler(service core.RevocationService) Handler {
+	return &handler{service}
+}
+
+// GetFeed returns the signed revocation feed
+// query: since (unix seconds, optional)
+func (h *handler) GetFeed(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Revocation.Handler.GetFeed")
+	defer span.End()
+
+	since := time.Time{}
+	if sinceStr := c.QueryParam("since"); sinceStr != "" {
+		sinceInt, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid since"})
+		}
+		since = time.Unix(sinceInt, 0)
+	}
+
+	feed, err := h.service.GetFeed(ctx, since)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"content": feed})
+}
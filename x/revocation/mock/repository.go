@@ -0,0 +1,129 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: repository.go
+//
+// Generated by this command:
+//
+//	mockgen -source=repository.go -destination=mock/repository.go
+//
+
+// Package mock_revocation is a generated GoMock package.
+package mock_revocation
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	core "github.com/totegamma/concurrent/core"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Add mocks base method.
+func (m *MockRepository) Add(ctx context.Context, revocation core.Revocation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Add", ctx, revocation)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Add indicates an expected call of Add.
+func (mr *MockRepositoryMockRecorder) Add(ctx, revocation any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockRepository)(nil).Add), ctx, revocation)
+}
+
+// GetRemoteCache mocks base method.
+func (m *MockRepository) GetRemoteCache(ctx context.Context, domain string) (core.RevocationCache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRemoteCache", ctx, domain)
+	ret0, _ := ret[0].(core.RevocationCache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRemoteCache indicates an expected call of GetRemoteCache.
+func (mr *MockRepositoryMockRecorder) GetRemoteCache(ctx, domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRemoteCache", reflect.TypeOf((*MockRepository)(nil).GetRemoteCache), ctx, domain)
+}
+
+// IsRemoteFailed mocks base method.
+func (m *MockRepository) IsRemoteFailed(ctx context.Context, domain string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsRemoteFailed", ctx, domain)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsRemoteFailed indicates an expected call of IsRemoteFailed.
+func (mr *MockRepositoryMockRecorder) IsRemoteFailed(ctx, domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsRemoteFailed", reflect.TypeOf((*MockRepository)(nil).IsRemoteFailed), ctx, domain)
+}
+
+// List mocks base method.
+func (m *MockRepository) List(ctx context.Context, since time.Time) ([]core.Revocation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, since)
+	ret0, _ := ret[0].([]core.Revocation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockRepositoryMockRecorder) List(ctx, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockRepository)(nil).List), ctx, since)
+}
+
+// SetRemoteCache mocks base method.
+func (m *MockRepository) SetRemoteCache(ctx context.Context, domain string, cache core.RevocationCache) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRemoteCache", ctx, domain, cache)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRemoteCache indicates an expected call of SetRemoteCache.
+func (mr *MockRepositoryMockRecorder) SetRemoteCache(ctx, domain, cache any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRemoteCache", reflect.TypeOf((*MockRepository)(nil).SetRemoteCache), ctx, domain, cache)
+}
+
+// SetRemoteFailed mocks base method.
+func (m *MockRepository) SetRemoteFailed(ctx context.Context, domain string, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRemoteFailed", ctx, domain, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRemoteFailed indicates an expected call of SetRemoteFailed.
+func (mr *MockRepositoryMockRecorder) SetRemoteFailed(ctx, domain, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRemoteFailed", reflect.TypeOf((*MockRepository)(nil).SetRemoteFailed), ctx, domain, ttl)
+}
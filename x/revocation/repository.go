@@ -0,0 +1,144 @@
+//go:generate go run go.uber.org/mock/mockgen -source=repository.go -destination=mock/repository.go
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// Repository is the interface for revocation repository
+type Repository interface {
+	Add(ctx context.Context, revocation core.Revocation) error
+	List(ctx context.Context, since time.Time) ([]core.Revocation, error)
+	GetRemoteCache(ctx context.Context, domain string) (core.RevocationCache, error)
+	SetRemoteCache(ctx context.Context, domain string, cache core.RevocationCache) error
+	IsRemoteFailed(ctx context.Context, domain string) (bool, error)
+	SetRemoteFailed(ctx context.Context, domain string, ttl time.Duration) error
+}
+
+type repository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+// NewRepository creates a new revocation repository
+func NewRepository(db *gorm.DB, rdb *redis.Client) Repository {
+	return &repository{db, rdb}
+}
+
+func remoteCacheKey(domain string) string {
+	return "revocation:remote:" + domain
+}
+
+func remoteFailedKey(domain string) string {
+	return "revocation:remote:failed:" + domain
+}
+
+// Add saves the revocation. the first revocation of the same target wins
+func (r *repository) Add(ctx context.Context, revocation core.Revocation) error {
+	ctx, span := tracer.Start(ctx, "Revocation.Repository.Add")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&revocation).Error
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// List returns revocations published after since
+func (r *repository) List(ctx context.Context, since time.Time) ([]core.Revocation, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Repository.List")
+	defer span.End()
+
+	var revocations []core.Revocation
+	err := r.db.WithContext(ctx).Where("c_date > ?", since).Order("c_date").Find(&revocations).Error
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// GetRemoteCache returns the last verified revocation feed of the remote domain
+func (r *repository) GetRemoteCache(ctx context.Context, domain string) (core.RevocationCache, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Repository.GetRemoteCache")
+	defer span.End()
+
+	value, err := r.rdb.Get(ctx, remoteCacheKey(domain)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return core.RevocationCache{}, core.NewErrorNotFound()
+		}
+		span.RecordError(err)
+		return core.RevocationCache{}, err
+	}
+
+	var cache core.RevocationCache
+	err = json.Unmarshal([]byte(value), &cache)
+	if err != nil {
+		span.RecordError(err)
+		return core.RevocationCache{}, err
+	}
+
+	return cache, nil
+}
+
+// SetRemoteCache saves the verified revocation feed of the remote domain without expiration
+func (r *repository) SetRemoteCache(ctx context.Context, domain string, cache core.RevocationCache) error {
+	ctx, span := tracer.Start(ctx, "Revocation.Repository.SetRemoteCache")
+	defer span.End()
+
+	value, err := json.Marshal(cache)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	err = r.rdb.Set(ctx, remoteCacheKey(domain), value, 0).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// IsRemoteFailed reports whether fetching the feed of the remote domain failed recently
+func (r *repository) IsRemoteFailed(ctx context.Context, domain string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Repository.IsRemoteFailed")
+	defer span.End()
+
+	count, err := r.rdb.Exists(ctx, remoteFailedKey(domain)).Result()
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// SetRemoteFailed marks the remote domain as failed for ttl
+func (r *repository) SetRemoteFailed(ctx context.Context, domain string, ttl time.Duration) error {
+	ctx, span := tracer.Start(ctx, "Revocation.Repository.SetRemoteFailed")
+	defer span.End()
+
+	err := r.rdb.Set(ctx, remoteFailedKey(domain), "1", ttl).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
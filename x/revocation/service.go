@@ -0,0 +1,294 @@
+package revocation
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/totegamma/concurrent/client"
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/x/key"
+)
+
+const (
+	// remoteFeedTTL is how often the verified feed of a remote domain is refreshed
+	remoteFeedTTL = 5 * time.Minute
+	// remoteFeedRetryTTL is how long a domain whose feed could not be fetched is not asked again
+	remoteFeedRetryTTL = 1 * time.Minute
+	// remoteFeedOverlap is subtracted from since when refreshing, to cover the skew between publishing and signing the feed
+	remoteFeedOverlap = 1 * time.Minute
+)
+
+type service struct {
+	repository Repository
+	client     client.Client
+	config     core.Config
+}
+
+// NewService creates a new revocation service
+func NewService(repository Repository, client client.Client, config core.Config) core.RevocationService {
+	return &service{repository, client, config}
+}
+
+// Publish adds the target to the revocation feed of this domain
+func (s *service) Publish(ctx context.Context, typ, target string, revokedAt time.Time) error {
+	ctx, span := tracer.Start(ctx, "Revocation.Service.Publish")
+	defer span.End()
+
+	switch typ {
+	case core.RevocationTypePassport, core.RevocationTypeEntity, core.RevocationTypeKey:
+	default:
+		return fmt.Errorf("unknown revocation type: %s", typ)
+	}
+
+	if target == "" {
+		return fmt.Errorf("revocation target is empty")
+	}
+
+	err := s.repository.Add(ctx, core.Revocation{
+		Type:      typ,
+		Target:    target,
+		RevokedAt: revokedAt,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// GetFeed returns the revocations published after since, signed by this domain
+func (s *service) GetFeed(ctx context.Context, since time.Time) (core.RevocationFeed, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Service.GetFeed")
+	defer span.End()
+
+	revocations, err := s.repository.List(ctx, since)
+	if err != nil {
+		span.RecordError(err)
+		return core.RevocationFeed{}, err
+	}
+	revocations = pruneRevocations(revocations, time.Now())
+
+	keyID, privateKey := s.config.DomainSigningKey()
+
+	document, err := json.Marshal(core.RevocationFeedDocument{
+		Domain:      s.config.FQDN,
+		Revocations: revocations,
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   s.config.CSID,
+			KeyID:    keyID,
+			Type:     "revocations",
+			SignedAt: time.Now(),
+		},
+	})
+	if err != nil {
+		span.RecordError(err)
+		return core.RevocationFeed{}, err
+	}
+
+	signature, err := core.SignBytes(document, privateKey)
+	if err != nil {
+		span.RecordError(err)
+		return core.RevocationFeed{}, err
+	}
+
+	return core.RevocationFeed{
+		Document:  string(document),
+		Signature: hex.EncodeToString(signature),
+	}, nil
+}
+
+// IsPassportRevoked checks the passport against the cached revocation feed of the issuing domain
+func (s *service) IsPassportRevoked(ctx context.Context, domain core.Domain, passport core.PassportDocument) (bool, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Service.IsPassportRevoked")
+	defer span.End()
+
+	revocations, err := s.getRemoteRevocations(ctx, domain)
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	keys := map[string]bool{}
+	if passport.KeyID != "" {
+		keys[passport.KeyID] = true
+	}
+	for _, k := range passport.Keys {
+		keys[k.ID] = true
+	}
+
+	for _, revocation := range revocations {
+		switch revocation.Type {
+		case core.RevocationTypePassport:
+			if passport.JTI != "" && revocation.Target == passport.JTI {
+				return true, nil
+			}
+		case core.RevocationTypeEntity:
+			if revocation.Target == passport.Entity.ID {
+				return true, nil
+			}
+		case core.RevocationTypeKey:
+			if keys[revocation.Target] {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// getRemoteRevocations returns the verified revocations of the remote domain.
+// the last verified list is kept and refreshed in place, so a domain that cannot be reached
+// never makes passports revoked by it valid again.
+func (s *service) getRemoteRevocations(ctx context.Context, domain core.Domain) ([]core.Revocation, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Service.getRemoteRevocations")
+	defer span.End()
+
+	cache, err := s.repository.GetRemoteCache(ctx, domain.ID)
+	cached := err == nil
+	if err != nil && !errors.Is(err, core.ErrorNotFound{}) {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	if cached && time.Since(cache.FetchedAt) < remoteFeedTTL {
+		return cache.Revocations, nil
+	}
+
+	failed, err := s.repository.IsRemoteFailed(ctx, domain.ID)
+	if err != nil {
+		span.RecordError(err)
+	}
+	if failed {
+		if cached {
+			return cache.Revocations, nil
+		}
+		return nil, fmt.Errorf("revocation feed of %s is unavailable", domain.ID)
+	}
+
+	since := time.Time{}
+	if cached {
+		since = cache.SignedAt.Add(-remoteFeedOverlap)
+	}
+
+	fetched, signedAt, err := s.fetchRemoteRevocations(ctx, domain, since)
+	if err != nil {
+		// 取得できないドメインへの問い合わせを繰り返さないよう、失敗だけを短期間記録する
+		// 検証済みのリストは残しておく
+		span.RecordError(err)
+		markErr := s.repository.SetRemoteFailed(ctx, domain.ID, remoteFeedRetryTTL)
+		if markErr != nil {
+			span.RecordError(markErr)
+		}
+		if cached {
+			return cache.Revocations, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	revocations := pruneRevocations(mergeRevocations(cache.Revocations, fetched), now)
+
+	err = s.repository.SetRemoteCache(ctx, domain.ID, core.RevocationCache{
+		Revocations: revocations,
+		SignedAt:    signedAt,
+		FetchedAt:   now,
+	})
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return revocations, nil
+}
+
+// mergeRevocations adds the fetched revocations to the cached ones. the first revocation of the same target wins
+func mergeRevocations(cached, fetched []core.Revocation) []core.Revocation {
+	merged := make([]core.Revocation, 0, len(cached)+len(fetched))
+	seen := make(map[string]bool)
+	for _, revocation := range append(cached, fetched...) {
+		id := revocation.Type + ":" + revocation.Target
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		merged = append(merged, revocation)
+	}
+	return merged
+}
+
+// pruneRevocations drops revocations of passports that have expired by now.
+// a passport is signed before it is revoked, so it is expired once PassportLifetime has passed since the revocation
+func pruneRevocations(revocations []core.Revocation, now time.Time) []core.Revocation {
+	pruned := make([]core.Revocation, 0, len(revocations))
+	for _, revocation := range revocations {
+		if revocation.Type == core.RevocationTypePassport && now.Sub(revocation.RevokedAt) > core.PassportLifetime {
+			continue
+		}
+		pruned = append(pruned, revocation)
+	}
+	return pruned
+}
+
+// fetchRemoteRevocations fetches the revocations published by the remote domain after since,
+// and returns them with the signedAt of the feed
+func (s *service) fetchRemoteRevocations(ctx context.Context, domain core.Domain, since time.Time) ([]core.Revocation, time.Time, error) {
+	ctx, span := tracer.Start(ctx, "Revocation.Service.fetchRemoteRevocations")
+	defer span.End()
+
+	if domain.CSID == "" {
+		return nil, time.Time{}, fmt.Errorf("CSID of %s is unknown", domain.ID)
+	}
+
+	feed, err := s.client.GetRevocations(ctx, domain.ID, since, nil)
+	if err != nil {
+		span.RecordError(err)
+		return nil, time.Time{}, err
+	}
+
+	var doc core.RevocationFeedDocument
+	err = json.Unmarshal([]byte(feed.Document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return nil, time.Time{}, errors.Wrap(err, "failed to unmarshal revocation feed")
+	}
+
+	if doc.Type != "revocations" || doc.Domain != domain.ID || doc.Signer != domain.CSID {
+		return nil, time.Time{}, fmt.Errorf("revocation feed is not issued by %s", domain.ID)
+	}
+
+	signer := doc.Signer
+	if doc.KeyID != "" {
+		keys, err := s.client.GetKey(ctx, domain.ID, doc.KeyID, nil)
+		if err != nil {
+			span.RecordError(err)
+			return nil, time.Time{}, errors.Wrap(err, "failed to resolve operator key")
+		}
+
+		err = key.ValidateOperatorKey(keys, doc.KeyID, domain.CSID, doc.SignedAt)
+		if err != nil {
+			span.RecordError(err)
+			return nil, time.Time{}, errors.Wrap(err, "invalid operator key")
+		}
+
+		signer = doc.KeyID
+	}
+
+	signature, err := hex.DecodeString(feed.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return nil, time.Time{}, errors.Wrap(err, "failed to decode signature")
+	}
+
+	err = core.VerifySignatureWithAlgorithm([]byte(feed.Document), signature, signer, doc.Algorithm)
+	if err != nil {
+		span.RecordError(err)
+		return nil, time.Time{}, errors.Wrap(err, "failed to verify revocation feed")
+	}
+
+	return doc.Revocations, doc.SignedAt, nil
+}
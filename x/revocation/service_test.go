@@ -0,0 +1,181 @@
+package revocation
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/client/mock"
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/x/revocation/mock"
+)
+
+const (
+	RemoteDomainFQDN = "remote.example.com"
+	RemoteDomainPriv = "3fcfac6c211b743975de2d7b3f622c12694b8125daf4013562c5a1aefa3253a5"
+
+	User1ID   = "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	SubKey1ID = "cck1ydda2qj3nr32hulm65vj2g746f06hy36wzh9ke"
+)
+
+func TestIsPassportRevoked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock_revocation.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetRemoteCache(gomock.Any(), RemoteDomainFQDN).Return(core.RevocationCache{
+		Revocations: []core.Revocation{
+			{Type: core.RevocationTypePassport, Target: "revokedjti"},
+			{Type: core.RevocationTypeKey, Target: SubKey1ID},
+		},
+		FetchedAt: time.Now(),
+	}, nil).AnyTimes()
+
+	service := NewService(mockRepo, nil, core.Config{})
+	domain := core.Domain{ID: RemoteDomainFQDN}
+
+	revoked, err := service.IsPassportRevoked(context.Background(), domain, core.PassportDocument{
+		JTI:    "validjti",
+		Entity: core.Entity{ID: User1ID},
+	})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	revoked, err = service.IsPassportRevoked(context.Background(), domain, core.PassportDocument{
+		JTI:    "revokedjti",
+		Entity: core.Entity{ID: User1ID},
+	})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// 失効したサブキーを含むパスポートも拒否
+	revoked, err = service.IsPassportRevoked(context.Background(), domain, core.PassportDocument{
+		JTI:    "validjti",
+		Entity: core.Entity{ID: User1ID},
+		Keys:   []core.Key{{ID: SubKey1ID}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func signedFeed(t *testing.T, csid string, signedAt time.Time, revocations []core.Revocation) core.RevocationFeed {
+	document, err := json.Marshal(core.RevocationFeedDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   csid,
+			Type:     "revocations",
+			SignedAt: signedAt,
+		},
+		Domain:      RemoteDomainFQDN,
+		Revocations: revocations,
+	})
+	assert.NoError(t, err)
+
+	signature, err := core.SignBytes(document, RemoteDomainPriv)
+	assert.NoError(t, err)
+
+	return core.RevocationFeed{
+		Document:  string(document),
+		Signature: hex.EncodeToString(signature),
+	}
+}
+
+func TestFetchRemoteRevocations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	csid, err := core.PrivKeyToAddr(RemoteDomainPriv, "ccs")
+	assert.NoError(t, err)
+
+	signedAt := time.Now().Truncate(time.Second)
+	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetRevocations(gomock.Any(), RemoteDomainFQDN, gomock.Any(), gomock.Any()).Return(signedFeed(t, csid, signedAt, []core.Revocation{
+		{Type: core.RevocationTypeEntity, Target: User1ID, RevokedAt: time.Now()},
+	}), nil).AnyTimes()
+
+	service := &service{client: mockClient}
+
+	revocations, fetchedSignedAt, err := service.fetchRemoteRevocations(context.Background(), core.Domain{ID: RemoteDomainFQDN, CSID: csid}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, revocations, 1)
+	assert.True(t, signedAt.Equal(fetchedSignedAt))
+
+	// CSIDが一致しないフィードは受け付けない
+	_, _, err = service.fetchRemoteRevocations(context.Background(), core.Domain{ID: RemoteDomainFQDN, CSID: "ccs1invalid"}, time.Time{})
+	assert.Error(t, err)
+}
+
+func TestGetRemoteRevocations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	csid, err := core.PrivKeyToAddr(RemoteDomainPriv, "ccs")
+	assert.NoError(t, err)
+	domain := core.Domain{ID: RemoteDomainFQDN, CSID: csid}
+
+	lastSignedAt := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	stale := core.RevocationCache{
+		Revocations: []core.Revocation{
+			{Type: core.RevocationTypePassport, Target: "revokedjti", RevokedAt: time.Now().Add(-time.Hour)},
+			{Type: core.RevocationTypePassport, Target: "expiredjti", RevokedAt: time.Now().Add(-core.PassportLifetime - time.Hour)},
+		},
+		SignedAt:  lastSignedAt,
+		FetchedAt: time.Now().Add(-10 * time.Minute),
+	}
+
+	mockRepo := mock_revocation.NewMockRepository(ctrl)
+	mockClient := mock_client.NewMockClient(ctrl)
+	service := &service{repository: mockRepo, client: mockClient}
+
+	// 取得に失敗しても検証済みのリストを使い続け、失敗だけを記録する
+	mockRepo.EXPECT().GetRemoteCache(gomock.Any(), RemoteDomainFQDN).Return(stale, nil)
+	mockRepo.EXPECT().IsRemoteFailed(gomock.Any(), RemoteDomainFQDN).Return(false, nil)
+	mockClient.EXPECT().GetRevocations(gomock.Any(), RemoteDomainFQDN, gomock.Any(), gomock.Any()).Return(core.RevocationFeed{}, fmt.Errorf("unreachable"))
+	mockRepo.EXPECT().SetRemoteFailed(gomock.Any(), RemoteDomainFQDN, remoteFeedRetryTTL).Return(nil)
+
+	revocations, err := service.getRemoteRevocations(context.Background(), domain)
+	assert.NoError(t, err)
+	assert.Equal(t, stale.Revocations, revocations)
+
+	// 失敗を記録している間は問い合わせない
+	mockRepo.EXPECT().GetRemoteCache(gomock.Any(), RemoteDomainFQDN).Return(stale, nil)
+	mockRepo.EXPECT().IsRemoteFailed(gomock.Any(), RemoteDomainFQDN).Return(true, nil)
+
+	revocations, err = service.getRemoteRevocations(context.Background(), domain)
+	assert.NoError(t, err)
+	assert.Equal(t, stale.Revocations, revocations)
+
+	// 検証済みのリストがなければエラーにする
+	mockRepo.EXPECT().GetRemoteCache(gomock.Any(), RemoteDomainFQDN).Return(core.RevocationCache{}, core.NewErrorNotFound())
+	mockRepo.EXPECT().IsRemoteFailed(gomock.Any(), RemoteDomainFQDN).Return(true, nil)
+
+	_, err = service.getRemoteRevocations(context.Background(), domain)
+	assert.Error(t, err)
+
+	// 前回以降の差分だけを取得して追記し、期限切れのパスポートは取り除く
+	signedAt := time.Now().Truncate(time.Second)
+	mockRepo.EXPECT().GetRemoteCache(gomock.Any(), RemoteDomainFQDN).Return(stale, nil)
+	mockRepo.EXPECT().IsRemoteFailed(gomock.Any(), RemoteDomainFQDN).Return(false, nil)
+	mockClient.EXPECT().GetRevocations(gomock.Any(), RemoteDomainFQDN, lastSignedAt.Add(-remoteFeedOverlap), gomock.Any()).Return(signedFeed(t, csid, signedAt, []core.Revocation{
+		{Type: core.RevocationTypePassport, Target: "revokedjti", RevokedAt: time.Now().Add(-time.Hour)},
+		{Type: core.RevocationTypeKey, Target: SubKey1ID, RevokedAt: time.Now()},
+	}), nil)
+	mockRepo.EXPECT().SetRemoteCache(gomock.Any(), RemoteDomainFQDN, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, cache core.RevocationCache) error {
+		assert.True(t, signedAt.Equal(cache.SignedAt))
+		assert.Len(t, cache.Revocations, 2)
+		return nil
+	})
+
+	revocations, err = service.getRemoteRevocations(context.Background(), domain)
+	assert.NoError(t, err)
+	targets := []string{}
+	for _, revocation := range revocations {
+		targets = append(targets, revocation.Target)
+	}
+	assert.ElementsMatch(t, []string{"revokedjti", SubKey1ID}, targets)
+}
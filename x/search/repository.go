@@ -0,0 +1,110 @@
+package search
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+var tracer = otel.Tracer("search")
+
+// Repository is the interface for search repository
+type Repository interface {
+	Upsert(ctx context.Context, index core.MessageIndex) error
+	Delete(ctx context.Context, id string) error
+	Search(ctx context.Context, query, timeline, author string, cursor core.SearchCursor, limit int) ([]core.MessageIndex, error)
+	Clean(ctx context.Context, ccid string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a new search repository
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db}
+}
+
+// Upsert creates or replaces the index entry of a message
+func (r *repository) Upsert(ctx context.Context, index core.MessageIndex) error {
+	ctx, span := tracer.Start(ctx, "Search.Repository.Upsert")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&index).Error
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the index entry of a message
+func (r *repository) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "Search.Repository.Delete")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Where("message_id = ?", id).Delete(&core.MessageIndex{}).Error
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Search finds index entries older than the cursor matching the query, newest first.
+// an entry matches if either the tsvector of the whole words or the trigram (ILIKE) of the substring matches
+func (r *repository) Search(ctx context.Context, query, timeline, author string, cursor core.SearchCursor, limit int) ([]core.MessageIndex, error) {
+	ctx, span := tracer.Start(ctx, "Search.Repository.Search")
+	defer span.End()
+
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	pattern := "%" + escaper.Replace(query) + "%"
+
+	q := r.db.WithContext(ctx).
+		Model(&core.MessageIndex{}).
+		Where("(to_tsvector('simple', text) @@ plainto_tsquery('simple', ?) OR text ILIKE ?)", query, pattern)
+
+	if timeline != "" {
+		q = q.Where("? = ANY(timelines)", timeline)
+	}
+
+	if author != "" {
+		q = q.Where("author = ?", author)
+	}
+
+	if cursor.MessageID != "" {
+		q = q.Where("(c_date, message_id) < (?, ?)", cursor.CDate, cursor.MessageID)
+	} else {
+		q = q.Where("c_date < ?", cursor.CDate)
+	}
+
+	var indices []core.MessageIndex
+	err := q.Order("c_date desc, message_id desc").Limit(limit).Find(&indices).Error
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return indices, nil
+}
+
+// Clean removes all index entries of the author
+func (r *repository) Clean(ctx context.Context, ccid string) error {
+	ctx, span := tracer.Start(ctx, "Search.Repository.Clean")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Where("author = ?", ccid).Delete(&core.MessageIndex{}).Error
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
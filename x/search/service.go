@@ -0,0 +1,161 @@
+// Package search provides full-text search over local resources
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+type service struct {
+	repository Repository
+}
+
+// NewService creates a new search service
+func NewService(repository Repository) core.SearchService {
+	return &service{repository}
+}
+
+func normalizeMessageID(id string) (string, error) {
+	if len(id) == 27 {
+		if id[0] != 'm' {
+			return "", fmt.Errorf("message id must start with 'm'. got %s", id)
+		}
+		id = id[1:]
+	}
+
+	if len(id) != 26 {
+		return "", fmt.Errorf("message id must be 26 characters long. got %s", id)
+	}
+
+	return id, nil
+}
+
+// extractText collects searchable text from the message body.
+// If the body has a "body" field, only that is used. Otherwise every string in the body is collected.
+func extractText(body any) string {
+	switch v := body.(type) {
+	case string:
+		return v
+	case map[string]any:
+		if text, ok := v["body"].(string); ok {
+			return text
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		texts := []string{}
+		for _, key := range keys {
+			if text := extractText(v[key]); text != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, " ")
+	case []any:
+		texts := []string{}
+		for _, item := range v {
+			if text := extractText(item); text != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, " ")
+	}
+	return ""
+}
+
+// IndexMessage registers the message body to the search index
+func (s *service) IndexMessage(ctx context.Context, message core.Message) error {
+	ctx, span := tracer.Start(ctx, "Search.Service.IndexMessage")
+	defer span.End()
+
+	id, err := normalizeMessageID(message.ID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	var doc core.MessageDocument[any]
+	err = json.Unmarshal([]byte(message.Document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	text := extractText(doc.Body)
+	if text == "" {
+		return nil
+	}
+
+	cdate := message.CDate
+	if cdate.IsZero() {
+		cdate = doc.SignedAt
+	}
+
+	return s.repository.Upsert(ctx, core.MessageIndex{
+		MessageID: id,
+		Author:    message.Author,
+		Timelines: message.Timelines,
+		Text:      text,
+		CDate:     cdate,
+	})
+}
+
+// RemoveMessage removes the message from the search index
+func (s *service) RemoveMessage(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "Search.Service.RemoveMessage")
+	defer span.End()
+
+	id, err := normalizeMessageID(id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return s.repository.Delete(ctx, id)
+}
+
+// SearchMessages returns index entries older than the cursor matching the query, newest first
+func (s *service) SearchMessages(ctx context.Context, query, timeline, author string, cursor core.SearchCursor, limit int) ([]core.MessageIndex, error) {
+	ctx, span := tracer.Start(ctx, "Search.Service.SearchMessages")
+	defer span.End()
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []core.MessageIndex{}, nil
+	}
+
+	if cursor.MessageID != "" {
+		id, err := normalizeMessageID(cursor.MessageID)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		cursor.MessageID = id
+	}
+
+	indices, err := s.repository.Search(ctx, query, timeline, author, cursor, limit)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	for i := range indices {
+		indices[i].MessageID = "m" + indices[i].MessageID
+	}
+
+	return indices, nil
+}
+
+// Clean removes all index entries of the author
+func (s *service) Clean(ctx context.Context, ccid string) error {
+	ctx, span := tracer.Start(ctx, "Search.Service.Clean")
+	defer span.End()
+
+	return s.repository.Clean(ctx, ccid)
+}
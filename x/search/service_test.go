@@ -0,0 +1,24 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractText(t *testing.T) {
+	assert.Equal(t, "hello", extractText(map[string]any{"body": "hello", "emojis": map[string]any{"a": "ignored"}}))
+	assert.Equal(t, "a b c", extractText(map[string]any{"z": "c", "a": "a", "m": []any{"b", 1.0}}))
+	assert.Equal(t, "", extractText(1.0))
+}
+
+func TestNormalizeMessageID(t *testing.T) {
+	id, err := normalizeMessageID("m00000000000000000000000001")
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000000000000000000001", id)
+
+	_, err = normalizeMessageID("a00000000000000000000000001")
+	assert.Error(t, err)
+	_, err = normalizeMessageID("m0001")
+	assert.Error(t, err)
+}
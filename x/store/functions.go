@@ -0,0 +1,141 @@
+package store
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// commitLine is a parsed line of the commit log file
+// format: "<id> <owner> <signature> <hash> <document>"
+// lines exported before the hash chain was introduced have no hash field.
+// the chain is backfilled on startup (see BackfillCommitChain), so exporting the log again yields hashed lines.
+type commitLine struct {
+	ID        string
+	Owner     string
+	Signature string
+	Hash      string
+	Document  string
+}
+
+func (l commitLine) String() string {
+	if l.Hash == "" {
+		return fmt.Sprintf("%s %s %s %s", l.ID, l.Owner, l.Signature, l.Document)
+	}
+	return fmt.Sprintf("%s %s %s %s %s", l.ID, l.Owner, l.Signature, l.Hash, l.Document)
+}
+
+func parseCommitLine(line string) (commitLine, error) {
+	split := strings.Split(line, " ")
+	if len(split) < 4 {
+		return commitLine{}, fmt.Errorf("invalid line")
+	}
+
+	if strings.HasPrefix(split[3], "{") {
+		return commitLine{
+			ID:        split[0],
+			Owner:     split[1],
+			Signature: split[2],
+			Document:  strings.Join(split[3:], " "),
+		}, nil
+	}
+
+	if len(split) < 5 {
+		return commitLine{}, fmt.Errorf("invalid line")
+	}
+
+	return commitLine{
+		ID:        split[0],
+		Owner:     split[1],
+		Signature: split[2],
+		Hash:      split[3],
+		Document:  strings.Join(split[4:], " "),
+	}, nil
+}
+
+// commitHash calculates the chained hash of a commit
+func commitHash(previous, documentID, owner, signature, document string) string {
+	hash := core.GetHash([]byte(strings.Join([]string{previous, documentID, owner, signature, document}, " ")))
+	return hex.EncodeToString(hash)
+}
+
+// verifyCommitChain checks that the lines form a continuous hash chain for each owner.
+// Every line must carry a hash. The chain only proves the order of the lines;
+// that nothing is missing at the end is checked against the signed head (see verifyCommitHeads).
+func verifyCommitChain(lines []commitLine) error {
+	heads := make(map[string]string)
+	for _, line := range lines {
+		previous := heads[line.Owner]
+		if line.Hash == "" {
+			return fmt.Errorf("commit %s has no hash", line.ID)
+		}
+
+		expected := commitHash(previous, line.ID, line.Owner, line.Signature, line.Document)
+		if expected != line.Hash {
+			return fmt.Errorf("commit chain is broken at %s: missing or reordered commits", line.ID)
+		}
+		heads[line.Owner] = line.Hash
+	}
+	return nil
+}
+
+// rechainCommitOwners recalculates the chain of the owner's records, which must be in id order.
+// it returns the records whose previous or hash changed and the hash of the last record
+func rechainCommitOwners(owner, previous string, records []core.CommitOwner, commits map[uint]core.CommitLog) ([]core.CommitOwner, string, error) {
+	changed := make([]core.CommitOwner, 0)
+	for _, record := range records {
+		commit, ok := commits[record.CommitLogID]
+		if !ok {
+			return nil, "", fmt.Errorf("commit log %d of %s is missing", record.CommitLogID, owner)
+		}
+
+		hash := commitHash(previous, commit.DocumentID, owner, commit.Signature, commit.Document)
+		// char型の列は空白で埋められて返ってくる
+		if strings.TrimSpace(record.Previous) != previous || strings.TrimSpace(record.Hash) != hash {
+			record.Previous = previous
+			record.Hash = hash
+			changed = append(changed, record)
+		}
+		previous = hash
+	}
+	return changed, previous, nil
+}
+
+// chainHeads returns the hash of the last line for each owner
+func chainHeads(lines []commitLine) map[string]string {
+	heads := make(map[string]string)
+	for _, line := range lines {
+		heads[line.Owner] = line.Hash
+	}
+	return heads
+}
+
+// verifyCommitHeads checks that the chain of each owner ends at the head signed by the domain
+func verifyCommitHeads(lines []commitLine, signed map[string]core.CommitHeadDocument) error {
+	for owner, hash := range chainHeads(lines) {
+		head, ok := signed[owner]
+		if !ok {
+			return fmt.Errorf("no signed head for %s", owner)
+		}
+		if head.Owner != owner || head.Head != hash {
+			return fmt.Errorf("commit chain of %s ends at %s but the signed head is %s: the log is truncated", owner, hash, head.Head)
+		}
+	}
+	return nil
+}
+
+// validateScheduledCommit checks that the document can be held until scheduledAt.
+// Timeline items are ordered by signedAt, so the document must be signed with the scheduled time
+// to appear at that time instead of when it was reserved.
+func validateScheduledCommit(base core.DocumentBase[any], scheduledAt time.Time) error {
+	if base.Type != "message" {
+		return fmt.Errorf("only messages can be scheduled")
+	}
+	if !base.SignedAt.Equal(scheduledAt) {
+		return fmt.Errorf("signedAt must be equal to scheduledAt")
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	Owner1 = "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	Owner2 = "con1fk8zlkrfmens3sgj7dzcu3gsw8v9kkysrf8dt5"
+)
+
+func buildChain(owner string, documents ...string) []commitLine {
+	lines := make([]commitLine, 0, len(documents))
+	previous := ""
+	for i, document := range documents {
+		id := owner[len(owner)-4:] + string(rune('a'+i))
+		hash := commitHash(previous, id, owner, "sig", document)
+		lines = append(lines, commitLine{ID: id, Owner: owner, Signature: "sig", Hash: hash, Document: document})
+		previous = hash
+	}
+	return lines
+}
+
+func TestParseCommitLine(t *testing.T) {
+	line, err := parseCommitLine(`id1 owner sig hash {"a": 1}`)
+	assert.NoError(t, err)
+	assert.Equal(t, commitLine{ID: "id1", Owner: "owner", Signature: "sig", Hash: "hash", Document: `{"a": 1}`}, line)
+	assert.Equal(t, `id1 owner sig hash {"a": 1}`, line.String())
+
+	legacy, err := parseCommitLine(`id1 owner sig {"a": 1}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "", legacy.Hash)
+	assert.Equal(t, `{"a": 1}`, legacy.Document)
+
+	_, err = parseCommitLine("id1 owner")
+	assert.Error(t, err)
+}
+
+func TestVerifyCommitChain(t *testing.T) {
+	lines := buildChain(Owner1, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+	assert.NoError(t, verifyCommitChain(lines))
+
+	// 所有者ごとに独立したチェーンが混ざっていてもよい
+	mixed := append([]commitLine{}, lines[0])
+	mixed = append(mixed, buildChain(Owner2, `{"n":1}`)...)
+	mixed = append(mixed, lines[1:]...)
+	assert.NoError(t, verifyCommitChain(mixed))
+
+	// 欠落
+	assert.Error(t, verifyCommitChain([]commitLine{lines[0], lines[2]}))
+
+	// 並べ替え
+	assert.Error(t, verifyCommitChain([]commitLine{lines[1], lines[0], lines[2]}))
+
+	// 改竄
+	tampered := append([]commitLine{}, lines...)
+	tampered[1].Document = `{"n":20}`
+	assert.Error(t, verifyCommitChain(tampered))
+
+	// ハッシュのない行は受け付けない
+	legacy := commitLine{ID: "legacy", Owner: Owner1, Signature: "sig", Document: "{}"}
+	assert.Error(t, verifyCommitChain(append([]commitLine{legacy}, lines...)))
+}
+
+func TestRechainCommitOwners(t *testing.T) {
+	commits := map[uint]core.CommitLog{
+		1: {ID: 1, DocumentID: "doc1", Signature: "sig", Document: `{"n":1}`},
+		2: {ID: 2, DocumentID: "doc2", Signature: "sig", Document: `{"n":2}`},
+		3: {ID: 3, DocumentID: "doc3", Signature: "sig", Document: `{"n":3}`},
+	}
+
+	// ハッシュのない古い行と、それを知らずにチェーンの先頭として記録された新しい行
+	records := []core.CommitOwner{
+		{ID: 10, CommitLogID: 1, Owner: Owner1},
+		{ID: 11, CommitLogID: 2, Owner: Owner1},
+		{ID: 12, CommitLogID: 3, Owner: Owner1, Hash: commitHash("", "doc3", Owner1, "sig", `{"n":3}`)},
+	}
+
+	changed, head, err := rechainCommitOwners(Owner1, "", records, commits)
+	assert.NoError(t, err)
+	assert.Len(t, changed, 3)
+	assert.Equal(t, changed[2].Hash, head)
+
+	lines := make([]commitLine, len(changed))
+	for i, record := range changed {
+		commit := commits[record.CommitLogID]
+		lines[i] = commitLine{ID: commit.DocumentID, Owner: Owner1, Signature: commit.Signature, Hash: record.Hash, Document: commit.Document}
+		if i > 0 {
+			assert.Equal(t, changed[i-1].Hash, record.Previous)
+		}
+	}
+	assert.NoError(t, verifyCommitChain(lines))
+
+	// 計算済みのチェーンは書き換えない
+	again, _, err := rechainCommitOwners(Owner1, "", changed, commits)
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+
+	_, _, err = rechainCommitOwners(Owner1, "", []core.CommitOwner{{ID: 13, CommitLogID: 4, Owner: Owner1}}, commits)
+	assert.Error(t, err)
+}
+
+func TestVerifyCommitHeads(t *testing.T) {
+	lines := buildChain(Owner1, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+	head := core.CommitHeadDocument{Owner: Owner1, Head: lines[2].Hash}
+
+	assert.NoError(t, verifyCommitHeads(lines, map[string]core.CommitHeadDocument{Owner1: head}))
+
+	// 末尾が切り詰められたログは署名されたヘッドに届かない
+	truncated := lines[:2]
+	assert.NoError(t, verifyCommitChain(truncated))
+	assert.Error(t, verifyCommitHeads(truncated, map[string]core.CommitHeadDocument{Owner1: head}))
+
+	// ヘッドがない所有者は拒否
+	assert.Error(t, verifyCommitHeads(lines, map[string]core.CommitHeadDocument{}))
+
+	// 他人のヘッドは使えない
+	other := core.CommitHeadDocument{Owner: Owner2, Head: lines[2].Hash}
+	assert.Error(t, verifyCommitHeads(lines, map[string]core.CommitHeadDocument{Owner1: other}))
+}
+
+func TestValidateScheduledCommit(t *testing.T) {
+	scheduledAt := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	err := validateScheduledCommit(core.DocumentBase[any]{Type: "message", SignedAt: scheduledAt.In(time.Local)}, scheduledAt)
+	assert.NoError(t, err)
+
+	// 予約した時点の時刻で署名されたものは過去に並んでしまう
+	err = validateScheduledCommit(core.DocumentBase[any]{Type: "message", SignedAt: time.Now()}, scheduledAt)
+	assert.Error(t, err)
+
+	err = validateScheduledCommit(core.DocumentBase[any]{Type: "association", SignedAt: scheduledAt}, scheduledAt)
+	assert.Error(t, err)
+}
@@ -20,6 +20,7 @@ type Handler interface {
 	Post(c echo.Context) error
 	GetSyncStatus(c echo.Context) error
 	PerformSync(c echo.Context) error
+	GetHead(c echo.Context) error
 }
 
 type handler struct {
@@ -66,8 +67,9 @@ func (h *handler) Commit(c echo.Context) error {
 
 	result, err := h.service.Commit(ctx, core.CommitModeExecute, request.Document, request.Signature, request.Option, keys, requesterIP)
 	if err != nil {
-		if errors.Is(err, core.ErrorPermissionDenied{}) {
-			return c.JSON(http.StatusForbidden, echo.Map{"status": "error", "error": err.Error()})
+		var denied core.ErrorPermissionDenied
+		if errors.As(err, &denied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"status": "error", "error": err.Error(), "statement": denied.Statement})
 		}
 		if errors.Is(err, core.ErrorAlreadyExists{}) {
 			return c.JSON(http.StatusOK, echo.Map{"status": "processed", "content": result})
@@ -133,6 +135,31 @@ func (h *handler) PerformSync(c echo.Context) error {
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": status})
 }
 
+func (h *handler) GetHead(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "Store.Handler.GetHead")
+	defer span.End()
+
+	owner := c.QueryParam("owner")
+	if owner == "" {
+		requester, ok := ctx.Value(core.RequesterIdCtxKey).(string)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "owner is required"})
+		}
+		owner = requester
+	}
+
+	head, err := h.service.GetHead(ctx, owner)
+	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "head not found"})
+		}
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": head})
+}
+
 func (h *handler) Post(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "Store.Handler.Post")
 	defer span.End()
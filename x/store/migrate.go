@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const backfillPageSize = 1000
+
+// BackfillCommitChain calculates the hash chain of commits logged before the chain was introduced.
+// The chain of each owner is rebuilt in commit_owners.id order under the same lock Log takes,
+// so commits logged after the upgrade are chained onto the backfilled ones.
+func BackfillCommitChain(ctx context.Context, db *gorm.DB) error {
+	ctx, span := tracer.Start(ctx, "Store.BackfillCommitChain")
+	defer span.End()
+
+	var owners []string
+	err := db.WithContext(ctx).
+		Model(&core.CommitOwner{}).
+		Joins("JOIN commit_logs ON commit_owners.commit_log_id = commit_logs.id").
+		Where("commit_logs.is_ephemeral = ?", false).
+		Where("(commit_owners.hash IS NULL OR commit_owners.hash = '')").
+		Distinct().
+		Pluck("commit_owners.owner", &owners).
+		Error
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	for _, owner := range owners {
+		var updated int
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			updated, err = backfillOwnerChain(ctx, tx, owner)
+			return err
+		})
+		if err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("failed to backfill commit chain of %s: %w", owner, err)
+		}
+
+		// 書き出し済みのログはハッシュが古いので作り直させる
+		err = os.Remove(filepath.Join("/tmp/concrnt", "/user", fmt.Sprintf("%s.log", owner)))
+		if err != nil && !os.IsNotExist(err) {
+			span.RecordError(err)
+			return err
+		}
+
+		slog.Info("backfilled commit chain", slog.String("owner", owner), slog.Int("updated", updated))
+	}
+
+	return nil
+}
+
+func backfillOwnerChain(ctx context.Context, tx *gorm.DB, owner string) (int, error) {
+	// Log と同じロックで、移行中に追記されないようにする
+	err := tx.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", owner).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var updated int
+	var previous string
+	var lastID uint
+	for {
+		var records []core.CommitOwner
+		err = tx.WithContext(ctx).
+			Joins("JOIN commit_logs ON commit_owners.commit_log_id = commit_logs.id").
+			Where("commit_owners.owner = ?", owner).
+			Where("commit_logs.is_ephemeral = ?", false).
+			Where("commit_owners.id > ?", lastID).
+			Order("commit_owners.id ASC").
+			Limit(backfillPageSize).
+			Find(&records).
+			Error
+		if err != nil {
+			return updated, err
+		}
+
+		if len(records) == 0 {
+			return updated, nil
+		}
+
+		commitIDs := make([]uint, len(records))
+		for i, record := range records {
+			commitIDs[i] = record.CommitLogID
+		}
+
+		var commits []core.CommitLog
+		err = tx.WithContext(ctx).Where("id IN ?", commitIDs).Find(&commits).Error
+		if err != nil {
+			return updated, err
+		}
+
+		commitMap := make(map[uint]core.CommitLog, len(commits))
+		for _, commit := range commits {
+			commitMap[commit.ID] = commit
+		}
+
+		changed, head, err := rechainCommitOwners(owner, previous, records, commitMap)
+		if err != nil {
+			return updated, err
+		}
+
+		for _, record := range changed {
+			err = tx.WithContext(ctx).
+				Model(&core.CommitOwner{}).
+				Where("id = ?", record.ID).
+				Updates(map[string]any{"previous": record.Previous, "hash": record.Hash}).
+				Error
+			if err != nil {
+				return updated, err
+			}
+		}
+
+		updated += len(changed)
+		previous = head
+		lastID = records[len(records)-1].ID
+	}
+}
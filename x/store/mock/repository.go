@@ -0,0 +1,101 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: repository.go
+//
+// Generated by this command:
+//
+//	mockgen -source=repository.go -destination=mock/repository.go
+//
+
+// Package mock_store is a generated GoMock package.
+package mock_store
+
+import (
+	context "context"
+	reflect "reflect"
+
+	core "github.com/totegamma/concurrent/core"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// GetHead mocks base method.
+func (m *MockRepository) GetHead(ctx context.Context, owner string) (core.CommitOwner, core.CommitLog, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHead", ctx, owner)
+	ret0, _ := ret[0].(core.CommitOwner)
+	ret1, _ := ret[1].(core.CommitLog)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetHead indicates an expected call of GetHead.
+func (mr *MockRepositoryMockRecorder) GetHead(ctx, owner any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHead", reflect.TypeOf((*MockRepository)(nil).GetHead), ctx, owner)
+}
+
+// Log mocks base method.
+func (m *MockRepository) Log(ctx context.Context, commit core.CommitLog) (core.CommitLog, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Log", ctx, commit)
+	ret0, _ := ret[0].(core.CommitLog)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Log indicates an expected call of Log.
+func (mr *MockRepositoryMockRecorder) Log(ctx, commit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Log", reflect.TypeOf((*MockRepository)(nil).Log), ctx, commit)
+}
+
+// SyncCommitFile mocks base method.
+func (m *MockRepository) SyncCommitFile(ctx context.Context, owner string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SyncCommitFile", ctx, owner)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SyncCommitFile indicates an expected call of SyncCommitFile.
+func (mr *MockRepositoryMockRecorder) SyncCommitFile(ctx, owner any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncCommitFile", reflect.TypeOf((*MockRepository)(nil).SyncCommitFile), ctx, owner)
+}
+
+// SyncStatus mocks base method.
+func (m *MockRepository) SyncStatus(ctx context.Context, owner string) (core.SyncStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SyncStatus", ctx, owner)
+	ret0, _ := ret[0].(core.SyncStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SyncStatus indicates an expected call of SyncStatus.
+func (mr *MockRepositoryMockRecorder) SyncStatus(ctx, owner any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncStatus", reflect.TypeOf((*MockRepository)(nil).SyncStatus), ctx, owner)
+}
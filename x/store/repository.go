@@ -1,3 +1,4 @@
+//go:generate go run go.uber.org/mock/mockgen -source=repository.go -destination=mock/repository.go
 package store
 
 import (
@@ -21,6 +22,7 @@ type Repository interface {
 	Log(ctx context.Context, commit core.CommitLog) (core.CommitLog, error)
 	SyncCommitFile(ctx context.Context, owner string) error
 	SyncStatus(ctx context.Context, owner string) (core.SyncStatus, error)
+	GetHead(ctx context.Context, owner string) (core.CommitOwner, core.CommitLog, error)
 }
 
 type repository struct {
@@ -54,6 +56,30 @@ func (r *repository) Log(ctx context.Context, commit core.CommitLog) (core.Commi
 			CommitLogID: commit.ID,
 			Owner:       owner,
 		}
+
+		if !commit.IsEphemeral {
+			// serialize chain updates per owner until the transaction ends
+			err = tx.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", owner).Error
+			if err != nil {
+				tx.Rollback()
+				return core.CommitLog{}, err
+			}
+
+			var previous core.CommitOwner
+			err = tx.WithContext(ctx).
+				Where("owner = ? AND hash <> ''", owner).
+				Order("id DESC").
+				Limit(1).
+				Find(&previous).Error
+			if err != nil {
+				tx.Rollback()
+				return core.CommitLog{}, err
+			}
+
+			ownerRecord.Previous = previous.Hash
+			ownerRecord.Hash = commitHash(previous.Hash, commit.DocumentID, owner, commit.Signature, commit.Document)
+		}
+
 		err = tx.WithContext(ctx).Create(&ownerRecord).Error
 		if err != nil {
 			tx.Rollback()
@@ -65,8 +91,9 @@ func (r *repository) Log(ctx context.Context, commit core.CommitLog) (core.Commi
 	return commit, err
 }
 
-func (r *repository) getLatestCommitDateByOwner(ctx context.Context, owner string) (time.Time, error) {
-	ctx, span := tracer.Start(ctx, "Store.Repository.GetLatestCommitByOwner")
+// getLatestCommitOnFile returns the last line of the owner's commit file
+func (r *repository) getLatestCommitOnFile(ctx context.Context, owner string) (commitLine, time.Time, error) {
+	ctx, span := tracer.Start(ctx, "Store.Repository.GetLatestCommitOnFile")
 	defer span.End()
 
 	userlogPath := filepath.Join("/tmp/concrnt", "/user")
@@ -80,7 +107,7 @@ func (r *repository) getLatestCommitDateByOwner(ctx context.Context, owner strin
 	userStore, err := os.OpenFile(filepath.Join(userlogPath, filename), os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		slog.Error("failed to open user log file:", slog.String("error", err.Error()))
-		return time.Time{}, err
+		return commitLine{}, time.Time{}, err
 	}
 	defer userStore.Close()
 
@@ -129,20 +156,109 @@ func (r *repository) getLatestCommitDateByOwner(ctx context.Context, owner strin
 		seeker = from
 	}
 
-	split := strings.Split(lastLine, " ")
-	if len(split) < 4 {
-		return time.Time{}, nil
+	line, err := parseCommitLine(lastLine)
+	if err != nil {
+		return commitLine{}, time.Time{}, nil
 	}
 
-	document := strings.Join(split[3:], " ")
 	object := core.DocumentBase[any]{}
-	err = json.Unmarshal([]byte(document), &object)
+	err = json.Unmarshal([]byte(line.Document), &object)
+	if err != nil {
+		span.RecordError(err)
+		return commitLine{}, time.Time{}, errors.Wrap(err, "failed to unmarshal payload")
+	}
+
+	return line, object.SignedAt, nil
+}
+
+// getCommitOwnerID returns the id of the owner's record of the commit
+func (r *repository) getCommitOwnerID(ctx context.Context, owner, documentID string) (uint, error) {
+	ctx, span := tracer.Start(ctx, "Store.Repository.GetCommitOwnerID")
+	defer span.End()
+
+	var ids []uint
+	err := r.db.WithContext(ctx).
+		Model(&core.CommitOwner{}).
+		Joins("JOIN commit_logs ON commit_owners.commit_log_id = commit_logs.id").
+		Where("commit_owners.owner = ?", owner).
+		Where("commit_logs.document_id = ?", documentID).
+		Limit(1).
+		Pluck("commit_owners.id", &ids).
+		Error
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, core.NewErrorNotFound()
+	}
+
+	return ids[0], nil
+}
+
+// getLatestCommitOnDB returns the latest non-ephemeral commit of the owner and its owner record
+func (r *repository) getLatestCommitOnDB(ctx context.Context, owner string) (core.CommitOwner, core.CommitLog, error) {
+	ctx, span := tracer.Start(ctx, "Store.Repository.GetLatestCommitOnDB")
+	defer span.End()
+
+	var ownerRecord core.CommitOwner
+	err := r.db.WithContext(ctx).
+		Joins("JOIN commit_logs ON commit_owners.commit_log_id = commit_logs.id").
+		Where("commit_owners.owner = ?", owner).
+		Where("commit_logs.is_ephemeral = ?", false).
+		Order("commit_owners.id DESC").
+		Limit(1).
+		Find(&ownerRecord).
+		Error
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitOwner{}, core.CommitLog{}, err
+	}
+
+	if ownerRecord.ID == 0 {
+		return core.CommitOwner{}, core.CommitLog{}, core.NewErrorNotFound()
+	}
+
+	var commit core.CommitLog
+	err = r.db.WithContext(ctx).Where("id = ?", ownerRecord.CommitLogID).First(&commit).Error
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitOwner{}, core.CommitLog{}, err
+	}
+
+	return ownerRecord, commit, nil
+}
+
+// GetHead returns the latest chained commit of the owner
+func (r *repository) GetHead(ctx context.Context, owner string) (core.CommitOwner, core.CommitLog, error) {
+	ctx, span := tracer.Start(ctx, "Store.Repository.GetHead")
+	defer span.End()
+
+	var ownerRecord core.CommitOwner
+	err := r.db.WithContext(ctx).
+		Where("owner = ? AND hash <> ''", owner).
+		Order("id DESC").
+		Limit(1).
+		Find(&ownerRecord).
+		Error
 	if err != nil {
 		span.RecordError(err)
-		return time.Time{}, errors.Wrap(err, "failed to unmarshal payload")
+		return core.CommitOwner{}, core.CommitLog{}, err
 	}
 
-	return object.SignedAt, nil
+	if ownerRecord.ID == 0 {
+		return core.CommitOwner{}, core.CommitLog{}, core.NewErrorNotFound()
+	}
+
+	var commit core.CommitLog
+	err = r.db.WithContext(ctx).Where("id = ?", ownerRecord.CommitLogID).First(&commit).Error
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitOwner{}, core.CommitLog{}, err
+	}
+
+	return ownerRecord, commit, nil
 }
 
 func (r *repository) SyncStatus(ctx context.Context, owner string) (core.SyncStatus, error) {
@@ -161,33 +277,32 @@ func (r *repository) SyncStatus(ctx context.Context, owner string) (core.SyncSta
 		}, nil
 	}
 
-	lastSignedAt, err := r.getLatestCommitDateByOwner(ctx, owner)
+	lastLine, lastSignedAt, err := r.getLatestCommitOnFile(ctx, owner)
 	if err != nil {
 		span.RecordError(err)
 		return core.SyncStatus{}, err
 	}
 
-	var latestSignedAt time.Time
-	err = r.db.WithContext(ctx).
-		Model(&core.CommitLog{}).
-		Joins("JOIN commit_owners ON commit_owners.commit_log_id = commit_logs.id").
-		Where("commit_owners.owner = ?", owner).
-		Where("commit_logs.is_ephemeral = ?", false).
-		Order("commit_logs.signed_at DESC").
-		Limit(1).
-		Pluck("commit_logs.signed_at", &latestSignedAt).
-		Error
-
-	if err != nil {
+	latestRecord, latestCommit, err := r.getLatestCommitOnDB(ctx, owner)
+	if err != nil && !errors.Is(err, core.ErrorNotFound{}) {
 		span.RecordError(err)
 		return core.SyncStatus{}, err
 	}
 
-	if latestSignedAt.Equal(lastSignedAt) {
-		return core.SyncStatus{Owner: owner, Status: "insync", LatestOnFile: lastSignedAt, LatestOnDB: latestSignedAt}, nil
+	status := core.SyncStatus{
+		Owner:        owner,
+		Status:       "outofsync",
+		LatestOnFile: lastSignedAt,
+		LatestOnDB:   latestCommit.SignedAt,
+		HeadOnFile:   lastLine.Hash,
+		HeadOnDB:     latestRecord.Hash,
 	}
 
-	return core.SyncStatus{Owner: owner, Status: "outofsync", LatestOnFile: lastSignedAt, LatestOnDB: latestSignedAt}, nil
+	if lastLine.ID == latestCommit.DocumentID {
+		status.Status = "insync"
+	}
+
+	return status, nil
 }
 
 func (r *repository) SyncCommitFile(ctx context.Context, owner string) error {
@@ -203,12 +318,21 @@ func (r *repository) SyncCommitFile(ctx context.Context, owner string) error {
 	}
 	defer r.rdb.Del(ctx, lockKey)
 
-	lastSignedAt, err := r.getLatestCommitDateByOwner(ctx, owner)
+	lastLine, _, err := r.getLatestCommitOnFile(ctx, owner)
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
 
+	var lastID uint
+	if lastLine.ID != "" {
+		lastID, err = r.getCommitOwnerID(ctx, owner, lastLine.ID)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
 	userlogPath := filepath.Join("/tmp/concrnt", "/user")
 	err = os.MkdirAll(userlogPath, 0755)
 	if err != nil {
@@ -226,42 +350,20 @@ func (r *repository) SyncCommitFile(ctx context.Context, owner string) error {
 
 	var pageSize = 1000
 
-	var firstCommitDate time.Time
-	err = r.db.WithContext(ctx).
-		Model(&core.CommitLog{}).
-		Joins("JOIN commit_owners ON commit_owners.commit_log_id = commit_logs.id").
-		Where("commit_owners.owner = ?", owner).
-		Where("commit_logs.is_ephemeral = ?", false).
-		Order("commit_logs.signed_at ASC").
-		Limit(1).
-		Pluck("commit_logs.signed_at", &firstCommitDate).
-		Error
-
-	if err != nil {
-		span.RecordError(err)
-		return err
-	}
-
-	var latestCommitDate time.Time
-	err = r.db.WithContext(ctx).
-		Model(&core.CommitLog{}).
-		Joins("JOIN commit_owners ON commit_owners.commit_log_id = commit_logs.id").
-		Where("commit_owners.owner = ?", owner).
-		Where("commit_logs.is_ephemeral = ?", false).
-		Order("commit_logs.signed_at DESC").
-		Limit(1).
-		Pluck("commit_logs.signed_at", &latestCommitDate).
-		Error
-
+	latestRecord, _, err := r.getLatestCommitOnDB(ctx, owner)
 	if err != nil {
+		if errors.Is(err, core.ErrorNotFound{}) {
+			return nil
+		}
 		span.RecordError(err)
 		return err
 	}
+	latestID := latestRecord.ID
 
 	progressCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	progress := float64(lastSignedAt.Sub(firstCommitDate).Seconds()) / float64(latestCommitDate.Sub(firstCommitDate).Seconds())
+	progress := float64(lastID) / float64(latestID)
 	r.rdb.SetNX(ctx, fmt.Sprintf("store:progress:%s", owner), fmt.Sprintf("%.2f%%", progress*100), 10*time.Minute)
 
 	// log dump progress
@@ -271,7 +373,7 @@ func (r *repository) SyncCommitFile(ctx context.Context, owner string) error {
 			case <-progressCtx.Done():
 				return
 			case <-time.After(10 * time.Second):
-				progress := float64(lastSignedAt.Sub(firstCommitDate).Seconds()) / float64(latestCommitDate.Sub(firstCommitDate).Seconds())
+				progress := float64(lastID) / float64(latestID)
 				fmt.Printf("dumping %s logs. (%.2f%%)\n", owner, progress*100)
 				r.rdb.SetNX(ctx, fmt.Sprintf("store:progress:%s", owner), fmt.Sprintf("%.2f%%", progress*100), 10*time.Minute)
 
@@ -282,31 +384,49 @@ func (r *repository) SyncCommitFile(ctx context.Context, owner string) error {
 	}()
 
 	for {
-		var commits []core.CommitLog
-
-		query := r.db.WithContext(ctx).
-			Joins("JOIN commit_owners ON commit_owners.commit_log_id = commit_logs.id").
+		var records []core.CommitOwner
+		err = r.db.WithContext(ctx).
+			Joins("JOIN commit_logs ON commit_owners.commit_log_id = commit_logs.id").
 			Where("commit_owners.owner = ?", owner).
-			Where("commit_logs.is_ephemeral = ?", false)
-
-		if !lastSignedAt.IsZero() {
-			query = query.Where("commit_logs.signed_at > ?", lastSignedAt)
+			Where("commit_logs.is_ephemeral = ?", false).
+			Where("commit_owners.id > ?", lastID).
+			Order("commit_owners.id ASC").
+			Limit(pageSize).
+			Find(&records).
+			Error
+		if err != nil {
+			span.RecordError(err)
+			return err
 		}
 
-		q := query.
-			Order("commit_logs.signed_at ASC").
-			Limit(pageSize).Find(&commits)
+		commitIDs := make([]uint, len(records))
+		for i, record := range records {
+			commitIDs[i] = record.CommitLogID
+		}
 
-		err = q.Error
+		var commits []core.CommitLog
+		err = r.db.WithContext(ctx).Where("id IN ?", commitIDs).Find(&commits).Error
 		if err != nil {
 			span.RecordError(err)
 			return err
 		}
 
-		var logs string
+		commitMap := make(map[uint]core.CommitLog, len(commits))
 		for _, commit := range commits {
-			// ID Owner Signature Document
-			logs += fmt.Sprintf("%s %s %s %s\n", commit.DocumentID, owner, commit.Signature, commit.Document)
+			commitMap[commit.ID] = commit
+		}
+
+		var logs string
+		for _, record := range records {
+			commit := commitMap[record.CommitLogID]
+			line := commitLine{
+				ID:        commit.DocumentID,
+				Owner:     owner,
+				Signature: commit.Signature,
+				Hash:      record.Hash,
+				Document:  commit.Document,
+			}
+			logs += line.String() + "\n"
 		}
 		_, err = userStore.WriteString(logs)
 		if err != nil {
@@ -314,11 +434,11 @@ func (r *repository) SyncCommitFile(ctx context.Context, owner string) error {
 			return err
 		}
 
-		if len(commits) > 0 {
-			lastSignedAt = commits[len(commits)-1].SignedAt
+		if len(records) > 0 {
+			lastID = records[len(records)-1].ID
 		}
 
-		if len(commits) < pageSize {
+		if len(records) < pageSize {
 			break
 		}
 	}
@@ -8,10 +8,12 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/totegamma/concurrent/cdid"
+	"github.com/totegamma/concurrent/client"
 	"github.com/totegamma/concurrent/core"
 	"github.com/totegamma/concurrent/x/key"
 )
@@ -27,6 +29,9 @@ type service struct {
 	ack            core.AckService
 	subscription   core.SubscriptionService
 	semanticID     core.SemanticIDService
+	job            core.JobService
+	domain         core.DomainService
+	client         client.Client
 	config         core.Config
 	repositoryPath string
 }
@@ -42,6 +47,9 @@ func NewService(
 	ack core.AckService,
 	subscription core.SubscriptionService,
 	semanticID core.SemanticIDService,
+	job core.JobService,
+	domain core.DomainService,
+	client client.Client,
 	config core.Config,
 	repositoryPath string,
 ) core.StoreService {
@@ -56,13 +64,17 @@ func NewService(
 		ack:            ack,
 		subscription:   subscription,
 		semanticID:     semanticID,
+		job:            job,
+		domain:         domain,
+		client:         client,
 		config:         config,
 		repositoryPath: repositoryPath,
 	}
 }
 
 type CommitOption struct {
-	IsEphemeral bool `json:"isEphemeral,omitempty"`
+	IsEphemeral bool       `json:"isEphemeral,omitempty"`
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"` // hold the message until this time. signedAt must be the same (see schedule)
 }
 
 func (s *service) Commit(
@@ -93,6 +105,15 @@ func (s *service) Commit(
 		return nil, err
 	}
 
+	var commitOption CommitOption
+	if option != "" {
+		json.Unmarshal([]byte(option), &commitOption)
+	}
+
+	if mode == core.CommitModeExecute && commitOption.ScheduledAt != nil && commitOption.ScheduledAt.After(time.Now()) {
+		return s.schedule(ctx, base, document, signature, commitOption)
+	}
+
 	var result any
 	owners := []string{}
 
@@ -121,6 +142,18 @@ func (s *service) Commit(
 		result = e
 		owners = []string{e.ID}
 
+	case "guardian":
+		var e core.Entity
+		e, err = s.entity.Guardian(ctx, mode, document, signature)
+		result = e
+		owners = []string{e.ID}
+
+	case "recover":
+		var e core.Entity
+		e, err = s.entity.Recover(ctx, mode, document, signature)
+		result = e
+		owners = []string{e.ID, base.Signer}
+
 	case "timeline":
 		var t core.Timeline
 		t, err = s.timeline.UpsertTimeline(ctx, mode, document, signature)
@@ -222,12 +255,7 @@ func (s *service) Commit(
 			}
 		}
 
-		isEphemeral := false
-		var commitOption CommitOption
-		err = json.Unmarshal([]byte(option), &commitOption)
-		if err == nil {
-			isEphemeral = commitOption.IsEphemeral
-		}
+		isEphemeral := commitOption.IsEphemeral
 
 		hash := core.GetHash([]byte(document))
 		hash10 := [10]byte{}
@@ -256,6 +284,55 @@ func (s *service) Commit(
 	return result, err
 }
 
+// schedule holds a validly signed message document as a job.
+// The job reactor commits it at the scheduled time, resolving the signing key again at that point.
+func (s *service) schedule(ctx context.Context, base core.DocumentBase[any], document, signature string, option CommitOption) (core.Job, error) {
+	ctx, span := tracer.Start(ctx, "Store.Service.Schedule")
+	defer span.End()
+
+	err := validateScheduledCommit(base, *option.ScheduledAt)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	signer, err := s.entity.Get(ctx, base.Signer)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, errors.Wrap(err, "failed to resolve signer")
+	}
+
+	if signer.Domain != s.config.FQDN {
+		return core.Job{}, fmt.Errorf("only local users can schedule messages")
+	}
+
+	scheduledAt := *option.ScheduledAt
+	option.ScheduledAt = nil
+	optionStr, err := json.Marshal(option)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	payload, err := json.Marshal(core.ScheduledCommitPayload{
+		Document:  document,
+		Signature: signature,
+		Option:    string(optionStr),
+	})
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	job, err := s.job.Create(ctx, base.Signer, core.JobTypeScheduledCommit, string(payload), scheduledAt)
+	if err != nil {
+		span.RecordError(err)
+		return core.Job{}, err
+	}
+
+	return job, nil
+}
+
 func (s *service) Restore(ctx context.Context, archive io.Reader, from string, IP string) ([]core.BatchResult, error) {
 	ctx, span := tracer.Start(ctx, "Store.Service.Restore")
 	defer span.End()
@@ -263,30 +340,61 @@ func (s *service) Restore(ctx context.Context, archive io.Reader, from string, I
 	results := make([]core.BatchResult, 0)
 
 	scanner := bufio.NewScanner(archive)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 
+	lines := make([]commitLine, 0)
 	for scanner.Scan() {
-		job := scanner.Text()
-		split := strings.Split(job, " ")
-		if len(split) < 4 {
-			results = append(results, core.BatchResult{ID: split[0], Error: "invalid job"})
+		text := scanner.Text()
+		if text == "" {
 			continue
+		}
+		line, err := parseCommitLine(text)
+		if err != nil {
+			results = append(results, core.BatchResult{ID: strings.Split(text, " ")[0], Error: "invalid job"})
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 
+	err = verifyCommitChain(lines)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	heads := make(map[string]core.CommitHeadDocument)
+	for owner := range chainHeads(lines) {
+		head, err := s.fetchVerifiedHead(ctx, from, owner)
+		if err != nil {
+			span.RecordError(err)
+			return nil, errors.Wrap(err, "failed to verify repository head")
 		}
-		// id := split[0]
-		// owner := split[1]
-		signature := split[2]
-		document := strings.Join(split[3:], " ")
+		heads[owner] = head
+	}
 
+	err = verifyCommitHeads(lines, heads)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	for _, line := range lines {
 		var doc core.DocumentBase[any]
-		err := json.Unmarshal([]byte(document), &doc)
+		err := json.Unmarshal([]byte(line.Document), &doc)
 		if err != nil {
-			results = append(results, core.BatchResult{ID: split[0], Error: fmt.Sprintf("%v", errors.Wrap(err, "failed to unmarshal document"))})
+			results = append(results, core.BatchResult{ID: line.ID, Error: fmt.Sprintf("%v", errors.Wrap(err, "failed to unmarshal document"))})
 			continue
 		}
 
 		signer, err := s.entity.GetWithHint(ctx, doc.Signer, from)
 		if err != nil {
-			results = append(results, core.BatchResult{ID: split[0], Error: fmt.Sprintf("%v", errors.Wrap(err, "failed to resolve signer"))})
+			results = append(results, core.BatchResult{ID: line.ID, Error: fmt.Sprintf("%v", errors.Wrap(err, "failed to resolve signer"))})
 			continue
 		}
 
@@ -299,17 +407,99 @@ func (s *service) Restore(ctx context.Context, archive io.Reader, from string, I
 			}
 		}
 		if err != nil {
-			results = append(results, core.BatchResult{ID: split[0], Error: fmt.Sprintf("%v", errors.Wrap(err, "failed to resolve key"))})
+			results = append(results, core.BatchResult{ID: line.ID, Error: fmt.Sprintf("%v", errors.Wrap(err, "failed to resolve key"))})
 			continue
 		}
 
-		_, err = s.Commit(ctx, core.CommitModeLocalOnlyExec, document, signature, "", keys, IP)
-		results = append(results, core.BatchResult{ID: split[0], Error: fmt.Sprintf("%v", err)})
+		_, err = s.Commit(ctx, core.CommitModeLocalOnlyExec, line.Document, line.Signature, "", keys, IP)
+		results = append(results, core.BatchResult{ID: line.ID, Error: fmt.Sprintf("%v", err)})
 	}
 
 	return results, nil
 }
 
+// fetchVerifiedHead returns the head of the owner's commit chain signed by the domain the log is restored from
+func (s *service) fetchVerifiedHead(ctx context.Context, domain, owner string) (core.CommitHeadDocument, error) {
+	ctx, span := tracer.Start(ctx, "Store.Service.fetchVerifiedHead")
+	defer span.End()
+
+	if domain == "" {
+		return core.CommitHeadDocument{}, fmt.Errorf("source domain is required to verify the commit log")
+	}
+
+	var head core.CommitHead
+	var csid string
+	if domain == s.config.FQDN {
+		local, err := s.GetHead(ctx, owner)
+		if err != nil {
+			span.RecordError(err)
+			return core.CommitHeadDocument{}, err
+		}
+		head = local
+		csid = s.config.CSID
+	} else {
+		remote, err := s.domain.GetByFQDN(ctx, domain)
+		if err != nil {
+			span.RecordError(err)
+			return core.CommitHeadDocument{}, err
+		}
+		csid = remote.CSID
+
+		head, err = s.client.GetRepositoryHead(ctx, domain, owner, nil)
+		if err != nil {
+			span.RecordError(err)
+			return core.CommitHeadDocument{}, err
+		}
+	}
+
+	var doc core.CommitHeadDocument
+	err := json.Unmarshal([]byte(head.Document), &doc)
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitHeadDocument{}, errors.Wrap(err, "failed to unmarshal head document")
+	}
+
+	if csid == "" || doc.Type != "commithead" || doc.Signer != csid || doc.Owner != owner {
+		return core.CommitHeadDocument{}, fmt.Errorf("head is not issued for %s by %s", owner, domain)
+	}
+
+	signature, err := hex.DecodeString(head.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitHeadDocument{}, errors.Wrap(err, "failed to decode head signature")
+	}
+
+	signer := doc.Signer
+	if doc.KeyID != "" {
+		var keys []core.Key
+		if domain == s.config.FQDN {
+			keys, err = s.key.GetKeyResolution(ctx, doc.KeyID)
+		} else {
+			keys, err = s.client.GetKey(ctx, domain, doc.KeyID, nil)
+		}
+		if err != nil {
+			span.RecordError(err)
+			return core.CommitHeadDocument{}, errors.Wrap(err, "failed to resolve operator key")
+		}
+
+		err = key.ValidateOperatorKey(keys, doc.KeyID, csid, doc.SignedAt)
+		if err != nil {
+			span.RecordError(err)
+			return core.CommitHeadDocument{}, errors.Wrap(err, "invalid operator key")
+		}
+
+		signer = doc.KeyID
+	}
+
+	err = core.VerifySignatureWithAlgorithm([]byte(head.Document), signature, signer, doc.Algorithm)
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitHeadDocument{}, errors.Wrap(err, "failed to verify head signature")
+	}
+
+	return doc, nil
+}
+
 func (s *service) ValidateDocument(ctx context.Context, document, signature string, keys []core.Key) error {
 	ctx, span := tracer.Start(ctx, "Key.Service.ValidateDocument")
 	defer span.End()
@@ -321,40 +511,77 @@ func (s *service) ValidateDocument(ctx context.Context, document, signature stri
 		return errors.Wrap(err, "failed to unmarshal payload")
 	}
 
+	// ガーディアンによって復旧されたエンティティの鍵 (マスターキー・サブキー共通):
+	// signedAtが復旧以降の文書は新しいマスターキーで、それより前の文書は元のCCIDで検証する (key.RootSigner)。
+	// signedAtは署名者が付けるので、古い鍵でも復旧前の日付を付ければ署名は通る。
+	// 復旧前に書かれたログをそのまま復元できるように、この遡りは受け入れる。
+
 	// マスターキーの場合: そのまま検証して終了
 	if object.KeyID == "" {
+		// 未登録以外の理由で解決できない場合は、古いマスターキーで通さないように失敗させる
+		masterKey := object.Signer
+		if core.IsCCID(object.Signer) {
+			signer, err := s.entity.Get(ctx, object.Signer)
+			if err != nil && !errors.Is(err, core.ErrorNotFound{}) {
+				span.RecordError(err)
+				return errors.Wrap(err, "[master] failed to resolve signer")
+			}
+			if err == nil {
+				masterKey = key.RootSigner(signer, object.SignedAt)
+			}
+		}
+
 		signatureBytes, err := hex.DecodeString(signature)
 		if err != nil {
 			span.RecordError(err)
 			return errors.Wrap(err, "[master] failed to decode signature")
 		}
-		err = core.VerifySignature([]byte(document), signatureBytes, object.Signer)
+		err = core.VerifySignatureWithAlgorithm([]byte(document), signatureBytes, masterKey, object.Algorithm)
 		if err != nil {
 			span.RecordError(err)
 			return errors.Wrap(err, "[master] failed to verify signature")
 		}
 	} else { // サブキーの場合: 親キーを取得して検証
 
-		signer, err := s.entity.Get(ctx, object.Signer)
-		if err != nil {
-			span.RecordError(err)
-			return errors.Wrap(err, "[sub] failed to resolve host")
+		// ドメインのCSIDはオペレーターのサブキーで署名できる
+		isLocal := false
+		rootSigner := ""
+		if core.IsCSID(object.Signer) {
+			isLocal = object.Signer == s.config.CSID
+		} else {
+			signer, err := s.entity.Get(ctx, object.Signer)
+			if err != nil {
+				span.RecordError(err)
+				return errors.Wrap(err, "[sub] failed to resolve host")
+			}
+			isLocal = signer.Domain == s.config.FQDN
+			rootSigner = key.RootSigner(signer, object.SignedAt)
 		}
 
 		ccid := ""
 
-		if signer.Domain == s.config.FQDN {
-			ccid, err = s.key.ResolveSubkey(ctx, object.KeyID)
+		if isLocal {
+			ccid, err = s.key.ResolveSubkey(ctx, object.KeyID, object.SignedAt)
 			if err != nil {
 				span.RecordError(err)
 				return errors.Wrap(err, "[sub] failed to resolve subkey")
 			}
+			keys, err = s.key.GetKeyResolution(ctx, object.KeyID)
+			if err != nil {
+				span.RecordError(err)
+				return errors.Wrap(err, "[sub] failed to get key resolution")
+			}
 		} else {
-			ccid, err = key.ValidateKeyResolution(keys)
+			ccid, err = key.ValidateKeyResolution(keys, object.SignedAt, rootSigner)
 			if err != nil {
 				span.RecordError(err)
 				return errors.Wrap(err, "[sub] failed to resolve remote subkey")
 			}
+			if len(keys) == 0 || keys[0].ID != object.KeyID {
+				err := fmt.Errorf("keychain does not start with the signing key")
+				span.RecordError(err)
+				return errors.Wrap(err, "[sub] failed to resolve remote subkey")
+			}
 		}
 
 		if ccid != object.Signer {
@@ -363,12 +590,18 @@ func (s *service) ValidateDocument(ctx context.Context, document, signature stri
 			return err
 		}
 
+		err = key.CheckKeyScope(keys, document)
+		if err != nil {
+			span.RecordError(err)
+			return errors.Wrap(err, "[sub] out of the key scope")
+		}
+
 		signatureBytes, err := hex.DecodeString(signature)
 		if err != nil {
 			span.RecordError(err)
 			return errors.Wrap(err, "[sub] failed to decode signature")
 		}
-		err = core.VerifySignature([]byte(document), signatureBytes, object.KeyID)
+		err = core.VerifySignatureWithAlgorithm([]byte(document), signatureBytes, object.KeyID, object.Algorithm)
 		if err != nil {
 			span.RecordError(err)
 			return errors.Wrap(err, "[sub] failed to verify signature")
@@ -454,3 +687,46 @@ func (s *service) SyncStatus(ctx context.Context, owner string) (core.SyncStatus
 
 	return s.repo.SyncStatus(ctx, owner)
 }
+
+// GetHead returns the head of the owner's commit chain signed by this domain
+func (s *service) GetHead(ctx context.Context, owner string) (core.CommitHead, error) {
+	ctx, span := tracer.Start(ctx, "Store.Service.GetHead")
+	defer span.End()
+
+	record, commit, err := s.repo.GetHead(ctx, owner)
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitHead{}, err
+	}
+
+	keyID, privateKey := s.config.DomainSigningKey()
+
+	documentObj := core.CommitHeadDocument{
+		Owner:      owner,
+		Head:       record.Hash,
+		DocumentID: commit.DocumentID,
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   s.config.CSID,
+			KeyID:    keyID,
+			Type:     "commithead",
+			SignedAt: time.Now(),
+		},
+	}
+
+	document, err := json.Marshal(documentObj)
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitHead{}, err
+	}
+
+	signatureBytes, err := core.SignBytes(document, privateKey)
+	if err != nil {
+		span.RecordError(err)
+		return core.CommitHead{}, err
+	}
+
+	return core.CommitHead{
+		Document:  string(document),
+		Signature: hex.EncodeToString(signatureBytes),
+	}, nil
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/client/mock"
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+	"github.com/totegamma/concurrent/x/store/mock"
+)
+
+const (
+	LocalFQDN      = "local.example.com"
+	LocalPriv      = "863183823d2c2a19101140eef0f905c872de1dae6470c9129a1547f3482cb612"
+	RemoteFQDN     = "remote.example.com"
+	RemotePriv     = "1ca30329e8d35217b2328bacfc21c5e3d762713edab0252eead1f4c1ac0b4d81"
+	UserPriv       = "3ea7b3ac5b3a7d8d1b8e1cf1b8a1a7f8e2a3c9b2b8e4b1d7a2f3c1e5d6a7b8c9"
+	NewMasterPriv  = "a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0"
+	testDocumentID = "doc"
+)
+
+func signDocument(t *testing.T, document any, priv string) (string, string) {
+	documentBytes, err := json.Marshal(document)
+	assert.NoError(t, err)
+	signature, err := core.SignBytes(documentBytes, priv)
+	assert.NoError(t, err)
+	return string(documentBytes), hex.EncodeToString(signature)
+}
+
+// TestRestoreAcrossRecovery restores a log that the entity kept writing after its guardians recovered it
+func TestRestoreAcrossRecovery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userID, err := core.PrivKeyToAddr(UserPriv, "con")
+	assert.NoError(t, err)
+	newMasterKey, err := core.PrivKeyToAddr(NewMasterPriv, "cck")
+	assert.NoError(t, err)
+	remoteCSID, err := core.PrivKeyToAddr(RemotePriv, "ccs")
+	assert.NoError(t, err)
+
+	recoveredAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	recovered := core.Entity{
+		ID:          userID,
+		Domain:      RemoteFQDN,
+		MasterKey:   &newMasterKey,
+		RecoveredAt: &recoveredAt,
+	}
+
+	message := func(body string, signedAt time.Time) core.MessageDocument[string] {
+		return core.MessageDocument[string]{
+			DocumentBase: core.DocumentBase[string]{
+				Signer:   userID,
+				Type:     "message",
+				Body:     body,
+				SignedAt: signedAt,
+			},
+			Timelines: []string{},
+		}
+	}
+
+	// 復旧前は元の鍵、復旧後は新しいマスターキーで署名されている
+	before, beforeSig := signDocument(t, message("before", recoveredAt.Add(-time.Hour)), UserPriv)
+	after, afterSig := signDocument(t, message("after", recoveredAt.Add(time.Minute)), NewMasterPriv)
+	// 古い鍵で復旧後の日付を付けたものは通らない
+	forged, forgedSig := signDocument(t, message("forged", recoveredAt.Add(2*time.Minute)), UserPriv)
+
+	var archive bytes.Buffer
+	previous := ""
+	for i, doc := range [][2]string{{before, beforeSig}, {after, afterSig}, {forged, forgedSig}} {
+		id := testDocumentID + string(rune('a'+i))
+		hash := commitHash(previous, id, userID, doc[1], doc[0])
+		archive.WriteString(commitLine{ID: id, Owner: userID, Signature: doc[1], Hash: hash, Document: doc[0]}.String() + "\n")
+		previous = hash
+	}
+
+	headDoc, headSig := signDocument(t, core.CommitHeadDocument{
+		DocumentBase: core.DocumentBase[any]{
+			Signer:   remoteCSID,
+			Type:     "commithead",
+			SignedAt: time.Now(),
+		},
+		Owner: userID,
+		Head:  previous,
+	}, RemotePriv)
+
+	mockRepo := mock_store.NewMockRepository(ctrl)
+	mockEntity := mock_core.NewMockEntityService(ctrl)
+	mockMessage := mock_core.NewMockMessageService(ctrl)
+	mockDomain := mock_core.NewMockDomainService(ctrl)
+	mockClient := mock_client.NewMockClient(ctrl)
+
+	mockDomain.EXPECT().GetByFQDN(gomock.Any(), RemoteFQDN).Return(core.Domain{ID: RemoteFQDN, CSID: remoteCSID}, nil)
+	mockClient.EXPECT().GetRepositoryHead(gomock.Any(), RemoteFQDN, userID, gomock.Any()).Return(core.CommitHead{Document: headDoc, Signature: headSig}, nil)
+	mockEntity.EXPECT().GetWithHint(gomock.Any(), userID, RemoteFQDN).Return(recovered, nil).AnyTimes()
+	mockEntity.EXPECT().Get(gomock.Any(), userID).Return(recovered, nil).AnyTimes()
+
+	created := []string{}
+	mockMessage.EXPECT().Create(gomock.Any(), core.CommitModeLocalOnlyExec, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ core.CommitMode, document, _ string) (core.Message, []string, error) {
+			created = append(created, document)
+			return core.Message{Author: userID}, []string{userID}, nil
+		}).Times(2)
+	mockRepo.EXPECT().Log(gomock.Any(), gomock.Any()).Return(core.CommitLog{}, nil).Times(2)
+
+	config := core.SetupConfig(core.ConfigInput{FQDN: LocalFQDN, PrivateKey: LocalPriv})
+	s := NewService(mockRepo, nil, mockEntity, mockMessage, nil, nil, nil, nil, nil, nil, nil, mockDomain, mockClient, config, "")
+
+	results, err := s.Restore(context.Background(), strings.NewReader(archive.String()), RemoteFQDN, "")
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "<nil>", results[0].Error)
+	assert.Equal(t, "<nil>", results[1].Error)
+	assert.Contains(t, results[2].Error, "failed to verify signature")
+	assert.Equal(t, []string{before, after}, created)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/totegamma/concurrent/core"
 	"gorm.io/gorm"
 )
@@ -20,16 +21,19 @@ type Repository interface {
 	CreateItem(ctx context.Context, item core.SubscriptionItem) (core.SubscriptionItem, error)
 	GetItem(ctx context.Context, id string, subscription string) (core.SubscriptionItem, error)
 	DeleteItem(ctx context.Context, id string, subscription string) error
+
+	PublishChanged(ctx context.Context, subscription string) error
 }
 
 type repository struct {
 	db     *gorm.DB
+	rdb    *redis.Client
 	schema core.SchemaService
 }
 
 // NewRepository creates a new collection repository
-func NewRepository(db *gorm.DB, schema core.SchemaService) Repository {
-	return &repository{db, schema}
+func NewRepository(db *gorm.DB, rdb *redis.Client, schema core.SchemaService) Repository {
+	return &repository{db, rdb, schema}
 }
 
 func (r *repository) normalizeDBID(id string) (string, error) {
@@ -297,3 +301,17 @@ func (r *repository) DeleteItem(ctx context.Context, id, subscription string) er
 
 	return err
 }
+
+// PublishChanged notifies realtime listeners that the items of the subscription are changed
+func (r *repository) PublishChanged(ctx context.Context, subscription string) error {
+	ctx, span := tracer.Start(ctx, "Subscription.Repository.PublishChanged")
+	defer span.End()
+
+	err := r.rdb.Publish(ctx, core.SubscriptionChangedChannelPrefix+subscription, subscription).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
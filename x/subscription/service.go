@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"strings"
 
 	"github.com/totegamma/concurrent/cdid"
@@ -11,6 +12,14 @@ import (
 	"go.opentelemetry.io/otel/codes"
 )
 
+func init() {
+	core.RegisterPolicyAction(
+		"subscription.create",
+		"subscription.delete",
+		"subscription.update",
+	)
+}
+
 type service struct {
 	repo   Repository
 	entity core.EntityService
@@ -52,6 +61,15 @@ func (s *service) UpsertSubscription(ctx context.Context, mode core.CommitMode,
 		doc.Owner = doc.Signer
 	}
 
+	// リストアやリレーでは取得を伴う検証をしない
+	if doc.Policy != "" && mode != core.CommitModeLocalOnlyExec {
+		err = s.policy.ValidateWithPolicyURL(ctx, doc.Policy)
+		if err != nil {
+			span.RecordError(err)
+			return core.Subscription{}, err
+		}
+	}
+
 	if doc.ID == "" { // New
 		hash := core.GetHash([]byte(document))
 		hash10 := [10]byte{}
@@ -211,7 +229,16 @@ func (s *service) DeleteSubscription(ctx context.Context, mode core.CommitMode,
 		return core.Subscription{}, err
 	}
 
-	return deleteTarget, err
+	err = s.repo.PublishChanged(ctx, deleteTarget.ID)
+	if err != nil {
+		slog.WarnContext(
+			ctx, "failed to publish subscription change",
+			slog.String("error", err.Error()),
+			slog.String("module", "subscription"),
+		)
+	}
+
+	return deleteTarget, nil
 }
 
 // GetOwnSubscriptions returns all subscriptions owned by the owner
@@ -270,6 +297,15 @@ func (s *service) Subscribe(ctx context.Context, mode core.CommitMode, document,
 		return created, err
 	}
 
+	err = s.repo.PublishChanged(ctx, subscription.ID)
+	if err != nil {
+		slog.WarnContext(
+			ctx, "failed to publish subscription change",
+			slog.String("error", err.Error()),
+			slog.String("module", "subscription"),
+		)
+	}
+
 	return created, nil
 }
 
@@ -302,8 +338,21 @@ func (s *service) Unsubscribe(ctx context.Context, mode core.CommitMode, documen
 	}
 
 	err = s.repo.DeleteItem(ctx, doc.Target, doc.Subscription)
+	if err != nil {
+		span.RecordError(err)
+		return item, err
+	}
+
+	err = s.repo.PublishChanged(ctx, subscription.ID)
+	if err != nil {
+		slog.WarnContext(
+			ctx, "failed to publish subscription change",
+			slog.String("error", err.Error()),
+			slog.String("module", "subscription"),
+		)
+	}
 
-	return item, err
+	return item, nil
 }
 
 func (s *service) Clean(ctx context.Context, ccid string) error {
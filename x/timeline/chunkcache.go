@@ -0,0 +1,525 @@
+package timeline
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	ChunkCacheMemcached = "memcached"
+	ChunkCacheRedis     = "redis"
+	ChunkCacheLRU       = "lru"
+
+	defaultLRUChunkCacheSize = 100000
+)
+
+// NewChunkCache creates the chunk cache selected by config ("memcached" by default)
+func NewChunkCache(kind string, rdb *redis.Client, mc *memcache.Client, size int) (core.ChunkCache, error) {
+	switch kind {
+	case "", ChunkCacheMemcached:
+		return NewMemcachedChunkCache(mc), nil
+	case ChunkCacheRedis:
+		return NewRedisChunkCache(rdb), nil
+	case ChunkCacheLRU:
+		return NewLRUChunkCache(size), nil
+	default:
+		return nil, fmt.Errorf("unknown chunk cache: %s", kind)
+	}
+}
+
+func chunkItrKey(timeline, epoch string) string {
+	return tlItrCachePrefix + timeline + ":" + epoch
+}
+
+func chunkBodyKey(timeline, epoch string) string {
+	return tlBodyCachePrefix + timeline + ":" + epoch
+}
+
+// bodies are stored as ",item,item..." so that a new item can be prepended without decoding
+func encodeChunkItem(item core.TimelineItem) (string, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	return "," + string(b), nil
+}
+
+func encodeChunkBody(items []core.TimelineItem) (string, error) {
+	if items == nil {
+		items = []core.TimelineItem{}
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	return "," + string(b[1:len(b)-1]), nil
+}
+
+func decodeChunkBody(value string) ([]core.TimelineItem, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("invalid chunk body")
+	}
+	var items []core.TimelineItem
+	err := json.Unmarshal([]byte("["+value[1:]+"]"), &items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// addItemToChunkCache reflects a newly inserted item to the cache.
+// When the item is older than the latest chunk, iterators of the chunks after it may point past the item,
+// so they are handed to invalidate instead of being deleted on the write path.
+func addItemToChunkCache(ctx context.Context, cache core.ChunkCache, config core.Config, invalidate func(timeline, epoch string), timeline string, item core.TimelineItem) error {
+	epoch := config.Time2Chunk(item.CDate)
+
+	if core.EpochTime(config.Time2Chunk(time.Now())).After(core.EpochTime(epoch)) {
+		invalidate(timeline, epoch)
+	}
+
+	err := cache.SetItr(ctx, timeline, epoch, epoch)
+	if err != nil {
+		return err
+	}
+
+	return cache.PrependBody(ctx, timeline, epoch, item)
+}
+
+// chunkInvalidator invalidates iterators of old chunks in the background.
+// Requests for the same timeline are merged into the oldest epoch, and a single worker processes them.
+type chunkInvalidator struct {
+	mu      sync.Mutex
+	cache   core.ChunkCache
+	config  core.Config
+	pending map[string]string // timeline -> oldest epoch
+	notify  chan struct{}
+}
+
+func newChunkInvalidator(cache core.ChunkCache, config core.Config) *chunkInvalidator {
+	return &chunkInvalidator{
+		cache:   cache,
+		config:  config,
+		pending: make(map[string]string),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Enqueue schedules the invalidation of iterators newer than epoch. It never blocks.
+func (i *chunkInvalidator) Enqueue(timeline, epoch string) {
+	i.mu.Lock()
+	current, ok := i.pending[timeline]
+	if !ok || core.EpochTime(epoch).Before(core.EpochTime(current)) {
+		i.pending[timeline] = epoch
+	}
+	i.mu.Unlock()
+
+	select {
+	case i.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (i *chunkInvalidator) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-i.notify:
+			i.flush(ctx)
+		}
+	}
+}
+
+// limit returns the number of chunks covered by the iterator TTL.
+// iterators older than this are left until they expire
+func (i *chunkInvalidator) limit() int {
+	length := i.config.LocalChunkLength()
+	return int((tlItrCacheTTL+length-1)/length) + 1
+}
+
+// flush invalidates every pending request
+func (i *chunkInvalidator) flush(ctx context.Context) {
+	i.mu.Lock()
+	pending := i.pending
+	i.pending = make(map[string]string)
+	i.mu.Unlock()
+
+	limit := i.limit()
+	for timeline, epoch := range pending {
+		oldest := core.EpochTime(epoch)
+
+		stale := make([]string, 0)
+		for e := i.config.Time2Chunk(time.Now()); core.EpochTime(e).After(oldest) && len(stale) < limit; e = i.config.PrevChunk(e) {
+			stale = append(stale, e)
+		}
+
+		err := i.cache.InvalidateItrs(ctx, timeline, stale)
+		if err != nil {
+			slog.ErrorContext(
+				ctx, "failed to invalidate chunk iterators",
+				slog.String("error", err.Error()),
+				slog.String("timeline", timeline),
+				slog.String("module", "timeline"),
+			)
+		}
+	}
+}
+
+// ---
+
+type memcachedChunkCache struct {
+	mc *memcache.Client
+}
+
+// NewMemcachedChunkCache creates a ChunkCache backed by memcached
+func NewMemcachedChunkCache(mc *memcache.Client) core.ChunkCache {
+	return &memcachedChunkCache{mc: mc}
+}
+
+func (c *memcachedChunkCache) GetItrs(ctx context.Context, timelines []string, epoch string) (map[string]string, error) {
+	keys := make([]string, len(timelines))
+	for i, timeline := range timelines {
+		keys[i] = chunkItrKey(timeline, epoch)
+	}
+
+	cache, err := c.mc.GetMulti(keys)
+
+	result := make(map[string]string)
+	for i, timeline := range timelines {
+		if item, ok := cache[keys[i]]; ok {
+			result[timeline] = string(item.Value)
+		}
+	}
+
+	return result, err
+}
+
+func (c *memcachedChunkCache) SetItr(ctx context.Context, timeline, epoch, itr string) error {
+	return c.mc.Set(&memcache.Item{Key: chunkItrKey(timeline, epoch), Value: []byte(itr), Expiration: tlItrCacheTTL})
+}
+
+func (c *memcachedChunkCache) GetBodies(ctx context.Context, query map[string]string) (map[string][]core.TimelineItem, error) {
+	keys := make([]string, 0, len(query))
+	keytable := make(map[string]string)
+	for timeline, epoch := range query {
+		key := chunkBodyKey(timeline, epoch)
+		keys = append(keys, key)
+		keytable[key] = timeline
+	}
+
+	cache, err := c.mc.GetMulti(keys)
+
+	result := make(map[string][]core.TimelineItem)
+	for key, item := range cache {
+		items, err := decodeChunkBody(string(item.Value))
+		if err != nil {
+			continue
+		}
+		result[keytable[key]] = items
+	}
+
+	return result, err
+}
+
+func (c *memcachedChunkCache) SetBody(ctx context.Context, timeline, epoch string, items []core.TimelineItem) error {
+	value, err := encodeChunkBody(items)
+	if err != nil {
+		return err
+	}
+	return c.mc.Set(&memcache.Item{Key: chunkBodyKey(timeline, epoch), Value: []byte(value), Expiration: tlBodyCacheTTL})
+}
+
+func (c *memcachedChunkCache) PrependBody(ctx context.Context, timeline, epoch string, item core.TimelineItem) error {
+	value, err := encodeChunkItem(item)
+	if err != nil {
+		return err
+	}
+	err = c.mc.Prepend(&memcache.Item{Key: chunkBodyKey(timeline, epoch), Value: []byte(value)})
+	if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+func (c *memcachedChunkCache) InvalidateItrs(ctx context.Context, timeline string, epochs []string) error {
+	for _, epoch := range epochs {
+		err := c.mc.Delete(chunkItrKey(timeline, epoch))
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---
+
+type redisChunkCache struct {
+	rdb *redis.Client
+}
+
+// NewRedisChunkCache creates a ChunkCache backed by redis
+func NewRedisChunkCache(rdb *redis.Client) core.ChunkCache {
+	return &redisChunkCache{rdb: rdb}
+}
+
+// prependScript emulates memcached prepend. KEEPTTL needs redis 6.0+
+var prependScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1] .. current, "KEEPTTL")
+return 1
+`)
+
+func (c *redisChunkCache) GetItrs(ctx context.Context, timelines []string, epoch string) (map[string]string, error) {
+	result := make(map[string]string)
+	if len(timelines) == 0 {
+		return result, nil
+	}
+
+	keys := make([]string, len(timelines))
+	for i, timeline := range timelines {
+		keys[i] = chunkItrKey(timeline, epoch)
+	}
+
+	values, err := c.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return result, err
+	}
+
+	for i, value := range values {
+		if itr, ok := value.(string); ok {
+			result[timelines[i]] = itr
+		}
+	}
+
+	return result, nil
+}
+
+func (c *redisChunkCache) SetItr(ctx context.Context, timeline, epoch, itr string) error {
+	return c.rdb.Set(ctx, chunkItrKey(timeline, epoch), itr, tlItrCacheTTL*time.Second).Err()
+}
+
+func (c *redisChunkCache) GetBodies(ctx context.Context, query map[string]string) (map[string][]core.TimelineItem, error) {
+	result := make(map[string][]core.TimelineItem)
+	if len(query) == 0 {
+		return result, nil
+	}
+
+	timelines := make([]string, 0, len(query))
+	keys := make([]string, 0, len(query))
+	for timeline, epoch := range query {
+		timelines = append(timelines, timeline)
+		keys = append(keys, chunkBodyKey(timeline, epoch))
+	}
+
+	values, err := c.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return result, err
+	}
+
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		items, err := decodeChunkBody(str)
+		if err != nil {
+			continue
+		}
+		result[timelines[i]] = items
+	}
+
+	return result, nil
+}
+
+func (c *redisChunkCache) SetBody(ctx context.Context, timeline, epoch string, items []core.TimelineItem) error {
+	value, err := encodeChunkBody(items)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, chunkBodyKey(timeline, epoch), value, tlBodyCacheTTL*time.Second).Err()
+}
+
+func (c *redisChunkCache) PrependBody(ctx context.Context, timeline, epoch string, item core.TimelineItem) error {
+	value, err := encodeChunkItem(item)
+	if err != nil {
+		return err
+	}
+	return prependScript.Run(ctx, c.rdb, []string{chunkBodyKey(timeline, epoch)}, value).Err()
+}
+
+func (c *redisChunkCache) InvalidateItrs(ctx context.Context, timeline string, epochs []string) error {
+	if len(epochs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(epochs))
+	for i, epoch := range epochs {
+		keys[i] = chunkItrKey(timeline, epoch)
+	}
+	return c.rdb.Del(ctx, keys...).Err()
+}
+
+// ---
+
+type lruEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+type lruChunkCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewLRUChunkCache creates an in-process ChunkCache holding up to size entries.
+// It is not shared between processes, so use it only with a single api instance.
+func NewLRUChunkCache(size int) core.ChunkCache {
+	if size <= 0 {
+		size = defaultLRUChunkCacheSize
+	}
+	return &lruChunkCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get must be called with the lock held
+func (c *lruChunkCache) get(key string) (string, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// set must be called with the lock held
+func (c *lruChunkCache) set(key, value string, ttl time.Duration) {
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expires = time.Now().Add(ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruChunkCache) GetItrs(ctx context.Context, timelines []string, epoch string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string]string)
+	for _, timeline := range timelines {
+		if itr, ok := c.get(chunkItrKey(timeline, epoch)); ok {
+			result[timeline] = itr
+		}
+	}
+	return result, nil
+}
+
+func (c *lruChunkCache) SetItr(ctx context.Context, timeline, epoch, itr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(chunkItrKey(timeline, epoch), itr, tlItrCacheTTL*time.Second)
+	return nil
+}
+
+func (c *lruChunkCache) GetBodies(ctx context.Context, query map[string]string) (map[string][]core.TimelineItem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string][]core.TimelineItem)
+	for timeline, epoch := range query {
+		value, ok := c.get(chunkBodyKey(timeline, epoch))
+		if !ok {
+			continue
+		}
+		items, err := decodeChunkBody(value)
+		if err != nil {
+			continue
+		}
+		result[timeline] = items
+	}
+	return result, nil
+}
+
+func (c *lruChunkCache) SetBody(ctx context.Context, timeline, epoch string, items []core.TimelineItem) error {
+	value, err := encodeChunkBody(items)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(chunkBodyKey(timeline, epoch), value, tlBodyCacheTTL*time.Second)
+	return nil
+}
+
+func (c *lruChunkCache) PrependBody(ctx context.Context, timeline, epoch string, item core.TimelineItem) error {
+	value, err := encodeChunkItem(item)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := chunkBodyKey(timeline, epoch)
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		return nil
+	}
+	entry.value = value + entry.value
+	c.order.MoveToFront(elem)
+	return nil
+}
+
+func (c *lruChunkCache) InvalidateItrs(ctx context.Context, timeline string, epochs []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, epoch := range epochs {
+		key := chunkItrKey(timeline, epoch)
+		if elem, ok := c.entries[key]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
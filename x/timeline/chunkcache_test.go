@@ -0,0 +1,155 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/internal/testutil"
+)
+
+// testChunkCache is the conformance suite shared by all ChunkCache implementations
+func testChunkCache(t *testing.T, cache core.ChunkCache) {
+	timeline := "t00000000000000000000000000@local.example.com"
+	other := "t11111111111111111111111111@local.example.com"
+
+	t.Run("itr", func(t *testing.T) {
+		itrs, err := cache.GetItrs(ctx, []string{timeline, other}, "6000")
+		assert.NoError(t, err)
+		assert.Empty(t, itrs)
+
+		assert.NoError(t, cache.SetItr(ctx, timeline, "6000", "5400"))
+		itrs, err = cache.GetItrs(ctx, []string{timeline, other}, "6000")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{timeline: "5400"}, itrs)
+	})
+
+	t.Run("body", func(t *testing.T) {
+		// キャッシュされていないbodyにはprependしない
+		assert.NoError(t, cache.PrependBody(ctx, timeline, "5400", core.TimelineItem{ResourceID: "m00000000000000000000000000"}))
+		bodies, err := cache.GetBodies(ctx, map[string]string{timeline: "5400"})
+		assert.NoError(t, err)
+		assert.Empty(t, bodies)
+
+		assert.NoError(t, cache.SetBody(ctx, timeline, "5400", []core.TimelineItem{{ResourceID: "m11111111111111111111111111"}}))
+		assert.NoError(t, cache.SetBody(ctx, other, "5400", []core.TimelineItem{}))
+		assert.NoError(t, cache.PrependBody(ctx, timeline, "5400", core.TimelineItem{ResourceID: "m22222222222222222222222222"}))
+
+		bodies, err = cache.GetBodies(ctx, map[string]string{timeline: "5400", other: "5400"})
+		assert.NoError(t, err)
+		if assert.Len(t, bodies[timeline], 2) {
+			assert.Equal(t, "m22222222222222222222222222", bodies[timeline][0].ResourceID)
+			assert.Equal(t, "m11111111111111111111111111", bodies[timeline][1].ResourceID)
+		}
+		assert.Contains(t, bodies, other)
+		assert.Empty(t, bodies[other])
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		assert.NoError(t, cache.InvalidateItrs(ctx, timeline, []string{"5400", "6000"}))
+
+		itrs, err := cache.GetItrs(ctx, []string{timeline}, "6000")
+		assert.NoError(t, err)
+		assert.Empty(t, itrs)
+
+		// bodyは消さない
+		bodies, err := cache.GetBodies(ctx, map[string]string{timeline: "5400"})
+		assert.NoError(t, err)
+		assert.Len(t, bodies[timeline], 2)
+	})
+
+	t.Run("addItem", func(t *testing.T) {
+		config := core.Config{}
+		latest := config.Time2Chunk(time.Now())
+		prev := config.PrevChunk(latest)
+		old := config.PrevChunk(prev)
+
+		// 新しいチャンクのイテレーターは古いチャンクを向いている
+		assert.NoError(t, cache.SetItr(ctx, timeline, latest, old))
+		assert.NoError(t, cache.SetItr(ctx, timeline, prev, old))
+		assert.NoError(t, cache.SetItr(ctx, timeline, old, old))
+		assert.NoError(t, cache.SetBody(ctx, timeline, old, []core.TimelineItem{{ResourceID: "m00000000000000000000000000"}}))
+
+		// 古いチャンクへの挿入
+		invalidator := newChunkInvalidator(cache, config)
+		err := addItemToChunkCache(ctx, cache, config, invalidator.Enqueue, timeline, core.TimelineItem{
+			ResourceID: "m33333333333333333333333333",
+			CDate:      core.EpochTime(old).Add(time.Second),
+		})
+		assert.NoError(t, err)
+
+		// 無効化は書き込みとは別に行われる
+		itrs, err := cache.GetItrs(ctx, []string{timeline}, latest)
+		assert.NoError(t, err)
+		assert.Equal(t, old, itrs[timeline])
+		assert.Equal(t, map[string]string{timeline: old}, invalidator.pending)
+
+		invalidator.flush(ctx)
+		assert.Empty(t, invalidator.pending)
+
+		itrs, err = cache.GetItrs(ctx, []string{timeline}, latest)
+		assert.NoError(t, err)
+		assert.Empty(t, itrs)
+		itrs, err = cache.GetItrs(ctx, []string{timeline}, prev)
+		assert.NoError(t, err)
+		assert.Empty(t, itrs)
+		itrs, err = cache.GetItrs(ctx, []string{timeline}, old)
+		assert.NoError(t, err)
+		assert.Equal(t, old, itrs[timeline])
+
+		bodies, err := cache.GetBodies(ctx, map[string]string{timeline: old})
+		assert.NoError(t, err)
+		if assert.Len(t, bodies[timeline], 2) {
+			assert.Equal(t, "m33333333333333333333333333", bodies[timeline][0].ResourceID)
+		}
+
+		// 最新チャンクへの挿入はイテレーターを向け直す
+		err = addItemToChunkCache(ctx, cache, config, invalidator.Enqueue, timeline, core.TimelineItem{
+			ResourceID: "m44444444444444444444444444",
+			CDate:      time.Now(),
+		})
+		assert.NoError(t, err)
+		itrs, err = cache.GetItrs(ctx, []string{timeline}, latest)
+		assert.NoError(t, err)
+		assert.Equal(t, latest, itrs[timeline])
+		assert.Empty(t, invalidator.pending)
+	})
+}
+
+func TestChunkInvalidatorLimit(t *testing.T) {
+	// イテレーターのTTLの間に作られたチャンクは全て無効化の対象になる
+	assert.Equal(t, 289, newChunkInvalidator(nil, core.Config{}).limit())
+	assert.Equal(t, 2881, newChunkInvalidator(nil, core.Config{ChunkLength: 60}).limit())
+	assert.Equal(t, 2, newChunkInvalidator(nil, core.Config{ChunkLength: 60 * 60 * 24 * 7}).limit())
+}
+
+func TestLRUChunkCache(t *testing.T) {
+	testChunkCache(t, NewLRUChunkCache(0))
+
+	// 溢れたら古いものから消える
+	cache := NewLRUChunkCache(2)
+	cache.SetItr(ctx, "a", "0", "0")
+	cache.SetItr(ctx, "b", "0", "0")
+	cache.GetItrs(ctx, []string{"a"}, "0")
+	cache.SetItr(ctx, "c", "0", "0")
+
+	itrs, err := cache.GetItrs(ctx, []string{"a", "b", "c"}, "0")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "0", "c": "0"}, itrs)
+}
+
+func TestMemcachedChunkCache(t *testing.T) {
+	mc, cleanup := testutil.CreateMC()
+	defer cleanup()
+
+	testChunkCache(t, NewMemcachedChunkCache(mc))
+}
+
+func TestRedisChunkCache(t *testing.T) {
+	rdb, cleanup := testutil.CreateRDB()
+	defer cleanup()
+
+	testChunkCache(t, NewRedisChunkCache(rdb))
+}
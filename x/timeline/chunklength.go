@@ -0,0 +1,85 @@
+package timeline
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	// maxChunkTranslationRounds bounds the number of remote chunks fetched to fill one local chunk
+	maxChunkTranslationRounds = 64
+)
+
+// translateRemoteItr converts an iterator returned by a remote domain into a local epoch.
+// The result never points past the local epoch the lookup was made for.
+func translateRemoteItr(itr, epoch string, remoteLength, localLength int64) string {
+	local := core.TranslateChunk(itr, remoteLength, localLength)
+
+	l, err1 := strconv.ParseInt(local, 10, 64)
+	e, err2 := strconv.ParseInt(epoch, 10, 64)
+	if err1 != nil || err2 != nil || l > e {
+		return epoch
+	}
+	return local
+}
+
+// remoteBody accumulates items of a local chunk from remote chunks of a different length
+type remoteBody struct {
+	epoch  string // local epoch
+	length int64  // local chunk length
+	items  []core.TimelineItem
+	seen   map[string]bool
+}
+
+func newRemoteBody(epoch string, length int64) *remoteBody {
+	return &remoteBody{
+		epoch:  epoch,
+		length: length,
+		items:  []core.TimelineItem{},
+		seen:   make(map[string]bool),
+	}
+}
+
+// add merges the remote chunk body of remoteEpoch.
+// It returns the next remote epoch to fetch, or false when the local chunk is fully covered.
+//
+// A remote chunk body holds every item of its own range, so everything newer than
+// min(oldest item, remote epoch) has been seen after merging it.
+func (b *remoteBody) add(remoteEpoch string, remoteLength int64, items []core.TimelineItem) (string, bool) {
+	end := core.Chunk2RecentTimeWithLength(b.epoch, b.length)
+	for _, item := range items {
+		if item.CDate.After(end) || b.seen[item.ResourceID] {
+			continue
+		}
+		b.seen[item.ResourceID] = true
+		b.items = append(b.items, item)
+	}
+
+	if len(items) == 0 {
+		return "", false
+	}
+
+	covered := core.EpochTime(remoteEpoch)
+	if oldest := items[len(items)-1].CDate; oldest.Before(covered) {
+		covered = oldest
+	}
+	if !covered.After(core.EpochTime(b.epoch)) {
+		return "", false
+	}
+
+	return core.Time2ChunkWithLength(covered.Add(-time.Second), remoteLength), true
+}
+
+func (b *remoteBody) chunk(timeline string) core.Chunk {
+	sort.SliceStable(b.items, func(i, j int) bool {
+		return b.items[i].CDate.After(b.items[j].CDate)
+	})
+	return core.Chunk{
+		Key:   chunkBodyKey(timeline, b.epoch),
+		Epoch: b.epoch,
+		Items: b.items,
+	}
+}
@@ -0,0 +1,61 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+func TestTranslateRemoteItr(t *testing.T) {
+	// remote: 3600s, local: 600s
+	assert.Equal(t, "3000", translateRemoteItr("0", "4200", 3600, 600))
+	// ローカルの問い合わせより先は指さない
+	assert.Equal(t, "4200", translateRemoteItr("3600", "4200", 3600, 600))
+	// remote: 100s
+	assert.Equal(t, "3600", translateRemoteItr("3600", "4200", 100, 600))
+}
+
+func TestRemoteBody(t *testing.T) {
+	item := func(id string, cdate int64) core.TimelineItem {
+		return core.TimelineItem{ResourceID: id, CDate: time.Unix(cdate, 0)}
+	}
+
+	// local: 600s (1200-1800), remote: 100s
+	body := newRemoteBody("1200", 600)
+	remoteEpoch := core.TranslateChunk("1200", 600, 100)
+	assert.Equal(t, "1700", remoteEpoch)
+
+	next, more := body.add(remoteEpoch, 100, []core.TimelineItem{
+		item("m1", 1850), // ローカルのチャンクより新しい
+		item("m2", 1750),
+		item("m3", 1720),
+	})
+	assert.True(t, more)
+	assert.Equal(t, "1600", next)
+
+	next, more = body.add(next, 100, []core.TimelineItem{
+		item("m3", 1720),
+		item("m4", 1650),
+		item("m5", 1100),
+	})
+	assert.False(t, more)
+	assert.Equal(t, "", next)
+
+	chunk := body.chunk("t00000000000000000000000000@remote.example.com")
+	assert.Equal(t, "1200", chunk.Epoch)
+	assert.Equal(t, "tl:body:t00000000000000000000000000@remote.example.com:1200", chunk.Key)
+
+	ids := []string{}
+	for _, it := range chunk.Items {
+		ids = append(ids, it.ResourceID)
+	}
+	assert.Equal(t, []string{"m2", "m3", "m4", "m5"}, ids)
+
+	// 空のチャンクはそれ以上古いアイテムがない
+	body = newRemoteBody("1200", 600)
+	_, more = body.add("1700", 100, []core.TimelineItem{})
+	assert.False(t, more)
+}
@@ -0,0 +1,157 @@
+package timeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	// eventStreamLength is the approximate number of events kept for replay per timeline
+	eventStreamLength = 1000
+	// eventStreamTTL is how long an idle timeline keeps its replay window, and therefore its sequence
+	eventStreamTTL = 24 * time.Hour
+)
+
+// publishEventScript assigns the next sequence number to the event,
+// appends it to the bounded replay stream and publishes it in one step.
+// the sequence is derived from the last entry of the stream, so the stream id and the sequence can not drift apart.
+// ARGV[1] is the marshalled event without seq.
+var publishEventScript = redis.NewScript(`
+local seq = 1
+local last = redis.call("XREVRANGE", KEYS[1], "+", "-", "COUNT", 1)
+if #last > 0 then
+	seq = tonumber(string.match(last[1][1], "^(%d+)")) + 1
+end
+local payload = '{"seq":' .. seq .. ',' .. string.sub(ARGV[1], 2)
+redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[2], seq .. "-0", "event", payload)
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+redis.call("PUBLISH", ARGV[4], payload)
+return seq
+`)
+
+func eventStreamKey(timeline string) string {
+	return "tl:stream:{" + timeline + "}"
+}
+
+// publishEvent publishes the event to the timeline channel and returns its sequence number
+func publishEvent(ctx context.Context, rdb *redis.Client, event core.Event) (int64, error) {
+	event.Seq = 0
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	return publishEventScript.Run(
+		ctx,
+		rdb,
+		[]string{eventStreamKey(event.Timeline)},
+		string(payload),
+		eventStreamLength,
+		int(eventStreamTTL.Seconds()),
+		event.Timeline,
+	).Int64()
+}
+
+// parseStreamSeq extracts the sequence number from a stream entry id ("<seq>-0")
+func parseStreamSeq(id string) (int64, error) {
+	seq, _, _ := strings.Cut(id, "-")
+	return strconv.ParseInt(seq, 10, 64)
+}
+
+// lastEventSeq returns the sequence of the latest event of the timeline, or 0 if the stream is empty
+func lastEventSeq(ctx context.Context, rdb *redis.Client, timeline string) (int64, error) {
+	entries, err := rdb.XRevRangeN(ctx, eventStreamKey(timeline), "+", "-", 1).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	return parseStreamSeq(entries[0].ID)
+}
+
+// timelineCursorKey shortens the timeline id used as a key of the resume cursor
+func timelineCursorKey(timeline string) string {
+	hash := sha256.Sum256([]byte(timeline))
+	return hex.EncodeToString(hash[:6])
+}
+
+// findGap reports the first sequence that can be delivered when the client missed events.
+// first is the sequence of the oldest retained event after since, or 0 if none are retained.
+func findGap(since, current, first int64) (int64, bool) {
+	if since > current { // sequence was reset
+		return current + 1, true
+	}
+	if since == current {
+		return 0, false
+	}
+	if first == 0 {
+		return current + 1, true
+	}
+	if first > since+1 {
+		return first, true
+	}
+	return 0, false
+}
+
+// replayEvents returns the events published to the timeline after since.
+// If some of them have been trimmed from the stream, a gap event is prepended.
+// The returned sequence is the latest one covered by the replay.
+func replayEvents(ctx context.Context, rdb *redis.Client, timeline string, since int64) ([]core.Event, int64, error) {
+	current, err := lastEventSeq(ctx, rdb, timeline)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if since >= current {
+		if gap, ok := findGap(since, current, 0); ok {
+			return []core.Event{{Type: core.EventTypeGap, Timeline: timeline, Seq: gap}}, current, nil
+		}
+		return nil, current, nil
+	}
+
+	entries, err := rdb.XRange(ctx, eventStreamKey(timeline), fmt.Sprintf("%d-0", since+1), "+").Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := make([]core.Event, 0, len(entries)+1)
+	var first int64
+	if len(entries) > 0 {
+		first, err = parseStreamSeq(entries[0].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if gap, ok := findGap(since, current, first); ok {
+		events = append(events, core.Event{Type: core.EventTypeGap, Timeline: timeline, Seq: gap})
+	}
+
+	for _, entry := range entries {
+		payload, ok := entry.Values["event"].(string)
+		if !ok {
+			continue
+		}
+		var event core.Event
+		err := json.Unmarshal([]byte(payload), &event)
+		if err != nil {
+			continue
+		}
+		events = append(events, event)
+		if event.Seq > current {
+			current = event.Seq
+		}
+	}
+
+	return events, current, nil
+}
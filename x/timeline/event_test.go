@@ -0,0 +1,117 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/internal/testutil"
+)
+
+func TestFindGap(t *testing.T) {
+	// 取りこぼしなし
+	_, ok := findGap(10, 10, 0)
+	assert.False(t, ok)
+	_, ok = findGap(5, 10, 6)
+	assert.False(t, ok)
+
+	// ストリームから溢れている
+	seq, ok := findGap(5, 10, 8)
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), seq)
+
+	// ストリームが空
+	seq, ok = findGap(5, 10, 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(11), seq)
+
+	// シーケンスがリセットされた
+	seq, ok = findGap(20, 3, 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), seq)
+}
+
+func TestParseStreamSeq(t *testing.T) {
+	seq, err := parseStreamSeq("42-0")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), seq)
+
+	_, err = parseStreamSeq("invalid")
+	assert.Error(t, err)
+}
+
+func TestEventID(t *testing.T) {
+	cursor := map[string]int64{
+		timelineCursorKey("t00000000000000000000000000@example.com"):                         12,
+		timelineCursorKey("world.concrnt.t-home@con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"): 3,
+	}
+	id := encodeEventID(cursor)
+	assert.Equal(t, cursor, decodeEventID(id))
+	assert.Empty(t, decodeEventID(""))
+	assert.Empty(t, decodeEventID("invalid"))
+
+	// タイムライン数に対してコンパクトであること
+	assert.Less(t, len(id), 2*(12+1+2)+1)
+}
+
+func TestReplayEvents(t *testing.T) {
+	rdb, cleanup_rdb := testutil.CreateRDB()
+	defer cleanup_rdb()
+
+	timeline := "t00000000000000000000000000@local.example.com"
+
+	for i := 1; i <= 3; i++ {
+		seq, err := publishEvent(ctx, rdb, core.Event{Timeline: timeline, Document: "{}"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(i), seq)
+	}
+
+	// 全件
+	events, current, err := replayEvents(ctx, rdb, timeline, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), current)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, int64(1), events[0].Seq)
+		assert.Equal(t, int64(3), events[2].Seq)
+	}
+
+	// 途中から
+	events, current, err = replayEvents(ctx, rdb, timeline, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), current)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, int64(3), events[0].Seq)
+	}
+
+	// 取りこぼしなし
+	events, _, err = replayEvents(ctx, rdb, timeline, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	// ストリームから溢れた分はギャップとして通知される
+	err = rdb.XTrimMaxLen(ctx, eventStreamKey(timeline), 1).Err()
+	assert.NoError(t, err)
+
+	events, current, err = replayEvents(ctx, rdb, timeline, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), current)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, core.EventTypeGap, events[0].Type)
+		assert.Equal(t, int64(3), events[0].Seq)
+		assert.Equal(t, int64(3), events[1].Seq)
+	}
+
+	// トリム後もシーケンスは続く
+	seq, err := publishEvent(ctx, rdb, core.Event{Timeline: timeline, Document: "{}"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), seq)
+
+	// シーケンスがリセットされた
+	events, _, err = replayEvents(ctx, rdb, "t00000000000000000000000001@local.example.com", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, core.EventTypeGap, events[0].Type)
+		assert.Equal(t, int64(1), events[0].Seq)
+	}
+}
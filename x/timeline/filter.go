@@ -0,0 +1,94 @@
+package timeline
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// eventKind classifies the event into core.EventKind*
+func eventKind(event core.Event, doc core.DocumentBase[any]) string {
+	if doc.Type == "delete" {
+		return core.EventKindRetract
+	}
+	if event.Item != nil {
+		return core.EventKindItem
+	}
+	return core.EventKindResource
+}
+
+// eventSchema returns the schema of the item or the resource of the event
+func eventSchema(event core.Event, doc core.DocumentBase[any]) string {
+	if event.Item != nil && event.Item.Schema != "" {
+		return event.Item.Schema
+	}
+	if doc.Schema != "" {
+		return doc.Schema
+	}
+	// deleteドキュメントはスキーマを持たないので消されたリソースから取る
+	if resource, ok := event.Resource.(map[string]any); ok {
+		if schema, ok := resource["schema"].(string); ok {
+			return schema
+		}
+	}
+	return ""
+}
+
+// eventAuthor returns the author of the item, or the signer of the document.
+// for a retraction it is the author of the deleted resource, and empty when the resource is not disclosed
+func eventAuthor(event core.Event, doc core.DocumentBase[any]) string {
+	if event.Item != nil && event.Item.Author != nil {
+		return *event.Item.Author
+	}
+	if doc.Type == "delete" {
+		if resource, ok := event.Resource.(map[string]any); ok {
+			if author, ok := resource["author"].(string); ok {
+				return author
+			}
+		}
+		return ""
+	}
+	return doc.Signer
+}
+
+// matchFilter reports whether the event should be sent to the client.
+// retractions are not dropped by the schema filters, since the client may hold the deleted item whatever its schema is.
+func matchFilter(filter *core.RealtimeFilter, event core.Event) bool {
+	if filter == nil || event.Type == core.EventTypeGap {
+		return true
+	}
+
+	var doc core.DocumentBase[any]
+	if event.Document != "" {
+		json.Unmarshal([]byte(event.Document), &doc)
+	}
+
+	kind := eventKind(event, doc)
+	if len(filter.Kinds) > 0 && !slices.Contains(filter.Kinds, kind) {
+		return false
+	}
+
+	if kind != core.EventKindRetract {
+		schema := eventSchema(event, doc)
+		if len(filter.Schemas) > 0 && !slices.Contains(filter.Schemas, schema) {
+			return false
+		}
+		if slices.Contains(filter.ExcludeSchemas, schema) {
+			return false
+		}
+	}
+
+	if len(filter.Authors) > 0 {
+		author := eventAuthor(event, doc)
+		// 非公開リソースの削除は作者が分からないので通す
+		if kind == core.EventKindRetract && author == "" {
+			return true
+		}
+		if !slices.Contains(filter.Authors, author) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,63 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+func TestMatchFilter(t *testing.T) {
+	author := "con1mu9xruulec4y6hd0d369sdf325l94z4770m33d"
+	item := core.Event{
+		Timeline: "t00000000000000000000000000@example.com",
+		Item:     &core.TimelineItem{Schema: "https://schema.concrnt.world/m/markdown.json", Author: &author},
+		Document: `{"signer":"` + author + `","type":"message","schema":"https://schema.concrnt.world/m/markdown.json"}`,
+	}
+	association := core.Event{
+		Timeline: "t00000000000000000000000000@example.com",
+		Document: `{"signer":"con1other","type":"association","schema":"https://schema.concrnt.world/a/like.json"}`,
+	}
+	retract := core.Event{
+		Timeline: "t00000000000000000000000000@example.com",
+		Document: `{"signer":"con1moderator","type":"delete","target":"m00000000000000000000000000"}`,
+		Resource: map[string]any{"schema": "https://schema.concrnt.world/m/markdown.json", "author": author},
+	}
+	// 非公開メッセージの削除はリソースを持たない
+	privateRetract := core.Event{
+		Timeline: "t00000000000000000000000000@example.com",
+		Document: `{"signer":"con1moderator","type":"delete","target":"m00000000000000000000000001"}`,
+	}
+	gap := core.Event{Type: core.EventTypeGap, Seq: 10}
+
+	assert.True(t, matchFilter(nil, association))
+
+	kinds := &core.RealtimeFilter{Kinds: []string{core.EventKindItem, core.EventKindRetract}}
+	assert.True(t, matchFilter(kinds, item))
+	assert.False(t, matchFilter(kinds, association))
+	assert.True(t, matchFilter(kinds, retract))
+	assert.True(t, matchFilter(kinds, gap))
+
+	exclude := &core.RealtimeFilter{ExcludeSchemas: []string{"https://schema.concrnt.world/a/like.json"}}
+	assert.True(t, matchFilter(exclude, item))
+	assert.False(t, matchFilter(exclude, association))
+
+	schemas := &core.RealtimeFilter{Schemas: []string{"https://schema.concrnt.world/m/markdown.json"}}
+	assert.True(t, matchFilter(schemas, retract))
+	assert.True(t, matchFilter(schemas, privateRetract))
+	assert.False(t, matchFilter(schemas, association))
+
+	excludeMarkdown := &core.RealtimeFilter{ExcludeSchemas: []string{"https://schema.concrnt.world/m/markdown.json"}}
+	assert.True(t, matchFilter(excludeMarkdown, retract))
+
+	authors := &core.RealtimeFilter{Authors: []string{author}}
+	assert.True(t, matchFilter(authors, item))
+	assert.True(t, matchFilter(authors, retract))
+	assert.True(t, matchFilter(authors, privateRetract))
+	assert.False(t, matchFilter(authors, association))
+
+	// 削除の署名者ではなく元の作者で判定する
+	moderator := &core.RealtimeFilter{Authors: []string{"con1moderator"}}
+	assert.False(t, matchFilter(moderator, retract))
+}
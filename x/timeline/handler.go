@@ -2,10 +2,14 @@
 package timeline
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +32,7 @@ type Handler interface {
 	ListMine(c echo.Context) error
 	GetChunks(c echo.Context) error
 	Realtime(c echo.Context) error
+	RealtimeSSE(c echo.Context) error
 	Query(c echo.Context) error
 
 	GetChunkItr(c echo.Context) error
@@ -36,12 +41,15 @@ type Handler interface {
 }
 
 type handler struct {
-	service core.TimelineService
+	service  core.TimelineService
+	auth     core.AuthService
+	config   core.Config
+	realtime core.RealtimeConfig
 }
 
 // NewHandler creates a new handler
-func NewHandler(service core.TimelineService) Handler {
-	return &handler{service: service}
+func NewHandler(service core.TimelineService, auth core.AuthService, config core.Config, realtime core.RealtimeConfig) Handler {
+	return &handler{service: service, auth: auth, config: config, realtime: normalizeRealtimeConfig(realtime)}
 }
 
 // Get returns a timeline by ID
@@ -196,7 +204,7 @@ func (h handler) GetChunks(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
 	}
 	time := time.Unix(timeInt, 0)
-	epoch := core.Time2Chunk(time)
+	epoch := h.config.Time2Chunk(time)
 	fmt.Println("epoch", epoch)
 
 	chunks, err := h.service.GetChunks(ctx, timelines, epoch)
@@ -321,11 +329,23 @@ var upgrader = websocket.Upgrader{
 }
 
 type Request struct {
-	Type     string   `json:"type"`
-	Channels []string `json:"channels"`
+	Type     string               `json:"type"`
+	Channels []string             `json:"channels"`
+	ID       string               `json:"id"`     // subscription id for listen-subscription
+	Since    map[string]int64     `json:"since"`  // timeline -> last seq the client has seen
+	Filter   *core.RealtimeFilter `json:"filter"` // optional, evaluated on the server
 }
 
 func (h handler) Realtime(c echo.Context) error {
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	slot, ok := h.acquireSocket(ctx, c)
+	if !ok {
+		return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "too many connections"})
+	}
+	defer slot.release()
+
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		slog.Error(
@@ -333,23 +353,18 @@ func (h handler) Realtime(c echo.Context) error {
 			slog.String("error", err.Error()),
 			slog.String("module", "socket"),
 		)
+		return nil
 	}
-	defer func() {
-		ws.Close()
-	}()
-
-	ctx := c.Request().Context()
+	defer ws.Close()
 
-	input := make(chan []string)
-	defer close(input)
+	input := make(chan core.RealtimeRequest)
 	output := make(chan core.Event)
-	defer close(output)
 
 	go h.service.Realtime(ctx, input, output)
-
-	quit := make(chan struct{})
+	queue := h.pump(ctx, cancel, output)
 
 	go func() {
+		defer cancel()
 		for {
 			var req Request
 			err := ws.ReadJSON(&req)
@@ -359,35 +374,251 @@ func (h handler) Realtime(c echo.Context) error {
 					slog.String("error", err.Error()),
 					slog.String("module", "socket"),
 				)
-				quit <- struct{}{}
-				break
+				return
 			}
 
+			var request core.RealtimeRequest
 			switch req.Type {
 			case "listen":
-				input <- req.Channels
+				if h.tooManyTimelines(req.Channels) {
+					ws.WriteControl(
+						websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many timelines"),
+						time.Now().Add(h.writeTimeout()),
+					)
+					return
+				}
+				request = core.RealtimeRequest{Channels: req.Channels, Since: req.Since, Filter: req.Filter, MaxChannels: h.realtime.MaxTimelines}
 				slog.DebugContext(
 					ctx, fmt.Sprintf("Socket subscribe: %s", req.Channels),
 					slog.String("module", "socket"),
 				)
+			case "listen-subscription":
+				request = core.RealtimeRequest{Subscription: req.ID, Since: req.Since, Filter: req.Filter, MaxChannels: h.realtime.MaxTimelines}
+				slog.DebugContext(
+					ctx, fmt.Sprintf("Socket subscribe subscription: %s", req.ID),
+					slog.String("module", "socket"),
+				)
 			case "h": // heartbeat
-				// do nothing
+				continue
 			default:
 				slog.InfoContext(
 					ctx, "Unknown request type",
 					slog.String("type", req.Type),
 					slog.String("module", "socket"),
 				)
+				continue
+			}
+
+			select {
+			case input <- request:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
+	refresh := time.NewTicker(socketSlotTTL / 2)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-refresh.C:
+			if !slot.refresh(ctx) {
+				ws.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many connections"),
+					time.Now().Add(h.writeTimeout()),
+				)
+				return nil
+			}
+		case event := <-queue.events:
+			if event.Type == core.EventTypeTooManyTimelines {
+				realtimeRejectedConnections.WithLabelValues("timelines").Inc()
+				ws.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many timelines"),
+					time.Now().Add(h.writeTimeout()),
+				)
+				return nil
+			}
+			ws.SetWriteDeadline(time.Now().Add(h.writeTimeout()))
+			err := ws.WriteJSON(event)
+			if err != nil {
+				slog.ErrorContext(
+					ctx, "Error writing message",
+					slog.String("error", err.Error()),
+					slog.String("module", "socket"),
+				)
+				return nil
+			}
+		}
+	}
+}
+
+const sseHeartbeatInterval = 15 * time.Second
+
+// encodeEventID encodes the last seen sequences of the timelines as a compact SSE event id.
+// cursor is keyed by timelineCursorKey, and the id is "<key>:<seq>" joined by "."
+func encodeEventID(cursor map[string]int64) string {
+	keys := make([]string, 0, len(cursor))
+	for key := range cursor {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, key+":"+strconv.FormatInt(cursor[key], 10))
+	}
+	return strings.Join(entries, ".")
+}
+
+// decodeEventID parses the Last-Event-ID sent by the client on reconnection
+func decodeEventID(id string) map[string]int64 {
+	cursor := make(map[string]int64)
+	if id == "" {
+		return cursor
+	}
+	for _, entry := range strings.Split(id, ".") {
+		key, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		cursor[key] = seq
+	}
+	return cursor
+}
+
+// sseFilter reads the realtime filter from comma separated query parameters
+func sseFilter(c echo.Context) *core.RealtimeFilter {
+	split := func(name string) []string {
+		value := c.QueryParam(name)
+		if value == "" {
+			return nil
+		}
+		return strings.Split(value, ",")
+	}
+
+	filter := core.RealtimeFilter{
+		Schemas:        split("schemas"),
+		ExcludeSchemas: split("excludeSchemas"),
+		Kinds:          split("kinds"),
+		Authors:        split("authors"),
+	}
+	if filter.Schemas == nil && filter.ExcludeSchemas == nil && filter.Kinds == nil && filter.Authors == nil {
+		return nil
+	}
+	return &filter
+}
+
+// RealtimeSSE streams timeline events as Server-Sent Events
+func (h handler) RealtimeSSE(c echo.Context) error {
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	timelinesStr := c.QueryParam("timelines")
+	subscription := c.QueryParam("subscription")
+	if timelinesStr == "" && subscription == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "timelines or subscription is required"})
+	}
+	var timelines []string
+	if subscription == "" {
+		timelines = strings.Split(timelinesStr, ",")
+	}
+	if h.tooManyTimelines(timelines) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "too many timelines"})
+	}
+
+	slot, ok := h.acquireSocket(ctx, c)
+	if !ok {
+		return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "too many connections"})
+	}
+	defer slot.release()
+
+	// sinceはtimelineCursorKeyで引かれる
+	since := decodeEventID(c.Request().Header.Get("Last-Event-ID"))
+	cursor := maps.Clone(since)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	input := make(chan core.RealtimeRequest)
+	output := make(chan core.Event)
+
+	go h.service.Realtime(ctx, input, output)
+	queue := h.pump(ctx, cancel, output)
+
+	select {
+	case input <- core.RealtimeRequest{Channels: timelines, Subscription: subscription, Since: since, Filter: sseFilter(c), MaxChannels: h.realtime.MaxTimelines}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	slog.DebugContext(
+		ctx, fmt.Sprintf("SSE subscribe: %s", timelines),
+		slog.String("module", "socket"),
+	)
+
+	rc := http.NewResponseController(w)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	refresh := time.NewTicker(socketSlotTTL / 2)
+	defer refresh.Stop()
+
 	for {
 		select {
-		case <-quit:
+		case <-ctx.Done():
 			return nil
-		case items := <-output:
-			err := ws.WriteJSON(items)
+		case <-refresh.C:
+			if !slot.refresh(ctx) {
+				return nil
+			}
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(h.writeTimeout()))
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return nil
+			}
+			w.Flush()
+		case event := <-queue.events:
+			if event.Type == core.EventTypeTooManyTimelines {
+				realtimeRejectedConnections.WithLabelValues("timelines").Inc()
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+
+			rc.SetWriteDeadline(time.Now().Add(h.writeTimeout()))
+
+			if event.Seq > 0 {
+				seq := event.Seq
+				if event.Type == core.EventTypeGap {
+					seq-- // gap points to the next event to be delivered
+				}
+				cursor[timelineCursorKey(event.Timeline)] = seq
+				_, err = fmt.Fprintf(w, "id: %s\n", encodeEventID(cursor))
+				if err != nil {
+					return nil
+				}
+			}
+
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
 			if err != nil {
 				slog.ErrorContext(
 					ctx, "Error writing message",
@@ -396,6 +627,11 @@ func (h handler) Realtime(c echo.Context) error {
 				)
 				return nil
 			}
+			w.Flush()
+
+			if event.Type == core.EventTypeTooManyTimelines {
+				return nil
+			}
 		}
 	}
 }
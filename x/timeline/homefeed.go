@@ -0,0 +1,494 @@
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+// ホームフィード
+// 購読しているタイムラインが多いsubscriptionについて、読み出しのたびにチャンクをマージする代わりに
+// ワーカーがタイムラインのイベントを購読して、上限付きのsorted setにアイテムを書き込んでおく。
+//
+// 状態遷移:
+//   (なし) --読み出し--> 登録 --ワーカーが購読開始--> listening --読み出し(build)--> ready
+// ワーカーが状態を更新し続ける間だけフィードは有効で、止まれば状態が消えてマージに戻る。
+// ワーカーはリースを持つ1つのレプリカだけが動かす。
+
+const (
+	defaultHomeFeedMinTimelines = 50
+	defaultHomeFeedSize         = 1000
+	defaultHomeFeedIdleTimeout  = 1 * time.Hour
+
+	// homeFeedStateTTL is how long a feed stays usable without being refreshed by a worker
+	homeFeedStateTTL     = 30 * time.Second
+	homeFeedSyncInterval = 10 * time.Second
+	homeFeedBuildLockTTL = 1 * time.Minute
+	homeFeedLeaderTTL    = 2 * homeFeedSyncInterval
+
+	homeFeedStateListening = "listening" // a worker follows the timelines but the feed is not filled yet
+	homeFeedStateBuild     = "build"     // the reader holds the lock to fill the feed
+	homeFeedStateReady     = "ready"
+
+	homeFeedActiveKey         = "tl:home:active" // subscription -> last read (unix)
+	homeFeedLeaderKey         = "tl:home:leader"
+	homeFeedRegisteredChannel = "concrnt:homefeed:registered"
+)
+
+// normalizeHomeFeedConfig fills the default values
+func normalizeHomeFeedConfig(config core.HomeFeedConfig) core.HomeFeedConfig {
+	if config.MinTimelines <= 0 {
+		config.MinTimelines = defaultHomeFeedMinTimelines
+	}
+	if config.Size <= 0 {
+		config.Size = defaultHomeFeedSize
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultHomeFeedIdleTimeout.Seconds()
+	}
+	return config
+}
+
+func homeFeedIdleTimeout(config core.HomeFeedConfig) time.Duration {
+	return time.Duration(config.IdleTimeout * float64(time.Second))
+}
+
+func homeFeedStateKey(subscription string) string {
+	return "tl:home:state:{" + subscription + "}"
+}
+
+func homeFeedBuildKey(subscription string) string {
+	return "tl:home:build:{" + subscription + "}"
+}
+
+func homeFeedItemsKey(subscription string) string {
+	return "tl:home:items:{" + subscription + "}"
+}
+
+func homeFeedTimelinesKey(subscription string) string {
+	return "tl:home:timelines:{" + subscription + "}"
+}
+
+func homeFeedScore(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// pickHomeFeedItems drops duplicated and retracted items and returns up to limit items
+func pickHomeFeedItems(items []core.TimelineItem, retracted map[string]bool, limit int) []core.TimelineItem {
+	result := make([]core.TimelineItem, 0, limit)
+	uniq := make(map[string]bool)
+	for _, item := range items {
+		if len(result) >= limit {
+			break
+		}
+		if uniq[item.ResourceID] || retracted[item.ResourceID] {
+			continue
+		}
+		uniq[item.ResourceID] = true
+		result = append(result, item)
+	}
+	return result
+}
+
+// touchHomeFeedScript returns the state of the feed.
+// When the feed is waiting to be filled, the first caller gets "build" with the lock.
+var touchHomeFeedScript = redis.NewScript(`
+local state = redis.call("GET", KEYS[1])
+if not state then
+	return ""
+end
+if state == "listening" and redis.call("SET", KEYS[2], "1", "NX", "PX", ARGV[1]) then
+	return "build"
+end
+return state
+`)
+
+// TouchHomeFeed marks the home feed of the subscription as read and returns its state
+func (r *repository) TouchHomeFeed(ctx context.Context, subscription string) (string, error) {
+	ctx, span := tracer.Start(ctx, "Timeline.Repository.TouchHomeFeed")
+	defer span.End()
+
+	err := r.rdb.ZAdd(ctx, homeFeedActiveKey, redis.Z{Score: float64(time.Now().Unix()), Member: subscription}).Err()
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	state, err := touchHomeFeedScript.Run(
+		ctx,
+		r.rdb,
+		[]string{homeFeedStateKey(subscription), homeFeedBuildKey(subscription)},
+		homeFeedBuildLockTTL.Milliseconds(),
+	).Text()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return state, nil
+}
+
+// RegisterHomeFeed asks the home feed workers to follow the timelines of the subscription
+func (r *repository) RegisterHomeFeed(ctx context.Context, subscription string, timelines []string) error {
+	ctx, span := tracer.Start(ctx, "Timeline.Repository.RegisterHomeFeed")
+	defer span.End()
+
+	if len(timelines) == 0 {
+		return nil
+	}
+
+	members := make([]any, len(timelines))
+	for i, timeline := range timelines {
+		members[i] = timeline
+	}
+
+	pipe := r.rdb.TxPipeline()
+	pipe.Del(ctx, homeFeedTimelinesKey(subscription))
+	pipe.SAdd(ctx, homeFeedTimelinesKey(subscription), members...)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	err = r.rdb.Publish(ctx, homeFeedRegisteredChannel, subscription).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// FillHomeFeed adds the items to the home feed and marks it ready.
+// Items the worker wrote while the reader was building the snapshot are kept.
+func (r *repository) FillHomeFeed(ctx context.Context, subscription string, items []core.TimelineItem) error {
+	ctx, span := tracer.Start(ctx, "Timeline.Repository.FillHomeFeed")
+	defer span.End()
+
+	config := normalizeHomeFeedConfig(r.config.HomeFeed)
+
+	members := make([]redis.Z, 0, len(items))
+	for _, item := range items {
+		member, err := json.Marshal(item)
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		members = append(members, redis.Z{Score: homeFeedScore(item.CDate), Member: string(member)})
+	}
+
+	key := homeFeedItemsKey(subscription)
+	pipe := r.rdb.TxPipeline()
+	if len(members) > 0 {
+		pipe.ZAdd(ctx, key, members...)
+		pipe.ZRemRangeByRank(ctx, key, 0, -(config.Size + 1))
+		pipe.Expire(ctx, key, homeFeedIdleTimeout(config))
+	}
+	pipe.SetArgs(ctx, homeFeedStateKey(subscription), homeFeedStateReady, redis.SetArgs{Mode: "XX", KeepTTL: true})
+	pipe.Del(ctx, homeFeedBuildKey(subscription))
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) { // the feed was dropped while filling
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// GetHomeFeedItems returns items of the home feed older than until, newest first
+func (r *repository) GetHomeFeedItems(ctx context.Context, subscription string, until time.Time, limit int) ([]core.TimelineItem, error) {
+	ctx, span := tracer.Start(ctx, "Timeline.Repository.GetHomeFeedItems")
+	defer span.End()
+
+	members, err := r.rdb.ZRevRangeByScore(ctx, homeFeedItemsKey(subscription), &redis.ZRangeBy{
+		Max:   "(" + strconv.FormatInt(until.UnixMilli(), 10),
+		Min:   "-inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	items := make([]core.TimelineItem, 0, len(members))
+	for _, member := range members {
+		var item core.TimelineItem
+		err := json.Unmarshal([]byte(member), &item)
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// homeFeedWorker follows the timelines of the registered home feeds and writes their items into the feeds.
+// Every replica runs one, but only the one holding the leader lease syncs and follows the timelines.
+type homeFeedWorker struct {
+	rdb        *redis.Client
+	config     core.HomeFeedConfig
+	instanceID string
+	leader     bool
+	pubsub     *redis.PubSub
+	feeds      map[string][]string // timeline -> subscriptions
+}
+
+func newHomeFeedWorker(rdb *redis.Client, config core.HomeFeedConfig) *homeFeedWorker {
+	return &homeFeedWorker{
+		rdb:        rdb,
+		config:     normalizeHomeFeedConfig(config),
+		instanceID: newInstanceID(),
+		feeds:      make(map[string][]string),
+	}
+}
+
+func (w *homeFeedWorker) run(ctx context.Context) {
+	w.pubsub = w.rdb.Subscribe(ctx, homeFeedRegisteredChannel)
+	defer w.pubsub.Close()
+
+	err := w.pubsub.PSubscribe(ctx, core.SubscriptionChangedChannelPrefix+"*")
+	if err != nil {
+		slog.ErrorContext(
+			ctx, "failed to watch subscription changes",
+			slog.String("error", err.Error()),
+			slog.String("module", "timeline"),
+			slog.String("group", "homefeed"),
+		)
+		return
+	}
+
+	ticker := time.NewTicker(homeFeedSyncInterval)
+	defer ticker.Stop()
+	defer releaseLeaseScript.Run(context.WithoutCancel(ctx), w.rdb, []string{homeFeedLeaderKey}, w.instanceID)
+
+	w.elect(ctx)
+
+	psch := w.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.elect(ctx)
+		case msg := <-psch:
+			if msg == nil || !w.leader {
+				continue
+			}
+			switch {
+			case msg.Channel == homeFeedRegisteredChannel:
+				w.sync(ctx)
+			case msg.Pattern != "":
+				// 購読が変わったフィードは作り直す
+				w.drop(ctx, msg.Payload)
+				w.sync(ctx)
+			default:
+				w.add(ctx, msg.Channel, msg.Payload)
+			}
+		}
+	}
+}
+
+// elect takes or renews the leader lease and syncs while holding it.
+// A replica that lost the lease stops following the timelines.
+func (w *homeFeedWorker) elect(ctx context.Context) {
+	leader, err := acquireLease(ctx, w.rdb, homeFeedLeaderKey, w.instanceID, homeFeedLeaderTTL)
+	if err != nil {
+		slog.ErrorContext(
+			ctx, "failed to acquire home feed lease",
+			slog.String("error", err.Error()),
+			slog.String("module", "timeline"),
+			slog.String("group", "homefeed"),
+		)
+		leader = false
+	}
+
+	if !leader {
+		if w.leader {
+			w.resign(ctx)
+		}
+		return
+	}
+
+	w.leader = true
+	w.sync(ctx)
+}
+
+// resign unsubscribes every timeline so that only the leader writes into the feeds
+func (w *homeFeedWorker) resign(ctx context.Context) {
+	w.leader = false
+	if len(w.feeds) == 0 {
+		return
+	}
+
+	timelines := make([]string, 0, len(w.feeds))
+	for timeline := range w.feeds {
+		timelines = append(timelines, timeline)
+	}
+	err := w.pubsub.Unsubscribe(ctx, timelines...)
+	if err != nil {
+		slog.ErrorContext(
+			ctx, "failed to unsubscribe home feed timelines",
+			slog.String("error", err.Error()),
+			slog.String("module", "timeline"),
+			slog.String("group", "homefeed"),
+		)
+	}
+	w.feeds = make(map[string][]string)
+}
+
+// sync drops idle feeds, follows the timelines of the active ones and keeps their states alive
+func (w *homeFeedWorker) sync(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "Timeline.HomeFeed.Sync")
+	defer span.End()
+
+	deadline := time.Now().Add(-homeFeedIdleTimeout(w.config)).Unix()
+	idle, err := w.rdb.ZRangeByScore(ctx, homeFeedActiveKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(deadline, 10),
+	}).Result()
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	for _, subscription := range idle {
+		w.drop(ctx, subscription)
+	}
+
+	subscriptions, err := w.rdb.ZRange(ctx, homeFeedActiveKey, 0, -1).Result()
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	feeds := make(map[string][]string)
+	following := make([]string, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		timelines, err := w.rdb.SMembers(ctx, homeFeedTimelinesKey(subscription)).Result()
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		if len(timelines) == 0 {
+			continue
+		}
+		for _, timeline := range timelines {
+			feeds[timeline] = append(feeds[timeline], subscription)
+		}
+		following = append(following, subscription)
+	}
+
+	subscribe, unsubscribe := diffHomeFeedChannels(w.feeds, feeds)
+	if len(subscribe) > 0 {
+		err := w.pubsub.Subscribe(ctx, subscribe...)
+		if err != nil {
+			span.RecordError(err)
+			return
+		}
+		// リモートのタイムラインはkeeperに接続してもらう
+		w.rdb.Publish(ctx, "concrnt:subscription:updated", "")
+	}
+	if len(unsubscribe) > 0 {
+		err := w.pubsub.Unsubscribe(ctx, unsubscribe...)
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+	w.feeds = feeds
+
+	pipe := w.rdb.Pipeline()
+	for _, subscription := range following {
+		pipe.SetNX(ctx, homeFeedStateKey(subscription), homeFeedStateListening, homeFeedStateTTL)
+		pipe.Expire(ctx, homeFeedStateKey(subscription), homeFeedStateTTL)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		span.RecordError(err)
+	}
+}
+
+// drop removes the feed. it will be registered again on the next read
+func (w *homeFeedWorker) drop(ctx context.Context, subscription string) {
+	pipe := w.rdb.Pipeline()
+	pipe.Del(ctx, homeFeedStateKey(subscription), homeFeedBuildKey(subscription), homeFeedItemsKey(subscription), homeFeedTimelinesKey(subscription))
+	pipe.ZRem(ctx, homeFeedActiveKey, subscription)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		slog.ErrorContext(
+			ctx, fmt.Sprintf("failed to drop home feed: %s", subscription),
+			slog.String("error", err.Error()),
+			slog.String("module", "timeline"),
+			slog.String("group", "homefeed"),
+		)
+	}
+}
+
+// add writes the item of the timeline event into the feeds following the timeline
+func (w *homeFeedWorker) add(ctx context.Context, timeline, payload string) {
+	subscriptions := w.feeds[timeline]
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	var event core.Event
+	err := json.Unmarshal([]byte(payload), &event)
+	if err != nil || event.Type != "" || event.Item == nil {
+		return
+	}
+
+	var doc core.DocumentBase[any]
+	json.Unmarshal([]byte(event.Document), &doc)
+	if eventKind(event, doc) != core.EventKindItem {
+		return
+	}
+
+	member, err := json.Marshal(event.Item)
+	if err != nil {
+		return
+	}
+
+	pipe := w.rdb.Pipeline()
+	for _, subscription := range subscriptions {
+		key := homeFeedItemsKey(subscription)
+		pipe.ZAdd(ctx, key, redis.Z{Score: homeFeedScore(event.Item.CDate), Member: string(member)})
+		pipe.ZRemRangeByRank(ctx, key, 0, -(w.config.Size + 1))
+		pipe.Expire(ctx, key, homeFeedIdleTimeout(w.config))
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		slog.ErrorContext(
+			ctx, "failed to write home feed",
+			slog.String("error", err.Error()),
+			slog.String("timeline", timeline),
+			slog.String("module", "timeline"),
+			slog.String("group", "homefeed"),
+		)
+	}
+}
+
+// diffHomeFeedChannels returns the timelines to subscribe and to unsubscribe
+func diffHomeFeedChannels(current, wanted map[string][]string) ([]string, []string) {
+	subscribe := make([]string, 0)
+	for timeline := range wanted {
+		if _, ok := current[timeline]; !ok {
+			subscribe = append(subscribe, timeline)
+		}
+	}
+	unsubscribe := make([]string, 0)
+	for timeline := range current {
+		if _, ok := wanted[timeline]; !ok {
+			unsubscribe = append(unsubscribe, timeline)
+		}
+	}
+	return subscribe, unsubscribe
+}
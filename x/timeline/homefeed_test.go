@@ -0,0 +1,169 @@
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/core/mock"
+	"github.com/totegamma/concurrent/internal/testutil"
+	"github.com/totegamma/concurrent/x/timeline/mock"
+)
+
+func TestPickHomeFeedItems(t *testing.T) {
+	items := []core.TimelineItem{
+		{ResourceID: "m1"},
+		{ResourceID: "m1"}, // 別のタイムライン経由
+		{ResourceID: "m2"},
+		{ResourceID: "m3"},
+		{ResourceID: "m4"},
+	}
+
+	picked := pickHomeFeedItems(items, map[string]bool{"m2": true}, 2)
+	assert.Equal(t, []core.TimelineItem{{ResourceID: "m1"}, {ResourceID: "m3"}}, picked)
+}
+
+func TestDiffHomeFeedChannels(t *testing.T) {
+	current := map[string][]string{"t1": {"s1"}, "t2": {"s1"}}
+	wanted := map[string][]string{"t2": {"s1", "s2"}, "t3": {"s2"}}
+
+	subscribe, unsubscribe := diffHomeFeedChannels(current, wanted)
+	assert.Equal(t, []string{"t3"}, subscribe)
+	assert.Equal(t, []string{"t1"}, unsubscribe)
+}
+
+func TestGetRecentItemsFromHomeFeed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	subscriptionID := "s00000000000000000000000000"
+	until := time.Now()
+
+	mockRepo := mock_timeline.NewMockRepository(ctrl)
+	mockRepo.EXPECT().
+		GetNormalizationCache(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id string) (string, error) {
+			return id + "@local.example.com", nil
+		}).AnyTimes()
+
+	mockSubscription := mock_core.NewMockSubscriptionService(ctrl)
+	mockSubscription.EXPECT().GetSubscription(gomock.Any(), subscriptionID).Return(core.Subscription{
+		ID: subscriptionID,
+		Items: []core.SubscriptionItem{
+			{ID: "t00000000000000000000000001"},
+			{ID: "t00000000000000000000000002"},
+		},
+	}, nil).AnyTimes()
+
+	service := NewService(
+		mockRepo,
+		mock_core.NewMockEntityService(ctrl),
+		mock_core.NewMockDomainService(ctrl),
+		mock_core.NewMockSemanticIDService(ctrl),
+		mockSubscription,
+		mock_core.NewMockPolicyService(ctrl),
+		core.Config{
+			FQDN:     "local.example.com",
+			HomeFeed: core.HomeFeedConfig{Enabled: true, MinTimelines: 2},
+		},
+	)
+
+	// 未登録のフィードは登録され、マージで返す
+	mockRepo.EXPECT().TouchHomeFeed(gomock.Any(), subscriptionID).Return("", nil)
+	mockRepo.EXPECT().RegisterHomeFeed(gomock.Any(), subscriptionID, []string{
+		"t00000000000000000000000001@local.example.com",
+		"t00000000000000000000000002@local.example.com",
+	}).Return(nil)
+	mockRepo.EXPECT().ListRecentlyRemovedItems(gomock.Any(), gomock.Any()).Return(map[string][]string{}, nil)
+	mockRepo.EXPECT().LookupChunkItrs(gomock.Any(), gomock.Any(), gomock.Any()).Return(map[string]string{}, nil)
+	mockRepo.EXPECT().LoadChunkBodies(gomock.Any(), gomock.Any()).Return(map[string]core.Chunk{}, nil)
+
+	items, err := service.GetRecentItemsFromSubscription(context.Background(), subscriptionID, until, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	// 準備ができたフィードから返す
+	mockRepo.EXPECT().TouchHomeFeed(gomock.Any(), subscriptionID).Return(homeFeedStateReady, nil)
+	mockRepo.EXPECT().GetHomeFeedItems(gomock.Any(), subscriptionID, until, 4).Return([]core.TimelineItem{
+		{ResourceID: "m00000000000000000000000003"},
+		{ResourceID: "m00000000000000000000000002"},
+		{ResourceID: "m00000000000000000000000001"},
+	}, nil)
+	mockRepo.EXPECT().ListRecentlyRemovedItems(gomock.Any(), gomock.Any()).Return(map[string][]string{
+		"t00000000000000000000000002@local.example.com": {"m00000000000000000000000002"},
+	}, nil)
+
+	items, err = service.GetRecentItemsFromSubscription(context.Background(), subscriptionID, until, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []core.TimelineItem{
+		{ResourceID: "m00000000000000000000000003"},
+		{ResourceID: "m00000000000000000000000001"},
+	}, items)
+}
+
+func TestFillHomeFeedKeepsWorkerItems(t *testing.T) {
+	rdb, cleanup := testutil.CreateRDB()
+	defer cleanup()
+
+	subscriptionID := "s00000000000000000000000000"
+	repo := repository{rdb: rdb, config: core.Config{HomeFeed: core.HomeFeedConfig{Size: 3}}}
+	worker := newHomeFeedWorker(rdb, core.HomeFeedConfig{Size: 3})
+	worker.feeds["t00000000000000000000000001@local.example.com"] = []string{subscriptionID}
+
+	base := time.Now()
+
+	// スナップショットを作っている間にワーカーが書き込んだアイテム
+	worker.add(ctx, "t00000000000000000000000001@local.example.com", `{"item":{"resourceID":"m00000000000000000000000004","cdate":"`+base.Add(4*time.Second).Format(time.RFC3339Nano)+`"}}`)
+
+	err := repo.FillHomeFeed(ctx, subscriptionID, []core.TimelineItem{
+		{ResourceID: "m00000000000000000000000003", CDate: base.Add(3 * time.Second)},
+		{ResourceID: "m00000000000000000000000002", CDate: base.Add(2 * time.Second)},
+		{ResourceID: "m00000000000000000000000001", CDate: base.Add(1 * time.Second)},
+	})
+	assert.NoError(t, err)
+
+	items, err := repo.GetHomeFeedItems(ctx, subscriptionID, base.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ResourceID)
+	}
+	// 古いものから上限を超えた分が消える
+	assert.Equal(t, []string{"m00000000000000000000000004", "m00000000000000000000000003", "m00000000000000000000000002"}, ids)
+}
+
+func TestHomeFeedWorkerElection(t *testing.T) {
+	rdb, cleanup := testutil.CreateRDB()
+	defer cleanup()
+
+	w1 := newHomeFeedWorker(rdb, core.HomeFeedConfig{})
+	w1.pubsub = rdb.Subscribe(ctx)
+	defer w1.pubsub.Close()
+	w2 := newHomeFeedWorker(rdb, core.HomeFeedConfig{})
+	w2.pubsub = rdb.Subscribe(ctx)
+	defer w2.pubsub.Close()
+
+	w1.elect(ctx)
+	w2.elect(ctx)
+	assert.True(t, w1.leader)
+	assert.False(t, w2.leader)
+
+	// 更新しても持ち主は変わらない
+	w1.elect(ctx)
+	w2.elect(ctx)
+	assert.True(t, w1.leader)
+	assert.False(t, w2.leader)
+
+	// リースが切れたら引き継がれ、元のリーダーは降りる
+	w1.feeds["t00000000000000000000000001@local.example.com"] = []string{"s00000000000000000000000000"}
+	rdb.Del(ctx, homeFeedLeaderKey)
+	w2.elect(ctx)
+	w1.elect(ctx)
+	assert.True(t, w2.leader)
+	assert.False(t, w1.leader)
+	assert.Empty(t, w1.feeds)
+}
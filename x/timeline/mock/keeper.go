@@ -81,6 +81,18 @@ func (mr *MockKeeperMockRecorder) GetRemoteSubs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRemoteSubs", reflect.TypeOf((*MockKeeper)(nil).GetRemoteSubs))
 }
 
+// InvalidateChunkItrs mocks base method.
+func (m *MockKeeper) InvalidateChunkItrs(timeline, epoch string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "InvalidateChunkItrs", timeline, epoch)
+}
+
+// InvalidateChunkItrs indicates an expected call of InvalidateChunkItrs.
+func (mr *MockKeeperMockRecorder) InvalidateChunkItrs(timeline, epoch any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InvalidateChunkItrs", reflect.TypeOf((*MockKeeper)(nil).InvalidateChunkItrs), timeline, epoch)
+}
+
 // Start mocks base method.
 func (m *MockKeeper) Start(ctx context.Context) {
 	m.ctrl.T.Helper()
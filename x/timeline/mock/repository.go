@@ -113,6 +113,35 @@ func (mr *MockRepositoryMockRecorder) DeleteTimeline(ctx, key any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTimeline", reflect.TypeOf((*MockRepository)(nil).DeleteTimeline), ctx, key)
 }
 
+// FillHomeFeed mocks base method.
+func (m *MockRepository) FillHomeFeed(ctx context.Context, subscription string, items []core.TimelineItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FillHomeFeed", ctx, subscription, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FillHomeFeed indicates an expected call of FillHomeFeed.
+func (mr *MockRepositoryMockRecorder) FillHomeFeed(ctx, subscription, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FillHomeFeed", reflect.TypeOf((*MockRepository)(nil).FillHomeFeed), ctx, subscription, items)
+}
+
+// GetHomeFeedItems mocks base method.
+func (m *MockRepository) GetHomeFeedItems(ctx context.Context, subscription string, until time.Time, limit int) ([]core.TimelineItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHomeFeedItems", ctx, subscription, until, limit)
+	ret0, _ := ret[0].([]core.TimelineItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHomeFeedItems indicates an expected call of GetHomeFeedItems.
+func (mr *MockRepositoryMockRecorder) GetHomeFeedItems(ctx, subscription, until, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHomeFeedItems", reflect.TypeOf((*MockRepository)(nil).GetHomeFeedItems), ctx, subscription, until, limit)
+}
+
 // GetImmediateItems mocks base method.
 func (m *MockRepository) GetImmediateItems(ctx context.Context, timelineID string, since time.Time, limit int) ([]core.TimelineItem, error) {
 	m.ctrl.T.Helper()
@@ -366,6 +395,20 @@ func (mr *MockRepositoryMockRecorder) Query(ctx, timelineID, schema, owner, auth
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockRepository)(nil).Query), ctx, timelineID, schema, owner, author, until, limit)
 }
 
+// RegisterHomeFeed mocks base method.
+func (m *MockRepository) RegisterHomeFeed(ctx context.Context, subscription string, timelines []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterHomeFeed", ctx, subscription, timelines)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RegisterHomeFeed indicates an expected call of RegisterHomeFeed.
+func (mr *MockRepositoryMockRecorder) RegisterHomeFeed(ctx, subscription, timelines any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterHomeFeed", reflect.TypeOf((*MockRepository)(nil).RegisterHomeFeed), ctx, subscription, timelines)
+}
+
 // SetNormalizationCache mocks base method.
 func (m *MockRepository) SetNormalizationCache(ctx context.Context, timelineID, value string) error {
 	m.ctrl.T.Helper()
@@ -381,17 +424,32 @@ func (mr *MockRepositoryMockRecorder) SetNormalizationCache(ctx, timelineID, val
 }
 
 // Subscribe mocks base method.
-func (m *MockRepository) Subscribe(ctx context.Context, channels []string, event chan<- core.Event) error {
+func (m *MockRepository) Subscribe(ctx context.Context, channels []string, since map[string]int64, event chan<- core.Event) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Subscribe", ctx, channels, event)
+	ret := m.ctrl.Call(m, "Subscribe", ctx, channels, since, event)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Subscribe indicates an expected call of Subscribe.
-func (mr *MockRepositoryMockRecorder) Subscribe(ctx, channels, event any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) Subscribe(ctx, channels, since, event any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockRepository)(nil).Subscribe), ctx, channels, event)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockRepository)(nil).Subscribe), ctx, channels, since, event)
+}
+
+// TouchHomeFeed mocks base method.
+func (m *MockRepository) TouchHomeFeed(ctx context.Context, subscription string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchHomeFeed", ctx, subscription)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TouchHomeFeed indicates an expected call of TouchHomeFeed.
+func (mr *MockRepositoryMockRecorder) TouchHomeFeed(ctx, subscription any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchHomeFeed", reflect.TypeOf((*MockRepository)(nil).TouchHomeFeed), ctx, subscription)
 }
 
 // UpsertTimeline mocks base method.
@@ -408,3 +466,17 @@ func (mr *MockRepositoryMockRecorder) UpsertTimeline(ctx, timeline any) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertTimeline", reflect.TypeOf((*MockRepository)(nil).UpsertTimeline), ctx, timeline)
 }
+
+// WatchSubscription mocks base method.
+func (m *MockRepository) WatchSubscription(ctx context.Context, subscription string, changed chan<- string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchSubscription", ctx, subscription, changed)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WatchSubscription indicates an expected call of WatchSubscription.
+func (mr *MockRepositoryMockRecorder) WatchSubscription(ctx, subscription, changed any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchSubscription", reflect.TypeOf((*MockRepository)(nil).WatchSubscription), ctx, subscription, changed)
+}
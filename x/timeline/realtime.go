@@ -0,0 +1,273 @@
+package timeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	defaultSendQueueSize = 256
+	defaultWriteTimeout  = 10 * time.Second
+
+	OverflowPolicyDropOldest = "drop-oldest"
+	OverflowPolicyDisconnect = "disconnect"
+)
+
+var realtimeDroppedEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cc_timeline_realtime_dropped_events_total",
+		Help: "Number of realtime events dropped because the client could not keep up",
+	},
+	[]string{"policy"},
+)
+
+var realtimeRejectedConnections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cc_timeline_realtime_rejected_total",
+		Help: "Number of realtime connections or requests rejected by the limits",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(realtimeDroppedEvents, realtimeRejectedConnections)
+}
+
+// normalizeRealtimeConfig fills the default values
+func normalizeRealtimeConfig(config core.RealtimeConfig) core.RealtimeConfig {
+	if config.SendQueueSize <= 0 {
+		config.SendQueueSize = defaultSendQueueSize
+	}
+	if config.OverflowPolicy != OverflowPolicyDisconnect {
+		config.OverflowPolicy = OverflowPolicyDropOldest
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultWriteTimeout.Seconds()
+	}
+	return config
+}
+
+func (h handler) writeTimeout() time.Duration {
+	return time.Duration(h.realtime.WriteTimeout * float64(time.Second))
+}
+
+// sendQueue is a bounded buffer between service.Realtime and a slow client
+type sendQueue struct {
+	events chan core.Event
+	policy string
+}
+
+func newSendQueue(size int, policy string) *sendQueue {
+	return &sendQueue{
+		events: make(chan core.Event, size),
+		policy: policy,
+	}
+}
+
+// push enqueues the event without blocking.
+// It returns false when the connection should be closed.
+// Only one goroutine may push.
+func (q *sendQueue) push(event core.Event) bool {
+	select {
+	case q.events <- event:
+		return true
+	default:
+	}
+
+	if q.policy == OverflowPolicyDisconnect {
+		realtimeDroppedEvents.WithLabelValues(q.policy).Inc()
+		return false
+	}
+
+	// 古いものから捨て、捨てたタイムラインごとにギャップを通知する
+	// gaps: timeline -> the first sequence that will be delivered
+	gaps := make(map[string]int64)
+drain:
+	for cap(q.events)-len(q.events) < len(gaps)+1 {
+		var dropped core.Event
+		select {
+		case dropped = <-q.events:
+		default:
+			break drain
+		}
+		realtimeDroppedEvents.WithLabelValues(q.policy).Inc()
+
+		if dropped.Timeline != "" {
+			addGap(gaps, dropped)
+		}
+	}
+
+	// 同じタイムラインの後続も捨てて、ギャップより古いイベントが後から届かないようにする
+	queued := len(q.events)
+rest:
+	for i := 0; i < queued; i++ {
+		var queuedEvent core.Event
+		select {
+		case queuedEvent = <-q.events:
+		default:
+			break rest
+		}
+		if _, ok := gaps[queuedEvent.Timeline]; ok {
+			realtimeDroppedEvents.WithLabelValues(q.policy).Inc()
+			addGap(gaps, queuedEvent)
+			continue
+		}
+		q.offer(queuedEvent)
+	}
+
+	timelines := make([]string, 0, len(gaps))
+	for timeline := range gaps {
+		timelines = append(timelines, timeline)
+	}
+	sort.Strings(timelines)
+
+	for _, timeline := range timelines {
+		q.offer(core.Event{Type: core.EventTypeGap, Timeline: timeline, Seq: gaps[timeline]})
+	}
+	q.offer(event)
+	return true
+}
+
+// addGap records the first sequence that can be delivered after the dropped event
+func addGap(gaps map[string]int64, dropped core.Event) {
+	next := dropped.Seq
+	if dropped.Type != core.EventTypeGap && dropped.Seq > 0 {
+		next = dropped.Seq + 1
+	}
+	if current, ok := gaps[dropped.Timeline]; !ok || next > current {
+		gaps[dropped.Timeline] = next
+	}
+}
+
+// offer enqueues the event if there is room
+func (q *sendQueue) offer(event core.Event) {
+	select {
+	case q.events <- event:
+	default:
+		realtimeDroppedEvents.WithLabelValues(q.policy).Inc()
+	}
+}
+
+func newConnectionID() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+// socketSlotTTL is how long a socket slot is held without being refreshed
+const socketSlotTTL = 2 * time.Minute
+
+// socketSlotName is the name of the realtime connection limit in the auth limiter
+const socketSlotName = "realtime:sockets"
+
+// socketSlot is a connection slot of the requester. nil means the number of sockets is not limited
+type socketSlot struct {
+	auth       core.AuthService
+	c          echo.Context
+	connection string
+	limit      int
+}
+
+// acquireSocket takes a connection slot for the request. ok is false when the requester has too many connections
+func (h handler) acquireSocket(ctx context.Context, c echo.Context) (*socketSlot, bool) {
+	if h.realtime.MaxSockets <= 0 {
+		return nil, true
+	}
+
+	slot := &socketSlot{
+		auth:       h.auth,
+		c:          c,
+		connection: newConnectionID(),
+		limit:      h.realtime.MaxSockets,
+	}
+
+	ok, err := h.auth.AcquireSlot(ctx, c, socketSlotName, slot.connection, slot.limit, socketSlotTTL)
+	if err != nil {
+		// redisが落ちていても接続は止めない
+		slog.WarnContext(
+			ctx, "failed to acquire socket slot",
+			slog.String("error", err.Error()),
+			slog.String("module", "socket"),
+		)
+		return nil, true
+	}
+	if !ok {
+		realtimeRejectedConnections.WithLabelValues("sockets").Inc()
+		return nil, false
+	}
+
+	return slot, true
+}
+
+// refresh keeps the slot alive while the connection is open.
+// It returns false when the slot was lost and taken by other connections, so the connection must be closed.
+func (s *socketSlot) refresh(ctx context.Context) bool {
+	if s == nil {
+		return true
+	}
+	ok, err := s.auth.AcquireSlot(ctx, s.c, socketSlotName, s.connection, s.limit, socketSlotTTL)
+	if err != nil {
+		// acquireSocketと同様にredisの障害では切断しない
+		slog.WarnContext(
+			ctx, "failed to refresh socket slot",
+			slog.String("error", err.Error()),
+			slog.String("module", "socket"),
+		)
+		return true
+	}
+	if !ok {
+		realtimeRejectedConnections.WithLabelValues("sockets").Inc()
+	}
+	return ok
+}
+
+func (s *socketSlot) release() {
+	if s == nil {
+		return
+	}
+	s.auth.ReleaseSlot(context.Background(), s.c, socketSlotName, s.connection)
+}
+
+// tooManyTimelines reports whether a listen request exceeds the per connection limit
+func (h handler) tooManyTimelines(timelines []string) bool {
+	if h.realtime.MaxTimelines > 0 && len(timelines) > h.realtime.MaxTimelines {
+		realtimeRejectedConnections.WithLabelValues("timelines").Inc()
+		return true
+	}
+	return false
+}
+
+// pump moves events from service.Realtime into a bounded queue so that a slow client never blocks the subscriber.
+// The connection is canceled when the queue overflows with the disconnect policy.
+func (h handler) pump(ctx context.Context, cancel context.CancelFunc, output <-chan core.Event) *sendQueue {
+	queue := newSendQueue(h.realtime.SendQueueSize, h.realtime.OverflowPolicy)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-output:
+				if !queue.push(event) {
+					slog.InfoContext(
+						ctx, "disconnecting slow realtime client",
+						slog.String("module", "socket"),
+					)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return queue
+}
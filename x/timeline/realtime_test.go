@@ -0,0 +1,38 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/totegamma/concurrent/core"
+)
+
+func TestSendQueue(t *testing.T) {
+	// 溢れたら古いものから捨て、ギャップを通知する
+	q := newSendQueue(4, OverflowPolicyDropOldest)
+	assert.True(t, q.push(core.Event{Timeline: "t1", Seq: 1}))
+	assert.True(t, q.push(core.Event{Timeline: "t1", Seq: 2}))
+	assert.True(t, q.push(core.Event{Timeline: "t2", Seq: 1}))
+	assert.True(t, q.push(core.Event{Timeline: "t2", Seq: 2}))
+	assert.True(t, q.push(core.Event{Timeline: "t2", Seq: 3}))
+
+	// t1は後続も捨てられ、ギャップの後から再開する
+	assert.Equal(t, core.Event{Timeline: "t2", Seq: 1}, <-q.events)
+	assert.Equal(t, core.Event{Timeline: "t2", Seq: 2}, <-q.events)
+	assert.Equal(t, core.Event{Type: core.EventTypeGap, Timeline: "t1", Seq: 3}, <-q.events)
+	assert.Equal(t, core.Event{Timeline: "t2", Seq: 3}, <-q.events)
+	assert.Len(t, q.events, 0)
+
+	// 溢れたら切断する
+	q = newSendQueue(1, OverflowPolicyDisconnect)
+	assert.True(t, q.push(core.Event{Seq: 1}))
+	assert.False(t, q.push(core.Event{Seq: 2}))
+}
+
+func TestNormalizeRealtimeConfig(t *testing.T) {
+	config := normalizeRealtimeConfig(core.RealtimeConfig{OverflowPolicy: "unknown"})
+	assert.Equal(t, defaultSendQueueSize, config.SendQueueSize)
+	assert.Equal(t, OverflowPolicyDropOldest, config.OverflowPolicy)
+	assert.Equal(t, defaultWriteTimeout.Seconds(), config.WriteTimeout)
+}
@@ -0,0 +1,525 @@
+package timeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/totegamma/concurrent/client"
+	"github.com/totegamma/concurrent/core"
+)
+
+const (
+	remoteLeasePrefix = "timeline:remote:lease:"
+	remoteLeaseTTL    = 30 * time.Second
+)
+
+// リースが自分のものである場合のみ延長/解放する
+var (
+	renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+	releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+type remoteState struct {
+	timelines  []string
+	conn       *websocket.Conn
+	writeMu    sync.Mutex
+	leader     bool
+	connecting bool
+	connected  bool // true once the first connection was established
+	reconnects int64
+	lastEvent  time.Time
+}
+
+// RemoteSubscriptionManager keeps the websocket connections to remote domains and relays their events into redis pubsub.
+// only the replica holding the lease of a domain connects to it, so each event is published once.
+type RemoteSubscriptionManager struct {
+	mu          sync.Mutex
+	remotes     map[string]*remoteState
+	instanceID  string
+	rdb         *redis.Client
+	cache       core.ChunkCache
+	invalidator *chunkInvalidator
+	client      client.Client
+	config      core.Config
+}
+
+// NewRemoteSubscriptionManager creates a new RemoteSubscriptionManager
+func NewRemoteSubscriptionManager(rdb *redis.Client, cache core.ChunkCache, invalidator *chunkInvalidator, client client.Client, config core.Config) *RemoteSubscriptionManager {
+	return &RemoteSubscriptionManager{
+		remotes:     make(map[string]*remoteState),
+		instanceID:  newInstanceID(),
+		rdb:         rdb,
+		cache:       cache,
+		invalidator: invalidator,
+		client:      client,
+		config:      config,
+	}
+}
+
+// Add registers new remote timelines and returns the domains whose subscriptions changed
+func (m *RemoteSubscriptionManager) Add(timelines []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := make([]string, 0)
+	for _, timeline := range timelines {
+		domain, ok := m.remoteDomain(timeline)
+		if !ok {
+			continue
+		}
+
+		state, ok := m.remotes[domain]
+		if !ok {
+			state = &remoteState{}
+			m.remotes[domain] = state
+		}
+
+		if !slices.Contains(state.timelines, timeline) {
+			state.timelines = append(state.timelines, timeline)
+			if !slices.Contains(changed, domain) {
+				changed = append(changed, domain)
+			}
+		}
+	}
+
+	return changed
+}
+
+// Retain drops timelines that are not in the given list and closes domains without subscriptions.
+// it returns the closed domains
+func (m *RemoteSubscriptionManager) Retain(ctx context.Context, timelines []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	closed := make([]string, 0)
+	for domain, state := range m.remotes {
+		retained := make([]string, 0, len(state.timelines))
+		for _, timeline := range state.timelines {
+			if slices.Contains(timelines, timeline) {
+				retained = append(retained, timeline)
+			}
+		}
+		state.timelines = retained
+
+		if len(state.timelines) == 0 {
+			if state.conn != nil {
+				state.conn.Close()
+			}
+			if state.leader {
+				releaseLeaseScript.Run(ctx, m.rdb, []string{remoteLeasePrefix + domain}, m.instanceID)
+			}
+			delete(m.remotes, domain)
+			closed = append(closed, domain)
+		}
+	}
+
+	sort.Strings(closed)
+	return closed
+}
+
+// Subscriptions returns every remote timeline this domain is interested in
+func (m *RemoteSubscriptionManager) Subscriptions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subs := make([]string, 0)
+	for _, state := range m.remotes {
+		subs = append(subs, state.timelines...)
+	}
+	return subs
+}
+
+// Sync acquires or renews the lease of each domain, connects to the domains this replica leads
+// and disconnects from the ones whose lease was lost
+func (m *RemoteSubscriptionManager) Sync(ctx context.Context) {
+	m.mu.Lock()
+	domains := make([]string, 0, len(m.remotes))
+	for domain := range m.remotes {
+		domains = append(domains, domain)
+	}
+	m.mu.Unlock()
+
+	for _, domain := range domains {
+		leader, err := m.acquireLease(ctx, domain)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("fail to acquire lease of %s", domain),
+				slog.String("error", err.Error()),
+				slog.String("module", "agent"),
+				slog.String("group", "realtime"),
+			)
+			continue
+		}
+
+		m.mu.Lock()
+		state, ok := m.remotes[domain]
+		if !ok {
+			m.mu.Unlock()
+			continue
+		}
+
+		state.leader = leader
+		if !leader {
+			if state.conn != nil {
+				slog.Info(
+					fmt.Sprintf("lease of %s is held by another replica. closing connection", domain),
+					slog.String("module", "agent"),
+					slog.String("group", "realtime"),
+				)
+				state.conn.Close()
+				state.conn = nil
+			}
+			m.mu.Unlock()
+			continue
+		}
+
+		if state.conn != nil || state.connecting {
+			m.mu.Unlock()
+			continue
+		}
+
+		if state.connected {
+			slog.Info(
+				fmt.Sprintf("broken connection found: %s", domain),
+				slog.String("module", "agent"),
+				slog.String("group", "realtime"),
+			)
+			state.reconnects++
+		}
+		state.connecting = true
+		m.mu.Unlock()
+
+		// 応答しないドメインが他のドメインのリース更新を遅らせないよう並行して接続する
+		go m.connect(ctx, domain)
+	}
+}
+
+// Listen sends the current subscriptions of the domain over its connection, if this replica holds one
+func (m *RemoteSubscriptionManager) Listen(domain string) {
+	m.mu.Lock()
+	state, ok := m.remotes[domain]
+	if !ok || state.conn == nil {
+		m.mu.Unlock()
+		return
+	}
+	conn := state.conn
+	request := channelRequest{
+		Type:     "listen",
+		Channels: slices.Clone(state.timelines),
+	}
+	m.mu.Unlock()
+
+	state.writeMu.Lock()
+	err := conn.WriteJSON(request)
+	state.writeMu.Unlock()
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("fail to send subscribe request to remote server %v", domain),
+			slog.String("error", err.Error()),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+		m.disconnect(domain, conn)
+		return
+	}
+
+	slog.Info(
+		fmt.Sprintf("remote connection updated: %s > %s", domain, request.Channels),
+		slog.String("module", "agent"),
+		slog.String("group", "realtime"),
+	)
+}
+
+// Metrics returns the aggregated and per-domain connection state.
+// per-domain keys are "remote.<domain>.<metric>"
+func (m *RemoteSubscriptionManager) Metrics() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := make(map[string]int64)
+	var conns int64
+	for domain, state := range m.remotes {
+		prefix := "remote." + domain + "."
+		metrics[prefix+"subscriptions"] = int64(len(state.timelines))
+		metrics[prefix+"leader"] = boolToInt64(state.leader)
+		metrics[prefix+"connected"] = boolToInt64(state.conn != nil)
+		metrics[prefix+"reconnects"] = state.reconnects
+		if !state.lastEvent.IsZero() {
+			metrics[prefix+"last_event"] = state.lastEvent.Unix()
+		}
+
+		if state.conn != nil {
+			conns++
+		}
+	}
+	metrics["remoteSubs"] = int64(len(m.remotes))
+	metrics["remoteConns"] = conns
+
+	return metrics
+}
+
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (m *RemoteSubscriptionManager) remoteDomain(timeline string) (string, bool) {
+	split := strings.Split(timeline, "@")
+	if len(split) <= 1 {
+		return "", false
+	}
+	domain := split[len(split)-1]
+	if domain == m.config.FQDN {
+		return "", false
+	}
+	return domain, true
+}
+
+func (m *RemoteSubscriptionManager) acquireLease(ctx context.Context, domain string) (bool, error) {
+	return acquireLease(ctx, m.rdb, remoteLeasePrefix+domain, m.instanceID, remoteLeaseTTL)
+}
+
+// newInstanceID returns an id identifying this replica as a lease owner
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 8)
+	rand.Read(random)
+	return hostname + ":" + hex.EncodeToString(random)
+}
+
+// acquireLease takes the lease of key, or renews it when owner already holds it
+func acquireLease(ctx context.Context, rdb *redis.Client, key, owner string, ttl time.Duration) (bool, error) {
+	acquired, err := rdb.SetNX(ctx, key, owner, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	if acquired {
+		return true, nil
+	}
+
+	renewed, err := renewLeaseScript.Run(ctx, rdb, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return renewed == 1, nil
+}
+
+func (m *RemoteSubscriptionManager) connect(ctx context.Context, domain string) {
+	defer func() {
+		m.mu.Lock()
+		if state, ok := m.remotes[domain]; ok {
+			state.connecting = false
+		}
+		m.mu.Unlock()
+	}()
+
+	// check server availability
+	domainInfo, err := m.client.GetDomain(ctx, domain, nil)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("fail to get domain info: %v", err),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+		return
+	}
+	if domainInfo.Dimension != m.config.Dimension {
+		slog.Error(
+			fmt.Sprintf("domain dimention mismatch: %s", domain),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+		return
+	}
+
+	u := url.URL{Scheme: "wss", Host: domain, Path: "/api/v1/timelines/realtime"}
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = 10 * time.Second
+
+	c, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("fail to dial to %v (%v)", domain, err),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+		return
+	}
+
+	m.mu.Lock()
+	state, ok := m.remotes[domain]
+	if !ok || !state.leader {
+		// 接続中に購読がなくなった、もしくはリースを失った
+		m.mu.Unlock()
+		c.Close()
+		return
+	}
+	state.conn = c
+	state.connected = true
+	m.mu.Unlock()
+
+	go m.readRoutine(ctx, domain, c)
+	go m.pingRoutine(domain, c, state)
+
+	m.Listen(domain)
+}
+
+// disconnect closes the connection if it is still the current one of the domain
+func (m *RemoteSubscriptionManager) disconnect(domain string, c *websocket.Conn) {
+	c.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.remotes[domain]; ok && state.conn == c {
+		state.conn = nil
+	}
+}
+
+// readRoutine relays messages from the remote server to redis
+func (m *RemoteSubscriptionManager) readRoutine(ctx context.Context, domain string, c *websocket.Conn) {
+	defer func() {
+		m.disconnect(domain, c)
+		slog.Info(
+			fmt.Sprintf("remote connection closed: %s", domain),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+	}()
+
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("fail to read message: %v", err),
+				slog.String("module", "agent"),
+				slog.String("group", "realtime"),
+			)
+			return
+		}
+
+		m.mu.Lock()
+		if state, ok := m.remotes[domain]; ok {
+			state.lastEvent = time.Now()
+		}
+		m.mu.Unlock()
+
+		m.relay(ctx, message)
+	}
+}
+
+func (m *RemoteSubscriptionManager) relay(ctx context.Context, message []byte) {
+	var event core.Event
+	err := json.Unmarshal(message, &event)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("fail to Unmarshall redis message"),
+			slog.String("error", err.Error()),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+		return
+	}
+
+	// publish message to Redis with the sequence of this domain
+	_, err = publishEvent(ctx, m.rdb, event)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("fail to publish message to Redis"),
+			slog.String("error", err.Error()),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+		return
+	}
+
+	if event.Item == nil || event.Item.ResourceID == "" {
+		return
+	}
+
+	// update cache
+	// Note: see x/timeline/repository.go CreateItem
+	err = addItemToChunkCache(ctx, m.cache, m.config, m.invalidator.Enqueue, event.Timeline, *event.Item)
+	if err != nil {
+		slog.Error(
+			"fail to update chunk cache",
+			slog.String("error", err.Error()),
+			slog.String("module", "agent"),
+			slog.String("group", "realtime"),
+		)
+	}
+}
+
+// pingRoutine keeps the connection alive and closes it on pong timeout
+func (m *RemoteSubscriptionManager) pingRoutine(domain string, c *websocket.Conn, state *remoteState) {
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+
+	// pong handler runs on the read goroutine
+	var lastPong atomic.Int64
+	lastPong.Store(time.Now().UnixNano())
+	c.SetPongHandler(func(string) error {
+		lastPong.Store(time.Now().UnixNano())
+		return nil
+	})
+
+	for range pingTicker.C {
+		m.mu.Lock()
+		current := state.conn == c
+		m.mu.Unlock()
+		if !current {
+			return
+		}
+
+		state.writeMu.Lock()
+		err := c.WriteMessage(websocket.PingMessage, []byte{})
+		state.writeMu.Unlock()
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("fail to send ping message: %v", err),
+				slog.String("module", "agent"),
+				slog.String("group", "realtime"),
+			)
+			m.disconnect(domain, c)
+			return
+		}
+
+		if time.Since(time.Unix(0, lastPong.Load())) > disconnectTimeout {
+			slog.Warn(
+				fmt.Sprintf("pong timeout: %s", domain),
+				slog.String("module", "agent"),
+				slog.String("group", "realtime"),
+			)
+			m.disconnect(domain, c)
+			return
+		}
+	}
+}
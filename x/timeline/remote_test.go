@@ -0,0 +1,115 @@
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/totegamma/concurrent/client"
+	"github.com/totegamma/concurrent/client/mock"
+	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/internal/testutil"
+)
+
+func TestRemoteSubscriptionManager(t *testing.T) {
+	m := NewRemoteSubscriptionManager(nil, nil, nil, nil, core.Config{FQDN: "local.example.com"})
+
+	changed := m.Add([]string{
+		"t00000000000000000000000000@local.example.com",
+		"t00000000000000000000000001@remote1.example.com",
+		"t00000000000000000000000002@remote1.example.com",
+		"t00000000000000000000000003@remote2.example.com",
+		"invalid",
+	})
+	assert.ElementsMatch(t, []string{"remote1.example.com", "remote2.example.com"}, changed)
+
+	// 既存の購読だけなら変更なし
+	changed = m.Add([]string{"t00000000000000000000000001@remote1.example.com"})
+	assert.Empty(t, changed)
+
+	assert.ElementsMatch(t, []string{
+		"t00000000000000000000000001@remote1.example.com",
+		"t00000000000000000000000002@remote1.example.com",
+		"t00000000000000000000000003@remote2.example.com",
+	}, m.Subscriptions())
+
+	metrics := m.Metrics()
+	assert.Equal(t, int64(2), metrics["remoteSubs"])
+	assert.Equal(t, int64(0), metrics["remoteConns"])
+	assert.Equal(t, int64(2), metrics["remote.remote1.example.com.subscriptions"])
+	assert.Equal(t, int64(0), metrics["remote.remote1.example.com.connected"])
+
+	closed := m.Retain(context.Background(), []string{"t00000000000000000000000002@remote1.example.com"})
+	assert.Equal(t, []string{"remote2.example.com"}, closed)
+	assert.Equal(t, []string{"t00000000000000000000000002@remote1.example.com"}, m.Subscriptions())
+}
+
+func TestRemoteSubscriptionManagerLease(t *testing.T) {
+	rdb, cleanup_rdb := testutil.CreateRDB()
+	defer cleanup_rdb()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 接続を保留させ、Syncが接続を待たないことを確認する
+	release := make(chan struct{})
+	defer close(release)
+	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetDomain(gomock.Any(), "remote1.example.com", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, domain string, opts *client.Options) (core.Domain, error) {
+			<-release
+			return core.Domain{}, fmt.Errorf("unavailable")
+		},
+	).AnyTimes()
+
+	config := core.Config{FQDN: "local.example.com"}
+	m1 := NewRemoteSubscriptionManager(rdb, nil, nil, mockClient, config)
+	m2 := NewRemoteSubscriptionManager(rdb, nil, nil, mockClient, config)
+
+	m1.Add([]string{"t00000000000000000000000001@remote1.example.com"})
+	m2.Add([]string{"t00000000000000000000000001@remote1.example.com"})
+
+	done := make(chan struct{})
+	go func() {
+		m1.Sync(ctx)
+		m2.Sync(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sync blocked on connect")
+	}
+
+	assert.Equal(t, int64(1), m1.Metrics()["remote.remote1.example.com.leader"])
+	assert.Equal(t, int64(0), m2.Metrics()["remote.remote1.example.com.leader"])
+
+	// リースの保持者は更新できる
+	leader, err := m1.acquireLease(ctx, "remote1.example.com")
+	assert.NoError(t, err)
+	assert.True(t, leader)
+
+	ttl, err := rdb.PTTL(ctx, remoteLeasePrefix+"remote1.example.com").Result()
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, remoteLeaseTTL-5*time.Second)
+
+	leader, err = m2.acquireLease(ctx, "remote1.example.com")
+	assert.NoError(t, err)
+	assert.False(t, leader)
+
+	// リースが失効したら他のレプリカが引き継ぐ
+	err = rdb.Del(ctx, remoteLeasePrefix+"remote1.example.com").Err()
+	assert.NoError(t, err)
+
+	leader, err = m2.acquireLease(ctx, "remote1.example.com")
+	assert.NoError(t, err)
+	assert.True(t, leader)
+
+	leader, err = m1.acquireLease(ctx, "remote1.example.com")
+	assert.NoError(t, err)
+	assert.False(t, leader)
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/totegamma/concurrent/client"
 	"github.com/totegamma/concurrent/core"
+	"github.com/totegamma/concurrent/x/policy"
 )
 
 // Repository is timeline repository interface
@@ -45,7 +46,8 @@ type Repository interface {
 	ListTimelineSubscriptions(ctx context.Context) (map[string]int64, error)
 	Count(ctx context.Context) (int64, error)
 
-	Subscribe(ctx context.Context, channels []string, event chan<- core.Event) error
+	Subscribe(ctx context.Context, channels []string, since map[string]int64, event chan<- core.Event) error
+	WatchSubscription(ctx context.Context, subscription string, changed chan<- string) error
 
 	SetNormalizationCache(ctx context.Context, timelineID string, value string) error
 	GetNormalizationCache(ctx context.Context, timelineID string) (string, error)
@@ -58,6 +60,11 @@ type Repository interface {
 	ListRecentlyRemovedItems(ctx context.Context, normalized []string) (map[string][]string, error)
 	ListRecentlyRemovedItemsLocal(ctx context.Context, timelineIDs []string) (map[string][]string, error)
 
+	TouchHomeFeed(ctx context.Context, subscription string) (string, error)
+	RegisterHomeFeed(ctx context.Context, subscription string, timelines []string) error
+	FillHomeFeed(ctx context.Context, subscription string, items []core.TimelineItem) error
+	GetHomeFeedItems(ctx context.Context, subscription string, until time.Time, limit int) ([]core.TimelineItem, error)
+
 	GetMetrics() map[string]int64
 }
 
@@ -65,6 +72,7 @@ type repository struct {
 	db     *gorm.DB
 	rdb    *redis.Client
 	mc     *memcache.Client
+	cache  core.ChunkCache
 	keeper Keeper
 	client client.Client
 	schema core.SchemaService
@@ -77,11 +85,12 @@ type repository struct {
 }
 
 // NewRepository creates a new timeline repository
-func NewRepository(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, keeper Keeper, client client.Client, schema core.SchemaService, config core.Config) Repository {
+func NewRepository(db *gorm.DB, rdb *redis.Client, mc *memcache.Client, cache core.ChunkCache, keeper Keeper, client client.Client, schema core.SchemaService, config core.Config) Repository {
 	return &repository{
 		db,
 		rdb,
 		mc,
+		cache,
 		keeper,
 		client,
 		schema,
@@ -137,15 +146,7 @@ func (r *repository) LookupChunkItrs(ctx context.Context, normalized []string, e
 	ctx, span := tracer.Start(ctx, "Timeline.Repository.LookupChunkItr")
 	defer span.End()
 
-	keys := make([]string, len(normalized))
-	keytable := make(map[string]string)
-	for i, timeline := range normalized {
-		key := tlItrCachePrefix + timeline + ":" + epoch
-		keys[i] = key
-		keytable[key] = timeline
-	}
-
-	cache, err := r.mc.GetMulti(keys)
+	cache, err := r.cache.GetItrs(ctx, normalized, epoch)
 	if err != nil {
 		span.RecordError(err)
 		//return nil, err
@@ -153,10 +154,9 @@ func (r *repository) LookupChunkItrs(ctx context.Context, normalized []string, e
 
 	var result = map[string]string{}
 	var missed = []string{}
-	for _, key := range keys {
-		timeline := keytable[key]
-		if cache[key] != nil {
-			result[timeline] = string(cache[key].Value)
+	for _, timeline := range normalized {
+		if itr, ok := cache[timeline]; ok {
+			result[timeline] = itr
 			r.lookupChunkItrsCacheHits++
 		} else {
 			missed = append(missed, timeline)
@@ -209,15 +209,7 @@ func (r *repository) LoadChunkBodies(ctx context.Context, query map[string]strin
 	ctx, span := tracer.Start(ctx, "Timeline.Repository.LoadChunkBodies")
 	defer span.End()
 
-	keys := []string{}
-	keytable := map[string]string{}
-	for timeline, epoch := range query {
-		key := tlBodyCachePrefix + timeline + ":" + epoch
-		keys = append(keys, key)
-		keytable[key] = timeline
-	}
-
-	cache, err := r.mc.GetMulti(keys)
+	cache, err := r.cache.GetBodies(ctx, query)
 	if err != nil {
 		span.RecordError(err)
 		//return nil, err
@@ -226,26 +218,16 @@ func (r *repository) LoadChunkBodies(ctx context.Context, query map[string]strin
 	result := make(map[string]core.Chunk)
 	var missed = map[string]string{}
 
-	for _, key := range keys {
-		timeline := keytable[key]
-		if cache[key] != nil {
-			var items []core.TimelineItem
-			cacheStr := string(cache[key].Value)
-			cacheStr = cacheStr[1:]
-			cacheStr = "[" + cacheStr + "]"
-			err = json.Unmarshal([]byte(cacheStr), &items)
-			if err != nil {
-				span.RecordError(err)
-				continue
-			}
+	for timeline, epoch := range query {
+		if items, ok := cache[timeline]; ok {
 			result[timeline] = core.Chunk{
-				Key:   key,
-				Epoch: query[timeline],
+				Key:   chunkBodyKey(timeline, epoch),
+				Epoch: epoch,
 				Items: items,
 			}
 			r.loadChunkBodiesCacheHits++
 		} else {
-			missed[timeline] = query[timeline]
+			missed[timeline] = epoch
 			r.loadChunkBodiesCacheMisses++
 		}
 	}
@@ -326,7 +308,7 @@ func (r *repository) lookupLocalItrs(ctx context.Context, timelines []string, ep
 		err := r.db.WithContext(ctx).
 			Model(&core.TimelineItem{}).
 			Select("timeline_id, max(c_date) as max_c_date").
-			Where("timeline_id in (?) and c_date <= ?", dbids, core.Chunk2RecentTime(epoch)).
+			Where("timeline_id in (?) and c_date <= ?", dbids, r.config.Chunk2RecentTime(epoch)).
 			Group("timeline_id").
 			Scan(&res).Error
 		if err != nil {
@@ -336,10 +318,9 @@ func (r *repository) lookupLocalItrs(ctx context.Context, timelines []string, ep
 
 		for _, item := range res {
 			id := "t" + item.TimelineID + "@" + r.config.FQDN
-			key := tlItrCachePrefix + id + ":" + epoch
-			value := core.Time2Chunk(item.MaxCDate)
-			span.AddEvent(fmt.Sprintf("cache lookupLocalItrs: %s", key))
-			r.mc.Set(&memcache.Item{Key: key, Value: []byte(value), Expiration: tlItrCacheTTL})
+			value := r.config.Time2Chunk(item.MaxCDate)
+			span.AddEvent(fmt.Sprintf("cache lookupLocalItrs: %s", chunkItrKey(id, epoch)))
+			r.cache.SetItr(ctx, id, epoch, value)
 			result[id] = value
 		}
 	}
@@ -357,25 +338,38 @@ func (r *repository) lookupRemoteItrs(ctx context.Context, domain string, timeli
 		attribute.String("epoch", epoch),
 	)
 
-	result, err := r.client.GetChunkItrs(ctx, domain, timelines, epoch, nil)
+	// リモートのチャンク長が異なる場合は、ローカルのチャンク末尾を含むリモートのチャンクで問い合わせる
+	remoteLength, err := r.client.GetChunkLength(ctx, domain, nil)
+	if err != nil {
+		span.RecordError(err)
+	}
+	remoteEpoch := core.TranslateChunk(epoch, r.config.LocalChunkLength(), remoteLength)
+	span.SetAttributes(attribute.String("remoteEpoch", remoteEpoch))
+
+	result, err := r.client.GetChunkItrs(ctx, domain, timelines, remoteEpoch, nil)
 	if err != nil {
 		span.RecordError(err)
 		return nil, err
 	}
 
+	if remoteLength != r.config.LocalChunkLength() {
+		for timeline, itr := range result {
+			result[timeline] = translateRemoteItr(itr, epoch, remoteLength, r.config.LocalChunkLength())
+		}
+	}
+
 	currentSubscriptions := r.keeper.GetRemoteSubs()
 	span.SetAttributes(attribute.StringSlice("currentSubscriptions", currentSubscriptions))
 	for timeline, itr := range result {
 
 		// 最新のチャンクに関しては、socketが張られてるキャッシュしか温められないのでそれだけ保持
-		if epoch == core.Time2Chunk(time.Now()) && !slices.Contains(currentSubscriptions, timeline) {
+		if epoch == r.config.Time2Chunk(time.Now()) && !slices.Contains(currentSubscriptions, timeline) {
 			span.AddEvent(fmt.Sprintf("continue: %s", timeline))
 			continue
 		}
 
-		key := tlItrCachePrefix + timeline + ":" + epoch
-		span.AddEvent(fmt.Sprintf("cache lookupRemoteItrs: %s", key))
-		r.mc.Set(&memcache.Item{Key: key, Value: []byte(itr), Expiration: tlItrCacheTTL})
+		span.AddEvent(fmt.Sprintf("cache lookupRemoteItrs: %s", chunkItrKey(timeline, epoch)))
+		r.cache.SetItr(ctx, timeline, epoch, itr)
 	}
 
 	return result, nil
@@ -385,8 +379,8 @@ func (r *repository) loadLocalBody(ctx context.Context, timeline string, epoch s
 	ctx, span := tracer.Start(ctx, "Timeline.Repository.LoadLocalBody")
 	defer span.End()
 
-	chunkDate := core.Chunk2RecentTime(epoch)
-	prevChunkDate := core.Chunk2RecentTime(core.PrevChunk(epoch))
+	chunkDate := r.config.Chunk2RecentTime(epoch)
+	prevChunkDate := r.config.Chunk2RecentTime(r.config.PrevChunk(epoch))
 
 	timelineID := timeline
 	if strings.Contains(timelineID, "@") {
@@ -426,15 +420,9 @@ func (r *repository) loadLocalBody(ctx context.Context, timeline string, epoch s
 		items[i].TimelineID = item.TimelineID + "@" + r.config.FQDN
 	}
 
-	b, err := json.Marshal(items)
-	if err != nil {
-		span.RecordError(err)
-		return core.Chunk{}, err
-	}
-	key := tlBodyCachePrefix + timeline + ":" + epoch
-	cacheStr := "," + string(b[1:len(b)-1])
+	key := chunkBodyKey(timeline, epoch)
 	span.AddEvent(fmt.Sprintf("cache loadLocalBody: %s", key))
-	err = r.mc.Set(&memcache.Item{Key: key, Value: []byte(cacheStr), Expiration: tlBodyCacheTTL})
+	err = r.cache.SetBody(ctx, timeline, epoch, items)
 	if err != nil {
 		span.RecordError(err)
 	}
@@ -451,7 +439,17 @@ func (r *repository) loadRemoteBodies(ctx context.Context, remote string, query
 	ctx, span := tracer.Start(ctx, "Timeline.Repository.LoadRemoteBody")
 	defer span.End()
 
-	result, err := r.client.GetChunkBodies(ctx, remote, query, nil)
+	remoteLength, err := r.client.GetChunkLength(ctx, remote, nil)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	var result map[string]core.Chunk
+	if remoteLength == r.config.LocalChunkLength() {
+		result, err = r.client.GetChunkBodies(ctx, remote, query, nil)
+	} else {
+		result, err = r.loadTranslatedRemoteBodies(ctx, remote, remoteLength, query)
+	}
 	if err != nil {
 		span.RecordError(err)
 		return nil, err
@@ -461,7 +459,7 @@ func (r *repository) loadRemoteBodies(ctx context.Context, remote string, query
 	for timeline, chunk := range result {
 
 		// 最新のチャンクに関しては、socketが張られてるキャッシュしか温められないのでそれだけ保持
-		if chunk.Epoch == core.Time2Chunk(time.Now()) && !slices.Contains(currentSubscriptions, timeline) {
+		if chunk.Epoch == r.config.Time2Chunk(time.Now()) && !slices.Contains(currentSubscriptions, timeline) {
 			span.AddEvent(fmt.Sprintf("continue: %s", timeline))
 			continue
 		}
@@ -471,19 +469,64 @@ func (r *repository) loadRemoteBodies(ctx context.Context, remote string, query
 			continue
 		}
 
-		key := tlBodyCachePrefix + timeline + ":" + chunk.Epoch
-		b, err := json.Marshal(chunk.Items)
+		span.AddEvent(fmt.Sprintf("cache loadRemoteBodies: %s", chunkBodyKey(timeline, chunk.Epoch)))
+		err = r.cache.SetBody(ctx, timeline, chunk.Epoch, chunk.Items)
 		if err != nil {
 			span.RecordError(err)
 			continue
 		}
-		cacheStr := "," + string(b[1:len(b)-1])
-		span.AddEvent(fmt.Sprintf("cache loadRemoteBodies: %s", key))
-		err = r.mc.Set(&memcache.Item{Key: key, Value: []byte(cacheStr), Expiration: tlBodyCacheTTL})
+	}
+
+	return result, nil
+}
+
+// loadTranslatedRemoteBodies builds local chunks from a remote domain that uses a different chunk length.
+// Remote chunks are fetched from the one holding the end of the local chunk backwards until its start is covered.
+func (r *repository) loadTranslatedRemoteBodies(ctx context.Context, remote string, remoteLength int64, query map[string]string) (map[string]core.Chunk, error) {
+	ctx, span := tracer.Start(ctx, "Timeline.Repository.LoadTranslatedRemoteBodies")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int64("remoteLength", remoteLength))
+
+	bodies := make(map[string]*remoteBody)
+	pending := make(map[string]string)
+	for timeline, epoch := range query {
+		bodies[timeline] = newRemoteBody(epoch, r.config.LocalChunkLength())
+		pending[timeline] = core.TranslateChunk(epoch, r.config.LocalChunkLength(), remoteLength)
+	}
+
+	for round := 0; len(pending) > 0; round++ {
+		if round >= maxChunkTranslationRounds {
+			span.AddEvent(fmt.Sprintf("translation rounds exceeded: %d timelines left", len(pending)))
+			break
+		}
+
+		chunks, err := r.client.GetChunkBodies(ctx, remote, pending, nil)
 		if err != nil {
+			if round == 0 {
+				span.RecordError(err)
+				return nil, err
+			}
 			span.RecordError(err)
-			continue
+			break
+		}
+
+		next := make(map[string]string)
+		for timeline, remoteEpoch := range pending {
+			chunk, ok := chunks[timeline]
+			if !ok {
+				continue
+			}
+			if prev, more := bodies[timeline].add(remoteEpoch, remoteLength, chunk.Items); more {
+				next[timeline] = prev
+			}
 		}
+		pending = next
+	}
+
+	result := make(map[string]core.Chunk)
+	for timeline, body := range bodies {
+		result[timeline] = body.chunk(timeline)
 	}
 
 	return result, nil
@@ -609,9 +652,7 @@ func (r *repository) PublishEvent(ctx context.Context, event core.Event) error {
 	ctx, span := tracer.Start(ctx, "Timeline.Repository.PublishEvent")
 	defer span.End()
 
-	jsonstr, _ := json.Marshal(event)
-
-	err := r.rdb.Publish(context.Background(), event.Timeline, jsonstr).Err()
+	_, err := publishEvent(context.Background(), r.rdb, event)
 	if err != nil {
 		span.RecordError(err)
 		slog.ErrorContext(
@@ -637,6 +678,9 @@ func (r *repository) getTimelineFromRemote(ctx context.Context, host, key string
 		return core.Timeline{}, err
 	}
 
+	// ホストドメインのローカルポリシーを自ドメインのものと取り違えないようにする
+	timeline.Policy = policy.QualifyLocalPolicyURL(timeline.Policy, host)
+
 	// save to cache
 	go func() {
 		body, err := json.Marshal(timeline)
@@ -758,29 +802,14 @@ func (r *repository) CreateItem(ctx context.Context, item core.TimelineItem) (co
 
 	timelineID := "t" + item.TimelineID + "@" + r.config.FQDN
 
-	json, err := json.Marshal(item)
-	if err != nil {
-		span.RecordError(err)
-		return item, err
-	}
-
-	val := "," + string(json)
-	itemChunk := core.Time2Chunk(item.CDate)
-	itrKey := tlItrCachePrefix + timelineID + ":" + itemChunk
-	cacheKey := tlBodyCachePrefix + timelineID + ":" + itemChunk
-
 	// もし今からPrependするbodyブロックにイテレーターが向いてない場合は向きを変えておく必要がある
 	// これが発生するのは、タイムラインが久々に更新されたときで、最近のイテレーターが古いbodyブロックを向いている状態になっている
 	// そのため、イテレーターを更新しないと、古いbodyブロック(更新されない)を見続けてしまう為、新しく書き込んだデータが読み込まれない。
-	// Note:
-	// この処理は今から挿入するアイテムが最新のチャンクであることが前提になっている。
-	// 古いデータを挿入する場合は、書き込みを行ったチャンクから最新のチャンクまでのイテレーターを更新する必要があるかも。
-	// 範囲でforを回して、キャッシュをdeleteする処理を追加する必要があるだろう...
-	span.AddEvent(fmt.Sprintf("cache CreateItem: %s -> %s", itrKey, cacheKey))
-	err = r.mc.Replace(&memcache.Item{Key: itrKey, Value: []byte(itemChunk)})
-	span.AddEvent(fmt.Sprintf("replace err: %v", err))
-	err = r.mc.Prepend(&memcache.Item{Key: cacheKey, Value: []byte(val)})
-	span.AddEvent(fmt.Sprintf("prepend err: %v", err))
+	// 古いデータを挿入した場合は、書き込んだチャンクから最新のチャンクまでのイテレーターをバックグラウンドで無効化する (see addItemToChunkCache)
+	err = addItemToChunkCache(ctx, r.cache, r.config, r.keeper.InvalidateChunkItrs, timelineID, item)
+	if err != nil {
+		span.RecordError(err)
+	}
 
 	item.TimelineID = "t" + item.TimelineID
 
@@ -1136,7 +1165,9 @@ func (r *repository) ListTimelineSubscriptions(ctx context.Context) (map[string]
 	return result, nil
 }
 
-func (r *repository) Subscribe(ctx context.Context, channels []string, event chan<- core.Event) error {
+// Subscribe streams events of the channels.
+// Events after the sequence given in since are replayed before the live events.
+func (r *repository) Subscribe(ctx context.Context, channels []string, since map[string]int64, event chan<- core.Event) error {
 
 	if len(channels) == 0 {
 		return nil
@@ -1145,8 +1176,14 @@ func (r *repository) Subscribe(ctx context.Context, channels []string, event cha
 	pubsub := r.rdb.Subscribe(ctx, channels...)
 	defer pubsub.Close()
 
+	// wait for the subscription so that nothing is lost between replay and live events
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		return err
+	}
+
 	chanstr := strings.Join(channels, ",")
-	err := r.rdb.Publish(context.Background(), "concrnt:subscription:updated", chanstr).Err()
+	err = r.rdb.Publish(context.Background(), "concrnt:subscription:updated", chanstr).Err()
 	if err != nil {
 		slog.ErrorContext(
 			ctx, "fail to publish message to Redis",
@@ -1157,6 +1194,34 @@ func (r *repository) Subscribe(ctx context.Context, channels []string, event cha
 
 	psch := pubsub.Channel()
 
+	lastSeq := make(map[string]int64)
+	for _, channel := range channels {
+		seq, ok := since[channel]
+		if !ok {
+			continue
+		}
+
+		missed, last, err := replayEvents(ctx, r.rdb, channel, seq)
+		if err != nil {
+			slog.ErrorContext(
+				ctx, "failed to replay events",
+				slog.String("error", err.Error()),
+				slog.String("timeline", channel),
+				slog.String("module", "timeline"),
+			)
+			continue
+		}
+		lastSeq[channel] = last
+
+		for _, item := range missed {
+			select {
+			case <-ctx.Done():
+				return nil
+			case event <- item:
+			}
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -1171,7 +1236,36 @@ func (r *repository) Subscribe(ctx context.Context, channels []string, event cha
 				)
 				continue
 			}
-			event <- item
+			// already delivered by the replay
+			if item.Seq != 0 && item.Seq <= lastSeq[item.Timeline] {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case event <- item:
+			}
+		}
+	}
+}
+
+// WatchSubscription notifies when the items of the subscription are changed
+func (r *repository) WatchSubscription(ctx context.Context, subscription string, changed chan<- string) error {
+	pubsub := r.rdb.Subscribe(ctx, core.SubscriptionChangedChannelPrefix+subscription)
+	defer pubsub.Close()
+
+	psch := pubsub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-psch:
+			select {
+			case <-ctx.Done():
+				return nil
+			case changed <- subscription:
+			}
 		}
 	}
 }
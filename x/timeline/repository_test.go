@@ -42,12 +42,15 @@ func TestCreateItem(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 
 	repo := repository{
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -123,8 +126,8 @@ func TestLoadChunkBodies(t *testing.T) {
 	mc, cleanup_mc := testutil.CreateMC()
 	defer cleanup_mc()
 
-	pivotEpoch := core.Time2Chunk(time.Now())
-	pivotTime := core.Chunk2RecentTime(pivotEpoch)
+	pivotEpoch := core.Time2ChunkWithLength(time.Now(), core.DefaultChunkLength)
+	pivotTime := core.Chunk2RecentTimeWithLength(pivotEpoch, core.DefaultChunkLength)
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -134,6 +137,7 @@ func TestLoadChunkBodies(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 	mockClient.EXPECT().GetChunkBodies(
 		gomock.Any(),
 		"remote.example.com",
@@ -194,12 +198,14 @@ func TestLoadChunkBodies(t *testing.T) {
 	)
 
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 	mockKeeper.EXPECT().GetRemoteSubs().Return([]string{"t00000000000000000000000000@remote.example.com"}).Times(2)
 
 	repo := repository{
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -295,7 +301,7 @@ func TestLookupChunkItrs(t *testing.T) {
 	defer cleanup_mc()
 
 	pivotTime := time.Now()
-	pivotEpoch := core.Time2Chunk(pivotTime)
+	pivotEpoch := core.Time2ChunkWithLength(pivotTime, core.DefaultChunkLength)
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -305,6 +311,7 @@ func TestLookupChunkItrs(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 	mockClient.EXPECT().GetChunkItrs(
 		gomock.Any(),
 		"remote.example.com",
@@ -323,6 +330,7 @@ func TestLookupChunkItrs(t *testing.T) {
 	)
 
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 	mockKeeper.EXPECT().GetRemoteSubs().Return([]string{
 		"t00000000000000000000000000@remote.example.com",
 		"t11111111111111111111111111@remote.example.com",
@@ -332,6 +340,7 @@ func TestLookupChunkItrs(t *testing.T) {
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -360,7 +369,7 @@ func TestLookupChunkItrs(t *testing.T) {
 	assert.NoError(t, err)
 
 	itemPivotTime := pivotTime.Add(-time.Minute * 0)
-	//itemPivotEpoch := core.Time2Chunk(itemPivotTime)
+	//itemPivotEpoch := core.Time2ChunkWithLength(itemPivotTime, core.DefaultChunkLength)
 
 	// Itemを追加
 	_, err = repo.CreateItem(ctx, core.TimelineItem{
@@ -452,7 +461,7 @@ func TestLoadRemoteBodies(t *testing.T) {
 	defer cleanup_mc()
 
 	pivotTime := time.Now()
-	pivotEpoch := core.Time2Chunk(pivotTime)
+	pivotEpoch := core.Time2ChunkWithLength(pivotTime, core.DefaultChunkLength)
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -462,6 +471,7 @@ func TestLoadRemoteBodies(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 	mockClient.EXPECT().GetChunkBodies(
 		gomock.Any(),
 		"remote.example.com",
@@ -499,6 +509,7 @@ func TestLoadRemoteBodies(t *testing.T) {
 	)
 
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 	mockKeeper.EXPECT().GetRemoteSubs().Return([]string{
 		"t00000000000000000000000000@remote.example.com",
 	})
@@ -507,6 +518,7 @@ func TestLoadRemoteBodies(t *testing.T) {
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -570,7 +582,7 @@ func TestLookupRemoteItrs(t *testing.T) {
 	defer cleanup_mc()
 
 	pivotTime := time.Now()
-	pivotEpoch := core.Time2Chunk(pivotTime)
+	pivotEpoch := core.Time2ChunkWithLength(pivotTime, core.DefaultChunkLength)
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -580,6 +592,7 @@ func TestLookupRemoteItrs(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 	mockClient.EXPECT().GetChunkItrs(
 		gomock.Any(),
 		"remote.example.com",
@@ -598,6 +611,7 @@ func TestLookupRemoteItrs(t *testing.T) {
 	)
 
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 	mockKeeper.EXPECT().GetRemoteSubs().Return([]string{
 		"t00000000000000000000000000@remote.example.com",
 		"t11111111111111111111111111@remote.example.com",
@@ -607,6 +621,7 @@ func TestLookupRemoteItrs(t *testing.T) {
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -662,7 +677,7 @@ func TestLookupLocalItrs(t *testing.T) {
 	defer cleanup_mc()
 
 	pivotTime := time.Now()
-	pivotEpoch := core.Time2Chunk(pivotTime)
+	pivotEpoch := core.Time2ChunkWithLength(pivotTime, core.DefaultChunkLength)
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -672,13 +687,16 @@ func TestLookupLocalItrs(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 
 	repo := repository{
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -707,7 +725,7 @@ func TestLookupLocalItrs(t *testing.T) {
 	assert.NoError(t, err)
 
 	itemPivotTime := pivotTime.Add(-time.Minute * 0)
-	itemPivotEpoch := core.Time2Chunk(itemPivotTime)
+	itemPivotEpoch := core.Time2ChunkWithLength(itemPivotTime, core.DefaultChunkLength)
 
 	// Itemを追加
 	_, err = repo.CreateItem(ctx, core.TimelineItem{
@@ -765,8 +783,8 @@ func TestLoadLocalBody(t *testing.T) {
 	mc, cleanup_mc := testutil.CreateMC()
 	defer cleanup_mc()
 
-	pivotEpoch := core.Time2Chunk(time.Now())
-	pivotTime := core.Chunk2RecentTime(pivotEpoch)
+	pivotEpoch := core.Time2ChunkWithLength(time.Now(), core.DefaultChunkLength)
+	pivotTime := core.Chunk2RecentTimeWithLength(pivotEpoch, core.DefaultChunkLength)
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -776,13 +794,16 @@ func TestLoadLocalBody(t *testing.T) {
 	mockSchema.EXPECT().IDToUrl(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
 
 	mockClient := mock_client.NewMockClient(ctrl)
+	mockClient.EXPECT().GetChunkLength(gomock.Any(), gomock.Any(), gomock.Any()).Return(core.DefaultChunkLength, nil).AnyTimes()
 
 	mockKeeper := mock_timeline.NewMockKeeper(ctrl)
+	mockKeeper.EXPECT().InvalidateChunkItrs(gomock.Any(), gomock.Any()).AnyTimes()
 
 	repo := repository{
 		db:     db,
 		rdb:    rdb,
 		mc:     mc,
+		cache:  NewMemcachedChunkCache(mc),
 		keeper: mockKeeper,
 		client: mockClient,
 		schema: mockSchema,
@@ -890,3 +911,32 @@ func TestLoadLocalBody(t *testing.T) {
 	}
 
 }
+
+func TestWatchSubscription(t *testing.T) {
+	rdb, cleanup_rdb := testutil.CreateRDB()
+	defer cleanup_rdb()
+
+	repo := repository{rdb: rdb}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan string)
+	go repo.WatchSubscription(ctx, "s00000000000000000000000000", changed)
+
+	// 購読が確立するまで通知を繰り返す
+	timeout := time.After(5 * time.Second)
+	for {
+		err := rdb.Publish(ctx, core.SubscriptionChangedChannelPrefix+"s00000000000000000000000000", "").Err()
+		assert.NoError(t, err)
+
+		select {
+		case subscription := <-changed:
+			assert.Equal(t, "s00000000000000000000000000", subscription)
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timeout")
+		}
+	}
+}
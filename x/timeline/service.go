@@ -21,6 +21,16 @@ import (
 	"github.com/totegamma/concurrent/core"
 )
 
+func init() {
+	core.RegisterPolicyAction(
+		"timeline.create",
+		"timeline.delete",
+		"timeline.distribute",
+		"timeline.retract",
+		"timeline.update",
+	)
+}
+
 type service struct {
 	repository   Repository
 	entity       core.EntityService
@@ -267,19 +277,119 @@ func (s *service) GetRecentItemsFromSubscription(ctx context.Context, subscripti
 	ctx, span := tracer.Start(ctx, "Timeline.Service.GetRecentItemsFromSubscription")
 	defer span.End()
 
-	sub, err := s.subscription.GetSubscription(ctx, subscription)
+	timelines, err := s.resolveSubscription(ctx, subscription)
 	if err != nil {
 		return nil, err
 	}
 
-	timelines := make([]string, 0)
-	for _, t := range sub.Items {
-		timelines = append(timelines, t.ID)
+	if s.config.HomeFeed.Enabled && len(timelines) >= normalizeHomeFeedConfig(s.config.HomeFeed).MinTimelines {
+		items, ok := s.getRecentItemsFromHomeFeed(ctx, normalizeSubscriptionID(subscription), timelines, until, limit)
+		span.SetAttributes(attribute.Bool("homeFeed", ok))
+		if ok {
+			return items, nil
+		}
 	}
 
 	return s.GetRecentItems(ctx, timelines, until, limit)
 }
 
+// getRecentItemsFromHomeFeed serves the items from the materialized home feed of the subscription.
+// It returns false when the feed cannot answer, registering or filling the feed for the following reads.
+func (s *service) getRecentItemsFromHomeFeed(ctx context.Context, subscription string, timelines []string, until time.Time, limit int) ([]core.TimelineItem, bool) {
+	ctx, span := tracer.Start(ctx, "Timeline.Service.GetRecentItemsFromHomeFeed")
+	defer span.End()
+
+	state, err := s.repository.TouchHomeFeed(ctx, subscription)
+	if err != nil {
+		span.RecordError(err)
+		return nil, false
+	}
+
+	span.SetAttributes(attribute.String("state", state))
+
+	switch state {
+	case "":
+		normalized := make([]string, 0, len(timelines))
+		for _, timeline := range timelines {
+			normalizedTimeline, err := s.NormalizeTimelineID(ctx, timeline)
+			if err != nil {
+				continue
+			}
+			normalized = append(normalized, normalizedTimeline)
+		}
+		err := s.repository.RegisterHomeFeed(ctx, subscription, normalized)
+		if err != nil {
+			span.RecordError(err)
+		}
+		return nil, false
+	case homeFeedStateBuild:
+		// ワーカーがタイムラインを購読し始めた後なので、今のマージ結果で埋めれば取りこぼしはない
+		go s.fillHomeFeed(context.WithoutCancel(ctx), subscription, timelines)
+		return nil, false
+	case homeFeedStateReady:
+	default:
+		return nil, false
+	}
+
+	// 重複と削除済みのアイテムを除くので多めに取る
+	items, err := s.repository.GetHomeFeedItems(ctx, subscription, until, limit*2)
+	if err != nil {
+		span.RecordError(err)
+		return nil, false
+	}
+
+	cancelMap, err := s.ListRecentlyRemovedItems(ctx, timelines)
+	if err != nil {
+		span.RecordError(err)
+		return nil, false
+	}
+
+	// フィードのアイテムはどのタイムライン経由か分からないので、どれかで削除されていれば除く
+	retracted := make(map[string]bool)
+	for _, cancelList := range cancelMap {
+		for _, resourceID := range cancelList {
+			retracted[resourceID] = true
+		}
+	}
+
+	result := pickHomeFeedItems(items, retracted, limit)
+	if len(result) < limit {
+		// フィードに残っていない古い範囲はマージで取得する
+		return nil, false
+	}
+
+	return result, true
+}
+
+// fillHomeFeed fills the home feed with the merged recent items of the timelines
+func (s *service) fillHomeFeed(ctx context.Context, subscription string, timelines []string) {
+	ctx, span := tracer.Start(ctx, "Timeline.Service.FillHomeFeed")
+	defer span.End()
+
+	config := normalizeHomeFeedConfig(s.config.HomeFeed)
+
+	items, err := s.GetRecentItems(ctx, timelines, time.Now(), int(config.Size))
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(
+			ctx, fmt.Sprintf("failed to build home feed: %s", subscription),
+			slog.String("error", err.Error()),
+			slog.String("module", "timeline"),
+		)
+		return
+	}
+
+	err = s.repository.FillHomeFeed(ctx, subscription, items)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(
+			ctx, fmt.Sprintf("failed to fill home feed: %s", subscription),
+			slog.String("error", err.Error()),
+			slog.String("module", "timeline"),
+		)
+	}
+}
+
 type QueueItem struct {
 	Timeline string
 	Epoch    string
@@ -321,7 +431,7 @@ func (s *service) GetRecentItems(ctx context.Context, timelines []string, until
 		return nil, err
 	}
 
-	epoch := core.Time2Chunk(until)
+	epoch := s.config.Time2Chunk(until)
 	chunks, err := s.GetChunks(ctx, timelines, epoch)
 	if err != nil {
 		span.RecordError(err)
@@ -387,9 +497,9 @@ func (s *service) GetRecentItems(ctx context.Context, timelines []string, until
 				Index:    nextIndex,
 			})
 		} else {
-			prevEpoch := core.Time2Chunk(smallest.Item.CDate)
+			prevEpoch := s.config.Time2Chunk(smallest.Item.CDate)
 			if prevEpoch == smallest.Epoch {
-				prevEpoch = core.PrevChunk(prevEpoch)
+				prevEpoch = s.config.PrevChunk(prevEpoch)
 			}
 			prevChunks, err := s.GetChunks(ctx, []string{timeline}, prevEpoch)
 			if err != nil {
@@ -421,16 +531,11 @@ func (s *service) GetImmediateItemsFromSubscription(ctx context.Context, subscri
 	ctx, span := tracer.Start(ctx, "Timeline.Service.GetImmediateItemsFromSubscription")
 	defer span.End()
 
-	sub, err := s.subscription.GetSubscription(ctx, subscription)
+	timelines, err := s.resolveSubscription(ctx, subscription)
 	if err != nil {
 		return nil, err
 	}
 
-	timelines := make([]string, 0)
-	for _, t := range sub.Items {
-		timelines = append(timelines, t.ID)
-	}
-
 	return s.GetImmediateItems(ctx, timelines, since, limit)
 }
 
@@ -634,6 +739,15 @@ func (s *service) UpsertTimeline(ctx context.Context, mode core.CommitMode, docu
 		doc.Owner = doc.Signer
 	}
 
+	// リストアやリレーでは取得を伴う検証をしない
+	if doc.Policy != "" && mode != core.CommitModeLocalOnlyExec {
+		err = s.policy.ValidateWithPolicyURL(ctx, doc.Policy)
+		if err != nil {
+			span.RecordError(err)
+			return core.Timeline{}, err
+		}
+	}
+
 	if doc.ID == "" { // Create
 		hash := core.GetHash([]byte(document))
 		hash10 := [10]byte{}
@@ -965,56 +1079,181 @@ func (s *service) GetTimelineAutoDomain(ctx context.Context, timelineID string)
 	}
 }
 
-func (s *service) Realtime(ctx context.Context, request <-chan []string, response chan<- core.Event) {
+func (s *service) Realtime(ctx context.Context, request <-chan core.RealtimeRequest, response chan<- core.Event) {
 
 	atomic.AddInt64(&s.socketCounter, 1)
 	defer atomic.AddInt64(&s.socketCounter, -1)
 
 	var cancel context.CancelFunc
+	var watchCancel context.CancelFunc
 	events := make(chan core.Event)
+	changed := make(chan string)
 
 	var mapper map[string]string
+	var filter *core.RealtimeFilter
+	var maxChannels int
+	lastSeq := make(map[string]int64) // client timeline id -> last delivered seq
+
+	// 購読を展開した後のタイムライン数も制限する
+	tooMany := func(channels []string) bool {
+		if maxChannels <= 0 || len(channels) <= maxChannels {
+			return false
+		}
+		select {
+		case response <- core.Event{Type: core.EventTypeTooManyTimelines}:
+		case <-ctx.Done():
+		}
+		return true
+	}
+
+	listen := func(channels []string, since map[string]int64) {
+		if cancel != nil {
+			cancel()
+		}
+
+		normalized := make([]string, 0)
+		mapper = make(map[string]string)
+		normalizedSince := make(map[string]int64)
+		for _, timeline := range channels {
+			normalizedTimeline, err := s.NormalizeTimelineID(ctx, timeline)
+			if err != nil {
+				slog.WarnContext(
+					ctx,
+					fmt.Sprintf("failed to normalize timeline: %s", timeline),
+					slog.String("module", "timeline"),
+				)
+				continue
+			}
+			normalized = append(normalized, normalizedTimeline)
+			mapper[normalizedTimeline] = timeline
+			if seq, ok := since[timeline]; ok {
+				normalizedSince[normalizedTimeline] = seq
+			} else if seq, ok := since[timelineCursorKey(timeline)]; ok { // SSEのLast-Event-ID
+				normalizedSince[normalizedTimeline] = seq
+			}
+		}
+
+		// キャンセルした購読からの残りのイベントを受け取らないよう、チャンネルごと差し替える
+		events = make(chan core.Event)
 
+		var subctx context.Context
+		subctx, cancel = context.WithCancel(ctx)
+		go s.repository.Subscribe(subctx, normalized, normalizedSince, events)
+	}
+
+loop:
 	for {
 		select {
-		case timelines := <-request:
-			if cancel != nil {
-				cancel()
+		case req := <-request:
+			if watchCancel != nil {
+				watchCancel()
+				watchCancel = nil
 			}
+			lastSeq = make(map[string]int64)
+			filter = req.Filter
+			maxChannels = req.MaxChannels
 
-			normalized := make([]string, 0)
-			mapper = make(map[string]string)
-			for _, timeline := range timelines {
-				normalizedTimeline, err := s.NormalizeTimelineID(ctx, timeline)
-				if err != nil {
-					slog.WarnContext(
-						ctx,
-						fmt.Sprintf("failed to normalize timeline: %s", timeline),
-						slog.String("module", "timeline"),
-					)
-					continue
+			if req.Subscription == "" {
+				if tooMany(req.Channels) {
+					break loop
 				}
-				normalized = append(normalized, normalizedTimeline)
-				mapper[normalizedTimeline] = timeline
+				listen(req.Channels, req.Since)
+				continue
 			}
 
-			var subctx context.Context
-			subctx, cancel = context.WithCancel(ctx)
-			go s.repository.Subscribe(subctx, normalized, events)
+			channels, err := s.resolveSubscription(ctx, req.Subscription)
+			if err != nil {
+				slog.WarnContext(
+					ctx,
+					fmt.Sprintf("failed to resolve subscription: %s", req.Subscription),
+					slog.String("error", err.Error()),
+					slog.String("module", "timeline"),
+				)
+				continue
+			}
+			if tooMany(channels) {
+				break loop
+			}
+			listen(channels, req.Since)
+
+			var watchctx context.Context
+			watchctx, watchCancel = context.WithCancel(ctx)
+			go s.repository.WatchSubscription(watchctx, normalizeSubscriptionID(req.Subscription), changed)
+		case subscription := <-changed:
+			// 購読が変更されたので取りこぼしが無いように続きから購読し直す
+			channels, err := s.resolveSubscription(ctx, subscription)
+			if err != nil {
+				slog.WarnContext(
+					ctx,
+					fmt.Sprintf("failed to resolve subscription: %s", subscription),
+					slog.String("error", err.Error()),
+					slog.String("module", "timeline"),
+				)
+				channels = []string{}
+			}
+			if tooMany(channels) {
+				break loop
+			}
+			listen(channels, lastSeq)
 		case event := <-events:
 			if mapper == nil {
 				slog.WarnContext(ctx, "mapper is nil", slog.String("module", "timeline"))
 				continue
 			}
-			event.Timeline = mapper[event.Timeline]
-			response <- event
-		case <-ctx.Done():
-			if cancel != nil {
-				cancel()
+			timeline, ok := mapper[event.Timeline]
+			if !ok {
+				continue
+			}
+			event.Timeline = timeline
+			if event.Seq > 0 {
+				if event.Type == core.EventTypeGap {
+					lastSeq[event.Timeline] = event.Seq - 1
+				} else {
+					lastSeq[event.Timeline] = event.Seq
+				}
 			}
-			return
+			if !matchFilter(filter, event) {
+				continue
+			}
+			select {
+			case response <- event:
+			case <-ctx.Done():
+				break loop
+			}
+		case <-ctx.Done():
+			break loop
 		}
 	}
+
+	if cancel != nil {
+		cancel()
+	}
+	if watchCancel != nil {
+		watchCancel()
+	}
+}
+
+// normalizeSubscriptionID adds the typed-id prefix to a bare subscription id
+func normalizeSubscriptionID(id string) string {
+	if len(id) == 26 {
+		return "s" + id
+	}
+	return id
+}
+
+// resolveSubscription returns the timelines of the subscription
+func (s *service) resolveSubscription(ctx context.Context, subscription string) ([]string, error) {
+	sub, err := s.subscription.GetSubscription(ctx, subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	timelines := make([]string, 0)
+	for _, t := range sub.Items {
+		timelines = append(timelines, t.ID)
+	}
+
+	return timelines, nil
 }
 
 func (s *service) GetOwners(ctx context.Context, timelines []string) ([]string, error) {
@@ -1091,6 +1330,7 @@ var (
 	loadChunkBodiesTotal              *prometheus.GaugeVec
 	timelineRealtimeConnectionMetrics prometheus.Gauge
 	outerConnection                   *prometheus.GaugeVec
+	remoteConnectionMetrics           *prometheus.GaugeVec
 )
 
 func (s *service) UpdateMetrics() {
@@ -1144,6 +1384,31 @@ func (s *service) UpdateMetrics() {
 
 	outerConnection.WithLabelValues("desired").Set(float64(metrics["remoteSubs"]))
 	outerConnection.WithLabelValues("current").Set(float64(metrics["remoteConns"]))
+
+	if remoteConnectionMetrics == nil {
+		remoteConnectionMetrics = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cc_timeline_remote_connection",
+				Help: "State of the connection to each remote domain (subscriptions, leader, connected, reconnects, last_event)",
+			},
+			[]string{"domain", "metric"},
+		)
+		prometheus.MustRegister(remoteConnectionMetrics)
+	}
+
+	// 購読がなくなったドメインを消すため毎回作り直す
+	remoteConnectionMetrics.Reset()
+	for key, value := range metrics {
+		if !strings.HasPrefix(key, "remote.") {
+			continue
+		}
+		rest := strings.TrimPrefix(key, "remote.")
+		sep := strings.LastIndex(rest, ".")
+		if sep <= 0 {
+			continue
+		}
+		remoteConnectionMetrics.WithLabelValues(rest[:sep], rest[sep+1:]).Set(float64(value))
+	}
 }
 
 func (s *service) ListLocalRecentlyRemovedItems(ctx context.Context, timelines []string) (map[string][]string, error) {
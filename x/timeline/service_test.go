@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -447,3 +448,150 @@ func TestGetRecentItemsWide(t *testing.T) {
 		assert.Equal(t, expected[i], item.ResourceID)
 	}
 }
+
+func TestRealtimeListenSubscription(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const (
+		subscriptionID = "s00000000000000000000000000"
+		timeline1      = "t00000000000000000000000001@local.example.com"
+		timeline2      = "t00000000000000000000000002@local.example.com"
+	)
+
+	mockRepo := mock_timeline.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetNormalizationCache(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, timeline string) (string, error) {
+			return timeline, nil
+		},
+	).AnyTimes()
+
+	mockSubscription := mock_core.NewMockSubscriptionService(ctrl)
+	gomock.InOrder(
+		mockSubscription.EXPECT().GetSubscription(gomock.Any(), subscriptionID).Return(core.Subscription{
+			ID:    subscriptionID,
+			Items: []core.SubscriptionItem{{ID: timeline1}},
+		}, nil),
+		mockSubscription.EXPECT().GetSubscription(gomock.Any(), subscriptionID).Return(core.Subscription{
+			ID:    subscriptionID,
+			Items: []core.SubscriptionItem{{ID: timeline2}},
+		}, nil),
+	)
+
+	// 購読の変更を通知する
+	changedCh := make(chan chan<- string, 1)
+	mockRepo.EXPECT().WatchSubscription(gomock.Any(), subscriptionID, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subscription string, changed chan<- string) error {
+			changedCh <- changed
+			<-ctx.Done()
+			return nil
+		},
+	)
+
+	// 古い購読はキャンセル後にもイベントを送ろうとする
+	staleSent := make(chan bool, 1)
+	mockRepo.EXPECT().Subscribe(gomock.Any(), []string{timeline1}, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, channels []string, since map[string]int64, event chan<- core.Event) error {
+			event <- core.Event{Timeline: timeline1, Seq: 1}
+			<-ctx.Done()
+			select {
+			case event <- core.Event{Timeline: timeline1, Seq: 2}:
+				staleSent <- true
+			case <-time.After(100 * time.Millisecond):
+				staleSent <- false
+			}
+			return nil
+		},
+	)
+	mockRepo.EXPECT().Subscribe(gomock.Any(), []string{timeline2}, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, channels []string, since map[string]int64, event chan<- core.Event) error {
+			event <- core.Event{Timeline: timeline2, Seq: 1}
+			<-ctx.Done()
+			return nil
+		},
+	)
+
+	service := NewService(
+		mockRepo,
+		mock_core.NewMockEntityService(ctrl),
+		mock_core.NewMockDomainService(ctrl),
+		mock_core.NewMockSemanticIDService(ctrl),
+		mockSubscription,
+		mock_core.NewMockPolicyService(ctrl),
+		core.Config{
+			FQDN: "local.example.com",
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	request := make(chan core.RealtimeRequest)
+	response := make(chan core.Event)
+	go service.Realtime(ctx, request, response)
+
+	request <- core.RealtimeRequest{Subscription: subscriptionID}
+
+	receive := func() core.Event {
+		select {
+		case event := <-response:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+			return core.Event{}
+		}
+	}
+
+	assert.Equal(t, timeline1, receive().Timeline)
+
+	changed := <-changedCh
+	changed <- subscriptionID
+
+	assert.Equal(t, timeline2, receive().Timeline)
+
+	// キャンセルされた購読のイベントは届かない
+	assert.False(t, <-staleSent)
+}
+
+func TestRealtimeTooManyTimelines(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSubscription := mock_core.NewMockSubscriptionService(ctrl)
+	mockSubscription.EXPECT().GetSubscription(gomock.Any(), "s00000000000000000000000000").Return(core.Subscription{
+		ID: "s00000000000000000000000000",
+		Items: []core.SubscriptionItem{
+			{ID: "t00000000000000000000000001@local.example.com"},
+			{ID: "t00000000000000000000000002@local.example.com"},
+		},
+	}, nil)
+
+	service := NewService(
+		mock_timeline.NewMockRepository(ctrl),
+		mock_core.NewMockEntityService(ctrl),
+		mock_core.NewMockDomainService(ctrl),
+		mock_core.NewMockSemanticIDService(ctrl),
+		mockSubscription,
+		mock_core.NewMockPolicyService(ctrl),
+		core.Config{
+			FQDN: "local.example.com",
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	request := make(chan core.RealtimeRequest)
+	response := make(chan core.Event)
+	go service.Realtime(ctx, request, response)
+
+	// 購読を展開した結果が上限を超える
+	request <- core.RealtimeRequest{Subscription: "s00000000000000000000000000", MaxChannels: 1}
+
+	select {
+	case event := <-response:
+		assert.Equal(t, core.EventTypeTooManyTimelines, event.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}